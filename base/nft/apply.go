@@ -69,7 +69,7 @@ func DropRules(nft Nft) error {
 	for tn, t := range nft {
 		for cn, c := range t.Chains {
 			for _, r := range c.Rules {
-				if err := Drop(tn, cn, r.Handle); err != nil {
+				if err := Drop(t.Family, tn, cn, r.Handle); err != nil {
 					return err
 				}
 			}
@@ -78,7 +78,7 @@ func DropRules(nft Nft) error {
 	return nil
 }
 
-func Drop(table, chain string, handle int) error {
-	_, err := pm.System("nft", "delete", "rule", "inet", table, chain, "handle", fmt.Sprint(handle))
+func Drop(family Family, table, chain string, handle int) error {
+	_, err := pm.System("nft", "delete", "rule", string(family), table, chain, "handle", fmt.Sprint(handle))
 	return err
 }
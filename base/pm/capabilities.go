@@ -0,0 +1,174 @@
+package pm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	//CapabilitiesAll can be used in the add and drop lists instead of listing all the capabilities
+	CapabilitiesAll = "ALL"
+
+	capSetPCap = 8
+)
+
+var (
+	//Capabilities linux capabilities by name (see capabilities(7))
+	Capabilities = map[string]uintptr{
+		"CAP_CHOWN":              0,
+		"CAP_DAC_OVERRIDE":       1,
+		"CAP_DAC_READ_SEARCH":    2,
+		"CAP_FOWNER":             3,
+		"CAP_FSETID":             4,
+		"CAP_KILL":               5,
+		"CAP_SETGID":             6,
+		"CAP_SETUID":             7,
+		"CAP_SETPCAP":            capSetPCap,
+		"CAP_LINUX_IMMUTABLE":    9,
+		"CAP_NET_BIND_SERVICE":   10,
+		"CAP_NET_BROADCAST":      11,
+		"CAP_NET_ADMIN":          12,
+		"CAP_NET_RAW":            13,
+		"CAP_IPC_LOCK":           14,
+		"CAP_IPC_OWNER":          15,
+		"CAP_SYS_MODULE":         16,
+		"CAP_SYS_RAWIO":          17,
+		"CAP_SYS_CHROOT":         18,
+		"CAP_SYS_PTRACE":         19,
+		"CAP_SYS_PACCT":          20,
+		"CAP_SYS_ADMIN":          21,
+		"CAP_SYS_BOOT":           22,
+		"CAP_SYS_NICE":           23,
+		"CAP_SYS_RESOURCE":       24,
+		"CAP_SYS_TIME":           25,
+		"CAP_SYS_TTY_CONFIG":     26,
+		"CAP_MKNOD":              27,
+		"CAP_LEASE":              28,
+		"CAP_AUDIT_WRITE":        29,
+		"CAP_AUDIT_CONTROL":      30,
+		"CAP_SETFCAP":            31,
+		"CAP_MAC_OVERRIDE":       32,
+		"CAP_MAC_ADMIN":          33,
+		"CAP_SYSLOG":             34,
+		"CAP_WAKE_ALARM":         35,
+		"CAP_BLOCK_SUSPEND":      36,
+		"CAP_AUDIT_READ":         37,
+		"CAP_PERFMON":            38,
+		"CAP_BPF":                39,
+		"CAP_CHECKPOINT_RESTORE": 40,
+	}
+
+	//DefaultCapabilities capabilities of the processes of an unprivileged container
+	DefaultCapabilities = []string{
+		"CAP_SETPCAP",
+		"CAP_MKNOD",
+		"CAP_AUDIT_WRITE",
+		"CAP_CHOWN",
+		"CAP_NET_RAW",
+		"CAP_DAC_OVERRIDE",
+		"CAP_FOWNER",
+		"CAP_FSETID",
+		"CAP_KILL",
+		"CAP_SETGID",
+		"CAP_SETUID",
+		"CAP_NET_BIND_SERVICE",
+		"CAP_SYS_CHROOT",
+		"CAP_SETFCAP",
+	}
+
+	//capabilities kept in the bounding set of the jobs when running unprivileged
+	bounding = DefaultCapabilities
+)
+
+//normalizeCapability accepts a capability name in any case, with or without the CAP_ prefix
+func normalizeCapability(name string) (string, error) {
+	name = strings.ToUpper(name)
+	if name == CapabilitiesAll {
+		return name, nil
+	}
+
+	if !strings.HasPrefix(name, "CAP_") {
+		name = "CAP_" + name
+	}
+
+	if _, ok := Capabilities[name]; !ok {
+		return "", fmt.Errorf("unknown capability '%s'", name)
+	}
+
+	return name, nil
+}
+
+//ResolveCapabilities gets the default capabilities plus add minus drop, sorted by name
+func ResolveCapabilities(add, drop []string) ([]string, error) {
+	set := make(map[string]bool)
+	for _, name := range DefaultCapabilities {
+		set[name] = true
+	}
+
+	for _, name := range add {
+		name, err := normalizeCapability(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if name == CapabilitiesAll {
+			for all := range Capabilities {
+				set[all] = true
+			}
+		} else {
+			set[name] = true
+		}
+	}
+
+	for _, name := range drop {
+		name, err := normalizeCapability(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if name == CapabilitiesAll {
+			set = make(map[string]bool)
+		} else {
+			delete(set, name)
+		}
+	}
+
+	caps := make([]string, 0, len(set))
+	for name := range set {
+		caps = append(caps, name)
+	}
+
+	sort.Strings(caps)
+	return caps, nil
+}
+
+//SetCapabilities sets the capabilities the jobs keep in their bounding set when running unprivileged
+//(see SetUnprivileged), the names must be resolved with ResolveCapabilities
+func SetCapabilities(caps []string) {
+	bounding = caps
+}
+
+//droppedCapabilities the capabilities to drop from the bounding set of the jobs, CAP_SETPCAP is always
+//dropped, it's only needed by pm to drop the bounding set
+func droppedCapabilities(keep []string) []uintptr {
+	kept := make(map[string]bool)
+	for _, name := range keep {
+		kept[name] = true
+	}
+
+	var drop []uintptr
+	for name, value := range Capabilities {
+		if kept[name] && value != capSetPCap {
+			continue
+		}
+
+		drop = append(drop, value)
+	}
+
+	sort.Slice(drop, func(i, j int) bool {
+		return drop[i] < drop[j]
+	})
+
+	return drop
+}
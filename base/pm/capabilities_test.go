@@ -0,0 +1,39 @@
+package pm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveCapabilities(t *testing.T) {
+	caps, err := ResolveCapabilities([]string{"net_admin", "CAP_SYS_PTRACE"}, []string{"mknod"})
+	if assert.NoError(t, err) {
+		assert.Contains(t, caps, "CAP_NET_ADMIN")
+		assert.Contains(t, caps, "CAP_SYS_PTRACE")
+		assert.Contains(t, caps, "CAP_CHOWN")
+		assert.NotContains(t, caps, "CAP_MKNOD")
+	}
+
+	caps, err = ResolveCapabilities([]string{"kill"}, []string{"ALL"})
+	if assert.NoError(t, err) {
+		assert.Empty(t, caps)
+	}
+
+	caps, err = ResolveCapabilities([]string{"ALL"}, nil)
+	if assert.NoError(t, err) {
+		assert.Len(t, caps, len(Capabilities))
+	}
+
+	_, err = ResolveCapabilities([]string{"CAP_NOPE"}, nil)
+	assert.Error(t, err)
+}
+
+func TestDroppedCapabilities(t *testing.T) {
+	drop := droppedCapabilities(DefaultCapabilities)
+
+	assert.Len(t, drop, len(Capabilities)-len(DefaultCapabilities)+1)
+	assert.Contains(t, drop, Capabilities["CAP_SYS_ADMIN"])
+	assert.Contains(t, drop, Capabilities["CAP_SETPCAP"])
+	assert.NotContains(t, drop, Capabilities["CAP_CHOWN"])
+}
@@ -12,6 +12,13 @@ import (
 	"github.com/zero-os/0-core/base/pm/stream"
 )
 
+//IDMap maps a range of user (or group) ids inside the container to ids on the host
+type IDMap struct {
+	ContainerID int `json:"container_id"`
+	HostID      int `json:"host_id"`
+	Size        int `json:"size"`
+}
+
 type ContainerCommandArguments struct {
 	Name        string            `json:"name"`
 	Dir         string            `json:"dir"`
@@ -20,6 +27,21 @@ type ContainerCommandArguments struct {
 	HostNetwork bool              `json:"host_network"`
 	Chroot      string            `json:"chroot"`
 	Log         string            `json:"log"`
+	UIDMappings []IDMap           `json:"uid_mappings"` //runs the container in a new user namespace if set
+	GIDMappings []IDMap           `json:"gid_mappings"`
+}
+
+func sysIDMappings(mappings []IDMap) []syscall.SysProcIDMap {
+	var sys []syscall.SysProcIDMap
+	for _, m := range mappings {
+		sys = append(sys, syscall.SysProcIDMap{
+			ContainerID: m.ContainerID,
+			HostID:      m.HostID,
+			Size:        m.Size,
+		})
+	}
+
+	return sys
 }
 
 func (c *ContainerCommandArguments) String() string {
@@ -56,6 +78,12 @@ func (c *channel) Write(p []byte) (n int, err error) {
 type ContainerProcess interface {
 	Process
 	Channel() Channel
+	//Output gets the output (stdout and stderr) of the container process if it was started with no log file,
+	//the caller closes it once it's read
+	Output() io.ReadCloser
+	//Release lets the container process go on with its start once it's placed in its cgroups, the process
+	//unshares its cgroup namespace then so the namespace is rooted at the container cgroups
+	Release() error
 }
 
 type containerProcessImpl struct {
@@ -64,6 +92,8 @@ type containerProcessImpl struct {
 	pid     int
 	process *psutils.Process
 	ch      *channel
+	output  *os.File
+	release *os.File
 
 	table PIDTable
 }
@@ -86,6 +116,25 @@ func (p *containerProcessImpl) Channel() Channel {
 	return p.ch
 }
 
+func (p *containerProcessImpl) Output() io.ReadCloser {
+	if p.output == nil {
+		//a nil *os.File is not a nil io.ReadCloser
+		return nil
+	}
+
+	return p.output
+}
+
+func (p *containerProcessImpl) Release() error {
+	if p.release == nil {
+		return fmt.Errorf("container process is not started")
+	}
+
+	defer p.release.Close()
+	_, err := p.release.Write([]byte{0})
+	return err
+}
+
 func (p *containerProcessImpl) Signal(sig syscall.Signal) error {
 	if p.process != nil {
 		return syscall.Kill(-int(p.process.Pid), sig)
@@ -163,12 +212,18 @@ func (p *containerProcessImpl) Run() (ch <-chan *stream.Message, err error) {
 	defer func() {
 		if err != nil {
 			close(channel)
+			if p.output != nil {
+				p.output.Close()
+			}
 		}
 	}()
 
 	var wg sync.WaitGroup
 
-	var flags uintptr = syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWUTS
+	//the cgroup namespace is unshared by the container process itself once it's released, if it's set here
+	//the namespace would be rooted at the cgroups of core0
+	var flags uintptr = syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWUTS |
+		syscall.CLONE_NEWIPC
 
 	if !p.args.HostNetwork {
 		flags |= syscall.CLONE_NEWNET
@@ -184,13 +239,35 @@ func (p *containerProcessImpl) Run() (ch <-chan *stream.Message, err error) {
 		if err != nil {
 			return nil, err
 		}
+	} else {
+		p.output, logf, err = os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	defer logf.Close()
+
+	wait, release, err := os.Pipe()
+	if err != nil {
+		return nil, err
 	}
 
+	defer wait.Close()
+	defer func() {
+		if err != nil {
+			release.Close()
+		}
+	}()
+
+	//the pid hooks run as soon as the process is registered, and may release it
+	p.release = release
+
 	attrs := os.ProcAttr{
 		Dir: p.args.Dir,
 		Env: env,
 		Files: []*os.File{
-			nil, logf, logf, r, w,
+			nil, logf, logf, r, w, wait,
 		},
 		Sys: &syscall.SysProcAttr{
 			Chroot:     p.args.Chroot,
@@ -199,6 +276,14 @@ func (p *containerProcessImpl) Run() (ch <-chan *stream.Message, err error) {
 		},
 	}
 
+	if len(p.args.UIDMappings) != 0 {
+		attrs.Sys.Cloneflags |= syscall.CLONE_NEWUSER
+		attrs.Sys.UidMappings = sysIDMappings(p.args.UIDMappings)
+		attrs.Sys.GidMappings = sysIDMappings(p.args.GIDMappings)
+		//the ids are mapped to a dedicated range, so it's safe to let coreX drop groups
+		attrs.Sys.GidMappingsEnableSetgroups = true
+	}
+
 	log.Debugf("system: %s", p.args)
 	var ps *os.Process
 	args := []string{name}
@@ -227,11 +312,17 @@ func (p *containerProcessImpl) Run() (ch <-chan *stream.Message, err error) {
 		//wait for all streams to finish copying
 		wg.Wait()
 		ps.Release()
+		//in case the process was never released
+		p.release.Close()
 		if err := p.ch.Close(); err != nil {
 			log.Errorf("failed to close container channel: %s", err)
 		}
 
 		code := state.ExitStatus()
+		if state.Signaled() {
+			//killed by a signal, the code is reported like a shell does
+			code = 128 + int(state.Signal())
+		}
 		log.Debugf("Process %s exited with state: %d", p.cmd, code)
 		if code == 0 {
 			channel <- &stream.Message{
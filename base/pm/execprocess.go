@@ -0,0 +1,429 @@
+package pm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	psutils "github.com/shirou/gopsutil/process"
+	"github.com/zero-os/0-core/base/pm/stream"
+)
+
+const (
+	CommandExec = "core.exec"
+
+	//EOT is written to the terminal on end of input, so the terminal line discipline
+	//delivers an EOF to the reader
+	EOT = 0x04
+)
+
+//ExecCommandArguments is core.system with user, terminal, and stdin streaming support
+type ExecCommandArguments struct {
+	Name  string            `json:"name"`
+	Args  []string          `json:"args"`
+	Dir   string            `json:"dir"`
+	Env   map[string]string `json:"env"`
+	User  string            `json:"user"`  //user name or uid, optionally followed by :group or :gid
+	TTY   bool              `json:"tty"`   //run the process in a pseudo terminal
+	Rows  uint16            `json:"rows"`  //initial terminal size
+	Cols  uint16            `json:"cols"`  //initial terminal size
+	Stdin bool              `json:"stdin"` //keep stdin open, the data is fed with Input
+}
+
+func (e *ExecCommandArguments) String() string {
+	return fmt.Sprintf("%v %s %v (%s, user: %s, tty: %v)", e.Env, e.Name, e.Args, e.Dir, e.User, e.TTY)
+}
+
+//Interactive is a process that accepts input while running
+type Interactive interface {
+	Process
+	Input(in *Input) error
+}
+
+type execProcessImpl struct {
+	cmd     *Command
+	args    ExecCommandArguments
+	pid     int
+	process *psutils.Process
+
+	stdin io.WriteCloser
+	pty   *os.File
+	m     sync.Mutex
+
+	table PIDTable
+}
+
+func NewExecProcess(table PIDTable, cmd *Command) Process {
+	process := &execProcessImpl{
+		cmd:   cmd,
+		table: table,
+	}
+
+	json.Unmarshal(*cmd.Arguments, &process.args)
+	return process
+}
+
+func (p *execProcessImpl) Command() *Command {
+	return p.cmd
+}
+
+func (p *execProcessImpl) Stats() *ProcessStats {
+	stats := ProcessStats{}
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Warningf("processUtils panic: %s", r)
+		}
+	}()
+
+	ps := p.process
+	if ps == nil {
+		return &stats
+	}
+
+	if cpu, err := ps.Percent(0); err == nil {
+		stats.CPU = cpu
+	}
+
+	if mem, err := ps.MemoryInfo(); err == nil {
+		stats.RSS = mem.RSS
+		stats.VMS = mem.VMS
+		stats.Swap = mem.Swap
+	}
+
+	stats.Debug = fmt.Sprintf("%d", ps.Pid)
+
+	return &stats
+}
+
+func (p *execProcessImpl) Signal(sig syscall.Signal) error {
+	if p.process == nil {
+		return fmt.Errorf("process not found")
+	}
+
+	kill := int(p.process.Pid)
+	if p.args.TTY || !p.cmd.Flags.NoSetPGID {
+		//a terminal process is the leader of its own session
+		gid, err := syscall.Getpgid(kill)
+		if err != nil {
+			return err
+		}
+		kill = -gid
+	}
+
+	return syscall.Kill(kill, sig)
+}
+
+//Input writes data to the process stdin, closes it on EOF, and resizes the terminal
+func (p *execProcessImpl) Input(in *Input) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.pty != nil {
+		if err := resizePTY(p.pty, in.Rows, in.Cols); err != nil {
+			return err
+		}
+	}
+
+	if len(in.Data) == 0 && !in.EOF {
+		return nil
+	}
+
+	if p.stdin == nil {
+		return fmt.Errorf("stdin is not open")
+	}
+
+	if len(in.Data) > 0 {
+		if _, err := p.stdin.Write(in.Data); err != nil {
+			return err
+		}
+	}
+
+	if !in.EOF {
+		return nil
+	}
+
+	if p.pty != nil {
+		_, err := p.stdin.Write([]byte{EOT})
+		return err
+	}
+
+	err := p.stdin.Close()
+	p.stdin = nil
+	return err
+}
+
+//credential resolves the user spec `user[:group]` where user and group can be names or ids
+func credential(spec string) (*syscall.Credential, *user.User, error) {
+	name, group := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, group = spec[:i], spec[i+1:]
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			if _, perr := strconv.ParseUint(name, 10, 32); perr != nil {
+				return nil, nil, NotFoundError(fmt.Errorf("unknown user '%s'", name))
+			}
+			//a uid that has no passwd entry.
+			u = &user.User{Uid: name, Gid: "0", Username: name, HomeDir: "/"}
+		}
+	}
+
+	gid := u.Gid
+	if group != "" {
+		if g, err := user.LookupGroup(group); err == nil {
+			gid = g.Gid
+		} else if _, err := strconv.ParseUint(group, 10, 32); err == nil {
+			gid = group
+		} else {
+			return nil, nil, NotFoundError(fmt.Errorf("unknown group '%s'", group))
+		}
+	}
+
+	uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+	g, _ := strconv.ParseUint(gid, 10, 32)
+
+	cred := &syscall.Credential{
+		Uid: uint32(uid),
+		Gid: uint32(g),
+	}
+
+	if group == "" {
+		if ids, err := u.GroupIds(); err == nil {
+			for _, id := range ids {
+				if x, err := strconv.ParseUint(id, 10, 32); err == nil {
+					cred.Groups = append(cred.Groups, uint32(x))
+				}
+			}
+		}
+	}
+
+	return cred, u, nil
+}
+
+func (p *execProcessImpl) env(u *user.User) []string {
+	env := os.Environ()
+	if u != nil {
+		env = append(env, fmt.Sprintf("HOME=%s", u.HomeDir), fmt.Sprintf("USER=%s", u.Username))
+	}
+
+	if _, ok := p.args.Env["TERM"]; p.args.TTY && !ok {
+		env = append(env, "TERM=xterm")
+	}
+
+	for k, v := range p.args.Env {
+		env = append(env, fmt.Sprintf("%v=%v", k, v))
+	}
+
+	return env
+}
+
+//terminal reads the terminal output in raw chunks, the output is not split into lines since
+//interactive programs don't always terminate their output with a new line
+func (p *execProcessImpl) terminal(wg *sync.WaitGroup, master *os.File, handler stream.MessageHandler) {
+	defer wg.Done()
+
+	buffer := make([]byte, 32*1024)
+	var offset int
+	for {
+		n, err := master.Read(buffer[offset:])
+		n += offset
+		offset = 0
+		if n > 0 {
+			cut := utf8Cut(buffer[:n])
+			if cut > 0 {
+				handler(&stream.Message{
+					Message: string(buffer[:cut]),
+					Meta:    stream.NewMeta(stream.LevelStdout),
+				})
+			}
+
+			offset = copy(buffer, buffer[cut:n])
+		}
+
+		if err != nil {
+			//reading the master fails with EIO once all the terminal users exit.
+			break
+		}
+	}
+
+	if offset > 0 {
+		handler(&stream.Message{
+			Message: string(buffer[:offset]),
+			Meta:    stream.NewMeta(stream.LevelStdout),
+		})
+	}
+}
+
+func (p *execProcessImpl) Run() (ch <-chan *stream.Message, err error) {
+	name, err := exec.LookPath(p.args.Name)
+	if err != nil {
+		return nil, NotFoundError(err)
+	}
+
+	attrs := os.ProcAttr{
+		Dir: p.args.Dir,
+		Sys: &syscall.SysProcAttr{},
+	}
+
+	var u *user.User
+	if p.args.User != "" {
+		if attrs.Sys.Credential, u, err = credential(p.args.User); err != nil {
+			return nil, err
+		}
+	}
+
+	attrs.Env = p.env(u)
+
+	channel := make(chan *stream.Message)
+	ch = channel
+	var toClose, cleanup []*os.File
+	defer func() {
+		if err != nil {
+			close(channel)
+			for _, f := range append(toClose, cleanup...) {
+				f.Close()
+			}
+		}
+	}()
+
+	handler := func(m *stream.Message) {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Errorf("error while writing output: %s", err)
+			}
+		}()
+		channel <- m
+	}
+
+	var wg sync.WaitGroup
+	var master, outRead, errRead *os.File
+
+	if p.args.TTY {
+		var slave *os.File
+		if master, slave, err = openPTY(); err != nil {
+			return nil, err
+		}
+
+		cleanup = append(cleanup, master)
+		toClose = append(toClose, slave)
+
+		if err = resizePTY(master, p.args.Rows, p.args.Cols); err != nil {
+			return nil, err
+		}
+
+		p.pty = master
+		if p.args.Stdin {
+			p.stdin = master
+		}
+
+		attrs.Files = []*os.File{slave, slave, slave}
+		attrs.Sys.Setsid = true
+		attrs.Sys.Setctty = true
+		attrs.Sys.Ctty = 0
+	} else {
+		var stdin, stdout, stderr *os.File
+		if p.args.Stdin {
+			var input *os.File
+			if stdin, input, err = os.Pipe(); err != nil {
+				return nil, err
+			}
+			cleanup = append(cleanup, input)
+			p.stdin = input
+		} else if stdin, err = os.Open(os.DevNull); err != nil {
+			return nil, err
+		}
+
+		toClose = append(toClose, stdin)
+
+		if outRead, stdout, err = os.Pipe(); err != nil {
+			return nil, err
+		}
+		cleanup = append(cleanup, outRead)
+		toClose = append(toClose, stdout)
+
+		if errRead, stderr, err = os.Pipe(); err != nil {
+			return nil, err
+		}
+		cleanup = append(cleanup, errRead)
+		toClose = append(toClose, stderr)
+
+		attrs.Files = []*os.File{stdin, stdout, stderr}
+		attrs.Sys.Setpgid = !p.cmd.Flags.NoSetPGID
+	}
+
+	log.Debugf("exec: %s", &p.args)
+	var ps *os.Process
+	args := []string{name}
+	args = append(args, p.args.Args...)
+	err = p.table.RegisterPID(func() (int, error) {
+		ps, err = os.StartProcess(name, args, &attrs)
+		if err != nil {
+			return 0, err
+		}
+		for _, f := range toClose {
+			f.Close()
+		}
+		toClose = nil
+		return ps.Pid, nil
+	})
+
+	if err != nil {
+		return
+	}
+
+	//outputs are only consumed once the process is started, so nothing is left behind on failure
+	if master != nil {
+		wg.Add(1)
+		go p.terminal(&wg, master, handler)
+	} else {
+		wg.Add(2)
+		stream.NewConsumer(&wg, outRead, 1, handler)
+		stream.NewConsumer(&wg, errRead, 2, handler)
+	}
+
+	p.pid = ps.Pid
+	psProcess, _ := psutils.NewProcess(int32(p.pid))
+	p.process = psProcess
+
+	go func(channel chan *stream.Message) {
+		defer close(channel)
+		state := p.table.WaitPID(p.pid)
+		wg.Wait()
+		ps.Release()
+
+		p.m.Lock()
+		if p.stdin != nil && p.pty == nil {
+			p.stdin.Close()
+		}
+		if p.pty != nil {
+			p.pty.Close()
+		}
+		p.stdin = nil
+		p.pty = nil
+		p.m.Unlock()
+
+		code := state.ExitStatus()
+		log.Debugf("Process %s exited with state: %d", p.cmd, code)
+		if code == 0 {
+			channel <- &stream.Message{
+				Meta: stream.NewMeta(stream.LevelStdout, stream.ExitSuccessFlag),
+			}
+		} else {
+			channel <- &stream.Message{
+				Meta: stream.NewMetaWithCode(uint32(1000+code), stream.LevelStderr, stream.ExitErrorFlag),
+			}
+		}
+	}(channel)
+
+	return channel, nil
+}
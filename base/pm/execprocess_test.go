@@ -0,0 +1,86 @@
+package pm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/zero-os/0-core/base/pm/stream"
+	"testing"
+)
+
+func TestExecProcess_RunStdin(t *testing.T) {
+	ps := NewExecProcess(&table{}, &Command{
+		Arguments: MustArguments(
+			ExecCommandArguments{
+				Name:  "cat",
+				Stdin: true,
+			},
+		),
+	})
+
+	ch, err := ps.Run()
+
+	if ok := assert.Nil(t, err); !ok {
+		t.Fatal(err)
+	}
+
+	interactive, ok := ps.(Interactive)
+	if ok := assert.True(t, ok); !ok {
+		t.Fatal()
+	}
+
+	assert.Nil(t, interactive.Input(&Input{Data: []byte("hello world\n")}))
+	assert.Nil(t, interactive.Input(&Input{EOF: true}))
+
+	var messages []*stream.Message
+	for msg := range ch {
+		messages = append(messages, msg)
+	}
+
+	if ok := assert.Len(t, messages, 2); !ok {
+		t.Fatal()
+	}
+
+	if ok := assert.Equal(t, "hello world", messages[0].Message); !ok {
+		t.Error()
+	}
+
+	assert.True(t, messages[1].Meta.Is(stream.ExitSuccessFlag))
+}
+
+func TestExecProcess_RunNoStdin(t *testing.T) {
+	ps := NewExecProcess(&table{}, &Command{
+		Arguments: MustArguments(
+			ExecCommandArguments{
+				Name: "pwd",
+				Dir:  "/tmp",
+			},
+		),
+	})
+
+	ch, err := ps.Run()
+
+	if ok := assert.Nil(t, err); !ok {
+		t.Fatal(err)
+	}
+
+	assert.Error(t, ps.(Interactive).Input(&Input{Data: []byte("data")}))
+
+	var messages []*stream.Message
+	for msg := range ch {
+		messages = append(messages, msg)
+	}
+
+	if ok := assert.Len(t, messages, 2); !ok {
+		t.Fatal()
+	}
+
+	assert.Equal(t, "/tmp", messages[0].Message)
+}
+
+func TestUTF8Cut(t *testing.T) {
+	data := []byte("hello ☺")
+
+	assert.Equal(t, len(data), utf8Cut(data))
+	assert.Equal(t, 6, utf8Cut(data[:len(data)-1]))
+	assert.Equal(t, 6, utf8Cut(data[:len(data)-2]))
+	assert.Equal(t, 6, utf8Cut(data[:6]))
+}
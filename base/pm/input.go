@@ -0,0 +1,95 @@
+package pm
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	inputQueueSize = 1000
+	inputIdle      = 30 * time.Second
+)
+
+//Input is data to the stdin of an interactive job, and/or a resize of its terminal
+type Input struct {
+	ID   string `json:"id"`
+	Data []byte `json:"data,omitempty"`
+	EOF  bool   `json:"eof,omitempty"`
+	Rows uint16 `json:"rows,omitempty"`
+	Cols uint16 `json:"cols,omitempty"`
+}
+
+var (
+	inputs  = make(map[string]chan *Input)
+	inputsM sync.Mutex
+)
+
+/*
+Feed queues input to the job with ID in.ID. Input can arrive before the job process is started (the job
+might still be waiting in the jobs queue) so it's delivered in order by a feeder routine once the process
+is ready. Input to a job that doesn't exist is dropped.
+*/
+func Feed(in *Input) error {
+	if _, ok := JobOf(in.ID); !ok {
+		return NotFoundError(fmt.Errorf("job '%s' does not exist", in.ID))
+	}
+
+	inputsM.Lock()
+	defer inputsM.Unlock()
+
+	queue, ok := inputs[in.ID]
+	if !ok {
+		queue = make(chan *Input, inputQueueSize)
+		inputs[in.ID] = queue
+		go feed(in.ID, queue)
+	}
+
+	select {
+	case queue <- in:
+		return nil
+	default:
+		return ServiceUnavailableError(fmt.Errorf("input queue of job '%s' is full", in.ID))
+	}
+}
+
+//interactive waits for the process of job id to start, it returns false if the job exits first
+func interactive(id string) (Interactive, bool) {
+	for {
+		job, ok := JobOf(id)
+		if !ok {
+			return nil, false
+		}
+
+		if ps, ok := job.Process().(Interactive); ok {
+			return ps, true
+		}
+
+		<-time.After(100 * time.Millisecond)
+	}
+}
+
+func feed(id string, queue chan *Input) {
+	for {
+		select {
+		case in := <-queue:
+			ps, ok := interactive(id)
+			if !ok {
+				log.Debugf("dropping input of job '%s', job exited", id)
+				continue
+			}
+
+			if err := ps.Input(in); err != nil {
+				log.Errorf("failed to feed input to job '%s': %s", id, err)
+			}
+		case <-time.After(inputIdle):
+			inputsM.Lock()
+			if _, ok := JobOf(id); !ok && len(queue) == 0 {
+				delete(inputs, id)
+				inputsM.Unlock()
+				return
+			}
+			inputsM.Unlock()
+		}
+	}
+}
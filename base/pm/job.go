@@ -1,8 +1,5 @@
 package pm
 
-// #cgo LDFLAGS: -lcap
-// #include <sys/capability.h>
-import "C"
 import (
 	"fmt"
 	"github.com/zero-os/0-core/base/pm/stream"
@@ -90,32 +87,7 @@ func (r *jobImb) timeout() <-chan time.Time {
 //pm it affects all threads from now on.
 func (r *jobImb) setUnprivileged() {
 	//drop bounding set for children.
-	bound := []uintptr{
-		C.CAP_SETPCAP,
-		C.CAP_SYS_MODULE,
-		C.CAP_SYS_RAWIO,
-		C.CAP_SYS_PACCT,
-		C.CAP_SYS_ADMIN,
-		C.CAP_SYS_NICE,
-		C.CAP_SYS_RESOURCE,
-		C.CAP_SYS_TIME,
-		C.CAP_SYS_TTY_CONFIG,
-		C.CAP_AUDIT_CONTROL,
-		C.CAP_MAC_OVERRIDE,
-		C.CAP_MAC_ADMIN,
-		C.CAP_NET_ADMIN,
-		C.CAP_SYSLOG,
-		C.CAP_DAC_READ_SEARCH,
-		C.CAP_LINUX_IMMUTABLE,
-		C.CAP_NET_BROADCAST,
-		C.CAP_IPC_LOCK,
-		C.CAP_IPC_OWNER,
-		C.CAP_SYS_PTRACE,
-		C.CAP_SYS_BOOT,
-		C.CAP_LEASE,
-		C.CAP_WAKE_ALARM,
-		C.CAP_BLOCK_SUSPEND,
-	}
+	bound := droppedCapabilities(bounding)
 
 	for _, c := range bound {
 		syscall.Syscall6(syscall.SYS_PRCTL, syscall.PR_CAPBSET_DROP,
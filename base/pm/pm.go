@@ -124,6 +124,17 @@ func loop() {
 	}
 }
 
+//WaitSlot blocks until the number of jobs is below MaxJobs. Jobs that are started while pm is saturated
+//wait in memory, so a caller that receives jobs from an external queue uses it to leave them on the queue.
+func WaitSlot() {
+	jobsCond.L.Lock()
+	defer jobsCond.L.Unlock()
+
+	for len(jobs) >= MaxJobs {
+		jobsCond.Wait()
+	}
+}
+
 func processWait() {
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, syscall.SIGCHLD)
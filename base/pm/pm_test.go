@@ -0,0 +1,47 @@
+package pm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitSlot(t *testing.T) {
+	New()
+
+	max := MaxJobs
+	defer func() {
+		MaxJobs = max
+	}()
+
+	MaxJobs = 1
+	jobsM.Lock()
+	jobs["wait-slot"] = nil
+	jobsM.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		WaitSlot()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("wait slot returned while pm is saturated")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	jobsCond.L.Lock()
+	jobsM.Lock()
+	delete(jobs, "wait-slot")
+	jobsM.Unlock()
+	jobsCond.L.Unlock()
+	jobsCond.Broadcast()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail(t, "wait slot didn't return once a slot was released")
+	}
+}
@@ -0,0 +1,83 @@
+package pm
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unicode/utf8"
+	"unsafe"
+)
+
+func ioctl(fd uintptr, request uintptr, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+//openPTY allocates a new pseudo terminal pair from /dev/ptmx
+func openPTY() (master *os.File, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			master.Close()
+		}
+	}()
+
+	var unlock int32
+	if err = ioctl(master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		return nil, nil, fmt.Errorf("failed to unlock pty: %s", err)
+	}
+
+	var n uint32
+	if err = ioctl(master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		return nil, nil, fmt.Errorf("failed to get pty number: %s", err)
+	}
+
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return master, slave, nil
+}
+
+type winsize struct {
+	rows   uint16
+	cols   uint16
+	xpixel uint16
+	ypixel uint16
+}
+
+//resizePTY sets the terminal window size, zero values are ignored
+func resizePTY(pty *os.File, rows, cols uint16) error {
+	if rows == 0 || cols == 0 {
+		return nil
+	}
+
+	ws := winsize{rows: rows, cols: cols}
+	return ioctl(pty.Fd(), syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+}
+
+//utf8Cut gets the length of the part of p that ends with a complete utf8 character, so a character
+//split between 2 reads is not broken when the output is sent as a string.
+func utf8Cut(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(p[i]) {
+			continue
+		}
+
+		if !utf8.FullRune(p[i:]) {
+			return i
+		}
+
+		break
+	}
+
+	return len(p)
+}
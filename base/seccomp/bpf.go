@@ -0,0 +1,251 @@
+package seccomp
+
+import (
+	"fmt"
+	"syscall"
+)
+
+const (
+	retKillProcess = 0x80000000
+	retKillThread  = 0x00000000
+	retTrap        = 0x00030000
+	retErrno       = 0x00050000
+	retTrace       = 0x7ff00000
+	retLog         = 0x7ffc0000
+	retAllow       = 0x7fff0000
+
+	//offsets in struct seccomp_data
+	offsetNR   = 0
+	offsetArch = 4
+	offsetArgs = 16
+
+	bpfLD  = 0x00
+	bpfALU = 0x04
+	bpfJMP = 0x05
+	bpfRET = 0x06
+	bpfW   = 0x00
+	bpfABS = 0x20
+	bpfAND = 0x50
+	bpfJEQ = 0x10
+	bpfJGT = 0x20
+	bpfJGE = 0x30
+	bpfK   = 0x00
+
+	maxInstructions = 4096
+	maxJump         = 255
+
+	//x32SyscallBit is set in the numbers of the x32 ABI syscalls, they share the audit arch of x86_64
+	x32SyscallBit = 0x40000000
+)
+
+//instruction struct sock_filter
+type instruction struct {
+	Code uint16
+	Jt   uint8
+	Jf   uint8
+	K    uint32
+}
+
+//jump an instruction of the program being assembled, the jump targets are labels
+type jump struct {
+	instruction
+	jt, jf string
+}
+
+type assembler struct {
+	code   []jump
+	labels map[string]int
+}
+
+func (a *assembler) emit(code uint16, k uint32, jt, jf string) {
+	a.code = append(a.code, jump{instruction: instruction{Code: code, K: k}, jt: jt, jf: jf})
+}
+
+func (a *assembler) load(offset uint32) {
+	a.emit(bpfLD|bpfW|bpfABS, offset, "", "")
+}
+
+func (a *assembler) and(k uint32) {
+	a.emit(bpfALU|bpfAND|bpfK, k, "", "")
+}
+
+//jmp conditional jump, an empty label is the next instruction
+func (a *assembler) jmp(op uint16, k uint32, jt, jf string) {
+	a.emit(bpfJMP|op|bpfK, k, jt, jf)
+}
+
+func (a *assembler) ret(k uint32) {
+	a.emit(bpfRET|bpfK, k, "", "")
+}
+
+func (a *assembler) label(name string) {
+	a.labels[name] = len(a.code)
+}
+
+func (a *assembler) offset(from int, label string) (uint8, error) {
+	if len(label) == 0 {
+		return 0, nil
+	}
+
+	target, ok := a.labels[label]
+	if !ok {
+		return 0, fmt.Errorf("undefined label '%s'", label)
+	}
+
+	offset := target - from - 1
+	if offset < 0 || offset > maxJump {
+		return 0, fmt.Errorf("jump to '%s' out of range", label)
+	}
+
+	return uint8(offset), nil
+}
+
+func (a *assembler) assemble() ([]instruction, error) {
+	if len(a.code) > maxInstructions {
+		return nil, fmt.Errorf("seccomp filter is too large (%d instructions)", len(a.code))
+	}
+
+	program := make([]instruction, 0, len(a.code))
+	for i, ins := range a.code {
+		var err error
+		if ins.Jt, err = a.offset(i, ins.jt); err != nil {
+			return nil, err
+		}
+		if ins.Jf, err = a.offset(i, ins.jf); err != nil {
+			return nil, err
+		}
+
+		program = append(program, ins.instruction)
+	}
+
+	return program, nil
+}
+
+func action(name string, errno *uint, defaultErrno uint) uint32 {
+	switch name {
+	case ActKill, ActKillThread:
+		return retKillThread
+	case ActKillProcess:
+		return retKillProcess
+	case ActTrap:
+		return retTrap
+	case ActErrno:
+		if errno != nil {
+			return retErrno | uint32(*errno&0xffff)
+		}
+		return retErrno | uint32(defaultErrno&0xffff)
+	case ActTrace:
+		return retTrace
+	case ActLog:
+		return retLog
+	default:
+		return retAllow
+	}
+}
+
+//compare checks a 64 bits argument, the program continues if the condition is true and jumps to fail otherwise
+func (a *assembler) compare(arg *Arg, pass, fail string) {
+	offset := uint32(offsetArgs + 8*arg.Index)
+	lo, hi := offset, offset+4
+	value, valueHi := uint32(arg.Value), uint32(arg.Value>>32)
+
+	switch arg.Op {
+	case OpEqualTo:
+		a.load(hi)
+		a.jmp(bpfJEQ, valueHi, "", fail)
+		a.load(lo)
+		a.jmp(bpfJEQ, value, "", fail)
+	case OpNotEqual:
+		a.load(hi)
+		a.jmp(bpfJEQ, valueHi, "", pass)
+		a.load(lo)
+		a.jmp(bpfJEQ, value, fail, "")
+	case OpMaskedEqual:
+		a.load(hi)
+		a.and(valueHi)
+		a.jmp(bpfJEQ, uint32(arg.ValueTwo>>32), "", fail)
+		a.load(lo)
+		a.and(value)
+		a.jmp(bpfJEQ, uint32(arg.ValueTwo), "", fail)
+	case OpGreaterThan, OpGreaterEqual:
+		a.load(hi)
+		a.jmp(bpfJGT, valueHi, pass, "")
+		a.jmp(bpfJEQ, valueHi, "", fail)
+		a.load(lo)
+		if arg.Op == OpGreaterThan {
+			a.jmp(bpfJGT, value, "", fail)
+		} else {
+			a.jmp(bpfJGE, value, "", fail)
+		}
+	case OpLessThan, OpLessEqual:
+		a.load(hi)
+		a.jmp(bpfJGT, valueHi, fail, "")
+		a.jmp(bpfJEQ, valueHi, "", pass)
+		a.load(lo)
+		if arg.Op == OpLessThan {
+			a.jmp(bpfJGE, value, fail, "")
+		} else {
+			a.jmp(bpfJGT, value, fail, "")
+		}
+	}
+
+	a.label(pass)
+}
+
+/*
+compile compiles the profile to a BPF program for the native architecture, caps are the capabilities of the
+container used by the rules filters. Syscalls that are not known on the architecture are ignored and the
+process is killed if it uses the syscalls of another architecture (or the x32 ABI, its syscalls would not
+match any rule).
+*/
+func (p *Profile) compile(caps []string) ([]instruction, error) {
+	if auditArch == 0 {
+		return nil, fmt.Errorf("seccomp is not supported on this architecture")
+	}
+
+	defaultErrno := uint(syscall.EPERM)
+	if p.DefaultErrnoRet != nil {
+		defaultErrno = *p.DefaultErrnoRet
+	}
+
+	a := &assembler{labels: make(map[string]int)}
+	a.load(offsetArch)
+	a.jmp(bpfJEQ, auditArch, "arch", "")
+	a.ret(retKillProcess)
+	a.label("arch")
+	a.load(offsetNR)
+	a.jmp(bpfJGE, x32SyscallBit, "", "native")
+	a.ret(retKillProcess)
+	a.label("native")
+
+	rule := 0
+	for _, call := range p.Syscalls {
+		if !call.applies(caps) {
+			continue
+		}
+
+		ret := action(call.Action, call.ErrnoRet, defaultErrno)
+		for _, name := range call.names() {
+			nr, ok := syscalls[name]
+			if !ok {
+				continue
+			}
+
+			next := fmt.Sprintf("rule-%d", rule)
+			rule++
+
+			//arguments checks overwrite the accumulator, the syscall number is loaded by every rule
+			a.load(offsetNR)
+			a.jmp(bpfJEQ, uint32(nr), "", next)
+			for i, arg := range call.Args {
+				a.compare(arg, fmt.Sprintf("%s-arg-%d", next, i), next)
+			}
+			a.ret(ret)
+			a.label(next)
+		}
+	}
+
+	a.ret(action(p.DefaultAction, nil, defaultErrno))
+
+	return a.assemble()
+}
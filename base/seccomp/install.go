@@ -0,0 +1,46 @@
+package seccomp
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	setModeFilter   = 1
+	filterFlagTSync = 1
+)
+
+//program struct sock_fprog
+type program struct {
+	Len    uint16
+	Filter *instruction
+}
+
+/*
+Install compiles the profile and installs it on all the threads of the process, the filter is inherited by
+all the children. The filter is installed without no_new_privs so the caller needs CAP_SYS_ADMIN, it must
+be installed before the capabilities are dropped.
+*/
+func (p *Profile) Install(caps []string) error {
+	filter, err := p.compile(caps)
+	if err != nil {
+		return err
+	}
+
+	prog := program{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+
+	r, _, errno := syscall.RawSyscall(unix.SYS_SECCOMP, setModeFilter, filterFlagTSync, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("failed to install seccomp filter: %s", errno)
+	} else if r != 0 {
+		return fmt.Errorf("failed to install seccomp filter, thread %d can't be synchronized", r)
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package seccomp
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+const (
+	ActKill        = "SCMP_ACT_KILL"
+	ActKillThread  = "SCMP_ACT_KILL_THREAD"
+	ActKillProcess = "SCMP_ACT_KILL_PROCESS"
+	ActTrap        = "SCMP_ACT_TRAP"
+	ActErrno       = "SCMP_ACT_ERRNO"
+	ActTrace       = "SCMP_ACT_TRACE"
+	ActLog         = "SCMP_ACT_LOG"
+	ActAllow       = "SCMP_ACT_ALLOW"
+
+	OpNotEqual     = "SCMP_CMP_NE"
+	OpLessThan     = "SCMP_CMP_LT"
+	OpLessEqual    = "SCMP_CMP_LE"
+	OpEqualTo      = "SCMP_CMP_EQ"
+	OpGreaterEqual = "SCMP_CMP_GE"
+	OpGreaterThan  = "SCMP_CMP_GT"
+	OpMaskedEqual  = "SCMP_CMP_MASKED_EQ"
+
+	//ProfileDefault and ProfileUnconfined are the names of the builtin profiles
+	ProfileDefault    = "default"
+	ProfileUnconfined = "unconfined"
+
+	maxArgs = 6
+
+	//cloneNamespaces the clone flags that create namespaces
+	cloneNamespaces = syscall.CLONE_NEWNS | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUSER |
+		syscall.CLONE_NEWPID | syscall.CLONE_NEWNET | syscall.CLONE_NEWCGROUP
+)
+
+//Profile is a seccomp profile in the docker format
+type Profile struct {
+	DefaultAction   string     `json:"defaultAction"`
+	DefaultErrnoRet *uint      `json:"defaultErrnoRet,omitempty"`
+	Architectures   []string   `json:"architectures,omitempty"` //not used, only the native architecture is allowed
+	Syscalls        []*Syscall `json:"syscalls"`
+}
+
+//Syscall an action for a list of syscalls, optionally only when the arguments match
+type Syscall struct {
+	Name     string   `json:"name,omitempty"` //old format, a single syscall
+	Names    []string `json:"names,omitempty"`
+	Action   string   `json:"action"`
+	ErrnoRet *uint    `json:"errnoRet,omitempty"`
+	Args     []*Arg   `json:"args"`
+	Comment  string   `json:"comment,omitempty"`
+	Includes Filter   `json:"includes"` //the rule is only used if the container matches all of the filter
+	Excludes Filter   `json:"excludes"` //the rule is not used if the container matches any of the filter
+}
+
+//Filter conditions on the container capabilities and the node architecture
+type Filter struct {
+	Caps   []string `json:"caps,omitempty"`
+	Arches []string `json:"arches,omitempty"`
+}
+
+//Arg a condition on a syscall argument, for SCMP_CMP_MASKED_EQ value is the mask and valueTwo the expected value
+type Arg struct {
+	Index    uint   `json:"index"`
+	Value    uint64 `json:"value"`
+	ValueTwo uint64 `json:"valueTwo"`
+	Op       string `json:"op"`
+}
+
+//Parse parses and validates a profile
+func Parse(data []byte) (*Profile, error) {
+	var profile Profile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, fmt.Errorf("invalid seccomp profile: %s", err)
+	}
+
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &profile, nil
+}
+
+func validAction(action string) bool {
+	switch action {
+	case ActKill, ActKillThread, ActKillProcess, ActTrap, ActErrno, ActTrace, ActLog, ActAllow:
+		return true
+	}
+
+	return false
+}
+
+func (p *Profile) Validate() error {
+	if !validAction(p.DefaultAction) {
+		return fmt.Errorf("invalid seccomp default action '%s'", p.DefaultAction)
+	}
+
+	for _, call := range p.Syscalls {
+		if len(call.Name) == 0 && len(call.Names) == 0 {
+			return fmt.Errorf("seccomp rule has no syscall names")
+		}
+
+		if !validAction(call.Action) {
+			return fmt.Errorf("invalid seccomp action '%s'", call.Action)
+		}
+
+		if len(call.Args) > maxArgs {
+			return fmt.Errorf("seccomp rule has too many argument conditions")
+		}
+
+		for _, arg := range call.Args {
+			if arg.Index >= maxArgs {
+				return fmt.Errorf("invalid seccomp argument index '%d'", arg.Index)
+			}
+
+			switch arg.Op {
+			case OpNotEqual, OpLessThan, OpLessEqual, OpEqualTo, OpGreaterEqual, OpGreaterThan, OpMaskedEqual:
+			default:
+				return fmt.Errorf("invalid seccomp operator '%s'", arg.Op)
+			}
+		}
+	}
+
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+//applies checks the includes and excludes filters of the rule
+func (s *Syscall) applies(caps []string) bool {
+	for _, c := range s.Includes.Caps {
+		if !contains(caps, c) {
+			return false
+		}
+	}
+
+	if len(s.Includes.Arches) != 0 && !contains(s.Includes.Arches, runtime.GOARCH) {
+		return false
+	}
+
+	for _, c := range s.Excludes.Caps {
+		if contains(caps, c) {
+			return false
+		}
+	}
+
+	return !contains(s.Excludes.Arches, runtime.GOARCH)
+}
+
+func (s *Syscall) names() []string {
+	if len(s.Name) != 0 {
+		return append([]string{s.Name}, s.Names...)
+	}
+
+	return s.Names
+}
+
+//denied are the syscalls denied by the default profile, with the capabilities that allow them
+var denied = []struct {
+	names []string
+	caps  []string
+}{
+	{[]string{"add_key", "keyctl", "request_key", "userfaultfd", "uselib", "ustat", "sysfs", "_sysctl",
+		"create_module", "get_kernel_syms", "query_module", "nfsservctl", "vm86", "vm86old"}, nil},
+	{[]string{"bpf", "fanotify_init", "lookup_dcookie", "mount", "umount2", "pivot_root", "name_to_handle_at",
+		"perf_event_open", "quotactl", "setdomainname", "sethostname", "setns", "unshare", "swapon", "swapoff"},
+		[]string{"CAP_SYS_ADMIN"}},
+	{[]string{"open_by_handle_at"}, []string{"CAP_DAC_READ_SEARCH"}},
+	{[]string{"reboot", "kexec_load", "kexec_file_load"}, []string{"CAP_SYS_BOOT"}},
+	{[]string{"init_module", "finit_module", "delete_module"}, []string{"CAP_SYS_MODULE"}},
+	{[]string{"acct"}, []string{"CAP_SYS_PACCT"}},
+	{[]string{"ptrace", "process_vm_readv", "process_vm_writev", "kcmp"}, []string{"CAP_SYS_PTRACE"}},
+	{[]string{"iopl", "ioperm"}, []string{"CAP_SYS_RAWIO"}},
+	{[]string{"settimeofday", "clock_settime", "clock_adjtime", "stime"}, []string{"CAP_SYS_TIME"}},
+	{[]string{"mbind", "set_mempolicy", "move_pages"}, []string{"CAP_SYS_NICE"}},
+	{[]string{"syslog"}, []string{"CAP_SYSLOG"}},
+}
+
+/*
+DefaultProfile allows all syscalls except the ones that give access to the kernel or the node (modules, keyring,
+mounts, namespaces, clock, ...). A syscall is allowed again if the container is given the capability it requires,
+ex: mount, unshare and clone with namespace flags are allowed with CAP_SYS_ADMIN.
+*/
+func DefaultProfile() *Profile {
+	profile := &Profile{
+		DefaultAction: ActAllow,
+	}
+
+	for _, deny := range denied {
+		profile.Syscalls = append(profile.Syscalls, &Syscall{
+			Names:    deny.names,
+			Action:   ActErrno,
+			Excludes: Filter{Caps: deny.caps},
+		})
+	}
+
+	/*
+		like unshare, clone can only create namespaces with CAP_SYS_ADMIN. The clone3 flags are in a struct
+		that can't be checked, so it fails with ENOSYS and the libc falls back to clone.
+	*/
+	enosys := uint(syscall.ENOSYS)
+	admin := Filter{Caps: []string{"CAP_SYS_ADMIN"}}
+	profile.Syscalls = append(profile.Syscalls,
+		&Syscall{
+			Names:    []string{"clone"},
+			Action:   ActAllow,
+			Args:     []*Arg{{Index: 0, Value: cloneNamespaces, ValueTwo: 0, Op: OpMaskedEqual}},
+			Excludes: admin,
+		},
+		&Syscall{
+			Names:    []string{"clone"},
+			Action:   ActErrno,
+			Excludes: admin,
+		},
+		&Syscall{
+			Names:    []string{"clone3"},
+			Action:   ActErrno,
+			ErrnoRet: &enosys,
+			Excludes: admin,
+		},
+	)
+
+	return profile
+}
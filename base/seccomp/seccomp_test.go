@@ -0,0 +1,149 @@
+package seccomp
+
+import (
+	"encoding/binary"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//run executes the filter the way the kernel does for a syscall with the given arguments
+func run(t *testing.T, filter []instruction, arch uint32, nr uintptr, args ...uint64) uint32 {
+	data := make([]byte, offsetArgs+8*maxArgs)
+	binary.LittleEndian.PutUint32(data[offsetNR:], uint32(nr))
+	binary.LittleEndian.PutUint32(data[offsetArch:], arch)
+	for i, arg := range args {
+		binary.LittleEndian.PutUint64(data[offsetArgs+8*i:], arg)
+	}
+
+	var acc uint32
+	for pc := 0; pc < len(filter); pc++ {
+		ins := filter[pc]
+		switch ins.Code {
+		case bpfLD | bpfW | bpfABS:
+			acc = binary.LittleEndian.Uint32(data[ins.K:])
+		case bpfALU | bpfAND | bpfK:
+			acc &= ins.K
+		case bpfRET | bpfK:
+			return ins.K
+		case bpfJMP | bpfJEQ | bpfK, bpfJMP | bpfJGT | bpfK, bpfJMP | bpfJGE | bpfK:
+			var cond bool
+			switch ins.Code &^ (bpfJMP | bpfK) {
+			case bpfJEQ:
+				cond = acc == ins.K
+			case bpfJGT:
+				cond = acc > ins.K
+			case bpfJGE:
+				cond = acc >= ins.K
+			}
+
+			if cond {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		default:
+			t.Fatalf("unexpected instruction %x", ins.Code)
+		}
+	}
+
+	t.Fatal("filter did not return")
+	return 0
+}
+
+func compile(t *testing.T, profile *Profile, caps ...string) []instruction {
+	if !assert.NoError(t, profile.Validate()) {
+		t.FailNow()
+	}
+
+	filter, err := profile.compile(caps)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return filter
+}
+
+func TestDefaultProfile(t *testing.T) {
+	filter := compile(t, DefaultProfile())
+	eperm := uint32(retErrno | uint32(syscall.EPERM))
+
+	assert.Equal(t, uint32(retAllow), run(t, filter, auditArch, syscalls["read"]))
+	assert.Equal(t, eperm, run(t, filter, auditArch, syscalls["mount"]))
+	assert.Equal(t, eperm, run(t, filter, auditArch, syscalls["keyctl"]))
+	assert.Equal(t, uint32(retKillProcess), run(t, filter, 0x40000003, syscalls["read"]))
+
+	//x32 syscalls would skip all the rules
+	assert.Equal(t, uint32(retKillProcess), run(t, filter, auditArch, x32SyscallBit|syscalls["unshare"]))
+
+	assert.Equal(t, uint32(retAllow), run(t, filter, auditArch, syscalls["clone"], uint64(syscall.SIGCHLD)))
+	assert.Equal(t, eperm, run(t, filter, auditArch, syscalls["clone"], syscall.CLONE_NEWUSER|uint64(syscall.SIGCHLD)))
+	assert.Equal(t, eperm, run(t, filter, auditArch, syscalls["clone"], syscall.CLONE_NEWCGROUP))
+	assert.Equal(t, uint32(retErrno|uint32(syscall.ENOSYS)), run(t, filter, auditArch, syscalls["clone3"]))
+
+	//mount is allowed with CAP_SYS_ADMIN, keyctl never is
+	filter = compile(t, DefaultProfile(), "CAP_SYS_ADMIN")
+	assert.Equal(t, uint32(retAllow), run(t, filter, auditArch, syscalls["mount"]))
+	assert.Equal(t, eperm, run(t, filter, auditArch, syscalls["keyctl"]))
+	assert.Equal(t, uint32(retAllow), run(t, filter, auditArch, syscalls["clone"], syscall.CLONE_NEWNET))
+	assert.Equal(t, uint32(retAllow), run(t, filter, auditArch, syscalls["clone3"]))
+}
+
+func TestParseProfile(t *testing.T) {
+	profile, err := Parse([]byte(`{
+		"defaultAction": "SCMP_ACT_ERRNO",
+		"defaultErrnoRet": 38,
+		"syscalls": [
+			{"names": ["read", "write", "not_a_syscall"], "action": "SCMP_ACT_ALLOW"},
+			{"name": "kill", "action": "SCMP_ACT_ERRNO", "errnoRet": 1},
+			{"names": ["personality"], "action": "SCMP_ACT_ALLOW", "args": [{"index": 0, "value": 8, "op": "SCMP_CMP_EQ"}]},
+			{"names": ["clone"], "action": "SCMP_ACT_ALLOW", "args": [{"index": 0, "value": 2114060288, "valueTwo": 0, "op": "SCMP_CMP_MASKED_EQ"}]},
+			{"names": ["socket"], "action": "SCMP_ACT_ALLOW", "args": [{"index": 0, "value": 40, "op": "SCMP_CMP_NE"}]},
+			{"names": ["lseek"], "action": "SCMP_ACT_ALLOW", "args": [{"index": 1, "value": 4294967296, "op": "SCMP_CMP_LT"}]},
+			{"names": ["dup2"], "action": "SCMP_ACT_ALLOW", "args": [{"index": 1, "value": 3, "op": "SCMP_CMP_GE"}]}
+		]
+	}`))
+
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	filter := compile(t, profile)
+	enosys := uint32(retErrno | 38)
+
+	assert.Equal(t, uint32(retAllow), run(t, filter, auditArch, syscalls["read"]))
+	assert.Equal(t, uint32(retAllow), run(t, filter, auditArch, syscalls["write"]))
+	assert.Equal(t, enosys, run(t, filter, auditArch, syscalls["open"]))
+	assert.Equal(t, uint32(retErrno|1), run(t, filter, auditArch, syscalls["kill"]))
+
+	assert.Equal(t, uint32(retAllow), run(t, filter, auditArch, syscalls["personality"], 8))
+	assert.Equal(t, enosys, run(t, filter, auditArch, syscalls["personality"], 8|1<<32))
+
+	assert.Equal(t, uint32(retAllow), run(t, filter, auditArch, syscalls["clone"], uint64(syscall.SIGCHLD)))
+	assert.Equal(t, enosys, run(t, filter, auditArch, syscalls["clone"], syscall.CLONE_NEWUSER))
+
+	assert.Equal(t, uint32(retAllow), run(t, filter, auditArch, syscalls["socket"], 2))
+	assert.Equal(t, enosys, run(t, filter, auditArch, syscalls["socket"], 40))
+
+	assert.Equal(t, uint32(retAllow), run(t, filter, auditArch, syscalls["lseek"], 0, 1<<32-1))
+	assert.Equal(t, enosys, run(t, filter, auditArch, syscalls["lseek"], 0, 1<<32))
+
+	assert.Equal(t, uint32(retAllow), run(t, filter, auditArch, syscalls["dup2"], 0, 3))
+	assert.Equal(t, uint32(retAllow), run(t, filter, auditArch, syscalls["dup2"], 0, 1<<33))
+	assert.Equal(t, enosys, run(t, filter, auditArch, syscalls["dup2"], 0, 2))
+}
+
+func TestParseInvalidProfile(t *testing.T) {
+	_, err := Parse([]byte(`{"defaultAction": "SCMP_ACT_NOPE"}`))
+	assert.Error(t, err)
+
+	_, err = Parse([]byte(`{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["read"], "action": "SCMP_ACT_ERRNO", "args": [{"index": 6, "op": "SCMP_CMP_EQ"}]}]}`))
+	assert.Error(t, err)
+
+	_, err = Parse([]byte(`{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["read"], "action": "SCMP_ACT_ERRNO", "args": [{"index": 0, "op": "SCMP_CMP_LIKE"}]}]}`))
+	assert.Error(t, err)
+
+	_, err = Parse([]byte(`{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"action": "SCMP_ACT_ERRNO"}]}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,345 @@
+package seccomp
+
+import "golang.org/x/sys/unix"
+
+const (
+	//auditArch AUDIT_ARCH_X86_64
+	auditArch = 0xc000003e
+)
+
+//syscalls numbers by name
+var syscalls = map[string]uintptr{
+	"read":                   unix.SYS_READ,
+	"write":                  unix.SYS_WRITE,
+	"open":                   unix.SYS_OPEN,
+	"close":                  unix.SYS_CLOSE,
+	"stat":                   unix.SYS_STAT,
+	"fstat":                  unix.SYS_FSTAT,
+	"lstat":                  unix.SYS_LSTAT,
+	"poll":                   unix.SYS_POLL,
+	"lseek":                  unix.SYS_LSEEK,
+	"mmap":                   unix.SYS_MMAP,
+	"mprotect":               unix.SYS_MPROTECT,
+	"munmap":                 unix.SYS_MUNMAP,
+	"brk":                    unix.SYS_BRK,
+	"rt_sigaction":           unix.SYS_RT_SIGACTION,
+	"rt_sigprocmask":         unix.SYS_RT_SIGPROCMASK,
+	"rt_sigreturn":           unix.SYS_RT_SIGRETURN,
+	"ioctl":                  unix.SYS_IOCTL,
+	"pread64":                unix.SYS_PREAD64,
+	"pwrite64":               unix.SYS_PWRITE64,
+	"readv":                  unix.SYS_READV,
+	"writev":                 unix.SYS_WRITEV,
+	"access":                 unix.SYS_ACCESS,
+	"pipe":                   unix.SYS_PIPE,
+	"select":                 unix.SYS_SELECT,
+	"sched_yield":            unix.SYS_SCHED_YIELD,
+	"mremap":                 unix.SYS_MREMAP,
+	"msync":                  unix.SYS_MSYNC,
+	"mincore":                unix.SYS_MINCORE,
+	"madvise":                unix.SYS_MADVISE,
+	"shmget":                 unix.SYS_SHMGET,
+	"shmat":                  unix.SYS_SHMAT,
+	"shmctl":                 unix.SYS_SHMCTL,
+	"dup":                    unix.SYS_DUP,
+	"dup2":                   unix.SYS_DUP2,
+	"pause":                  unix.SYS_PAUSE,
+	"nanosleep":              unix.SYS_NANOSLEEP,
+	"getitimer":              unix.SYS_GETITIMER,
+	"alarm":                  unix.SYS_ALARM,
+	"setitimer":              unix.SYS_SETITIMER,
+	"getpid":                 unix.SYS_GETPID,
+	"sendfile":               unix.SYS_SENDFILE,
+	"socket":                 unix.SYS_SOCKET,
+	"connect":                unix.SYS_CONNECT,
+	"accept":                 unix.SYS_ACCEPT,
+	"sendto":                 unix.SYS_SENDTO,
+	"recvfrom":               unix.SYS_RECVFROM,
+	"sendmsg":                unix.SYS_SENDMSG,
+	"recvmsg":                unix.SYS_RECVMSG,
+	"shutdown":               unix.SYS_SHUTDOWN,
+	"bind":                   unix.SYS_BIND,
+	"listen":                 unix.SYS_LISTEN,
+	"getsockname":            unix.SYS_GETSOCKNAME,
+	"getpeername":            unix.SYS_GETPEERNAME,
+	"socketpair":             unix.SYS_SOCKETPAIR,
+	"setsockopt":             unix.SYS_SETSOCKOPT,
+	"getsockopt":             unix.SYS_GETSOCKOPT,
+	"clone":                  unix.SYS_CLONE,
+	"fork":                   unix.SYS_FORK,
+	"vfork":                  unix.SYS_VFORK,
+	"execve":                 unix.SYS_EXECVE,
+	"exit":                   unix.SYS_EXIT,
+	"wait4":                  unix.SYS_WAIT4,
+	"kill":                   unix.SYS_KILL,
+	"uname":                  unix.SYS_UNAME,
+	"semget":                 unix.SYS_SEMGET,
+	"semop":                  unix.SYS_SEMOP,
+	"semctl":                 unix.SYS_SEMCTL,
+	"shmdt":                  unix.SYS_SHMDT,
+	"msgget":                 unix.SYS_MSGGET,
+	"msgsnd":                 unix.SYS_MSGSND,
+	"msgrcv":                 unix.SYS_MSGRCV,
+	"msgctl":                 unix.SYS_MSGCTL,
+	"fcntl":                  unix.SYS_FCNTL,
+	"flock":                  unix.SYS_FLOCK,
+	"fsync":                  unix.SYS_FSYNC,
+	"fdatasync":              unix.SYS_FDATASYNC,
+	"truncate":               unix.SYS_TRUNCATE,
+	"ftruncate":              unix.SYS_FTRUNCATE,
+	"getdents":               unix.SYS_GETDENTS,
+	"getcwd":                 unix.SYS_GETCWD,
+	"chdir":                  unix.SYS_CHDIR,
+	"fchdir":                 unix.SYS_FCHDIR,
+	"rename":                 unix.SYS_RENAME,
+	"mkdir":                  unix.SYS_MKDIR,
+	"rmdir":                  unix.SYS_RMDIR,
+	"creat":                  unix.SYS_CREAT,
+	"link":                   unix.SYS_LINK,
+	"unlink":                 unix.SYS_UNLINK,
+	"symlink":                unix.SYS_SYMLINK,
+	"readlink":               unix.SYS_READLINK,
+	"chmod":                  unix.SYS_CHMOD,
+	"fchmod":                 unix.SYS_FCHMOD,
+	"chown":                  unix.SYS_CHOWN,
+	"fchown":                 unix.SYS_FCHOWN,
+	"lchown":                 unix.SYS_LCHOWN,
+	"umask":                  unix.SYS_UMASK,
+	"gettimeofday":           unix.SYS_GETTIMEOFDAY,
+	"getrlimit":              unix.SYS_GETRLIMIT,
+	"getrusage":              unix.SYS_GETRUSAGE,
+	"sysinfo":                unix.SYS_SYSINFO,
+	"times":                  unix.SYS_TIMES,
+	"ptrace":                 unix.SYS_PTRACE,
+	"getuid":                 unix.SYS_GETUID,
+	"syslog":                 unix.SYS_SYSLOG,
+	"getgid":                 unix.SYS_GETGID,
+	"setuid":                 unix.SYS_SETUID,
+	"setgid":                 unix.SYS_SETGID,
+	"geteuid":                unix.SYS_GETEUID,
+	"getegid":                unix.SYS_GETEGID,
+	"setpgid":                unix.SYS_SETPGID,
+	"getppid":                unix.SYS_GETPPID,
+	"getpgrp":                unix.SYS_GETPGRP,
+	"setsid":                 unix.SYS_SETSID,
+	"setreuid":               unix.SYS_SETREUID,
+	"setregid":               unix.SYS_SETREGID,
+	"getgroups":              unix.SYS_GETGROUPS,
+	"setgroups":              unix.SYS_SETGROUPS,
+	"setresuid":              unix.SYS_SETRESUID,
+	"getresuid":              unix.SYS_GETRESUID,
+	"setresgid":              unix.SYS_SETRESGID,
+	"getresgid":              unix.SYS_GETRESGID,
+	"getpgid":                unix.SYS_GETPGID,
+	"setfsuid":               unix.SYS_SETFSUID,
+	"setfsgid":               unix.SYS_SETFSGID,
+	"getsid":                 unix.SYS_GETSID,
+	"capget":                 unix.SYS_CAPGET,
+	"capset":                 unix.SYS_CAPSET,
+	"rt_sigpending":          unix.SYS_RT_SIGPENDING,
+	"rt_sigtimedwait":        unix.SYS_RT_SIGTIMEDWAIT,
+	"rt_sigqueueinfo":        unix.SYS_RT_SIGQUEUEINFO,
+	"rt_sigsuspend":          unix.SYS_RT_SIGSUSPEND,
+	"sigaltstack":            unix.SYS_SIGALTSTACK,
+	"utime":                  unix.SYS_UTIME,
+	"mknod":                  unix.SYS_MKNOD,
+	"uselib":                 unix.SYS_USELIB,
+	"personality":            unix.SYS_PERSONALITY,
+	"ustat":                  unix.SYS_USTAT,
+	"statfs":                 unix.SYS_STATFS,
+	"fstatfs":                unix.SYS_FSTATFS,
+	"sysfs":                  unix.SYS_SYSFS,
+	"getpriority":            unix.SYS_GETPRIORITY,
+	"setpriority":            unix.SYS_SETPRIORITY,
+	"sched_setparam":         unix.SYS_SCHED_SETPARAM,
+	"sched_getparam":         unix.SYS_SCHED_GETPARAM,
+	"sched_setscheduler":     unix.SYS_SCHED_SETSCHEDULER,
+	"sched_getscheduler":     unix.SYS_SCHED_GETSCHEDULER,
+	"sched_get_priority_max": unix.SYS_SCHED_GET_PRIORITY_MAX,
+	"sched_get_priority_min": unix.SYS_SCHED_GET_PRIORITY_MIN,
+	"sched_rr_get_interval":  unix.SYS_SCHED_RR_GET_INTERVAL,
+	"mlock":                  unix.SYS_MLOCK,
+	"munlock":                unix.SYS_MUNLOCK,
+	"mlockall":               unix.SYS_MLOCKALL,
+	"munlockall":             unix.SYS_MUNLOCKALL,
+	"vhangup":                unix.SYS_VHANGUP,
+	"modify_ldt":             unix.SYS_MODIFY_LDT,
+	"pivot_root":             unix.SYS_PIVOT_ROOT,
+	"_sysctl":                unix.SYS__SYSCTL,
+	"prctl":                  unix.SYS_PRCTL,
+	"arch_prctl":             unix.SYS_ARCH_PRCTL,
+	"adjtimex":               unix.SYS_ADJTIMEX,
+	"setrlimit":              unix.SYS_SETRLIMIT,
+	"chroot":                 unix.SYS_CHROOT,
+	"sync":                   unix.SYS_SYNC,
+	"acct":                   unix.SYS_ACCT,
+	"settimeofday":           unix.SYS_SETTIMEOFDAY,
+	"mount":                  unix.SYS_MOUNT,
+	"umount2":                unix.SYS_UMOUNT2,
+	"swapon":                 unix.SYS_SWAPON,
+	"swapoff":                unix.SYS_SWAPOFF,
+	"reboot":                 unix.SYS_REBOOT,
+	"sethostname":            unix.SYS_SETHOSTNAME,
+	"setdomainname":          unix.SYS_SETDOMAINNAME,
+	"iopl":                   unix.SYS_IOPL,
+	"ioperm":                 unix.SYS_IOPERM,
+	"create_module":          unix.SYS_CREATE_MODULE,
+	"init_module":            unix.SYS_INIT_MODULE,
+	"delete_module":          unix.SYS_DELETE_MODULE,
+	"get_kernel_syms":        unix.SYS_GET_KERNEL_SYMS,
+	"query_module":           unix.SYS_QUERY_MODULE,
+	"quotactl":               unix.SYS_QUOTACTL,
+	"nfsservctl":             unix.SYS_NFSSERVCTL,
+	"getpmsg":                unix.SYS_GETPMSG,
+	"putpmsg":                unix.SYS_PUTPMSG,
+	"afs_syscall":            unix.SYS_AFS_SYSCALL,
+	"tuxcall":                unix.SYS_TUXCALL,
+	"security":               unix.SYS_SECURITY,
+	"gettid":                 unix.SYS_GETTID,
+	"readahead":              unix.SYS_READAHEAD,
+	"setxattr":               unix.SYS_SETXATTR,
+	"lsetxattr":              unix.SYS_LSETXATTR,
+	"fsetxattr":              unix.SYS_FSETXATTR,
+	"getxattr":               unix.SYS_GETXATTR,
+	"lgetxattr":              unix.SYS_LGETXATTR,
+	"fgetxattr":              unix.SYS_FGETXATTR,
+	"listxattr":              unix.SYS_LISTXATTR,
+	"llistxattr":             unix.SYS_LLISTXATTR,
+	"flistxattr":             unix.SYS_FLISTXATTR,
+	"removexattr":            unix.SYS_REMOVEXATTR,
+	"lremovexattr":           unix.SYS_LREMOVEXATTR,
+	"fremovexattr":           unix.SYS_FREMOVEXATTR,
+	"tkill":                  unix.SYS_TKILL,
+	"time":                   unix.SYS_TIME,
+	"futex":                  unix.SYS_FUTEX,
+	"sched_setaffinity":      unix.SYS_SCHED_SETAFFINITY,
+	"sched_getaffinity":      unix.SYS_SCHED_GETAFFINITY,
+	"set_thread_area":        unix.SYS_SET_THREAD_AREA,
+	"io_setup":               unix.SYS_IO_SETUP,
+	"io_destroy":             unix.SYS_IO_DESTROY,
+	"io_getevents":           unix.SYS_IO_GETEVENTS,
+	"io_submit":              unix.SYS_IO_SUBMIT,
+	"io_cancel":              unix.SYS_IO_CANCEL,
+	"get_thread_area":        unix.SYS_GET_THREAD_AREA,
+	"lookup_dcookie":         unix.SYS_LOOKUP_DCOOKIE,
+	"epoll_create":           unix.SYS_EPOLL_CREATE,
+	"epoll_ctl_old":          unix.SYS_EPOLL_CTL_OLD,
+	"epoll_wait_old":         unix.SYS_EPOLL_WAIT_OLD,
+	"remap_file_pages":       unix.SYS_REMAP_FILE_PAGES,
+	"getdents64":             unix.SYS_GETDENTS64,
+	"set_tid_address":        unix.SYS_SET_TID_ADDRESS,
+	"restart_syscall":        unix.SYS_RESTART_SYSCALL,
+	"semtimedop":             unix.SYS_SEMTIMEDOP,
+	"fadvise64":              unix.SYS_FADVISE64,
+	"timer_create":           unix.SYS_TIMER_CREATE,
+	"timer_settime":          unix.SYS_TIMER_SETTIME,
+	"timer_gettime":          unix.SYS_TIMER_GETTIME,
+	"timer_getoverrun":       unix.SYS_TIMER_GETOVERRUN,
+	"timer_delete":           unix.SYS_TIMER_DELETE,
+	"clock_settime":          unix.SYS_CLOCK_SETTIME,
+	"clock_gettime":          unix.SYS_CLOCK_GETTIME,
+	"clock_getres":           unix.SYS_CLOCK_GETRES,
+	"clock_nanosleep":        unix.SYS_CLOCK_NANOSLEEP,
+	"exit_group":             unix.SYS_EXIT_GROUP,
+	"epoll_wait":             unix.SYS_EPOLL_WAIT,
+	"epoll_ctl":              unix.SYS_EPOLL_CTL,
+	"tgkill":                 unix.SYS_TGKILL,
+	"utimes":                 unix.SYS_UTIMES,
+	"vserver":                unix.SYS_VSERVER,
+	"mbind":                  unix.SYS_MBIND,
+	"set_mempolicy":          unix.SYS_SET_MEMPOLICY,
+	"get_mempolicy":          unix.SYS_GET_MEMPOLICY,
+	"mq_open":                unix.SYS_MQ_OPEN,
+	"mq_unlink":              unix.SYS_MQ_UNLINK,
+	"mq_timedsend":           unix.SYS_MQ_TIMEDSEND,
+	"mq_timedreceive":        unix.SYS_MQ_TIMEDRECEIVE,
+	"mq_notify":              unix.SYS_MQ_NOTIFY,
+	"mq_getsetattr":          unix.SYS_MQ_GETSETATTR,
+	"kexec_load":             unix.SYS_KEXEC_LOAD,
+	"waitid":                 unix.SYS_WAITID,
+	"add_key":                unix.SYS_ADD_KEY,
+	"request_key":            unix.SYS_REQUEST_KEY,
+	"keyctl":                 unix.SYS_KEYCTL,
+	"ioprio_set":             unix.SYS_IOPRIO_SET,
+	"ioprio_get":             unix.SYS_IOPRIO_GET,
+	"inotify_init":           unix.SYS_INOTIFY_INIT,
+	"inotify_add_watch":      unix.SYS_INOTIFY_ADD_WATCH,
+	"inotify_rm_watch":       unix.SYS_INOTIFY_RM_WATCH,
+	"migrate_pages":          unix.SYS_MIGRATE_PAGES,
+	"openat":                 unix.SYS_OPENAT,
+	"mkdirat":                unix.SYS_MKDIRAT,
+	"mknodat":                unix.SYS_MKNODAT,
+	"fchownat":               unix.SYS_FCHOWNAT,
+	"futimesat":              unix.SYS_FUTIMESAT,
+	"newfstatat":             unix.SYS_NEWFSTATAT,
+	"unlinkat":               unix.SYS_UNLINKAT,
+	"renameat":               unix.SYS_RENAMEAT,
+	"linkat":                 unix.SYS_LINKAT,
+	"symlinkat":              unix.SYS_SYMLINKAT,
+	"readlinkat":             unix.SYS_READLINKAT,
+	"fchmodat":               unix.SYS_FCHMODAT,
+	"faccessat":              unix.SYS_FACCESSAT,
+	"pselect6":               unix.SYS_PSELECT6,
+	"ppoll":                  unix.SYS_PPOLL,
+	"unshare":                unix.SYS_UNSHARE,
+	"set_robust_list":        unix.SYS_SET_ROBUST_LIST,
+	"get_robust_list":        unix.SYS_GET_ROBUST_LIST,
+	"splice":                 unix.SYS_SPLICE,
+	"tee":                    unix.SYS_TEE,
+	"sync_file_range":        unix.SYS_SYNC_FILE_RANGE,
+	"vmsplice":               unix.SYS_VMSPLICE,
+	"move_pages":             unix.SYS_MOVE_PAGES,
+	"utimensat":              unix.SYS_UTIMENSAT,
+	"epoll_pwait":            unix.SYS_EPOLL_PWAIT,
+	"signalfd":               unix.SYS_SIGNALFD,
+	"timerfd_create":         unix.SYS_TIMERFD_CREATE,
+	"eventfd":                unix.SYS_EVENTFD,
+	"fallocate":              unix.SYS_FALLOCATE,
+	"timerfd_settime":        unix.SYS_TIMERFD_SETTIME,
+	"timerfd_gettime":        unix.SYS_TIMERFD_GETTIME,
+	"accept4":                unix.SYS_ACCEPT4,
+	"signalfd4":              unix.SYS_SIGNALFD4,
+	"eventfd2":               unix.SYS_EVENTFD2,
+	"epoll_create1":          unix.SYS_EPOLL_CREATE1,
+	"dup3":                   unix.SYS_DUP3,
+	"pipe2":                  unix.SYS_PIPE2,
+	"inotify_init1":          unix.SYS_INOTIFY_INIT1,
+	"preadv":                 unix.SYS_PREADV,
+	"pwritev":                unix.SYS_PWRITEV,
+	"rt_tgsigqueueinfo":      unix.SYS_RT_TGSIGQUEUEINFO,
+	"perf_event_open":        unix.SYS_PERF_EVENT_OPEN,
+	"recvmmsg":               unix.SYS_RECVMMSG,
+	"fanotify_init":          unix.SYS_FANOTIFY_INIT,
+	"fanotify_mark":          unix.SYS_FANOTIFY_MARK,
+	"prlimit64":              unix.SYS_PRLIMIT64,
+	"name_to_handle_at":      unix.SYS_NAME_TO_HANDLE_AT,
+	"open_by_handle_at":      unix.SYS_OPEN_BY_HANDLE_AT,
+	"clock_adjtime":          unix.SYS_CLOCK_ADJTIME,
+	"syncfs":                 unix.SYS_SYNCFS,
+	"sendmmsg":               unix.SYS_SENDMMSG,
+	"setns":                  unix.SYS_SETNS,
+	"getcpu":                 unix.SYS_GETCPU,
+	"process_vm_readv":       unix.SYS_PROCESS_VM_READV,
+	"process_vm_writev":      unix.SYS_PROCESS_VM_WRITEV,
+	"kcmp":                   unix.SYS_KCMP,
+	"finit_module":           unix.SYS_FINIT_MODULE,
+	"sched_setattr":          unix.SYS_SCHED_SETATTR,
+	"sched_getattr":          unix.SYS_SCHED_GETATTR,
+	"renameat2":              unix.SYS_RENAMEAT2,
+	"seccomp":                unix.SYS_SECCOMP,
+	"getrandom":              unix.SYS_GETRANDOM,
+	"memfd_create":           unix.SYS_MEMFD_CREATE,
+	"kexec_file_load":        unix.SYS_KEXEC_FILE_LOAD,
+	"bpf":                    unix.SYS_BPF,
+	"execveat":               unix.SYS_EXECVEAT,
+	"userfaultfd":            unix.SYS_USERFAULTFD,
+	"membarrier":             unix.SYS_MEMBARRIER,
+	"mlock2":                 unix.SYS_MLOCK2,
+	"copy_file_range":        unix.SYS_COPY_FILE_RANGE,
+	"preadv2":                unix.SYS_PREADV2,
+	"pwritev2":               unix.SYS_PWRITEV2,
+	"pkey_mprotect":          unix.SYS_PKEY_MPROTECT,
+	"pkey_alloc":             unix.SYS_PKEY_ALLOC,
+	"pkey_free":              unix.SYS_PKEY_FREE,
+	"clone3":                 435, //not in the vendored x/sys
+}
@@ -0,0 +1,10 @@
+// +build !amd64
+
+package seccomp
+
+const (
+	//auditArch is not known, filters can't be installed
+	auditArch = 0
+)
+
+var syscalls = map[string]uintptr{}
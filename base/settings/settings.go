@@ -1,6 +1,10 @@
 package settings
 
 import (
+	"fmt"
+	"path"
+	"strings"
+
 	"github.com/op/go-logging"
 	"github.com/zero-os/0-core/base/utils"
 )
@@ -44,6 +48,47 @@ type Security struct {
 	ClientCertificateKey string
 }
 
+//SinkQueue an extra named ingress queue on the command sink
+type SinkQueue struct {
+	//Organization (itsyouonline) that is allowed to push to this queue and read its results
+	Organization string `json:"organization"`
+	//MaxJobs max number of jobs from this queue that can run concurrently (0 means no limit)
+	MaxJobs int `json:"max_jobs"`
+}
+
+//SinkLimits rate limits and quotas of the command sink
+type SinkLimits struct {
+	//Rate max number of commands per second a client identity (token or ip) can push (0 means no limit)
+	Rate float64 `json:"rate"`
+	//Burst number of commands a client identity can push at once before the rate applies
+	Burst int `json:"burst"`
+	//MaxQueueLength max number of pending commands on each sink queue (0 means no limit)
+	MaxQueueLength int64 `json:"max_queue_length"`
+	//Commands max number of concurrent jobs per command name
+	Commands map[string]int `json:"commands"`
+}
+
+//LocalRule grants local users (by uid) or groups (by gid) access to commands over the local socket
+type LocalRule struct {
+	UIDs []uint32 `json:"uids"`
+	GIDs []uint32 `json:"gids"`
+	//Commands allowed command names, glob patterns like `info.*` are supported
+	Commands []string `json:"commands"`
+	//Containers allows dispatching the allowed commands to containers
+	Containers bool `json:"containers"`
+}
+
+//Upstream (call-home) transport, when set core0 connects to the upstream redis and pulls
+//commands from its node queue
+type Upstream struct {
+	//Address of the upstream redis (host:port)
+	Address  string `json:"address"`
+	Password string `json:"password"`
+	//Node identity, defaults to the mac address of the first physical nic
+	Node string `json:"node"`
+	TLS  bool   `json:"tls"`
+}
+
 type Globals map[string]string
 
 func (g Globals) Get(key string, def ...string) string {
@@ -76,10 +121,29 @@ type AppSettings struct {
 
 	Containers struct {
 		MaxCount int `json:"max_count"`
+		//LogSize max size of a container log file in MB before it's rotated
+		LogSize int64 `json:"log_size"`
+		//LogFiles number of rotated log files to keep per container
+		LogFiles int `json:"log_files"`
+		//DNS registers the containers on the default bridge dns, so containers can resolve each other
+		//by name, hostname or tag
+		DNS bool `json:"dns"`
+		//UserNSStart first host uid/gid of the subordinate range used for containers with a user namespace
+		UserNSStart uint32 `json:"userns_start"`
+		//UserNSSize number of uids/gids mapped for each container
+		UserNSSize uint32 `json:"userns_size"`
 	} `json:"containers"`
 	Stats struct {
 		Enabled bool `json:"enabled"`
 	} `json:"stats"`
+	Sink struct {
+		Queues map[string]SinkQueue `json:"queues"`
+		Limits SinkLimits           `json:"limits"`
+	} `json:"sink"`
+	Upstream Upstream `json:"upstream"`
+	Local    struct {
+		Rules []LocalRule `json:"rules"`
+	} `json:"local"`
 }
 
 var Settings AppSettings
@@ -89,7 +153,22 @@ func (s *AppSettings) Validate() []error {
 		s.Main.LogLevel = "info"
 	}
 
-	return nil
+	var errors []error
+	for name := range s.Sink.Queues {
+		if name == "" || name == "default" || name == "upstream" || strings.ContainsAny(name, ": ") {
+			errors = append(errors, fmt.Errorf("invalid sink queue name '%s'", name))
+		}
+	}
+
+	for _, rule := range s.Local.Rules {
+		for _, pattern := range rule.Commands {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errors = append(errors, fmt.Errorf("invalid local rule command pattern '%s': %s", pattern, err))
+			}
+		}
+	}
+
+	return errors
 }
 
 //GetSettings loads main settings from a filename
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+const (
+	maxSymlinks = 255
+)
+
+/*
+InRoot resolves the path p as if root was the file system root. Symlinks are followed inside
+the root, so an absolute link (or a link with enough ..) can't escape it. The last element of
+the path doesn't need to exist.
+*/
+func InRoot(root, p string) (string, error) {
+	root = path.Clean(root)
+	var resolved string
+	parts := strings.Split(path.Clean("/"+p), "/")
+	links := 0
+
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			if resolved == "." || resolved == "/" {
+				resolved = ""
+			}
+			continue
+		}
+
+		full := path.Join(root, resolved, part)
+		info, err := os.Lstat(full)
+		if os.IsNotExist(err) {
+			resolved = path.Join(resolved, part)
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = path.Join(resolved, part)
+			continue
+		}
+
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links")
+		}
+
+		target, err := os.Readlink(full)
+		if err != nil {
+			return "", err
+		}
+
+		if path.IsAbs(target) {
+			resolved = ""
+		}
+
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+
+	return path.Join(root, resolved), nil
+}
+
+/*
+OpenInRoot opens the path p resolved with InRoot. The resolved path is opened one element at a time
+without following symlinks, so it fails instead of escaping the root if a process that has access to the
+root replaces an element with a symlink after it was resolved.
+*/
+func OpenInRoot(root, p string, flag int, perm os.FileMode) (*os.File, error) {
+	resolved, err := InRoot(root, p)
+	if err != nil {
+		return nil, err
+	}
+
+	root = path.Clean(root)
+	dir, err := syscall.Open(root, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+
+	rel := strings.TrimPrefix(strings.TrimPrefix(resolved, root), "/")
+	if rel == "" {
+		return os.NewFile(uintptr(dir), resolved), nil
+	}
+
+	parts := strings.Split(rel, "/")
+	for _, part := range parts[:len(parts)-1] {
+		next, err := syscall.Openat(dir, part, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+		syscall.Close(dir)
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: resolved, Err: err}
+		}
+
+		dir = next
+	}
+
+	defer syscall.Close(dir)
+	fd, err := syscall.Openat(dir, parts[len(parts)-1], flag|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, uint32(perm))
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: resolved, Err: err}
+	}
+
+	return os.NewFile(uintptr(fd), resolved), nil
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInRoot(t *testing.T) {
+	root, err := ioutil.TempDir("", "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	os.MkdirAll(path.Join(root, "etc"), 0755)
+	os.Symlink("/etc", path.Join(root, "abs"))
+	os.Symlink("../../../../etc", path.Join(root, "etc", "rel"))
+
+	cases := map[string]string{
+		"/etc/passwd":            "/etc/passwd",
+		"etc/passwd":             "/etc/passwd",
+		"/../../etc/passwd":      "/etc/passwd",
+		"/abs/passwd":            "/etc/passwd",
+		"/etc/rel/passwd":        "/etc/passwd",
+		"/missing/../etc/passwd": "/etc/passwd",
+		"/":                      "",
+	}
+
+	for p, expected := range cases {
+		resolved, err := InRoot(root, p)
+		if ok := assert.NoError(t, err); !ok {
+			t.Fatal()
+		}
+
+		assert.Equal(t, path.Join(root, expected), resolved, p)
+	}
+
+	os.Symlink("loop", path.Join(root, "loop"))
+	_, err = InRoot(root, "/loop/file")
+	assert.Error(t, err)
+}
+
+func TestOpenInRoot(t *testing.T) {
+	root, err := ioutil.TempDir("", "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	os.MkdirAll(path.Join(root, "etc"), 0755)
+	ioutil.WriteFile(path.Join(root, "etc", "passwd"), []byte("root"), 0644)
+	os.Symlink("/etc", path.Join(root, "abs"))
+
+	file, err := OpenInRoot(root, "/abs/passwd", os.O_RDONLY, 0)
+	if ok := assert.NoError(t, err); !ok {
+		t.Fatal()
+	}
+
+	data, err := ioutil.ReadAll(file)
+	file.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "root", string(data))
+	assert.Equal(t, path.Join(root, "etc", "passwd"), file.Name())
+
+	file, err = OpenInRoot(root, "/etc/new", os.O_WRONLY|os.O_CREATE, 0600)
+	if assert.NoError(t, err) {
+		file.Close()
+	}
+	assert.True(t, Exists(path.Join(root, "etc", "new")))
+
+	file, err = OpenInRoot(root, "/", os.O_RDONLY, 0)
+	if assert.NoError(t, err) {
+		assert.Equal(t, root, file.Name())
+		file.Close()
+	}
+}
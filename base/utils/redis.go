@@ -5,14 +5,14 @@ import (
 	"time"
 )
 
-func NewRedisPool(network string, address string, password string) *redis.Pool {
+func NewRedisPool(network string, address string, password string, options ...redis.DialOption) *redis.Pool {
 	return &redis.Pool{
 		MaxIdle:     50,
 		MaxActive:   100,
 		IdleTimeout: 5 * time.Minute,
 		Dial: func() (redis.Conn, error) {
 			// the redis protocol should probably be made sett-able
-			c, err := redis.Dial(network, address)
+			c, err := redis.Dial(network, address, options...)
 			if err != nil {
 				return nil, err
 			}
@@ -9,10 +9,13 @@ import socket
 import logging
 import time
 import sys
+import gzip
 from . import typchk
 
 
 DefaultTimeout = 10  # seconds
+TransferChunkSize = 512 * 1024
+TransferWindow = 32  # max number of chunks waiting on the node on upload
 
 logger = logging.getLogger('g8core')
 
@@ -148,7 +151,7 @@ class Response:
     def __init__(self, client, id):
         self._client = client
         self._id = id
-        self._queue = 'result:{}'.format(id)
+        self._queue = client._result_queue(id)
 
     @property
     def id(self):
@@ -213,7 +216,7 @@ class Response:
         if not callable(callback):
             raise Exception('callback must be callable')
 
-        queue = 'stream:%s' % self.id
+        queue = self._client._job_queue('stream', self.id)
         r = self._client._redis
 
         # we can terminate quickly by checking if the process is not running and it has no queued output.
@@ -293,6 +296,44 @@ class JSONResponse(Response):
         return json.loads(result.data)
 
 
+class ExecResponse(Response):
+    def __init__(self, client, id, container):
+        super().__init__(client, id)
+        self._container = container
+
+    def write(self, data):
+        """
+        Write data to the process stdin (the job must be started with stdin=True)
+
+        :param data: bytes or str
+        """
+        if isinstance(data, str):
+            data = data.encode()
+        if data == b'':
+            return
+        self._client._redis.rpush(self._client._job_queue('stdin', self._id), data)
+
+    def close_input(self):
+        """
+        Close the process stdin
+        """
+        self._client._redis.rpush(self._client._job_queue('stdin', self._id), b'')
+
+    def resize(self, rows, cols):
+        """
+        Resize the process terminal (the job must be started with tty=True)
+
+        :param rows: number of rows
+        :param cols: number of columns
+        """
+        self._client.json('corex.exec_resize', {
+            'container': self._container,
+            'id': self._id,
+            'rows': rows,
+            'cols': cols,
+        })
+
+
 class InfoManager:
 
     def __init__(self, client):
@@ -426,6 +467,54 @@ class ProcessManager:
         return self._client.json('process.kill', args)
 
 
+def _push_transfer(client, response, reader, compression):
+    # pushes the data of reader to the transfer queue of the job, an empty chunk marks the end of data
+    queue = client._job_queue('transfer', response.id)
+    r = client._redis
+
+    while True:
+        chunk = reader.read(TransferChunkSize)
+        if chunk == b'':
+            break
+        if compression == 'gzip':
+            chunk = gzip.compress(chunk)
+        while r.llen(queue) >= TransferWindow and response.running:
+            time.sleep(0.1)
+        if not response.running:
+            break
+        r.rpush(queue, chunk)
+
+    r.rpush(queue, b'')
+    result = response.get()
+    if result.state != 'SUCCESS':
+        raise ResultError(msg='%s' % result.data, code=result.code)
+    return json.loads(result.data)
+
+
+def _pull_transfer(client, response, writer, compression):
+    # writes the chunks pushed by the job to the transfer queue to writer till the empty chunk
+    queue = client._job_queue('transfer', response.id)
+    r = client._redis
+
+    while True:
+        data = r.blpop(queue, 1)
+        if data is None:
+            if not response.running:
+                break
+            continue
+        chunk = data[1]
+        if chunk == b'':
+            break
+        if compression == 'gzip':
+            chunk = gzip.decompress(chunk)
+        writer.write(chunk)
+
+    result = response.get()
+    if result.state != 'SUCCESS':
+        raise ResultError(msg='%s' % result.data, code=result.code)
+    return json.loads(result.data)
+
+
 class FilesystemManager:
 
     def __init__(self, client):
@@ -657,6 +746,65 @@ class FilesystemManager:
         finally:
             file.close()
 
+    def _transfer_target(self):
+        # transfers are always handled by the node, files of containers are accessed from the host side
+        if isinstance(self._client, ContainerClient):
+            return self._client._client, self._client.container
+        return self._client, 0
+
+    def stream_upload(self, remote, reader, offset=0, compression=None, checksum=None, perm=0o0644):
+        """
+        Uploads a file in one job, the data is streamed over a dedicated transfer queue instead of
+        a command round trip per chunk
+
+        :param remote: remote file name
+        :param reader: an object that implements the read(size) method (typically a file descriptor)
+        :param offset: start writing at this offset (the remote file is truncated to offset first), a negative
+                       offset resumes the upload from the end of the remote file
+        :param compression: None or 'gzip' (each chunk is compressed separately)
+        :param checksum: optional md5 of the full remote file after the upload, upload fails if it doesn't match
+        :param perm: file permission in octet form (if the file is created)
+        :return: dict with offset, count (bytes uploaded), size (of the remote file) and md5 (of the remote file)
+        """
+        client, container = self._transfer_target()
+        args = {
+            'container': container,
+            'file': remote,
+            'offset': offset,
+            'compression': compression or '',
+            'checksum': checksum or '',
+            'perm': perm,
+        }
+
+        response = client.raw('filesystem.upload', args)
+        return _push_transfer(client, response, reader, compression)
+
+    def stream_download(self, remote, writer, offset=0, length=0, compression=None, chunk_size=TransferChunkSize):
+        """
+        Downloads a file in one job, the data is streamed over a dedicated transfer queue instead of
+        a command round trip per chunk
+
+        :param remote: remote file name
+        :param writer: an object the implements the write(bytes) interface (typical a file descriptor)
+        :param offset: start reading from this offset (to resume a download)
+        :param length: max number of bytes to download (0 means till the end of the file)
+        :param compression: None or 'gzip' (each chunk is compressed separately)
+        :param chunk_size: size of each chunk
+        :return: dict with offset, count (bytes downloaded), size (of the remote file) and md5 (of the downloaded bytes)
+        """
+        client, container = self._transfer_target()
+        args = {
+            'container': container,
+            'file': remote,
+            'offset': offset,
+            'length': length,
+            'compression': compression or '',
+            'chunk_size': chunk_size,
+        }
+
+        response = client.raw('filesystem.download', args)
+        return _pull_transfer(client, response, writer, compression)
+
 
 class BaseClient:
     _system_chk = typchk.Checker({
@@ -896,6 +1044,20 @@ class ContainerClient(BaseClient):
         }
     })
 
+    _exec_chk = typchk.Checker({
+        'container': int,
+        'id': typchk.Or(str, typchk.IsNone()),
+        'name': str,
+        'args': [str],
+        'dir': str,
+        'env': typchk.Or(typchk.Map(str, str), typchk.IsNone()),
+        'user': str,
+        'tty': bool,
+        'rows': int,
+        'cols': int,
+        'stdin': bool,
+    })
+
     def __init__(self, client, container):
         super().__init__(client.timeout)
 
@@ -959,6 +1121,55 @@ class ContainerClient(BaseClient):
         cmd_id = json.loads(result.data)
         return self._client.response_for(cmd_id)
 
+    def exec(self, command, args=None, dir='', env=None, user='', tty=False, rows=0, cols=0, stdin=False,
+             max_time=None, tags=None, id=None):
+        """
+        Execute a command inside the container, unlike system the process can run as another user and
+        in a terminal, and its stdin can be fed while it runs.
+
+        The job output is always streamed if tty or stdin are set (see Response.stream), with a terminal the
+        output is delivered in raw chunks instead of lines.
+
+        :param command: command to execute (ex: 'bash')
+        :param args: list of command arguments
+        :param dir: working directory of the process
+        :param env: dict of environment variables
+        :param user: user name or uid, optionally followed by :group or :gid (ex: 'www-data', '1000:1000')
+        :param tty: run the process in a pseudo terminal
+        :param rows: initial terminal rows
+        :param cols: initial terminal columns
+        :param stdin: keep the process stdin open, data is sent with the returned response write() and
+                      close_input() methods
+        :param max_time: kill job server side if it exceeded this amount of seconds
+        :param tags: job tags
+        :param id: job id. Generated if not supplied
+        :return: ExecResponse object
+        """
+        exec_args = {
+            'container': self._container,
+            'id': id,
+            'name': command,
+            'args': args or [],
+            'dir': dir,
+            'env': env,
+            'user': user,
+            'tty': tty,
+            'rows': rows,
+            'cols': cols,
+            'stdin': stdin,
+        }
+
+        self._exec_chk.check(exec_args)
+
+        response = self._client.raw('corex.exec', exec_args, max_time=max_time, tags=tags)
+
+        result = response.get()
+        if result.state != 'SUCCESS':
+            raise RuntimeError('failed to exec command in container: %s' % result.data)
+
+        cmd_id = json.loads(result.data)
+        return ExecResponse(self._client, cmd_id, self._container)
+
 
 class ContainerManager:
     _nic = {
@@ -970,27 +1181,108 @@ class ContainerManager:
             typchk.Missing(),
             {
                 'dhcp': typchk.Or(bool, typchk.Missing()),
+                'slaac': typchk.Or(bool, typchk.Missing()),
                 'cidr': typchk.Or(str, typchk.Missing()),
+                'cidrs': typchk.Or([str], typchk.Missing()),
                 'gateway': typchk.Or(str, typchk.Missing()),
+                'gateway6': typchk.Or(str, typchk.Missing()),
                 'dns': typchk.Or([str], typchk.Missing()),
             }
         ),
         'monitor': typchk.Or(bool, typchk.Missing()),
     }
 
+    _limits = {
+        'cpu_shares': typchk.Or(int, typchk.Missing()),
+        'cpu_quota': typchk.Or(int, typchk.Missing()),
+        'cpu_period': typchk.Or(int, typchk.Missing()),
+        'cpuset': typchk.Or(str, typchk.Missing()),
+        'memory': typchk.Or(int, typchk.Missing()),
+        'swap': typchk.Or(int, typchk.Missing()),
+        'pids_max': typchk.Or(int, typchk.Missing()),
+        'blkio_weight': typchk.Or(int, typchk.Missing()),
+        'blkio_throttle': typchk.Or(
+            [{
+                'device': str,
+                'read_bps': typchk.Or(int, typchk.Missing()),
+                'write_bps': typchk.Or(int, typchk.Missing()),
+                'read_iops': typchk.Or(int, typchk.Missing()),
+                'write_iops': typchk.Or(int, typchk.Missing()),
+            }],
+            typchk.Missing()
+        ),
+    }
+
+    _retention = {
+        'keep_last': typchk.Or(int, typchk.Missing()),
+        'keep_hourly': typchk.Or(int, typchk.Missing()),
+        'keep_daily': typchk.Or(int, typchk.Missing()),
+        'keep_weekly': typchk.Or(int, typchk.Missing()),
+        'keep_monthly': typchk.Or(int, typchk.Missing()),
+        'keep_yearly': typchk.Or(int, typchk.Missing()),
+        'prune': typchk.Or(bool, typchk.Missing()),
+    }
+
+    _backup_schedule = {
+        'url': str,
+        'period': int,
+        'tags': typchk.Or([str], typchk.Missing()),
+        'retention': typchk.Or(_retention, typchk.Missing()),
+    }
+
+    _mount = {
+        'type': typchk.Or(typchk.Enum('bind', 'flist', 'volume', 'tmpfs'), typchk.Missing()),
+        'source': typchk.Or(str, typchk.Missing()),
+        'target': str,
+        'read_only': typchk.Or(bool, typchk.Missing()),
+        'propagation': typchk.Or(
+            typchk.Enum('private', 'rprivate', 'shared', 'rshared', 'slave', 'rslave'),
+            typchk.Missing()
+        ),
+        'size': typchk.Or(int, typchk.Missing()),
+    }
+
+    _dns = {
+        'nameservers': typchk.Or([str], typchk.Missing()),
+        'search': typchk.Or([str], typchk.Missing()),
+        'options': typchk.Or([str], typchk.Missing()),
+    }
+
+    _hook = {
+        'name': str,
+        'args': typchk.Or([str], typchk.Missing()),
+        'env': typchk.Or(typchk.Map(str, str), typchk.Missing()),
+        'timeout': typchk.Or(int, typchk.Missing()),
+        'on_failure': typchk.Or(typchk.Enum('ignore', 'abort'), typchk.Missing()),
+    }
+
+    _hooks = {
+        'prestart': typchk.Or(_hook, typchk.Missing()),
+        'poststart': typchk.Or(_hook, typchk.Missing()),
+        'prestop': typchk.Or(_hook, typchk.Missing()),
+        'init': typchk.Or(_hook, typchk.Missing()),
+    }
+
     _create_chk = typchk.Checker({
         'root': str,
         'mount': typchk.Or(
             typchk.Map(str, str),
             typchk.IsNone()
         ),
+        'mounts': typchk.Or([_mount], typchk.IsNone()),
+        'hosts': typchk.Or(typchk.Map(str, str), typchk.IsNone()),
+        'dns': typchk.Or(typchk.IsNone(), _dns),
         'host_network': bool,
         'nics': [_nic],
         'port': typchk.Or(
-            typchk.Map(int, int),
+            typchk.Map(typchk.Or(int, str), int),
             typchk.IsNone()
         ),
         'privileged': bool,
+        'userns': bool,
+        'cap_add': typchk.Or([str], typchk.IsNone()),
+        'cap_drop': typchk.Or([str], typchk.IsNone()),
+        'seccomp': typchk.Or(str, dict, typchk.IsNone()),
         'hostname': typchk.Or(
             str,
             typchk.IsNone()
@@ -998,13 +1290,65 @@ class ContainerManager:
         'storage': typchk.Or(str, typchk.IsNone()),
         'name': typchk.Or(str, typchk.IsNone()),
         'identity': typchk.Or(str, typchk.IsNone()),
-        'env': typchk.Or(typchk.IsNone(), typchk.Map(str, str))
+        'env': typchk.Or(typchk.IsNone(), typchk.Map(str, str)),
+        'labels': typchk.Or(typchk.IsNone(), typchk.Map(str, str)),
+        'limits': typchk.Or(typchk.IsNone(), _limits),
+        'persistent': bool,
+        'restart': typchk.Enum('no', 'on-failure', 'always'),
+        'backup': typchk.Or(typchk.IsNone(), _backup_schedule),
+        'hooks': typchk.Or(typchk.IsNone(), _hooks),
+    })
+
+    _backup_chk = typchk.Checker({
+        'container': int,
+        'url': str,
+        'tags': [str],
+        'retention': typchk.Or(typchk.IsNone(), _retention),
+    })
+
+    _backup_schedule_chk = typchk.Checker({
+        'container': int,
+        'schedule': typchk.Or(typchk.IsNone(), _backup_schedule),
+    })
+
+    _backup_forget_chk = typchk.Checker({
+        'url': str,
+        'tags': [str],
+        'snapshots': [str],
+        'retention': _retention,
+    })
+
+    _restore_files_chk = typchk.Checker({
+        'container': int,
+        'url': str,
+        'paths': [str],
+        'target': str,
+    })
+
+    _update_chk = typchk.Checker({
+        'container': int,
+        'limits': typchk.Or(typchk.IsNone(), _limits),
+        'name': typchk.Or(str, typchk.IsNone()),
+        'tags': typchk.Or([str], typchk.IsNone()),
+        'env': typchk.Or(typchk.Map(str, str), typchk.IsNone()),
+        'labels': typchk.Or(typchk.Map(str, str), typchk.IsNone()),
     })
 
     _client_chk = typchk.Checker(
         typchk.Or(int, str)
     )
 
+    _logs_chk = typchk.Checker({
+        'container': int,
+        'since': int,
+        'tail': int,
+        'levels': [int],
+        'job': str,
+        'follow': bool,
+        'timeout': int,
+        'previous': bool,
+    })
+
     _nic_add = typchk.Checker({
         'container': int,
         'nic': _nic,
@@ -1015,6 +1359,36 @@ class ContainerManager:
         'index': int,
     })
 
+    _portforward_chk = typchk.Checker({
+        'container': int,
+        'host_port': str,
+        'container_port': int,
+    })
+
+    _snapshot_chk = typchk.Checker({
+        'container': int,
+        'name': str,
+    })
+
+    _copy_chk = typchk.Checker({
+        'container': int,
+        'path': str,
+        'compression': typchk.Enum('', 'gzip'),
+        'chunk_size': typchk.Or(int, typchk.Missing()),
+    })
+
+    _clone_chk = typchk.Checker({
+        'snapshot': str,
+        'name': typchk.Or(str, typchk.IsNone()),
+        'hostname': typchk.Or(str, typchk.IsNone()),
+        'nics': [_nic],
+        'port': typchk.Or(
+            typchk.Map(typchk.Or(int, str), int),
+            typchk.IsNone()
+        ),
+        'persistent': bool,
+    })
+
     DefaultNetworking = object()
 
 
@@ -1022,11 +1396,14 @@ class ContainerManager:
     def __init__(self, client):
         self._client = client
 
-    def create(self, root_url, mount=None, host_network=False, nics=DefaultNetworking, port=None, hostname=None, privileged=False, storage=None, name=None, tags=None, identity=None, env=None):
+    def create(self, root_url, mount=None, host_network=False, nics=DefaultNetworking, port=None, hostname=None, privileged=False, storage=None, name=None, tags=None, identity=None, env=None, limits=None,
+               persistent=False, restart='no', backup=None, mounts=None, hosts=None, dns=None, userns=False,
+               cap_add=None, cap_drop=None, seccomp=None, hooks=None, labels=None):
         """
         Creater a new container with the given root flist, mount points and
         zerotier id, and connected to the given bridges
-        :param root_url: The root filesystem flist
+        :param root_url: The root filesystem flist, or a local image as `oci:<layout dir>[:<ref>]`,
+                         `oci-archive:<tarball>[:<ref>]` or `docker-archive:<tarball>[:<repo:tag>]`
         :param mount: a dict with {host_source: container_target} mount points.
                       where host_source directory must exists.
                       host_source can be a url to a flist to mount.
@@ -1040,25 +1417,94 @@ class ContainerManager:
                         'id': id # depends on the type, bridge name, zerotier network id, the vlan tag or the vxlan id
                         'name': name of the nic inside the container (ignored in zerotier type)
                         'hwaddr': Mac address of nic.
-                        'config': { # config is only honored for bridge, vlan, and vxlan types
+                        'config': { # config is only honored for bridge, vlan, and vxlan types (and cidr for default)
                             'dhcp': bool,
-                            'cidr': static_ip # ip/mask
+                            'cidr': static_ip # ip/mask, on the default bridge (and bridges with static or dnsmasq
+                                              # networking) the ip is allocated by the ipam if not set
+                            'cidrs': [static_ip] # extra ipv4 or ipv6 addresses (ip/mask)
+                            'slaac': bool # ipv6 auto configuration from the bridge router advertisements
                             'gateway': gateway
+                            'gateway6': ipv6 gateway
                             'dns': [dns]
                         }
                      }
         :param port: A dict of host_port: container_port pairs (only if default networking is enabled)
+                       host_port is a port, a range of ports (forwarded to the same ports in the container)
+                       and optionally the protocol (tcp or udp, tcp is the default)
                        Example:
-                        `port={8080: 80, 7000:7000}`
+                        `port={8080: 80, 7000:7000, '53/udp': 53, '8000-8100': 8000}`
         :param hostname: Specific hostname you want to give to the container.
                          if None it will automatically be set to core-x,
                          x beeing the ID of the container
         :param privileged: If true, container runs in privileged mode.
+        :param userns: If true, container runs in a user namespace, root in the container is mapped to an unprivileged
+                       user on the host (not supported with privileged or host_network)
+        :param cap_add: list of capabilities added to the defaults of an unprivileged container (ex: ['NET_ADMIN'])
+        :param cap_drop: list of capabilities removed from the defaults of an unprivileged container ('ALL' drops all)
+        :param seccomp: seccomp profile, 'default', 'unconfined' or a dict with a profile in the docker format.
+                        if None, unprivileged containers use the default profile and privileged ones are unconfined
         :param storage: A Url to the ardb storage to use to mount the root flist (or any other mount that requires g8fs)
                         if not provided, the default one from core0 configuration will be used.
         :param name: Optional name for the container
         :param identity: Container Zerotier identity, Only used if at least one of the nics is of type zerotier
         :param env: a dict with the environment variables needed to be set for the container
+        :param labels: a dict with key/value labels of the container, used to find containers with selectors
+        :param limits: a dict with the resource limits of the container (all optional, not set means no limit)
+                       {
+                          'cpu_shares': relative cpu weight (default 1024)
+                          'cpu_quota': cpu time in microseconds the container can use every cpu period
+                          'cpu_period': cpu period in microseconds (default 100000)
+                          'cpuset': cpus the container can run on (ex: '0-2,5')
+                          'memory': memory limit in bytes
+                          'swap': swap the container can use on top of the memory limit in bytes (-1 for unlimited)
+                          'pids_max': max number of processes
+                          'blkio_weight': relative io weight from 10 to 1000 (default 500)
+                          'blkio_throttle': [{
+                              'device': device path or major:minor,
+                              'read_bps': 'write_bps': 'read_iops': 'write_iops': max rates
+                          }]
+                       }
+        :param persistent: If true, the container spec is saved on the node, and the container is recreated (with the same ID)
+                           after a node reboot. Only data on the container mounts survives the reboot.
+        :param restart: Restart policy if the container exits, 'no', 'on-failure' or 'always'. A container that is
+                        terminated is never restarted.
+        :param backup: Recurring backup of the container (see backup_schedule)
+                       {
+                          'url': restic url (the password is better stored with backup_credentials_set)
+                          'period': seconds between backups (at least 60)
+                          'tags': extra snapshot tags
+                          'retention': retention policy applied after each backup (see backup_forget)
+                       }
+        :param mounts: a list of mount points with options (added to the mount argument above)
+                       [{
+                          'type': 'bind' (default), 'flist', 'volume' (a named volume, see client.volume) or 'tmpfs'
+                          'source': host path, flist url or volume name (not set for tmpfs)
+                          'target': absolute path inside the container
+                          'read_only': mount read-only
+                          'propagation': 'private', 'rprivate', 'shared', 'rshared', 'slave' or 'rslave'
+                          'size': size of a tmpfs mount in bytes
+                       }]
+        :param hosts: a dict with extra /etc/hosts entries {name: ip}
+        :param dns: resolv.conf configuration of the container (with host networking the node resolv.conf is used if not set)
+                    {
+                       'nameservers': nameservers used before the nameservers of the nics
+                       'search': search domains
+                       'options': resolver options (ex: ['ndots:2'])
+                    }
+        :param hooks: commands run at the container life cycle stages
+                      {
+                         'prestart': runs on the host before the container is started
+                         'poststart': runs on the host once the container is started
+                         'init': runs inside the container before the entrypoint
+                         'prestop': runs on the host before the container is terminated
+                      }
+                      each hook is {
+                         'name': binary to run
+                         'args': binary arguments
+                         'env': extra environment variables
+                         'timeout': seconds before the hook is killed (default 60)
+                         'on_failure': 'ignore' (default) or 'abort'
+                      }
         """
 
         if nics == self.DefaultNetworking:
@@ -1074,10 +1520,23 @@ class ContainerManager:
             'port': port,
             'hostname': hostname,
             'privileged': privileged,
+            'userns': userns,
+            'cap_add': cap_add,
+            'cap_drop': cap_drop,
+            'seccomp': seccomp,
             'storage': storage,
             'name': name,
             'identity': identity,
-            'env': env
+            'env': env,
+            'labels': labels,
+            'limits': limits,
+            'persistent': persistent,
+            'restart': restart,
+            'backup': backup,
+            'mounts': mounts,
+            'hosts': hosts,
+            'dns': dns,
+            'hooks': hooks,
         }
 
         # validate input
@@ -1089,30 +1548,44 @@ class ContainerManager:
 
     def list(self):
         """
-        List running containers
-        :return: a dict with {container_id: <container info object>}
+        List running containers and the recently exited ones
+        :return: a dict with {container_id: <container info object>}, the info of an exited container (or of a
+                 restarted container) has the 'exit' of its last run (see terminate)
         """
         return self._client.json('corex.list', {})
 
-    def find(self, *tags):
+    def find(self, *tags, selector=None):
         """
-        Find containers that matches set of tags
+        Find containers that matches set of tags and a label selector
         :param tags:
+        :param selector: comma separated label requirements, `key=value`, `key!=value`, `key` (label is set)
+                         or `!key` (label is not set), ex: 'env=prod,role!=db'
         :return:
         """
         tags = list(map(str, tags))
-        return self._client.json('corex.find', {'tags': tags})
+        return self._client.json('corex.find', {'tags': tags, 'selector': selector or ''})
 
-    def terminate(self, container):
+    def terminate(self, container, timeout=None):
         """
-        Terminate a container given it's id
+        Terminate a container given it's id, the container gets SIGTERM and is killed if it doesn't exit
+        within the timeout
 
         :param container: container id
-        :return:
+        :param timeout: seconds to wait for the container to exit before it's killed (default 30), the prestop
+                        hook counts in the timeout
+        :return: how the container ended {
+                    'code': exit code of coreX (128 + signal if it was killed by a signal),
+                    'signal': signal that killed coreX (0 if it exited),
+                    'duration': seconds the container was running,
+                    'killed': True if the container was killed after the timeout,
+                    'terminated': True,
+                    'exited': unix time,
+                 }
         """
         self._client_chk.check(container)
         args = {
             'container': int(container),
+            'timeout': timeout or 0,
         }
         response = self._client.raw('corex.terminate', args)
 
@@ -1120,6 +1593,291 @@ class ContainerManager:
         if result.state != 'SUCCESS':
             raise RuntimeError('failed to terminate container: %s' % result.data)
 
+        return json.loads(result.data) if result.data else None
+
+    def update(self, container, limits=None, name=None, tags=None, env=None, labels=None):
+        """
+        Update a running container, only the given values are changed.
+
+        :param container: container ID
+        :param limits: a dict with the resource limits of the container (see create), the new limits replace
+                       the old ones, so any limit that is not set is removed
+        :param name: new name of the container
+        :param tags: list of tags, replaces the container tags
+        :param env: dict of environment variables, replaces the container env for the next commands
+                    (running processes keep their env)
+        :param labels: dict of labels, replaces the container labels
+        :return:
+        """
+        args = {
+            'container': container,
+            'limits': limits,
+            'name': name,
+            'tags': tags,
+            'env': env,
+            'labels': labels,
+        }
+        self._update_chk.check(args)
+
+        return self._client.json('corex.update', args)
+
+    def portforward_add(self, container, host_port, container_port):
+        """
+        Add a port forward to a running container
+
+        :param container: container ID
+        :param host_port: host port, range of ports (ex: 8000-8100) and optionally the protocol (ex: 53/udp)
+        :param container_port: port inside the container (must be the start of the range for port ranges)
+        :return:
+        """
+        args = {
+            'container': container,
+            'host_port': str(host_port),
+            'container_port': container_port,
+        }
+        self._portforward_chk.check(args)
+
+        return self._client.json('corex.portforward_add', args)
+
+    def portforward_remove(self, container, host_port):
+        """
+        Remove a port forward from a running container
+
+        :param container: container ID
+        :param host_port: host port as given on create or portforward_add
+        :return:
+        """
+        args = {
+            'container': container,
+            'host_port': str(host_port),
+            'container_port': 0,
+        }
+        self._portforward_chk.check(args)
+
+        return self._client.json('corex.portforward_remove', args)
+
+    def pause(self, container):
+        """
+        Pause (freeze) all the processes of a container
+
+        :param container: container ID
+        :return:
+        """
+        self._client_chk.check(container)
+        return self._client.json('corex.pause', {'container': int(container)})
+
+    def resume(self, container):
+        """
+        Resume a paused container
+
+        :param container: container ID
+        :return:
+        """
+        self._client_chk.check(container)
+        return self._client.json('corex.resume', {'container': int(container)})
+
+    def snapshot(self, container, name=''):
+        """
+        Snapshot the writable layer of a running container (requires a btrfs backend), the container is
+        paused while the snapshot is taken. The container mounts are not part of the snapshot
+
+        :param container: container ID
+        :param name: name of the snapshot, generated from the container ID and the time if not set
+        :return: the snapshot {'name': , 'container': , 'created': , 'arguments': the container spec}
+        """
+        args = {
+            'container': container,
+            'name': name,
+        }
+        self._snapshot_chk.check(args)
+
+        return self._client.json('corex.snapshot', args)
+
+    def snapshot_list(self, container=None):
+        """
+        List the container snapshots
+
+        :param container: container ID, if set only the snapshots of this container are listed
+        :return: list of snapshots sorted by creation time
+        """
+        return self._client.json('corex.snapshot_list', {'container': container or 0})
+
+    def snapshot_remove(self, name):
+        """
+        Remove a snapshot, a snapshot can't be removed while a container created from it exists
+
+        :param name: name of the snapshot
+        :return:
+        """
+        return self._client.json('corex.snapshot_remove', {'name': name})
+
+    def clone(self, snapshot, nics=DefaultNetworking, port=None, name=None, hostname=None, persistent=False, tags=None):
+        """
+        Create a new container from a snapshot, the container gets the spec of the snapshotted container with
+        the writable layer of the snapshot but a new networking
+
+        :param snapshot: name of the snapshot
+        :param nics: Configure the attached nics to the container (see create)
+        :param port: A dict of host_port: container_port pairs (see create)
+        :param name: Optional name for the container
+        :param hostname: Specific hostname you want to give to the container
+        :param persistent: If true, the container is recreated from the snapshot when the node boots
+        :param tags: tags of the container
+        :return: Job object (do .get() to get the container ID)
+        """
+        if nics == self.DefaultNetworking:
+            nics = [{'type': 'default'}]
+        elif nics is None:
+            nics = []
+
+        args = {
+            'snapshot': snapshot,
+            'name': name,
+            'hostname': hostname,
+            'nics': nics,
+            'port': port,
+            'persistent': persistent,
+        }
+        self._clone_chk.check(args)
+
+        response = self._client.raw('corex.clone', args, tags=tags)
+
+        return JSONResponse(response)
+
+    def copy_to(self, container, path, reader, compression=None):
+        """
+        Extract a tar archive in a directory of the container, the archive is streamed over a transfer queue
+        like filesystem.stream_upload. The files are written from the host side with the ownership and modes of
+        the archive (ids are mapped to the container user namespace). The container is paused during the copy.
+        Device nodes in the archive are skipped.
+
+        :param container: container id
+        :param path: destination directory in the container (created if it doesn't exist)
+        :param reader: an object that implements the read(size) method and gives the tar archive
+                       (for example a file or an io.BytesIO filled with tarfile)
+        :param compression: None or 'gzip' (each chunk is compressed separately)
+        :return: dict with files (number of archive entries) and count (size of the archive)
+        """
+        args = {
+            'container': container,
+            'path': path,
+            'compression': compression or '',
+        }
+        self._copy_chk.check(args)
+
+        response = self._client.raw('corex.copy_to', args)
+        return _push_transfer(self._client, response, reader, compression)
+
+    def copy_from(self, container, path, writer, compression=None, chunk_size=TransferChunkSize):
+        """
+        Stream a tar archive of a file or directory of the container, the archive is streamed over a transfer
+        queue like filesystem.stream_download. The entries are named after the last element of path, symlinks
+        are not followed and the ownership is the one seen from inside the container. The container is paused
+        while the archive (max 1G) is built on the node, and resumed before it's streamed. The container must be running.
+
+        :param container: container id
+        :param path: file or directory in the container
+        :param writer: an object the implements the write(bytes) interface, it receives the tar archive
+        :param compression: None or 'gzip' (each chunk is compressed separately)
+        :param chunk_size: size of each chunk
+        :return: dict with files (number of archive entries) and count (size of the archive)
+        """
+        args = {
+            'container': container,
+            'path': path,
+            'compression': compression or '',
+            'chunk_size': chunk_size,
+        }
+        self._copy_chk.check(args)
+
+        response = self._client.raw('corex.copy_from', args)
+        return _pull_transfer(self._client, response, writer, compression)
+
+    def stats(self, container=None):
+        """
+        Get the resource usage of a container (all its processes) as accounted by the container cgroups
+
+        :param container: container ID, if not set the stats of all the running containers are returned
+        :return: {
+                    'cpu': cpu time in nano seconds,
+                    'memory': {'usage': usage, 'cache': page cache, 'rss': rss, 'swap': swap} (in bytes),
+                    'pids': number of processes and threads,
+                    'blkio': {'read_bytes': , 'write_bytes': , 'read_ops': , 'write_ops': },
+                    'network': {<nic name>: {'rxbytes': , 'rxpackets': , 'txbytes': , 'txpackets': }},
+                 }
+                 or a dict of {container_id: stats} if container is not set
+        """
+        args = {
+            'container': 0,
+        }
+
+        if container is not None:
+            self._client_chk.check(container)
+            args['container'] = int(container)
+
+        return self._client.json('corex.stats', args)
+
+    def logs(self, container, since=0, tail=0, levels=None, job='', follow=False, timeout=0, callback=None,
+             previous=False):
+        """
+        Get the log of a container. The log holds the output of the container jobs and of coreX itself, and
+        is kept after the container exits till logs_remove is called. When the container ID is reused the old
+        log is kept aside, and can be read with previous
+
+        :param container: container ID
+        :param since: only entries logged after this unix time (in seconds)
+        :param tail: only the last `tail` entries (0 means all)
+        :param levels: only entries with these levels (None means all)
+        :param job: only entries of this job ID
+        :param follow: keep receiving new entries till the container exits (or the timeout is reached)
+        :param timeout: stop following after timeout seconds (0 means no timeout)
+        :param callback: follow mode only, a callback that is called with each log entry as a dict
+                         {'epoch': <nano seconds>, 'command': <job id>, 'level': <level>, 'message': <message>}
+                         if not set the entries are printed on stdout
+        :param previous: get the log of the previous container that had this ID (can't be followed)
+        :return: list of the log entries (if not in follow mode)
+        """
+        args = {
+            'container': container,
+            'since': since,
+            'tail': tail,
+            'levels': levels or [],
+            'job': job,
+            'follow': follow,
+            'timeout': timeout,
+            'previous': previous,
+        }
+        self._logs_chk.check(args)
+
+        if not follow:
+            return self._client.json('corex.logs', args)
+
+        if callback is None:
+            def callback(entry):
+                print(entry['message'])
+
+        def handler(level, line, flags):
+            if level != 20 or not line:
+                return
+            callback(json.loads(line))
+
+        response = self._client.raw('corex.logs', args, stream=True)
+        response.stream(handler)
+
+        result = response.get()
+        if result.state != 'SUCCESS':
+            raise RuntimeError('failed to follow container logs: %s' % result.data)
+
+    def logs_remove(self, container):
+        """
+        Remove the log of a container that is not running
+
+        :param container: container ID
+        :return:
+        """
+        self._client_chk.check(container)
+        return self._client.json('corex.logs_remove', {'container': int(container)})
+
     def nic_add(self, container, nic):
         """
         Hot plug a nic into a container
@@ -1130,10 +1888,14 @@ class ContainerManager:
                         'id': id # depends on the type, bridge name, zerotier network id, the vlan tag or the vxlan id
                         'name': name of the nic inside the container (ignored in zerotier type)
                         'hwaddr': Mac address of nic.
-                        'config': { # config is only honored for bridge, vlan, and vxlan types
+                        'config': { # config is only honored for bridge, vlan, and vxlan types (and cidr for default)
                             'dhcp': bool,
-                            'cidr': static_ip # ip/mask
+                            'cidr': static_ip # ip/mask, on the default bridge (and bridges with static or dnsmasq
+                                              # networking) the ip is allocated by the ipam if not set
+                            'cidrs': [static_ip] # extra ipv4 or ipv6 addresses (ip/mask)
+                            'slaac': bool # ipv6 auto configuration from the bridge router advertisements
                             'gateway': gateway
+                            'gateway6': ipv6 gateway
                             'dns': [dns]
                         }
                      }
@@ -1178,15 +1940,19 @@ class ContainerManager:
         self._client_chk.check(container)
         return ContainerClient(self._client, int(container))
 
-    def backup(self, container, url):
+    def backup(self, container, url, tags=None, retention=None):
         """
         Backup a container to the given restic url
-        all restic urls are supported
+        all restic urls are supported. Backups are incremental, only the data that changed since the
+        last backup of the container is uploaded.
 
         :param container:
         :param url: Url to restic repo
                 examples
                 (file:///path/to/restic/?password=<password>)
+                if the url has no password, the password stored with backup_credentials_set is used
+        :param tags: extra snapshot tags
+        :param retention: if set, old snapshots of the container are forgotten after the backup (see backup_forget)
 
         :return: Json response to the backup job (do .get() to get the snapshot ID
         """
@@ -1194,10 +1960,145 @@ class ContainerManager:
         args = {
             'container': container,
             'url': url,
+            'tags': tags or [],
+            'retention': retention,
         }
+        self._backup_chk.check(args)
 
         return JSONResponse(self._client.raw('corex.backup', args))
 
+    def backup_schedule(self, container, url=None, period=3600, tags=None, retention=None):
+        """
+        Set the recurring backup of a running container, replacing the old schedule (if any). The first
+        backup is taken after one period.
+
+        :param container: container ID
+        :param url: restic url (the password is better stored with backup_credentials_set), if not set the
+                    schedule is removed
+        :param period: seconds between backups (at least 60)
+        :param tags: extra snapshot tags
+        :param retention: retention policy applied after each backup (see backup_forget)
+        :return:
+        """
+        schedule = None
+        if url is not None:
+            schedule = {
+                'url': url,
+                'period': period,
+                'tags': tags or [],
+            }
+            if retention is not None:
+                schedule['retention'] = retention
+
+        args = {
+            'container': container,
+            'schedule': schedule,
+        }
+        self._backup_schedule_chk.check(args)
+
+        return self._client.json('corex.backup_schedule', args)
+
+    def backup_list(self, url, tags=None):
+        """
+        List the snapshots of a restic repository
+
+        :param url: restic url
+        :param tags: only snapshots that have all the tags
+        :return: list of snapshots as reported by restic
+        """
+        args = {
+            'url': url,
+            'tags': tags or [],
+        }
+
+        return self._client.json('corex.backup_list', args)
+
+    def backup_forget(self, url, tags=None, snapshots=None, keep_last=0, keep_hourly=0, keep_daily=0,
+                      keep_weekly=0, keep_monthly=0, keep_yearly=0, prune=False):
+        """
+        Forget snapshots of a restic repository, either the given snapshots or the snapshots that don't
+        match any of the keep policies. Snapshots are grouped by host and paths (so by container) when
+        applying the policies.
+
+        :param url: restic url
+        :param tags: only consider snapshots that have all the tags
+        :param snapshots: list of snapshot IDs to forget (exclusive with the keep policies)
+        :param keep_last: keep the last n snapshots
+        :param keep_hourly: keep the last snapshot of the last n hours
+        :param keep_daily: keep the last snapshot of the last n days
+        :param keep_weekly: keep the last snapshot of the last n weeks
+        :param keep_monthly: keep the last snapshot of the last n months
+        :param keep_yearly: keep the last snapshot of the last n years
+        :param prune: remove the data that is not referenced anymore
+        :return:
+        """
+        args = {
+            'url': url,
+            'tags': tags or [],
+            'snapshots': snapshots or [],
+            'retention': {
+                'keep_last': keep_last,
+                'keep_hourly': keep_hourly,
+                'keep_daily': keep_daily,
+                'keep_weekly': keep_weekly,
+                'keep_monthly': keep_monthly,
+                'keep_yearly': keep_yearly,
+                'prune': prune,
+            },
+        }
+        self._backup_forget_chk.check(args)
+
+        return self._client.json('corex.backup_forget', args)
+
+    def backup_credentials_set(self, url, password):
+        """
+        Store the password of a restic repository on the node, so urls to this repository don't need
+        to carry the password
+
+        :param url: restic url without password
+        :param password: repository password
+        :return:
+        """
+        return self._client.json('corex.backup_credentials_set', {'url': url, 'password': password})
+
+    def backup_credentials_remove(self, url):
+        """
+        Remove the stored password of a restic repository
+
+        :param url: restic url without password
+        :return:
+        """
+        return self._client.json('corex.backup_credentials_remove', {'url': url})
+
+    def backup_credentials_list(self):
+        """
+        List the restic repositories that have a stored password (passwords are never returned)
+
+        :return: list of repositories
+        """
+        return self._client.json('corex.backup_credentials_list', {})
+
+    def restore_files(self, container, url, paths, target='/'):
+        """
+        Restore files from a snapshot into a running container. The files are restored to their
+        original location relative to target.
+
+        :param container: container ID
+        :param url: snapshot url, the snapshot ID is passed as a url fragment
+        :param paths: list of paths to restore as seen from inside the container (ex: ['/etc/nginx'])
+        :param target: directory inside the container to restore to
+        :return:
+        """
+        args = {
+            'container': container,
+            'url': url,
+            'paths': paths,
+            'target': target,
+        }
+        self._restore_files_chk.check(args)
+
+        return self._client.json('corex.restore_files', args)
+
     def restore(self, url, tags=None):
         """
         Full restore of a container backup. This restore method will recreate
@@ -1479,15 +2380,17 @@ class BridgeManager:
                         none:
                             no settings, bridge won't get any ip settings
                         static:
-                            settings={'cidr': 'ip/net'}
-                            bridge will get assigned the given IP address
+                            settings={'cidr': 'ip/net', 'cidr6': 'ipv6/net'}
+                            bridge will get assigned the given IP address (and the optional IPv6 address)
                         dnsmasq:
-                            settings={'cidr': 'ip/net', 'start': 'ip', 'end': 'ip'}
+                            settings={'cidr': 'ip/net', 'start': 'ip', 'end': 'ip', 'cidr6': 'ipv6/net'}
                             bridge will get assigned the ip in cidr
                             and each running container that is attached to this IP will get
                             IP from the start/end range. Netmask of the range is the netmask
                             part of the provided cidr.
-                            if nat is true, SNAT rules will be automatically added in the firewall.
+                            If cidr6 is set, the IPv6 prefix is advertised (router advertisements) to the
+                            containers and vms attached to the bridge.
+                            if nat is true, SNAT rules will be automatically added in the firewall (for both IPv4 and IPv6).
         """
         args = {
             'name': name,
@@ -1910,6 +2813,113 @@ class BtrfsManager:
         self._client.sync('btrfs.subvol_snapshot', args)
 
 
+class VolumeManager:
+    _create_chk = typchk.Checker({
+        'name': str,
+        'size': int,
+    })
+
+    _name_chk = typchk.Checker({
+        'name': str,
+    })
+
+    def __init__(self, client):
+        self._client = client
+
+    def create(self, name, size=0):
+        """
+        Create a named volume, volumes are kept when the containers that mount them are terminated
+        :param name: volume name
+        :param size: quota in bytes, 0 means no quota (requires the volumes to be on btrfs)
+        :return: the volume info
+        """
+        args = {
+            'name': name,
+            'size': size,
+        }
+
+        self._create_chk.check(args)
+
+        return self._client.json('volume.create', args)
+
+    def list(self):
+        """
+        List all volumes
+        :return: list of volumes info
+        """
+        return self._client.json('volume.list', {})
+
+    def get(self, name):
+        """
+        Get volume info (size, used space and number of running containers using it)
+        :param name: volume name
+        """
+        args = {
+            'name': name,
+        }
+
+        self._name_chk.check(args)
+
+        return self._client.json('volume.get', args)
+
+    def delete(self, name):
+        """
+        Delete a volume and all its data, a volume mounted by a running container can't be deleted
+        :param name: volume name
+        """
+        args = {
+            'name': name,
+        }
+
+        self._name_chk.check(args)
+
+        self._client.sync('volume.delete', args)
+
+
+class IPAMManager:
+    _list_chk = typchk.Checker({
+        'network': str,
+    })
+
+    _release_chk = typchk.Checker({
+        'network': str,
+        'ip': str,
+    })
+
+    def __init__(self, client):
+        self._client = client
+
+    def list(self, network=''):
+        """
+        List the ips allocated to containers and vms on the bridges managed by the ipam, an ip without an
+        owner is only reserved for the container (or vm) name
+        :param network: bridge name (core0, kvm0 or a bridge created with bridge.create), all bridges if not set
+        :return: list of leases (network, ip, owner, name, created)
+        """
+        args = {
+            'network': network,
+        }
+
+        self._list_chk.check(args)
+
+        return self._client.json('ipam.list', args)
+
+    def release(self, network, ip):
+        """
+        Release an ip reserved for a name, an ip in use by a running container or vm can't be released
+        :param network: bridge name
+        :param ip: ip to release
+        """
+        args = {
+            'network': network,
+            'ip': ip,
+        }
+
+        self._release_chk.check(args)
+
+        self._client.sync('ipam.release', args)
+
+
 class ZerotierManager:
     _network_chk = typchk.Checker({
         'network': str,
@@ -2029,6 +3039,7 @@ class KvmManager:
             'type': typchk.Enum('default', 'bridge', 'vxlan', 'vlan'),
             'id': typchk.Or(str, typchk.Missing()),
             'hwaddr': typchk.Or(str, typchk.Missing()),
+            'ip': typchk.Or(str, typchk.Missing()),
         }],
         'port': typchk.Or(
             typchk.Map(int, int),
@@ -2045,6 +3056,7 @@ class KvmManager:
             'type': typchk.Enum('default', 'bridge', 'vxlan', 'vlan'),
             'id': typchk.Or(str, typchk.Missing()),
             'hwaddr': typchk.Or(str, typchk.Missing()),
+            'ip': typchk.Or(str, typchk.Missing()),
         }],
         'port': typchk.Or(
             typchk.Map(int, int),
@@ -2067,6 +3079,7 @@ class KvmManager:
         'type': typchk.Enum('default', 'bridge', 'vxlan', 'vlan'),
         'id': typchk.Or(str, typchk.IsNone()),
         'hwaddr': typchk.Or(str, typchk.IsNone()),
+        'ip': typchk.Or(str, typchk.IsNone(), typchk.Missing()),
     })
 
     _migrate_action_chk = typchk.Checker({
@@ -2105,6 +3118,7 @@ class KvmManager:
                      {
                         'type': nic_type # default, bridge, vlan, or vxlan (note, vlan and vxlan only supported by ovs)
                         'id': id # depends on the type, bridge name (bridge type) zerotier network id (zertier type), the vlan tag or the vxlan id
+                        'ip': ip # (default type only) the ip of the vm on the default bridge, allocated by the ipam if not set
                      }
         :param port: Configure port forwards to vm, this only works if default network nic is added. Is a dict of {host-port: guest-port}
         :param mount: A list of host shared folders in the format {'source': '/host/path', 'target': '/guest/path', 'readonly': True|False}
@@ -2300,13 +3314,14 @@ class KvmManager:
 
         self._client.sync('kvm.detach_disk', args)
 
-    def add_nic(self, uuid, type, id=None, hwaddr=None):
+    def add_nic(self, uuid, type, id=None, hwaddr=None, ip=None):
         """
         Add a nic to a machine
         :param uuid: uuid of the kvm container (same as the used in create)
         :param type: nic_type # default, bridge, vlan, or vxlan (note, vlan and vxlan only supported by ovs)
          param id: id # depends on the type, bridge name (bridge type) zerotier network id (zertier type), the vlan tag or the vxlan id
          param hwaddr: the hardware address of the nic
+         param ip: (default type only) the ip of the vm on the default bridge, allocated by the ipam if not set
         :return:
         """
         args = {
@@ -2314,6 +3329,7 @@ class KvmManager:
             'type': type,
             'id': id,
             'hwaddr': hwaddr,
+            'ip': ip,
         }
         self._man_nic_action_chk.check(args)
 
@@ -2614,9 +3630,11 @@ class Client(BaseClient):
         'tags': typchk.Or([str], typchk.IsNone()),
     })
 
-    def __init__(self, host, port=6379, password="", db=0, ssl=True, timeout=None, testConnectionAttempts=3):
+    def __init__(self, host, port=6379, password="", db=0, ssl=True, timeout=None, testConnectionAttempts=3, sink=None):
         super().__init__(timeout=timeout)
 
+        self._sink = sink
+
         socket_timeout = (timeout + 5) if timeout else 15
         socket_keepalive_options = dict()
         if hasattr(socket, 'TCP_KEEPIDLE'):
@@ -2632,6 +3650,8 @@ class Client(BaseClient):
         self._bridge_manager = BridgeManager(self)
         self._disk_manager = DiskManager(self)
         self._btrfs_manager = BtrfsManager(self)
+        self._volume_manager = VolumeManager(self)
+        self._ipam_manager = IPAMManager(self)
         self._zerotier = ZerotierManager(self)
         self._kvm = KvmManager(self)
         self._logger = Logger(self)
@@ -2681,6 +3701,22 @@ class Client(BaseClient):
         """
         return self._btrfs_manager
 
+    @property
+    def volume(self):
+        """
+        Volume manager
+        :return:
+        """
+        return self._volume_manager
+
+    @property
+    def ipam(self):
+        """
+        IP address manager
+        :return:
+        """
+        return self._ipam_manager
+
     @property
     def zerotier(self):
         """
@@ -2759,8 +3795,8 @@ class Client(BaseClient):
         }
 
         self._raw_chk.check(payload)
-        flag = 'result:{}:flag'.format(id)
-        self._redis.rpush('core:default', json.dumps(payload))
+        flag = '{}:flag'.format(self._result_queue(id))
+        self._redis.rpush('core:{}'.format(self._sink or 'default'), json.dumps(payload))
         if self._redis.brpoplpush(flag, flag, DefaultTimeout) is None:
             TimeoutError('failed to queue job {}'.format(id))
         logger.debug('%s >> g8core.%s(%s)', id, command, ', '.join(("%s=%s" % (k, v) for k, v in arguments.items())))
@@ -2769,3 +3805,12 @@ class Client(BaseClient):
 
     def response_for(self, id):
         return Response(self, id)
+
+    def _job_queue(self, kind, id):
+        # queues of the jobs pushed to a named sink queue are namespaced with the queue name
+        if self._sink:
+            return '{}:{}:{}'.format(kind, self._sink, id)
+        return '{}:{}'.format(kind, id)
+
+    def _result_queue(self, id):
+        return self._job_queue('result', id)
@@ -24,6 +24,12 @@ var (
 					Priority: 0,
 					Policy:   "accept",
 				},
+				"output": nft.Chain{
+					Type:     nft.TypeNAT,
+					Hook:     "output",
+					Priority: 0,
+					Policy:   "accept",
+				},
 			},
 		},
 		"filter": nft.Table{
@@ -39,6 +45,7 @@ var (
 						{Body: "iifname lo accept"},
 						{Body: "iifname vxbackend accept"},
 						{Body: "ip protocol icmp accept"},
+						{Body: "ip6 nexthdr icmpv6 accept"},
 					},
 				},
 				"forward": nft.Chain{
@@ -57,6 +64,27 @@ var (
 		},
 	}
 
+	//ipv6 nat is set up apart since the nat table name must be unique across families
+	nftInit6 = nft.Nft{
+		"nat6": nft.Table{
+			Family: nft.FamilyIP6,
+			Chains: nft.Chains{
+				"pre": nft.Chain{
+					Type:     nft.TypeNAT,
+					Hook:     "prerouting",
+					Priority: 0,
+					Policy:   "accept",
+				},
+				"post": nft.Chain{
+					Type:     nft.TypeNAT,
+					Hook:     "postrouting",
+					Priority: 0,
+					Policy:   "accept",
+				},
+			},
+		},
+	}
+
 	zt = nft.Nft{
 		"filter": nft.Table{
 			Family: nft.FamilyINET,
@@ -102,6 +130,10 @@ func (b *Bootstrap) setNFT() error {
 		return err
 	}
 
+	if err := nft.Apply(nftInit6); err != nil {
+		log.Errorf("failed to setup ipv6 nat: %s", err)
+	}
+
 	if options.Options.Kernel.Is("zerotier") && !options.Options.Kernel.Is("debug") {
 		if err := nft.Apply(zt); err != nil {
 			return err
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path"
 	"regexp"
+	"strings"
 	"sync"
 	"syscall"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/zero-os/0-core/base/nft"
 	"github.com/zero-os/0-core/base/pm"
 	"github.com/zero-os/0-core/base/utils"
+	"github.com/zero-os/0-core/core0/subsys/ipam"
 )
 
 type bridgeMgr struct {
@@ -30,8 +32,9 @@ func init() {
 }
 
 var (
-	ruleHandlerP = regexp.MustCompile(`(?m:ip saddr ([\d\./]+) masquerade # handle (\d+))`)
-	HandlerP     = regexp.MustCompile(`handle (\d+)$`)
+	ruleHandlerP  = regexp.MustCompile(`(?m:ip saddr ([\d\./]+) masquerade # handle (\d+))`)
+	rule6HandlerP = regexp.MustCompile(`(?m:ip6 saddr ([\da-f:/]+) masquerade # handle (\d+))`)
+	HandlerP      = regexp.MustCompile(`handle (\d+)$`)
 )
 
 const (
@@ -43,7 +46,8 @@ const (
 type BridgeNetworkMode string
 
 type NetworkStaticSettings struct {
-	CIDR string `json:"cidr"`
+	CIDR  string `json:"cidr"`
+	CIDR6 string `json:"cidr6"` //optional ipv6 address of the bridge
 }
 
 func (n *NetworkStaticSettings) Validate() error {
@@ -56,6 +60,27 @@ func (n *NetworkStaticSettings) Validate() error {
 		return fmt.Errorf("Invalid IP")
 	}
 
+	return n.validateCIDR6()
+}
+
+func (n *NetworkStaticSettings) validateCIDR6() error {
+	if len(n.CIDR6) == 0 {
+		return nil
+	}
+
+	ip, network, err := net.ParseCIDR(n.CIDR6)
+	if err != nil {
+		return err
+	}
+
+	if ip.To4() != nil {
+		return fmt.Errorf("cidr6 must be an ipv6 address")
+	}
+
+	if network.IP.Equal(ip) {
+		return fmt.Errorf("Invalid IPv6")
+	}
+
 	return nil
 }
 
@@ -83,7 +108,7 @@ func (n *NetworkDnsMasqSettings) Validate() error {
 		return fmt.Errorf("end ip address out of range")
 	}
 
-	return nil
+	return n.validateCIDR6()
 }
 
 type BridgeNetwork struct {
@@ -147,27 +172,102 @@ func (b *bridgeMgr) conflict(addr *netlink.Addr) error {
 	return nil
 }
 
-func (b *bridgeMgr) bridgeStaticNetworking(bridge *netlink.Bridge, network *BridgeNetwork) (*netlink.Addr, error) {
+/*
+acceptRA keeps router advertisements accepted on the node links (except the bridge) once ipv6 forwarding is
+enabled. With forwarding on, the kernel ignores router advertisements on links with accept_ra=1, so a node
+configured with SLAAC would lose its default route.
+*/
+func acceptRA(bridge string) error {
+	links, err := ioutil.ReadDir("/proc/sys/net/ipv6/conf")
+	if err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		if link.Name() == "all" || link.Name() == bridge {
+			continue
+		}
+
+		p := path.Join("/proc/sys/net/ipv6/conf", link.Name(), "accept_ra")
+		value, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		if strings.TrimSpace(string(value)) != "1" {
+			continue
+		}
+
+		if err := ioutil.WriteFile(p, []byte("2"), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//addIPv6 sets the ipv6 address of the bridge and enables ipv6 forwarding, it does nothing if cidr is not set
+func (b *bridgeMgr) addIPv6(bridge *netlink.Bridge, cidr string) (*netlink.Addr, error) {
+	if len(cidr) == 0 {
+		return nil, nil
+	}
+
+	addr, err := netlink.ParseAddr(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.conflict(addr); err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(fmt.Sprintf("/proc/sys/net/ipv6/conf/%s/disable_ipv6", bridge.Name), []byte("0"), 0644); err != nil {
+		return nil, err
+	}
+
+	if err := acceptRA(bridge.Name); err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile("/proc/sys/net/ipv6/conf/all/forwarding", []byte("1"), 0644); err != nil {
+		return nil, err
+	}
+
+	//no duplicate address detection, so dnsmasq can bind the address right away
+	addr.Flags = syscall.IFA_F_NODAD
+	if err := netlink.AddrAdd(bridge, addr); err != nil {
+		return nil, err
+	}
+
+	return addr, nil
+}
+
+func (b *bridgeMgr) bridgeStaticNetworking(bridge *netlink.Bridge, network *BridgeNetwork) (*netlink.Addr, *netlink.Addr, error) {
 	var settings NetworkStaticSettings
 	if err := json.Unmarshal(network.Settings, &settings); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if err := settings.Validate(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	addr, err := netlink.ParseAddr(settings.CIDR)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if err := b.conflict(addr); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if err := netlink.AddrAdd(bridge, addr); err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	addr6, err := b.addIPv6(bridge, settings.CIDR6)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	//we still dnsmasq also for the default bridge for dns resolving.
@@ -175,17 +275,27 @@ func (b *bridgeMgr) bridgeStaticNetworking(bridge *netlink.Bridge, network *Brid
 	leases := fmt.Sprintf("/var/lib/misc/%s.leases", bridge.Name)
 	os.RemoveAll(leases)
 
+	//extra host records (one file per host) that are reloaded on SIGHUP
+	addnHosts := b.dnsmasqAddnHostsPath(bridge.Name)
+	os.RemoveAll(addnHosts)
+	os.MkdirAll(addnHosts, 0755)
+
 	args := []string{
 		"--no-hosts",
 		"--keep-in-foreground",
 		fmt.Sprintf("--pid-file=/var/run/dnsmasq/%s.pid", bridge.Name),
 		fmt.Sprintf("--dhcp-leasefile=%s", leases),
+		fmt.Sprintf("--addn-hosts=%s", addnHosts),
 		fmt.Sprintf("--listen-address=%s", addr.IP),
 		fmt.Sprintf("--interface=%s", bridge.Name),
 		"--bind-interfaces",
 		"--except-interface=lo",
 	}
 
+	if addr6 != nil {
+		args = append(args, fmt.Sprintf("--listen-address=%s", addr6.IP))
+	}
+
 	cmd := &pm.Command{
 		ID:      b.dnsmasqPName(bridge.Name),
 		Command: pm.CommandSystem,
@@ -209,10 +319,10 @@ func (b *bridgeMgr) bridgeStaticNetworking(bridge *netlink.Bridge, network *Brid
 	_, err = pm.Run(cmd, onExit)
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return addr, nil
+	return addr, addr6, nil
 }
 
 func (b *bridgeMgr) dnsmasqPName(n string) string {
@@ -223,29 +333,38 @@ func (b *bridgeMgr) dnsmasqHostsFilePath(n string) string {
 	return fmt.Sprintf("/var/run/dnsmasq/%s", b.dnsmasqPName(n))
 }
 
-func (b *bridgeMgr) bridgeDnsMasqNetworking(bridge *netlink.Bridge, network *BridgeNetwork) (*netlink.Addr, error) {
+func (b *bridgeMgr) dnsmasqAddnHostsPath(n string) string {
+	return fmt.Sprintf("/var/run/dnsmasq/%s.hosts", n)
+}
+
+func (b *bridgeMgr) bridgeDnsMasqNetworking(bridge *netlink.Bridge, network *BridgeNetwork) (*netlink.Addr, *netlink.Addr, error) {
 	var settings NetworkDnsMasqSettings
 	if err := json.Unmarshal(network.Settings, &settings); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if err := settings.Validate(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	os.MkdirAll("/var/run/dnsmasq", 0755)
 
 	addr, err := netlink.ParseAddr(settings.CIDR)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if err := b.conflict(addr); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if err := netlink.AddrAdd(bridge, addr); err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	addr6, err := b.addIPv6(bridge, settings.CIDR6)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	hostsFile := b.dnsmasqHostsFilePath(bridge.Name)
@@ -269,6 +388,17 @@ func (b *bridgeMgr) bridgeDnsMasqNetworking(bridge *netlink.Bridge, network *Bri
 		"--except-interface=lo",
 	}
 
+	if addr6 != nil {
+		//router advertisements for the ipv6 prefix (slaac), dns is served with stateless dhcpv6
+		ones, _ := addr6.Mask.Size()
+		args = append(args,
+			fmt.Sprintf("--listen-address=%s", addr6.IP),
+			"--enable-ra",
+			fmt.Sprintf("--dhcp-range=%s,ra-stateless,%d", addr6.IP.Mask(addr6.Mask), ones),
+			fmt.Sprintf("--dhcp-option=option6:dns-server,[%s]", addr6.IP),
+		)
+	}
+
 	cmd := &pm.Command{
 		ID:      b.dnsmasqPName(bridge.Name),
 		Command: pm.CommandSystem,
@@ -295,10 +425,10 @@ func (b *bridgeMgr) bridgeDnsMasqNetworking(bridge *netlink.Bridge, network *Bri
 	_, err = pm.Run(cmd, onExit)
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return addr, nil
+	return addr, addr6, nil
 }
 
 func (b *bridgeMgr) addHost(cmd *pm.Command) (interface{}, error) {
@@ -327,13 +457,13 @@ func (b *bridgeMgr) addHost(cmd *pm.Command) (interface{}, error) {
 }
 
 func (b *bridgeMgr) bridgeNetworking(bridge *netlink.Bridge, network *BridgeNetwork) error {
-	var addr *netlink.Addr
+	var addr, addr6 *netlink.Addr
 	var err error
 	switch network.Mode {
 	case StaticBridgeNetworkMode:
-		addr, err = b.bridgeStaticNetworking(bridge, network)
+		addr, addr6, err = b.bridgeStaticNetworking(bridge, network)
 	case DnsMasqBridgeNetworkMode:
-		addr, err = b.bridgeDnsMasqNetworking(bridge, network)
+		addr, addr6, err = b.bridgeDnsMasqNetworking(bridge, network)
 	case NoneBridgeNetworkMode:
 		return nil
 	default:
@@ -344,14 +474,21 @@ func (b *bridgeMgr) bridgeNetworking(bridge *netlink.Bridge, network *BridgeNetw
 		return err
 	}
 
+	//containers and vms attached to the bridge get their ips from the ipam
+	if addr != nil {
+		if err := ipam.Register(bridge.Name, addr.IPNet); err != nil {
+			log.Errorf("failed to manage ips of bridge '%s': %s", bridge.Name, err)
+		}
+	}
+
 	if network.Nat && addr != nil {
-		return b.setNAT(addr)
+		return b.setNAT(addr, addr6)
 	}
 
 	return nil
 }
 
-func (b *bridgeMgr) setNAT(addr *netlink.Addr) error {
+func (b *bridgeMgr) setNAT(addr *netlink.Addr, addr6 *netlink.Addr) error {
 	//enable nat-ting
 	n := nft.Nft{
 		"nat": nft.Table{
@@ -366,6 +503,20 @@ func (b *bridgeMgr) setNAT(addr *netlink.Addr) error {
 		},
 	}
 
+	if addr6 != nil {
+		_, network, _ := net.ParseCIDR(addr6.IPNet.String())
+		n["nat6"] = nft.Table{
+			Family: nft.FamilyIP6,
+			Chains: nft.Chains{
+				"post": nft.Chain{
+					Rules: []nft.Rule{
+						{Body: fmt.Sprintf("ip6 saddr %s masquerade", network.String())},
+					},
+				},
+			},
+		}
+	}
+
 	return nft.Apply(n)
 }
 
@@ -391,6 +542,14 @@ func (b *bridgeMgr) unsetNAT(addr []netlink.Addr) error {
 		}
 	}
 
+	for _, line := range rule6HandlerP.FindAllStringSubmatch(job.Streams.Stdout(), -1) {
+		ip := line[1]
+		handle := line[2]
+		if utils.InString(ips, ip) {
+			pm.System("nft", "delete", "rule", "ip6", "nat6", "post", "handle", handle)
+		}
+	}
+
 	return nil
 }
 
@@ -477,7 +636,7 @@ func (b *bridgeMgr) nft(br string) error {
 			Chains: nft.Chains{
 				"input": nft.Chain{
 					Rules: []nft.Rule{
-						{Body: fmt.Sprintf("iif %s udp dport {53,67,68} accept", name)},
+						{Body: fmt.Sprintf("iif %s udp dport {53,67,68,547} accept", name)},
 					},
 				},
 				"forward": nft.Chain{
@@ -490,7 +649,29 @@ func (b *bridgeMgr) nft(br string) error {
 		},
 	}
 
-	return nft.Apply(n)
+	if err := nft.Apply(n); err != nil {
+		return err
+	}
+
+	//the ipv6 traffic is marked the same way, it's not fatal if the node has no ipv6 nat
+	n6 := nft.Nft{
+		"nat6": nft.Table{
+			Family: nft.FamilyIP6,
+			Chains: nft.Chains{
+				"pre": nft.Chain{
+					Rules: []nft.Rule{
+						{Body: fmt.Sprintf("iif %s meta mark set 1", name)},
+					},
+				},
+			},
+		},
+	}
+
+	if err := nft.Apply(n6); err != nil {
+		log.Errorf("failed to set ipv6 rules for bridge %s: %s", br, err)
+	}
+
+	return nil
 }
 
 func (b *bridgeMgr) unNFT(idx int) error {
@@ -509,7 +690,7 @@ func (b *bridgeMgr) unNFT(idx int) error {
 		for cname, chain := range table.Chains {
 			for _, rule := range chain.Rules {
 				if ok := pat.MatchString(rule.Body); ok {
-					if err := nft.Drop(tname, cname, rule.Handle); err != nil {
+					if err := nft.Drop(table.Family, tname, cname, rule.Handle); err != nil {
 						log.Errorf("nft delete rule: %s", err)
 						errored = true
 					}
@@ -562,7 +743,7 @@ func (b *bridgeMgr) delete(cmd *pm.Command) (interface{}, error) {
 	//make sure to stop dnsmasq, just in case it's running
 	pm.Kill(fmt.Sprintf("dnsmasq-%s", link.Attrs().Name))
 
-	addresses, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	addresses, err := netlink.AddrList(link, netlink.FAMILY_ALL)
 	if err != nil {
 		return nil, err
 	}
@@ -575,6 +756,8 @@ func (b *bridgeMgr) delete(cmd *pm.Command) (interface{}, error) {
 		return nil, err
 	}
 
+	ipam.Unregister(args.Name)
+
 	//we remove the bridge first before we remove the nft rules
 	if err := b.unNFT(link.Attrs().Index); err != nil {
 		log.Errorf("error cleaning up nft rules for bridge %s: %s", args.Name, err)
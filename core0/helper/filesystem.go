@@ -163,6 +163,15 @@ func getMetaDB(namespace, src string) (string, error) {
 }
 
 func MountFList(namespace, storage, src string, target string, hooks ...pm.RunnerHook) error {
+	return mountFList(namespace, storage, src, target, true, hooks...)
+}
+
+//RemountFList mounts the flist on top of the writable layer that is already in the backend of the namespace
+func RemountFList(namespace, storage, src string, target string, hooks ...pm.RunnerHook) error {
+	return mountFList(namespace, storage, src, target, false, hooks...)
+}
+
+func mountFList(namespace, storage, src string, target string, reset bool, hooks ...pm.RunnerHook) error {
 	//check
 	if err := os.MkdirAll(target, 0755); err != nil {
 		return err
@@ -171,22 +180,27 @@ func MountFList(namespace, storage, src string, target string, hooks ...pm.Runne
 	hash := Hash(src)
 	backend := path.Join(CacheBaseDir, namespace, hash)
 
-	os.RemoveAll(backend)
+	if reset {
+		os.RemoveAll(backend)
+	}
 	os.MkdirAll(backend, 0755)
 
 	cache := settings.Settings.Globals.Get("cache", path.Join(CacheBaseDir, "zerofs"))
 	g8ufs := []string{
-		"-reset",
 		"-backend", backend,
 		"-cache", cache,
 	}
 
+	if reset {
+		g8ufs = append([]string{"-reset"}, g8ufs...)
+	}
+
 	if strings.HasPrefix(src, "restic:") {
-		if err := RestoreRepo(
-			strings.TrimPrefix(src, "restic:"),
-			path.Join(backend, "ro"),
-		); err != nil {
-			return err
+		ro := path.Join(backend, "ro")
+		if _, err := os.Stat(ro); reset || os.IsNotExist(err) {
+			if err := RestoreRepo(strings.TrimPrefix(src, "restic:"), ro); err != nil {
+				return err
+			}
 		}
 	} else {
 		//assume an flist, an flist requires the meat and storage url
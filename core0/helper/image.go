@@ -0,0 +1,729 @@
+package helper
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/utils"
+)
+
+const (
+	//ImageCacheDir is where the image layers are unpacked, on btrfs each layer is a snapshot of its parent
+	ImageCacheDir = "/var/cache/images"
+
+	ImageOCI           = "oci"            //oci:<layout directory>[:<ref>]
+	ImageOCIArchive    = "oci-archive"    //oci-archive:<tar of a layout directory>[:<ref>]
+	ImageDockerArchive = "docker-archive" //docker-archive:<docker save tarball>[:<repo:tag>]
+
+	ociRefAnnotation = "org.opencontainers.image.ref.name"
+	whiteoutPrefix   = ".wh."
+	whiteoutOpaque   = ".wh..wh..opq"
+	btrfsMagic       = 0x9123683E
+)
+
+var (
+	imagesM sync.Mutex
+
+	digestPattern = regexp.MustCompile(`^[a-z0-9]+:[a-f0-9]{32,}$`)
+)
+
+//ImageConfig is the runtime config of an image
+type ImageConfig struct {
+	Entrypoint []string `json:"Entrypoint"`
+	Cmd        []string `json:"Cmd"`
+	Env        []string `json:"Env"`
+	WorkingDir string   `json:"WorkingDir"`
+	User       string   `json:"User"`
+}
+
+//Image is an unpacked image
+type Image struct {
+	Path      string //image root filesystem, must not be modified
+	Subvolume bool   //the root filesystem is a btrfs subvolume that can be snapshotted
+	Config    ImageConfig
+}
+
+type imageDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations"`
+	Platform    *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	} `json:"platform"`
+}
+
+type imageIndex struct {
+	Manifests []imageDescriptor `json:"manifests"`
+}
+
+type imageManifest struct {
+	Config imageDescriptor   `json:"config"`
+	Layers []imageDescriptor `json:"layers"`
+}
+
+type dockerManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+//imageLayer a layer file and its digest
+type imageLayer struct {
+	file   string
+	digest string
+}
+
+//IsImage checks if a container root is an image
+func IsImage(src string) bool {
+	for _, kind := range []string{ImageOCI, ImageOCIArchive, ImageDockerArchive} {
+		if strings.HasPrefix(src, kind+":") {
+			return true
+		}
+	}
+
+	return false
+}
+
+//parseImageSource splits an image source in its kind, path and optional reference
+func parseImageSource(src string) (kind, p, ref string, err error) {
+	parts := strings.SplitN(src, ":", 3)
+	if len(parts) < 2 || len(parts[1]) == 0 {
+		return "", "", "", fmt.Errorf("invalid image '%s'", src)
+	}
+
+	kind, p = parts[0], parts[1]
+	if len(parts) == 3 {
+		ref = parts[2]
+	}
+
+	if !path.IsAbs(p) {
+		return "", "", "", fmt.Errorf("image path '%s' must be absolute", p)
+	}
+
+	return
+}
+
+//ValidateImage checks that the image source is well formed and exists
+func ValidateImage(src string) error {
+	_, p, _, err := parseImageSource(src)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(p); err != nil {
+		return fmt.Errorf("image '%s' does not exist", p)
+	}
+
+	return nil
+}
+
+//openImageFile opens a file of an image directory or an extracted archive, symlinks are resolved inside the directory
+func openImageFile(dir, name string) (*os.File, error) {
+	p, err := utils.InRoot(dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(p)
+}
+
+func loadImageJSON(dir, name string, v interface{}) error {
+	file, err := openImageFile(dir, name)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+	return json.NewDecoder(file).Decode(v)
+}
+
+//verifier hashes the content of a blob so it can be checked against the blob digest
+type verifier struct {
+	hash.Hash
+	digest string
+}
+
+func newVerifier(digest string) (*verifier, error) {
+	var h hash.Hash
+	switch strings.SplitN(digest, ":", 2)[0] {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, fmt.Errorf("unsupported digest '%s'", digest)
+	}
+
+	return &verifier{Hash: h, digest: digest}, nil
+}
+
+func (v *verifier) verify() error {
+	algorithm := strings.SplitN(v.digest, ":", 2)[0]
+	if actual := fmt.Sprintf("%s:%x", algorithm, v.Sum(nil)); actual != v.digest {
+		return fmt.Errorf("digest mismatch, expected '%s' got '%s'", v.digest, actual)
+	}
+
+	return nil
+}
+
+//loadBlobJSON loads a json blob of an oci layout, the blob is checked against its digest
+func loadBlobJSON(dir, digest string, v interface{}) error {
+	blob, err := blobPath(digest)
+	if err != nil {
+		return err
+	}
+
+	file, err := openImageFile(dir, blob)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+	verifier, err := newVerifier(digest)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(io.TeeReader(file, verifier))
+	if err != nil {
+		return err
+	}
+
+	if err := verifier.verify(); err != nil {
+		return fmt.Errorf("invalid blob %s: %s", blob, err)
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func blobPath(digest string) (string, error) {
+	if !digestPattern.MatchString(digest) {
+		return "", fmt.Errorf("invalid digest '%s'", digest)
+	}
+
+	return path.Join("blobs", strings.Replace(digest, ":", "/", 1)), nil
+}
+
+func isIndex(mediaType string) bool {
+	return mediaType == "application/vnd.oci.image.index.v1+json" ||
+		mediaType == "application/vnd.docker.distribution.manifest.list.v2+json"
+}
+
+//selectManifest selects a manifest from an index by reference, or by platform, or the only one
+func selectManifest(index *imageIndex, ref string) (*imageDescriptor, error) {
+	if len(ref) != 0 {
+		for i := range index.Manifests {
+			if index.Manifests[i].Annotations[ociRefAnnotation] == ref {
+				return &index.Manifests[i], nil
+			}
+		}
+
+		return nil, fmt.Errorf("image reference '%s' not found", ref)
+	}
+
+	if len(index.Manifests) == 1 {
+		return &index.Manifests[0], nil
+	}
+
+	for i := range index.Manifests {
+		platform := index.Manifests[i].Platform
+		if platform != nil && platform.OS == "linux" && platform.Architecture == runtime.GOARCH {
+			return &index.Manifests[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("image has %d manifests, a reference is required", len(index.Manifests))
+}
+
+//ociImage gets the config and layers of an oci layout directory
+func ociImage(dir, ref string) (*ImageConfig, []imageLayer, error) {
+	var index imageIndex
+	if err := loadImageJSON(dir, "index.json", &index); err != nil {
+		return nil, nil, fmt.Errorf("invalid oci layout: %s", err)
+	}
+
+	descriptor, err := selectManifest(&index, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//nested index, the platform manifest is selected
+	for isIndex(descriptor.MediaType) {
+		var nested imageIndex
+		if err := loadBlobJSON(dir, descriptor.Digest, &nested); err != nil {
+			return nil, nil, err
+		}
+
+		if descriptor, err = selectManifest(&nested, ""); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var manifest imageManifest
+	if err := loadBlobJSON(dir, descriptor.Digest, &manifest); err != nil {
+		return nil, nil, err
+	}
+
+	var config struct {
+		Config ImageConfig `json:"config"`
+	}
+
+	if err := loadBlobJSON(dir, manifest.Config.Digest, &config); err != nil {
+		return nil, nil, err
+	}
+
+	var layers []imageLayer
+	for _, layer := range manifest.Layers {
+		blob, err := blobPath(layer.Digest)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		layers = append(layers, imageLayer{file: blob, digest: layer.Digest})
+	}
+
+	return &config.Config, layers, nil
+}
+
+func fileDigest(dir, name string) (string, error) {
+	file, err := openImageFile(dir, name)
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), nil
+}
+
+//dockerImage gets the config and layers of an extracted docker save tarball
+func dockerImage(dir, ref string) (*ImageConfig, []imageLayer, error) {
+	var manifests []dockerManifest
+	if err := loadImageJSON(dir, "manifest.json", &manifests); err != nil {
+		return nil, nil, fmt.Errorf("invalid docker archive: %s", err)
+	}
+
+	var manifest *dockerManifest
+	for i := range manifests {
+		if len(ref) == 0 && len(manifests) == 1 {
+			manifest = &manifests[i]
+		}
+
+		for _, tag := range manifests[i].RepoTags {
+			if tag == ref {
+				manifest = &manifests[i]
+			}
+		}
+	}
+
+	if manifest == nil {
+		if len(ref) == 0 {
+			return nil, nil, fmt.Errorf("archive has %d images, a reference is required", len(manifests))
+		}
+
+		return nil, nil, fmt.Errorf("image reference '%s' not found", ref)
+	}
+
+	var config struct {
+		Config ImageConfig `json:"config"`
+	}
+
+	if err := loadImageJSON(dir, manifest.Config, &config); err != nil {
+		return nil, nil, err
+	}
+
+	var layers []imageLayer
+	for _, layer := range manifest.Layers {
+		digest, err := fileDigest(dir, layer)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		layers = append(layers, imageLayer{file: layer, digest: digest})
+	}
+
+	return &config.Config, layers, nil
+}
+
+//decompress detects a gzip stream, other streams are returned as they are
+func decompress(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(buffered)
+	}
+
+	if len(magic) == 4 && magic[0] == 0x28 && magic[1] == 0xb5 && magic[2] == 0x2f && magic[3] == 0xfd {
+		return nil, fmt.Errorf("zstd compressed layers are not supported")
+	}
+
+	return buffered, nil
+}
+
+//extractArchive extracts an image archive (tar or tar.gz) to dir
+func extractArchive(archive, dir string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+	reader, err := decompress(file)
+	if err != nil {
+		return err
+	}
+
+	return applyLayer(dir, reader, false)
+}
+
+//clearDir removes the content of dir except the entries created by the current layer
+func clearDir(dir, name string, created map[string]bool) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if created[path.Join(name, entry.Name())] {
+			continue
+		}
+
+		if err := os.RemoveAll(path.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+applyLayer extracts a layer tar stream on top of root, whiteout files remove the entries of the lower
+layers. Ownership is only restored if owners is set.
+*/
+func applyLayer(root string, r io.Reader, owners bool) error {
+	archive := tar.NewReader(r)
+	created := make(map[string]bool)
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		name := path.Clean("/" + header.Name)
+		if name == "/" {
+			continue
+		}
+
+		dir, base := path.Split(name)
+		parent, err := utils.InRoot(root, dir)
+		if err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(parent, 0755); err != nil {
+			return err
+		}
+
+		if base == whiteoutOpaque {
+			if err := clearDir(parent, path.Clean(dir), created); err != nil {
+				return err
+			}
+			continue
+		} else if strings.HasPrefix(base, whiteoutPrefix) {
+			if err := os.RemoveAll(path.Join(parent, strings.TrimPrefix(base, whiteoutPrefix))); err != nil {
+				return err
+			}
+			continue
+		}
+
+		target := path.Join(parent, base)
+		if info, err := os.Lstat(target); err == nil && !(info.IsDir() && header.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+
+		mode := header.FileInfo().Mode()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.Mkdir(target, 0755); err != nil && !os.IsExist(err) {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+			if err != nil {
+				return err
+			}
+
+			_, err = io.Copy(file, archive)
+			file.Close()
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			linkDir, linkBase := path.Split(path.Clean("/" + header.Linkname))
+			source, err := utils.InRoot(root, linkDir)
+			if err != nil {
+				return err
+			}
+
+			if err := os.Link(path.Join(source, linkBase), target); err != nil {
+				return err
+			}
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			if !owners {
+				//devices are only needed in root filesystems
+				continue
+			}
+
+			var kind uint32 = syscall.S_IFIFO
+			if header.Typeflag == tar.TypeChar {
+				kind = syscall.S_IFCHR
+			} else if header.Typeflag == tar.TypeBlock {
+				kind = syscall.S_IFBLK
+			}
+
+			dev := int((header.Devmajor << 8) | (header.Devminor & 0xff) | ((header.Devminor &^ 0xff) << 12))
+			if err := syscall.Mknod(target, kind|uint32(mode.Perm()), dev); err != nil {
+				return err
+			}
+		default:
+			//pax headers and other entries that are not files
+			continue
+		}
+
+		created[name] = true
+		if owners {
+			if err := os.Lchown(target, header.Uid, header.Gid); err != nil {
+				return err
+			}
+		}
+
+		if mode&os.ModeSymlink != 0 {
+			continue
+		}
+
+		//chmod after chown, chown clears the setuid and setgid bits
+		if err := os.Chmod(target, mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeDir {
+			os.Chtimes(target, time.Now(), header.ModTime)
+		}
+	}
+}
+
+func isBtrfs(dir string) bool {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return false
+	}
+
+	return uint32(stat.Type) == btrfsMagic
+}
+
+//chainKey identifies a layer and all its parents
+func chainKey(parent, digest string) string {
+	return Hash(parent + " " + digest)
+}
+
+//applyLayerFile applies a layer on top of target, the layer is refused if its content doesn't match its digest
+func applyLayerFile(dir string, layer imageLayer, target string) error {
+	file, err := openImageFile(dir, layer.file)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+	verifier, err := newVerifier(layer.digest)
+	if err != nil {
+		return err
+	}
+
+	content := io.TeeReader(file, verifier)
+	reader, err := decompress(content)
+	if err != nil {
+		return err
+	}
+
+	if err := applyLayer(target, reader, true); err != nil {
+		return fmt.Errorf("failed to apply layer %s: %s", layer.digest, err)
+	}
+
+	//the tar stream ends before the blob (padding and compression trailer)
+	if _, err := io.Copy(ioutil.Discard, content); err != nil {
+		return err
+	}
+
+	if err := verifier.verify(); err != nil {
+		return fmt.Errorf("invalid layer %s: %s", layer.file, err)
+	}
+
+	return nil
+}
+
+//unpackLayers unpacks the image layers in the cache, on btrfs each layer is a snapshot of its parent so images
+//share their common layers, otherwise only the full image is cached
+func unpackLayers(dir string, layers []imageLayer) (string, bool, error) {
+	if len(layers) == 0 {
+		return "", false, fmt.Errorf("image has no layers")
+	}
+
+	if err := os.MkdirAll(ImageCacheDir, 0755); err != nil {
+		return "", false, err
+	}
+
+	if !isBtrfs(ImageCacheDir) {
+		var key string
+		for _, layer := range layers {
+			key = chainKey(key, layer.digest)
+		}
+
+		top := path.Join(ImageCacheDir, key)
+		if _, err := os.Stat(top); err == nil {
+			return top, false, nil
+		}
+
+		tmp := top + ".tmp"
+		os.RemoveAll(tmp)
+		if err := os.Mkdir(tmp, 0755); err != nil {
+			return "", false, err
+		}
+
+		for _, layer := range layers {
+			if err := applyLayerFile(dir, layer, tmp); err != nil {
+				os.RemoveAll(tmp)
+				return "", false, err
+			}
+		}
+
+		return top, false, os.Rename(tmp, top)
+	}
+
+	var key, parent string
+	for _, layer := range layers {
+		key = chainKey(key, layer.digest)
+		current := path.Join(ImageCacheDir, key)
+		if _, err := os.Stat(current); err == nil {
+			parent = current
+			continue
+		}
+
+		tmp := current + ".tmp"
+		pm.System("btrfs", "subvolume", "delete", tmp)
+
+		var err error
+		if len(parent) == 0 {
+			_, err = pm.System("btrfs", "subvolume", "create", tmp)
+		} else {
+			_, err = pm.System("btrfs", "subvolume", "snapshot", parent, tmp)
+		}
+
+		if err != nil {
+			return "", false, err
+		}
+
+		if err := applyLayerFile(dir, layer, tmp); err != nil {
+			pm.System("btrfs", "subvolume", "delete", tmp)
+			return "", false, err
+		}
+
+		if err := os.Rename(tmp, current); err != nil {
+			return "", false, err
+		}
+
+		parent = current
+	}
+
+	return parent, true, nil
+}
+
+//PrepareImage unpacks an image (see IsImage) in the image cache, layers that are already unpacked are reused
+func PrepareImage(src string) (*Image, error) {
+	kind, p, ref, err := parseImageSource(src)
+	if err != nil {
+		return nil, err
+	}
+
+	imagesM.Lock()
+	defer imagesM.Unlock()
+
+	dir := p
+	if kind == ImageOCIArchive || kind == ImageDockerArchive {
+		if err := os.MkdirAll(ImageCacheDir, 0755); err != nil {
+			return nil, err
+		}
+
+		if dir, err = ioutil.TempDir(ImageCacheDir, ".archive-"); err != nil {
+			return nil, err
+		}
+
+		defer os.RemoveAll(dir)
+		if err := extractArchive(p, dir); err != nil {
+			return nil, fmt.Errorf("failed to extract image archive: %s", err)
+		}
+	}
+
+	var config *ImageConfig
+	var layers []imageLayer
+	switch kind {
+	case ImageOCI, ImageOCIArchive:
+		config, layers, err = ociImage(dir, ref)
+	case ImageDockerArchive:
+		config, layers, err = dockerImage(dir, ref)
+	default:
+		return nil, fmt.Errorf("unknown image type '%s'", kind)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	root, subvolume, err := unpackLayers(dir, layers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Image{
+		Path:      root,
+		Subvolume: subvolume,
+		Config:    *config,
+	}, nil
+}
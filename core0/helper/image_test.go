@@ -0,0 +1,239 @@
+package helper
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+func makeTar(t *testing.T, entries ...tarEntry) []byte {
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.name,
+			Typeflag: entry.typeflag,
+			Linkname: entry.linkname,
+			Mode:     0644,
+			Size:     int64(len(entry.body)),
+		}
+
+		if entry.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+
+		if !assert.NoError(t, writer.WriteHeader(header)) {
+			t.FailNow()
+		}
+
+		if _, err := writer.Write([]byte(entry.body)); !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+
+	if !assert.NoError(t, writer.Close()) {
+		t.FailNow()
+	}
+
+	return buf.Bytes()
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "image-test")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return dir
+}
+
+func TestIsImage(t *testing.T) {
+	assert.True(t, IsImage("oci:/images/alpine"))
+	assert.True(t, IsImage("oci-archive:/images/alpine.tar:latest"))
+	assert.True(t, IsImage("docker-archive:/images/alpine.tar"))
+	assert.False(t, IsImage("https://hub.gig.tech/gig-official-apps/ubuntu1604.flist"))
+	assert.False(t, IsImage("/images/alpine"))
+}
+
+func TestParseImageSource(t *testing.T) {
+	kind, p, ref, err := parseImageSource("docker-archive:/images/alpine.tar:alpine:3.6")
+	if assert.NoError(t, err) {
+		assert.Equal(t, ImageDockerArchive, kind)
+		assert.Equal(t, "/images/alpine.tar", p)
+		assert.Equal(t, "alpine:3.6", ref)
+	}
+
+	_, _, _, err = parseImageSource("oci:images/alpine")
+	assert.Error(t, err)
+
+	_, _, _, err = parseImageSource("oci:")
+	assert.Error(t, err)
+}
+
+func TestApplyLayerWhiteouts(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	lower := makeTar(t,
+		tarEntry{name: "etc/", typeflag: tar.TypeDir},
+		tarEntry{name: "etc/hostname", typeflag: tar.TypeReg, body: "lower"},
+		tarEntry{name: "etc/removed", typeflag: tar.TypeReg, body: "lower"},
+		tarEntry{name: "opaque/", typeflag: tar.TypeDir},
+		tarEntry{name: "opaque/old", typeflag: tar.TypeReg, body: "lower"},
+	)
+
+	upper := makeTar(t,
+		tarEntry{name: "etc/.wh.removed", typeflag: tar.TypeReg},
+		tarEntry{name: "etc/hostname", typeflag: tar.TypeReg, body: "upper"},
+		tarEntry{name: "opaque/new", typeflag: tar.TypeReg, body: "upper"},
+		tarEntry{name: "opaque/.wh..wh..opq", typeflag: tar.TypeReg},
+		tarEntry{name: "bin/sh", typeflag: tar.TypeSymlink, linkname: "busybox"},
+		tarEntry{name: "bin/ls", typeflag: tar.TypeLink, linkname: "etc/hostname"},
+	)
+
+	if !assert.NoError(t, applyLayer(root, bytes.NewReader(lower), false)) {
+		t.FailNow()
+	}
+
+	if !assert.NoError(t, applyLayer(root, bytes.NewReader(upper), false)) {
+		t.FailNow()
+	}
+
+	data, err := ioutil.ReadFile(path.Join(root, "etc", "hostname"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "upper", string(data))
+	}
+
+	_, err = os.Stat(path.Join(root, "etc", "removed"))
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = os.Stat(path.Join(root, "opaque", "old"))
+	assert.True(t, os.IsNotExist(err))
+
+	//entries of the same layer are kept by the opaque whiteout
+	_, err = os.Stat(path.Join(root, "opaque", "new"))
+	assert.NoError(t, err)
+
+	link, err := os.Readlink(path.Join(root, "bin", "sh"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "busybox", link)
+	}
+
+	data, err = ioutil.ReadFile(path.Join(root, "bin", "ls"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "upper", string(data))
+	}
+}
+
+func writeBlob(t *testing.T, layout string, data []byte) string {
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	p := path.Join(layout, "blobs", "sha256", digest[7:])
+	if !assert.NoError(t, ioutil.WriteFile(p, data, 0644)) {
+		t.FailNow()
+	}
+
+	return digest
+}
+
+func writeJSONBlob(t *testing.T, layout string, v interface{}) string {
+	data, err := json.Marshal(v)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return writeBlob(t, layout, data)
+}
+
+func TestOCIImage(t *testing.T) {
+	layout := tempDir(t)
+	defer os.RemoveAll(layout)
+
+	os.MkdirAll(path.Join(layout, "blobs", "sha256"), 0755)
+
+	layer := writeBlob(t, layout, makeTar(t, tarEntry{name: "hello", typeflag: tar.TypeReg, body: "world"}))
+	config := writeJSONBlob(t, layout, map[string]interface{}{
+		"config": ImageConfig{
+			Entrypoint: []string{"/bin/sh", "-c"},
+			Cmd:        []string{"echo hello"},
+			Env:        []string{"PATH=/bin"},
+			WorkingDir: "/root",
+		},
+	})
+
+	manifest := writeJSONBlob(t, layout, map[string]interface{}{
+		"config": map[string]string{"digest": config},
+		"layers": []map[string]string{{"digest": layer}},
+	})
+
+	index, _ := json.Marshal(map[string]interface{}{
+		"manifests": []map[string]interface{}{
+			{
+				"digest":      manifest,
+				"annotations": map[string]string{ociRefAnnotation: "latest"},
+			},
+		},
+	})
+
+	if !assert.NoError(t, ioutil.WriteFile(path.Join(layout, "index.json"), index, 0644)) {
+		t.FailNow()
+	}
+
+	cfg, layers, err := ociImage(layout, "latest")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, []string{"/bin/sh", "-c"}, cfg.Entrypoint)
+	assert.Equal(t, []string{"echo hello"}, cfg.Cmd)
+	assert.Equal(t, []string{"PATH=/bin"}, cfg.Env)
+	assert.Equal(t, "/root", cfg.WorkingDir)
+	assert.Equal(t, []imageLayer{{file: path.Join("blobs", "sha256", layer[7:]), digest: layer}}, layers)
+
+	//a single manifest doesn't need a reference
+	_, _, err = ociImage(layout, "")
+	assert.NoError(t, err)
+
+	_, _, err = ociImage(layout, "unknown")
+	assert.Error(t, err)
+
+	//blobs are checked against their digest
+	if !assert.NoError(t, ioutil.WriteFile(path.Join(layout, "blobs", "sha256", config[7:]), []byte("{}"), 0644)) {
+		t.FailNow()
+	}
+
+	_, _, err = ociImage(layout, "latest")
+	assert.Error(t, err)
+}
+
+func TestApplyLayerFileDigest(t *testing.T) {
+	layout := tempDir(t)
+	defer os.RemoveAll(layout)
+
+	os.MkdirAll(path.Join(layout, "blobs", "sha256"), 0755)
+	digest := writeBlob(t, layout, makeTar(t, tarEntry{name: "hello", typeflag: tar.TypeReg, body: "world"}))
+	layer := imageLayer{file: path.Join("blobs", "sha256", digest[7:]), digest: digest}
+
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	assert.NoError(t, applyLayerFile(layout, layer, root))
+
+	//the same content under another digest is refused
+	layer.digest = fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("other")))
+	assert.Error(t, applyLayerFile(layout, layer, root))
+}
@@ -1,60 +1,249 @@
 package helper
 
 import (
+	"encoding/json"
 	"fmt"
-	"github.com/zero-os/0-core/base/pm"
+	"io/ioutil"
 	"net/url"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/zero-os/0-core/base/pm"
 )
 
-func RestoreRepo(repo, target string, include ...string) error {
-	//file://password/path/to/repo
+const (
+	//ResticCredentials is where the passwords of the restic repositories are kept, so clients don't
+	//need to send the password with every url.
+	ResticCredentials = "/var/cache/corex/restic/credentials.json"
+)
+
+var (
+	credentialsM sync.Mutex
+)
+
+//ResticRepo is a parsed restic url
+type ResticRepo struct {
+	Repo     string
+	Password string
+	Snapshot string
+}
+
+//repoOf gets the restic repository of a url, which is the path for the file scheme (or no scheme),
+//or the url without the query and fragment
+func repoOf(u *url.URL) string {
+	if u.Scheme == "file" || len(u.Scheme) == 0 {
+		return u.Path
+	}
+
+	repo := *u
+	repo.RawQuery = ""
+	repo.Fragment = ""
+	return repo.String()
+}
+
+/*
+ParseResticURL parses a restic url of the form `<repo>[?password=<password>][#<snapshot>]`, for the file
+scheme (or no scheme) the repo is the url path. If the url has no password, the password stored for the
+repo with SetResticPassword is used.
+*/
+func ParseResticURL(repo string) (*ResticRepo, error) {
 	u, err := url.Parse(repo)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	password := u.Query().Get("password")
-	snapshot := u.Fragment
-	if u.Scheme == "file" || len(u.Scheme) == 0 {
-		repo = u.Path
-	} else {
-		u.Fragment = ""
-		u.RawQuery = ""
-		repo = u.String()
+	r := ResticRepo{
+		Repo:     repoOf(u),
+		Password: u.Query().Get("password"),
+		Snapshot: u.Fragment,
 	}
 
-	restic := []string{
-		"-r", repo,
-		"restore",
-		"-t", target,
+	if len(r.Repo) == 0 {
+		return nil, fmt.Errorf("invalid restic url, missing repository")
 	}
 
-	for _, i := range include {
-		restic = append(restic, "-i", i)
+	if len(r.Password) != 0 {
+		return &r, nil
+	}
+
+	credentials, err := loadResticCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	password, ok := credentials[r.Repo]
+	if !ok {
+		return nil, fmt.Errorf("no password for restic repository '%s'", r.Repo)
 	}
 
-	restic = append(restic, snapshot)
+	r.Password = password
+	return &r, nil
+}
 
+//Run runs restic against the repo with the given arguments
+func (r *ResticRepo) Run(args ...string) (*pm.JobResult, error) {
 	job, err := pm.Run(
 		&pm.Command{
 			Command: pm.CommandSystem,
 			Arguments: pm.MustArguments(
 				pm.SystemCommandArguments{
 					Name:  "restic",
-					Args:  restic,
-					StdIn: password,
+					Args:  append([]string{"-r", r.Repo}, args...),
+					StdIn: r.Password,
 				},
 			),
 		},
 	)
 
+	if err != nil {
+		return nil, err
+	}
+
+	result := job.Wait()
+	if result.State != pm.StateSuccess {
+		return result, fmt.Errorf("restic %s failed: %s", args[0], result.Streams.Stderr())
+	}
+
+	return result, nil
+}
+
+//Restore restores the repo snapshot to target, if include is set only the matching files are restored
+func (r *ResticRepo) Restore(target string, include ...string) error {
+	if len(r.Snapshot) == 0 {
+		return fmt.Errorf("snapshot id is required")
+	}
+
+	restic := []string{
+		"restore",
+		"-t", target,
+	}
+
+	for _, i := range include {
+		restic = append(restic, "-i", i)
+	}
+
+	restic = append(restic, r.Snapshot)
+
+	_, err := r.Run(restic...)
+	return err
+}
+
+func RestoreRepo(repo, target string, include ...string) error {
+	//file:///path/to/repo?password=<password>#<snapshot>
+	r, err := ParseResticURL(repo)
 	if err != nil {
 		return err
 	}
 
-	if result := job.Wait(); result.State != pm.StateSuccess {
-		return fmt.Errorf("failed to restore snapshot: %s", result.Streams.Stderr())
+	return r.Restore(target, include...)
+}
+
+func loadResticCredentials() (map[string]string, error) {
+	credentialsM.Lock()
+	defer credentialsM.Unlock()
+
+	return readResticCredentials()
+}
+
+//readResticCredentials must be called with the credentials lock held
+func readResticCredentials() (map[string]string, error) {
+	credentials := make(map[string]string)
+
+	data, err := ioutil.ReadFile(ResticCredentials)
+	if os.IsNotExist(err) {
+		return credentials, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return nil, fmt.Errorf("invalid restic credentials file: %s", err)
+	}
+
+	return credentials, nil
+}
+
+func updateResticCredentials(update func(credentials map[string]string)) error {
+	credentialsM.Lock()
+	defer credentialsM.Unlock()
+
+	credentials, err := readResticCredentials()
+	if err != nil {
+		return err
+	}
+
+	update(credentials)
+
+	data, err := json.Marshal(credentials)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(ResticCredentials), 0700); err != nil {
+		return err
+	}
+
+	tmp := ResticCredentials + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, ResticCredentials)
+}
+
+//SetResticPassword stores the password of a restic repository on the node, repo is a restic url
+//without password
+func SetResticPassword(repo, password string) error {
+	u, err := url.Parse(repo)
+	if err != nil {
+		return err
+	}
+
+	if len(u.Query().Get("password")) != 0 || len(u.Fragment) != 0 {
+		return fmt.Errorf("repository url must not have a password or a snapshot")
+	}
+
+	key := repoOf(u)
+	if len(key) == 0 {
+		return fmt.Errorf("invalid restic url, missing repository")
+	}
+
+	if len(password) == 0 {
+		return fmt.Errorf("password is required")
+	}
+
+	return updateResticCredentials(func(credentials map[string]string) {
+		credentials[key] = password
+	})
+}
+
+//RemoveResticPassword removes the stored password of a restic repository
+func RemoveResticPassword(repo string) error {
+	u, err := url.Parse(repo)
+	if err != nil {
+		return err
+	}
+
+	key := repoOf(u)
+	return updateResticCredentials(func(credentials map[string]string) {
+		delete(credentials, key)
+	})
+}
+
+//ResticRepos lists the repositories that have a stored password
+func ResticRepos() ([]string, error) {
+	credentials, err := loadResticCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	repos := make([]string, 0, len(credentials))
+	for repo := range credentials {
+		repos = append(repos, repo)
 	}
 
-	return nil
+	sort.Strings(repos)
+	return repos, nil
 }
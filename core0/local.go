@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/pm/stream"
 	"github.com/zero-os/0-core/base/utils"
 	"github.com/zero-os/0-core/core0/subsys/containers"
 	"net"
@@ -19,10 +20,24 @@ type Local struct {
 
 type LocalCmd struct {
 	Sync      bool            `json:"sync"`
+	Attach    bool            `json:"attach"` //attach to the command, only for commands that run in containers
 	Container string          `json:"container"`
 	Content   json.RawMessage `json:"content"`
 }
 
+/*
+LocalFrame is the unit of the attach protocol. Once attached, the server sends the job output messages
+followed by a final frame with the job result, and the client sends its stdin and terminal size changes.
+*/
+type LocalFrame struct {
+	Message *stream.Message `json:"message,omitempty"`
+	Result  *pm.JobResult   `json:"result,omitempty"`
+	Stdin   []byte          `json:"stdin,omitempty"`
+	EOF     bool            `json:"eof,omitempty"`
+	Rows    uint16          `json:"rows,omitempty"`
+	Cols    uint16          `json:"cols,omitempty"`
+}
+
 func NewLocal(mgr containers.ContainerManager, s string) (*Local, error) {
 	if utils.Exists(s) {
 		os.Remove(s)
@@ -36,6 +51,12 @@ func NewLocal(mgr containers.ContainerManager, s string) (*Local, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	if err := os.Chmod(s, socketMode()); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
 	return &Local{
 		mgr:      mgr,
 		listener: listener,
@@ -56,24 +77,41 @@ func (l *Local) container(x string) containers.Container {
 	return l.mgr.GetOneWithTags(tags...)
 }
 
-func (l *Local) server(con net.Conn) {
+func (l *Local) server(con *net.UnixConn) {
 	//read command
 	result := &pm.JobResult{
 		State: pm.StateError,
 	}
 
+	var lcmd LocalCmd
 	defer func() {
 		//send result
-		m, _ := json.Marshal(result)
+		var m []byte
+		if lcmd.Attach {
+			m, _ = json.Marshal(LocalFrame{Result: result})
+		} else {
+			m, _ = json.Marshal(result)
+		}
+
 		if _, err := con.Write(m); err != nil {
 			log.Errorf("Failed to write response to local transport: %s", err)
 		}
 		con.Close()
 	}()
 
-	decoder := json.NewDecoder(con)
-	var lcmd LocalCmd
+	cred, err := peerCredentials(con)
+	if err != nil {
+		result.Streams = pm.Streams{"", fmt.Sprintf("Failed to get peer credentials: %s", err)}
+		return
+	}
 
+	scope, err := l.scope(cred)
+	if err != nil {
+		result.Streams = pm.Streams{"", err.Error()}
+		return
+	}
+
+	decoder := json.NewDecoder(con)
 	if err := decoder.Decode(&lcmd); err != nil {
 		result.Streams = pm.Streams{"", fmt.Sprintf("Failed to decode message: %s", err)}
 		return
@@ -92,6 +130,22 @@ func (l *Local) server(con net.Conn) {
 		return
 	}
 
+	if container, err = scope.allowed(cmd.Command, container); err != nil {
+		log.Warningf("local transport (uid: %d, pid: %d): %s", cred.Uid, cred.Pid, err)
+		result.Streams = pm.Streams{"", err.Error()}
+		return
+	}
+
+	if lcmd.Attach {
+		if container == nil {
+			result.Streams = pm.Streams{"", "attach is only supported for commands that run in containers"}
+			return
+		}
+
+		result = l.attach(con, decoder, container, cmd)
+		return
+	}
+
 	if container == nil {
 		job, err := pm.Run(cmd)
 		if err != nil {
@@ -114,12 +168,67 @@ func (l *Local) server(con net.Conn) {
 	}
 }
 
+//attach runs cmd in the container and proxies the frames of the attach protocol till the job exits
+func (l *Local) attach(con *net.UnixConn, decoder *json.Decoder, container containers.Container, cmd *pm.Command) *pm.JobResult {
+	attachment, err := l.mgr.Attach(container.ID(), cmd)
+	if err != nil {
+		return &pm.JobResult{
+			State:   pm.StateError,
+			Streams: pm.Streams{"", fmt.Sprintf("Failed to dispatch command (%s): %s", cmd.Command, err)},
+		}
+	}
+
+	go func() {
+		for {
+			var frame LocalFrame
+			if err := decoder.Decode(&frame); err != nil {
+				return
+			}
+
+			var err error
+			if frame.Rows > 0 && frame.Cols > 0 {
+				err = attachment.Resize(frame.Rows, frame.Cols)
+			}
+			if err == nil && len(frame.Stdin) > 0 {
+				err = attachment.Write(frame.Stdin)
+			}
+			if err == nil && frame.EOF {
+				err = attachment.CloseInput()
+			}
+
+			if err != nil {
+				log.Errorf("failed to forward input to job '%s': %s", attachment.ID, err)
+			}
+		}
+	}()
+
+	encoder := json.NewEncoder(con)
+	for msg := range attachment.Output {
+		if err := encoder.Encode(LocalFrame{Message: msg}); err != nil {
+			log.Errorf("local transport client of job '%s' went away: %s", attachment.ID, err)
+			attachment.Detach()
+		}
+	}
+
+	result, ok := <-attachment.Result
+	if !ok {
+		return &pm.JobResult{
+			ID:      cmd.ID,
+			State:   pm.StateError,
+			Streams: pm.Streams{"", "container exited"},
+		}
+	}
+
+	return result
+}
+
 func (l *Local) start() {
 	defer l.listener.Close()
 	for {
-		con, err := l.listener.Accept()
+		con, err := l.listener.AcceptUnix()
 		if err != nil {
 			log.Errorf("local transport error: %s", err)
+			continue
 		}
 		go l.server(con)
 	}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/zero-os/0-core/base/settings"
+	"github.com/zero-os/0-core/core0/subsys/containers"
+)
+
+/*
+localScope is what a local socket peer is allowed to do. Host root has full access, processes that
+run inside a container are confined to their own container, and other local users get the commands
+granted to their uid/gid by the `[[local.rules]]` settings.
+*/
+type localScope struct {
+	full       bool
+	container  containers.Container
+	commands   []string
+	containers bool
+}
+
+func peerCredentials(con *net.UnixConn) (*syscall.Ucred, error) {
+	raw, err := con.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cred, credErr
+}
+
+func sameNamespace(pid int) bool {
+	ns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/pid", pid))
+	if err != nil {
+		return false
+	}
+
+	self, err := os.Readlink("/proc/self/ns/pid")
+	if err != nil {
+		return false
+	}
+
+	return ns == self
+}
+
+func (l *Local) scope(cred *syscall.Ucred) (*localScope, error) {
+	if !sameNamespace(int(cred.Pid)) {
+		container := l.mgr.OfPID(int(cred.Pid))
+		if container == nil {
+			return nil, fmt.Errorf("unknown peer namespace (pid: %d)", cred.Pid)
+		}
+
+		return &localScope{container: container}, nil
+	}
+
+	if cred.Uid == 0 {
+		return &localScope{full: true}, nil
+	}
+
+	scope := &localScope{}
+	for _, rule := range settings.Settings.Local.Rules {
+		if !inUint32(rule.UIDs, cred.Uid) && !inUint32(rule.GIDs, cred.Gid) {
+			continue
+		}
+
+		scope.commands = append(scope.commands, rule.Commands...)
+		scope.containers = scope.containers || rule.Containers
+	}
+
+	if len(scope.commands) == 0 {
+		return nil, fmt.Errorf("permission denied (uid: %d, gid: %d)", cred.Uid, cred.Gid)
+	}
+
+	return scope, nil
+}
+
+func inUint32(l []uint32, x uint32) bool {
+	for _, y := range l {
+		if x == y {
+			return true
+		}
+	}
+
+	return false
+}
+
+//allowed checks if command can run in the given container (nil for the host), and gets the container
+//the command should actually run in
+func (s *localScope) allowed(command string, container containers.Container) (containers.Container, error) {
+	if s.full {
+		return container, nil
+	}
+
+	if s.container != nil {
+		if container != nil && container.ID() != s.container.ID() {
+			return nil, fmt.Errorf("permission denied, can't access container %d", container.ID())
+		}
+
+		return s.container, nil
+	}
+
+	if container != nil && !s.containers {
+		return nil, fmt.Errorf("permission denied, can't access containers")
+	}
+
+	for _, pattern := range s.commands {
+		if ok, _ := path.Match(pattern, command); ok {
+			return container, nil
+		}
+	}
+
+	return nil, fmt.Errorf("permission denied, command '%s' is not allowed", command)
+}
+
+//socketMode gets the local socket permissions, the socket is only accessible by root
+//unless local rules are configured.
+func socketMode() os.FileMode {
+	if len(settings.Settings.Local.Rules) > 0 {
+		return 0666
+	}
+
+	return 0600
+}
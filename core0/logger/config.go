@@ -28,12 +28,18 @@ func (l Loggers) LogRecord(record *LogRecord) {
 }
 
 // ConfigureLogging attachs the correct message handler on top the process manager from the configurations
-func ConfigureLogging(sink *transport.Sink) {
+func ConfigureLogging(sink *transport.Sink, upstream *transport.Upstream) {
 	Current = append(Current,
 		NewConsoleLogger(settings.Settings.Logging.File.Levels),
 		NewLedisLogger(sink, settings.Settings.Logging.Ledis.Levels, settings.Settings.Logging.Ledis.Size),
 		NewStreamLogger(sink, 0),
 	)
 
+	if upstream != nil {
+		Current = append(Current,
+			NewUpstreamLogger(upstream, settings.Settings.Logging.Ledis.Levels, settings.Settings.Logging.Ledis.Size),
+		)
+	}
+
 	pm.AddHandle(Current)
 }
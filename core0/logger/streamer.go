@@ -2,7 +2,6 @@ package logger
 
 import (
 	"encoding/json"
-	"fmt"
 	"github.com/zero-os/0-core/base/pm/stream"
 	"github.com/zero-os/0-core/core0/transport"
 )
@@ -17,7 +16,12 @@ type streamLogger struct {
 	sink *transport.Sink
 	size int64
 
-	ch chan *LogRecord
+	ch chan *streamRecord
+}
+
+type streamRecord struct {
+	queue  string
+	record *LogRecord
 }
 
 // NewRedisLogger creates new redis logger handler
@@ -29,7 +33,7 @@ func NewStreamLogger(db *transport.Sink, size int64) Logger {
 	rl := &streamLogger{
 		sink: db,
 		size: size,
-		ch:   make(chan *LogRecord, MaxStreamRedisQueueSize),
+		ch:   make(chan *streamRecord, MaxStreamRedisQueueSize),
 	}
 
 	go rl.pusher()
@@ -42,7 +46,11 @@ func (l *streamLogger) LogRecord(record *LogRecord) {
 		return
 	}
 
-	l.ch <- record
+	//the queue is resolved now, the job may be gone by the time the record is pushed
+	l.ch <- &streamRecord{
+		queue:  l.sink.JobQueue(transport.StreamQueue, record.Command),
+		record: record,
+	}
 }
 
 func (l *streamLogger) pusher() {
@@ -56,12 +64,12 @@ func (l *streamLogger) pusher() {
 func (l *streamLogger) push() error {
 	for {
 		record := <-l.ch
-		bytes, err := json.Marshal(record)
+		bytes, err := json.Marshal(record.record)
 		if err != nil {
 			continue
 		}
 
-		queue := fmt.Sprintf("stream:%s", record.Command)
+		queue := record.queue
 		if _, err := l.sink.RPush([]byte(queue), bytes); err != nil {
 			return err
 		}
@@ -0,0 +1,36 @@
+package logger
+
+import (
+	"encoding/json"
+	"github.com/zero-os/0-core/core0/transport"
+)
+
+// upstreamLogger sends log records to the upstream (call-home) transport
+type upstreamLogger struct {
+	upstream *transport.Upstream
+	defaults []uint16
+	size     int64
+}
+
+// NewUpstreamLogger creates a logger that pushes log records to the upstream `logs:<node>` queue
+func NewUpstreamLogger(upstream *transport.Upstream, defaults []uint16, size int64) Logger {
+	return &upstreamLogger{
+		upstream: upstream,
+		defaults: defaults,
+		size:     size,
+	}
+}
+
+func (l *upstreamLogger) LogRecord(record *LogRecord) {
+	if !IsLoggable(l.defaults, record.Message) {
+		return
+	}
+
+	bytes, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("failed to serialize message for upstream logger: %s", err)
+		return
+	}
+
+	l.upstream.Push("logs", bytes, l.size)
+}
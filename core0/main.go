@@ -16,6 +16,8 @@ import (
 	"github.com/zero-os/0-core/core0/stats"
 	"github.com/zero-os/0-core/core0/subsys/containers"
 	"github.com/zero-os/0-core/core0/subsys/kvm"
+	"github.com/zero-os/0-core/core0/subsys/transfer"
+	"github.com/zero-os/0-core/core0/subsys/volumes"
 
 	_ "github.com/zero-os/0-core/base/builtin"
 	_ "github.com/zero-os/0-core/core0/builtin"
@@ -156,7 +158,12 @@ func main() {
 		log.Errorf("failed to start command sink: %s", err)
 	}
 
-	logger.ConfigureLogging(sink)
+	var upstream *transport.Upstream
+	if cfg, ok := transport.GetUpstreamConfig(); ok {
+		upstream = transport.NewUpstream(sink, cfg)
+	}
+
+	logger.ConfigureLogging(sink, upstream)
 
 	bs := bootstrap.NewBootstrap(options.Agent())
 	bs.First()
@@ -169,17 +176,28 @@ func main() {
 	}
 	screen.Push(row)
 
+	if err := volumes.VolumeSubsystem(); err != nil {
+		log.Errorf("failed to initialize volume subsystem: %s", err)
+	}
+
 	contMgr, err := containers.ContainerSubsystem(sink, &row.Cells[0])
 	if err != nil {
 		log.Fatal("failed to intialize container subsystem", err)
 	}
 
+	if err := transfer.TransferSubsystem(sink, contMgr); err != nil {
+		log.Errorf("failed to initialize transfer subsystem: %s", err)
+	}
+
 	bs.Second()
 
 	if err := kvm.KVMSubsystem(contMgr, &row.Cells[1]); err != nil {
 		log.Errorf("failed to initialize kvm subsystem", err)
 	}
 
+	//recreate the persistent containers once the node is fully bootstrapped
+	go contMgr.Replay()
+
 	log.Infof("Starting local transport")
 	local, err := NewLocal(contMgr, "/var/run/core.sock")
 	if err != nil {
@@ -192,11 +210,17 @@ func main() {
 	log.Infof("Starting Sinks")
 
 	sink.Start()
+	if upstream != nil {
+		upstream.Start()
+	}
 	screen.Refresh()
 
 	if config.Stats.Enabled {
 		aggregator := stats.NewLedisStatsAggregator(sink)
 		pm.AddHandle(aggregator)
+		if upstream != nil {
+			pm.AddHandle(upstream)
+		}
 	}
 
 	//wait
@@ -0,0 +1,116 @@
+package cgroups
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultBlkioWeight = 500
+
+	ThrottleReadBPS   = "read_bps"
+	ThrottleWriteBPS  = "write_bps"
+	ThrottleReadIOPS  = "read_iops"
+	ThrottleWriteIOPS = "write_iops"
+)
+
+type BlkioGroup interface {
+	Group
+	Weight(weight uint16) error
+	Throttle(kind string, device string, rate uint64) error
+	Serviced() (*BlkioServiced, error)
+}
+
+//BlkioServiced is the io done by the group on all devices
+type BlkioServiced struct {
+	ReadBytes  uint64 `json:"read_bytes"`
+	WriteBytes uint64 `json:"write_bytes"`
+	ReadOps    uint64 `json:"read_ops"`
+	WriteOps   uint64 `json:"write_ops"`
+}
+
+func mkBlkioGroup(name, subsys string) (Group, error) {
+	return &blkioCGroup{
+		cgroup{name: name, subsys: subsys},
+	}, nil
+}
+
+type blkioCGroup struct {
+	cgroup
+}
+
+//Weight sets the relative io weight (10 to 1000) of the group, 0 resets to the default weight.
+//It's a noop if the io scheduler doesn't support weights.
+func (g *blkioCGroup) Weight(weight uint16) error {
+	if !g.has("blkio.weight") {
+		if weight != 0 {
+			return fmt.Errorf("blkio weight is not supported")
+		}
+
+		return nil
+	}
+
+	if weight == 0 {
+		weight = DefaultBlkioWeight
+	}
+
+	return g.set("blkio.weight", weight)
+}
+
+//Throttle sets the max rate (bytes or operations per second) of device (major:minor), a rate of 0 removes the limit
+func (g *blkioCGroup) Throttle(kind string, device string, rate uint64) error {
+	switch kind {
+	case ThrottleReadBPS, ThrottleWriteBPS, ThrottleReadIOPS, ThrottleWriteIOPS:
+	default:
+		return fmt.Errorf("unknown throttle '%s'", kind)
+	}
+
+	return g.set(fmt.Sprintf("blkio.throttle.%s_device", kind), fmt.Sprintf("%s %d", device, rate))
+}
+
+//io sums the read and write values of all devices in a blkio io file (`major:minor Read|Write|... value` per line)
+func (g *blkioCGroup) io(file string) (read, write uint64, err error) {
+	data, err := g.get(file)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, line := range strings.Split(data, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) != 3 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(parts[2], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		switch parts[1] {
+		case "Read":
+			read += value
+		case "Write":
+			write += value
+		}
+	}
+
+	return
+}
+
+//Serviced gets the io of the group, the throttle counters are used since they are accounted
+//regardless of the io scheduler.
+func (g *blkioCGroup) Serviced() (*BlkioServiced, error) {
+	var serviced BlkioServiced
+	var err error
+
+	if serviced.ReadBytes, serviced.WriteBytes, err = g.io("blkio.throttle.io_service_bytes"); err != nil {
+		return nil, err
+	}
+
+	if serviced.ReadOps, serviced.WriteOps, err = g.io("blkio.throttle.io_serviced"); err != nil {
+		return nil, err
+	}
+
+	return &serviced, nil
+}
@@ -5,28 +5,52 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+
+	"github.com/op/go-logging"
 )
 
-type mkg func(name, subsys string) Group
+type mkg func(name, subsys string) (Group, error)
 
 type Group interface {
 	Name() string
 	Subsystem() string
 	Task(pid int) error
+	Remove() error
 }
 
 const (
 	DevicesSubsystem = "devices"
+	CPUSubsystem     = "cpu"
+	CPUAcctSubsystem = "cpuacct"
+	CPUSetSubsystem  = "cpuset"
+	MemorySubsystem  = "memory"
+	PidsSubsystem    = "pids"
+	BlkioSubsystem   = "blkio"
+	FreezerSubsystem = "freezer"
 	CGroupBase       = "/sys/fs/cgroup"
 )
 
 var (
+	log = logging.MustGetLogger("cgroups")
+
 	once       sync.Once
 	subsystems = map[string]mkg{
 		DevicesSubsystem: mkDevicesGroup,
+		CPUSubsystem:     mkCPUGroup,
+		CPUAcctSubsystem: mkCPUAcctGroup,
+		CPUSetSubsystem:  mkCPUSetGroup,
+		MemorySubsystem:  mkMemoryGroup,
+		PidsSubsystem:    mkPidsGroup,
+		BlkioSubsystem:   mkBlkioGroup,
+		FreezerSubsystem: mkFreezerGroup,
 	}
+
+	//mounted subsystems, a subsystem that is not supported by the kernel is skipped
+	mounted = map[string]bool{}
 )
 
 func Init() (err error) {
@@ -41,28 +65,46 @@ func Init() (err error) {
 			p := path.Join(CGroupBase, sub)
 			os.MkdirAll(p, 0755)
 
-			err = syscall.Mount(sub, p, "cgroup", 0, sub)
-			if err != nil {
-				return
+			if merr := syscall.Mount(sub, p, "cgroup", 0, sub); merr != nil {
+				if sub == DevicesSubsystem {
+					//devices cgroup is required to isolate the containers
+					err = merr
+					return
+				}
+
+				log.Warningf("failed to mount cgroup subsystem '%s': %s", sub, merr)
+				os.Remove(p)
+				continue
 			}
+
+			mounted[sub] = true
 		}
 	})
 
 	return
 }
 
+//Available checks if subsystem is supported and mounted
+func Available(subsystem string) bool {
+	return mounted[subsystem]
+}
+
 func GetGroup(name string, subsystem string) (Group, error) {
 	mkg, ok := subsystems[subsystem]
 	if !ok {
 		return nil, fmt.Errorf("unknown subsystem '%s'", subsystem)
 	}
 
+	if !Available(subsystem) {
+		return nil, fmt.Errorf("subsystem '%s' is not available", subsystem)
+	}
+
 	p := path.Join(CGroupBase, subsystem, name)
 	if err := os.Mkdir(p, 0755); err != nil && !os.IsExist(err) {
 		return nil, err
 	}
 
-	return mkg(name, subsystem), nil
+	return mkg(name, subsystem)
 }
 
 type cgroup struct {
@@ -85,3 +127,57 @@ func (g *cgroup) base() string {
 func (g *cgroup) Task(pid int) error {
 	return ioutil.WriteFile(path.Join(g.base(), "cgroup.procs"), []byte(fmt.Sprint(pid)), 0644)
 }
+
+//Remove the cgroup, it fails if the group still has tasks
+func (g *cgroup) Remove() error {
+	return os.Remove(g.base())
+}
+
+func (g *cgroup) set(file string, value interface{}) error {
+	return ioutil.WriteFile(path.Join(g.base(), file), []byte(fmt.Sprint(value)), 0644)
+}
+
+func (g *cgroup) get(file string) (string, error) {
+	data, err := ioutil.ReadFile(path.Join(g.base(), file))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (g *cgroup) uint(file string) (uint64, error) {
+	value, err := g.get(file)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(value, 10, 64)
+}
+
+//stat reads a flat keyed file (`key value` per line)
+func (g *cgroup) stat(file string) (map[string]uint64, error) {
+	data, err := g.get(file)
+	if err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]uint64)
+	for _, line := range strings.Split(data, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) != 2 {
+			continue
+		}
+
+		if value, err := strconv.ParseUint(parts[1], 10, 64); err == nil {
+			stat[parts[0]] = value
+		}
+	}
+
+	return stat, nil
+}
+
+func (g *cgroup) has(file string) bool {
+	_, err := os.Stat(path.Join(g.base(), file))
+	return err == nil
+}
@@ -0,0 +1,48 @@
+package cgroups
+
+const (
+	DefaultCPUShares = 1024
+	DefaultCPUPeriod = 100000 //100ms
+)
+
+type CPUGroup interface {
+	Group
+	Shares(shares uint64) error
+	Quota(quota int64, period uint64) error
+}
+
+func mkCPUGroup(name, subsys string) (Group, error) {
+	return &cpuCGroup{
+		cgroup{name: name, subsys: subsys},
+	}, nil
+}
+
+type cpuCGroup struct {
+	cgroup
+}
+
+//Shares sets the relative cpu weight of the group, 0 resets to the default weight
+func (g *cpuCGroup) Shares(shares uint64) error {
+	if shares == 0 {
+		shares = DefaultCPUShares
+	}
+
+	return g.set("cpu.shares", shares)
+}
+
+//Quota sets the cpu time (in microseconds) the group can use every period, a quota <= 0 removes the limit
+func (g *cpuCGroup) Quota(quota int64, period uint64) error {
+	if period == 0 {
+		period = DefaultCPUPeriod
+	}
+
+	if quota <= 0 {
+		quota = -1
+	}
+
+	if err := g.set("cpu.cfs_period_us", period); err != nil {
+		return err
+	}
+
+	return g.set("cpu.cfs_quota_us", quota)
+}
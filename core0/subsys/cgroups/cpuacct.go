@@ -0,0 +1,21 @@
+package cgroups
+
+type CPUAcctGroup interface {
+	Group
+	Usage() (uint64, error)
+}
+
+func mkCPUAcctGroup(name, subsys string) (Group, error) {
+	return &cpuacctCGroup{
+		cgroup{name: name, subsys: subsys},
+	}, nil
+}
+
+type cpuacctCGroup struct {
+	cgroup
+}
+
+//Usage gets the total cpu time (in nanoseconds) consumed by the tasks of the group
+func (g *cpuacctCGroup) Usage() (uint64, error) {
+	return g.uint("cpuacct.usage")
+}
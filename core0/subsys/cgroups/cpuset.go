@@ -0,0 +1,57 @@
+package cgroups
+
+import (
+	"path"
+)
+
+type CPUSetGroup interface {
+	Group
+	CPUs(cpus string) error
+}
+
+//mkCPUSetGroup creates a cpuset group, a new cpuset has no cpus or memory nodes so it inherits the
+//ones of the root group, otherwise no task can join it.
+func mkCPUSetGroup(name, subsys string) (Group, error) {
+	g := &cpusetCGroup{
+		cgroup{name: name, subsys: subsys},
+	}
+
+	for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+		if value, err := g.get(file); err != nil {
+			return nil, err
+		} else if value != "" {
+			continue
+		}
+
+		value, err := g.root().get(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := g.set(file, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+type cpusetCGroup struct {
+	cgroup
+}
+
+func (g *cpusetCGroup) root() *cgroup {
+	return &cgroup{name: path.Dir(g.name), subsys: g.subsys}
+}
+
+//CPUs sets the list of cpus (ex: 0-2,5) the group can run on, an empty list means all cpus of the node
+func (g *cpusetCGroup) CPUs(cpus string) error {
+	if cpus == "" {
+		var err error
+		if cpus, err = g.root().get("cpuset.cpus"); err != nil {
+			return err
+		}
+	}
+
+	return g.set("cpuset.cpus", cpus)
+}
@@ -13,10 +13,10 @@ type DevicesGroup interface {
 	List() ([]string, error)
 }
 
-func mkDevicesGroup(name, subsys string) Group {
+func mkDevicesGroup(name, subsys string) (Group, error) {
 	return &devicesCGroup{
 		cgroup{name: name, subsys: subsys},
-	}
+	}, nil
 }
 
 type devicesCGroup struct {
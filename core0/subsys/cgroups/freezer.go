@@ -0,0 +1,65 @@
+package cgroups
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	FreezerStateThawed   = "THAWED"
+	FreezerStateFreezing = "FREEZING"
+	FreezerStateFrozen   = "FROZEN"
+
+	freezeTimeout = 10 * time.Second
+)
+
+type FreezerGroup interface {
+	Group
+	Freeze() error
+	Thaw() error
+	State() (string, error)
+}
+
+func mkFreezerGroup(name, subsys string) (Group, error) {
+	return &freezerCGroup{
+		cgroup{name: name, subsys: subsys},
+	}, nil
+}
+
+type freezerCGroup struct {
+	cgroup
+}
+
+//Freeze stops all the tasks of the group, it waits until all the tasks are frozen
+func (g *freezerCGroup) Freeze() error {
+	if err := g.set("freezer.state", FreezerStateFrozen); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(freezeTimeout)
+	for time.Now().Before(deadline) {
+		state, err := g.State()
+		if err != nil {
+			return err
+		}
+
+		if state == FreezerStateFrozen {
+			return nil
+		}
+
+		<-time.After(10 * time.Millisecond)
+	}
+
+	//some tasks couldn't be frozen, leave the group in a consistent state
+	g.Thaw()
+	return fmt.Errorf("timeout waiting for tasks to freeze")
+}
+
+//Thaw resumes the tasks of the group
+func (g *freezerCGroup) Thaw() error {
+	return g.set("freezer.state", FreezerStateThawed)
+}
+
+func (g *freezerCGroup) State() (string, error) {
+	return g.get("freezer.state")
+}
@@ -0,0 +1,57 @@
+package cgroups
+
+type MemoryGroup interface {
+	Group
+	Limits(mem, swap int64) error
+	Usage() (uint64, error)
+	Stat() (map[string]uint64, error)
+}
+
+func mkMemoryGroup(name, subsys string) (Group, error) {
+	return &memoryCGroup{
+		cgroup{name: name, subsys: subsys},
+	}, nil
+}
+
+type memoryCGroup struct {
+	cgroup
+}
+
+/*
+Limits sets the memory limit of the group, and how much swap it can use on top of it (both in bytes).
+A mem <= 0 removes the limit. Swap is only applied if swap accounting is enabled in the kernel.
+*/
+func (g *memoryCGroup) Limits(mem, swap int64) error {
+	memsw := int64(-1)
+	if mem <= 0 {
+		mem = -1
+	} else if swap >= 0 {
+		memsw = mem + swap
+	}
+
+	if !g.has("memory.memsw.limit_in_bytes") {
+		return g.set("memory.limit_in_bytes", mem)
+	}
+
+	//memory+swap limit can never be lower than the memory limit, so the order depends on if we
+	//are growing or shrinking the limits
+	if err := g.set("memory.limit_in_bytes", mem); err != nil {
+		if err := g.set("memory.memsw.limit_in_bytes", memsw); err != nil {
+			return err
+		}
+
+		return g.set("memory.limit_in_bytes", mem)
+	}
+
+	return g.set("memory.memsw.limit_in_bytes", memsw)
+}
+
+//Usage gets the memory (including the page cache) used by the group in bytes
+func (g *memoryCGroup) Usage() (uint64, error) {
+	return g.uint("memory.usage_in_bytes")
+}
+
+//Stat gets the detailed memory usage of the group (memory.stat)
+func (g *memoryCGroup) Stat() (map[string]uint64, error) {
+	return g.stat("memory.stat")
+}
@@ -0,0 +1,31 @@
+package cgroups
+
+type PidsGroup interface {
+	Group
+	Max(max int64) error
+	Current() (uint64, error)
+}
+
+func mkPidsGroup(name, subsys string) (Group, error) {
+	return &pidsCGroup{
+		cgroup{name: name, subsys: subsys},
+	}, nil
+}
+
+type pidsCGroup struct {
+	cgroup
+}
+
+//Max sets the max number of processes (and threads) in the group, max <= 0 removes the limit
+func (g *pidsCGroup) Max(max int64) error {
+	if max <= 0 {
+		return g.set("pids.max", "max")
+	}
+
+	return g.set("pids.max", max)
+}
+
+//Current gets the number of processes (and threads) in the group
+func (g *pidsCGroup) Current() (uint64, error) {
+	return g.uint("pids.current")
+}
@@ -4,30 +4,124 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/utils"
 	"github.com/zero-os/0-core/core0/helper"
+	"io"
 	"io/ioutil"
-	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"syscall"
+	"time"
 )
 
 const (
 	backupMetaName = ".corex.meta"
+
+	backupMinPeriod = 60 //seconds
 )
 
 var (
 	resticSnaphostIdP = regexp.MustCompile(`snapshot ([^\s]+) saved`)
 )
 
-func (m *containerManager) backup(cmd *pm.Command) (interface{}, error) {
-	var args struct {
-		Container uint16   `json:"container"`
-		URL       string   `json:"url"`
-		Tags      []string `json:"tags"`
+//BackupRetention is the policy of which snapshots to keep when forgetting snapshots, snapshots that
+//match any of the keep rules are kept.
+type BackupRetention struct {
+	KeepLast    int  `json:"keep_last"`    //keep the last n snapshots
+	KeepHourly  int  `json:"keep_hourly"`  //keep the last snapshot of the last n hours
+	KeepDaily   int  `json:"keep_daily"`   //keep the last snapshot of the last n days
+	KeepWeekly  int  `json:"keep_weekly"`  //keep the last snapshot of the last n weeks
+	KeepMonthly int  `json:"keep_monthly"` //keep the last snapshot of the last n months
+	KeepYearly  int  `json:"keep_yearly"`  //keep the last snapshot of the last n years
+	Prune       bool `json:"prune"`        //remove the data that is not referenced anymore
+}
+
+func (r *BackupRetention) args() []string {
+	var args []string
+	for _, keep := range []struct {
+		flag  string
+		value int
+	}{
+		{"--keep-last", r.KeepLast},
+		{"--keep-hourly", r.KeepHourly},
+		{"--keep-daily", r.KeepDaily},
+		{"--keep-weekly", r.KeepWeekly},
+		{"--keep-monthly", r.KeepMonthly},
+		{"--keep-yearly", r.KeepYearly},
+	} {
+		if keep.value > 0 {
+			args = append(args, keep.flag, fmt.Sprint(keep.value))
+		}
+	}
+
+	if r.Prune {
+		args = append(args, "--prune")
+	}
+
+	return args
+}
+
+func (r *BackupRetention) empty() bool {
+	return r.KeepLast == 0 && r.KeepHourly == 0 && r.KeepDaily == 0 &&
+		r.KeepWeekly == 0 && r.KeepMonthly == 0 && r.KeepYearly == 0
+}
+
+func (r *BackupRetention) Validate() error {
+	for _, keep := range []int{r.KeepLast, r.KeepHourly, r.KeepDaily, r.KeepWeekly, r.KeepMonthly, r.KeepYearly} {
+		if keep < 0 {
+			return fmt.Errorf("invalid retention, keep values must be positive")
+		}
+	}
+
+	if r.empty() {
+		return fmt.Errorf("invalid retention, at least one keep policy is required")
 	}
 
+	return nil
+}
+
+//BackupSchedule is a recurring backup of a container
+type BackupSchedule struct {
+	URL       string           `json:"url"`       //restic url, the password is better stored with corex.backup_credentials_set
+	Period    int              `json:"period"`    //seconds between backups
+	Tags      []string         `json:"tags"`      //extra snapshot tags
+	Retention *BackupRetention `json:"retention"` //old snapshots of the container are forgotten after each backup
+}
+
+func (s *BackupSchedule) Validate() error {
+	if len(s.URL) == 0 {
+		return fmt.Errorf("backup url is required")
+	}
+
+	if s.Period < backupMinPeriod {
+		return fmt.Errorf("backup period must be at least %d seconds", backupMinPeriod)
+	}
+
+	if s.Retention != nil {
+		return s.Retention.Validate()
+	}
+
+	return nil
+}
+
+type ContainerBackupArguments struct {
+	Container uint16           `json:"container"`
+	URL       string           `json:"url"`
+	Tags      []string         `json:"tags"`
+	Retention *BackupRetention `json:"retention"`
+}
+
+//backupTag is the tag set on all the snapshots of a container, it uses the instance id since the
+//container id is reused by the next containers
+func backupTag(instance string) string {
+	return fmt.Sprintf("container-%s", instance)
+}
+
+func (m *containerManager) backup(cmd *pm.Command) (interface{}, error) {
+	var args ContainerBackupArguments
 	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
 		return nil, err
 	}
@@ -36,6 +130,12 @@ func (m *containerManager) backup(cmd *pm.Command) (interface{}, error) {
 		return nil, fmt.Errorf("invalid container id")
 	}
 
+	if args.Retention != nil {
+		if err := args.Retention.Validate(); err != nil {
+			return nil, pm.BadRequestError(err)
+		}
+	}
+
 	m.conM.RLock()
 	cont, ok := m.containers[args.Container]
 	m.conM.RUnlock()
@@ -44,35 +144,21 @@ func (m *containerManager) backup(cmd *pm.Command) (interface{}, error) {
 		return nil, fmt.Errorf("container does not exist")
 	}
 
-	//pause container
-	//TODO: avoid race if cont has just started and pid is not set yet!
-	if cont.PID == 0 {
-		return nil, fmt.Errorf("container is not fully started yet")
-	}
-
-	u, err := url.Parse(args.URL)
+	repo, err := helper.ParseResticURL(args.URL)
 	if err != nil {
 		return nil, err
 	}
 
-	password := u.Query().Get("password")
-	u.Fragment = "" //just to make sure
-	repo := args.URL
-	if u.Scheme == "file" || len(u.Scheme) == 0 {
-		repo = u.Path
-	} else {
-		u.RawQuery = ""
-		repo = u.String()
-	}
-
 	restic := []string{
-		"-r", repo,
 		"backup",
 		"--exclude", "proc/**",
 		"--exclude", "dev/**",
 		"--exclude", "sys/**",
 	}
 
+	//the container tag scopes the retention to the snapshots of this container
+	restic = append(restic, "--tag", backupTag(cont.Args.Instance))
+
 	for _, tag := range cont.Args.Tags {
 		restic = append(restic, "--tag", tag)
 	}
@@ -125,30 +211,19 @@ func (m *containerManager) backup(cmd *pm.Command) (interface{}, error) {
 
 	restic = append(restic, files...)
 
-	//pause container
-	syscall.Kill(-cont.PID, syscall.SIGSTOP)
-	defer syscall.Kill(-cont.PID, syscall.SIGCONT)
-
-	job, err := pm.Run(
-		&pm.Command{
-			Command: pm.CommandSystem,
-			Arguments: pm.MustArguments(
-				pm.SystemCommandArguments{
-					Name:  "restic",
-					Args:  restic,
-					StdIn: password,
-				},
-			),
-		},
-	)
-
+	//pause container, so the backup is consistent. A container that is already paused
+	//is left paused after the backup.
+	resume, err := cont.Freeze()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to pause container: %s", err)
 	}
 
-	result := job.Wait()
-	if result.State != pm.StateSuccess {
-		return nil, fmt.Errorf("failed to backup container: %s", result.Streams.Stderr())
+	defer resume()
+
+	//restic only uploads the data that changed since the last snapshot of the same paths
+	result, err := repo.Run(restic...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to backup container: %s", err)
 	}
 
 	//read snapshot id
@@ -157,6 +232,15 @@ func (m *containerManager) backup(cmd *pm.Command) (interface{}, error) {
 		return nil, fmt.Errorf("failed to retrieve snapshot ID")
 	}
 
+	if args.Retention != nil {
+		//restic applies the policy to all the snapshots of the repository unless filtered, so
+		//only the snapshots tagged with this container are considered
+		forget := append([]string{"forget", "--tag", backupTag(cont.Args.Instance)}, args.Retention.args()...)
+		if _, err := repo.Run(forget...); err != nil {
+			return nil, fmt.Errorf("snapshot %s saved, but failed to forget old snapshots: %s", match[1], err)
+		}
+	}
+
 	return match[1], nil
 }
 
@@ -206,3 +290,381 @@ func (m *containerManager) restore(cmd *pm.Command) (interface{}, error) {
 
 	return cont.id, nil
 }
+
+type ContainerRestoreFilesArguments struct {
+	Container uint16   `json:"container"`
+	URL       string   `json:"url"`    //restic url with the snapshot id as fragment
+	Paths     []string `json:"paths"`  //paths to restore as seen from inside the container
+	Target    string   `json:"target"` //directory inside the container to restore to (default /)
+}
+
+//restoreFiles restores some files of a snapshot into a running container, the files are restored to
+//their original location relative to the target directory.
+func (m *containerManager) restoreFiles(cmd *pm.Command) (interface{}, error) {
+	var args ContainerRestoreFilesArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	if len(args.Paths) == 0 {
+		return nil, pm.BadRequestError(fmt.Errorf("paths is required"))
+	}
+
+	m.conM.RLock()
+	cont, ok := m.containers[args.Container]
+	m.conM.RUnlock()
+
+	if !ok {
+		return nil, pm.NotFoundError(fmt.Errorf("container does not exist"))
+	}
+
+	repo, err := helper.ParseResticURL(args.URL)
+	if err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	tmp, err := ioutil.TempDir("", "restic")
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.RemoveAll(tmp)
+
+	var include []string
+	for _, p := range args.Paths {
+		include = append(include, path.Clean("/"+p))
+	}
+
+	if err := repo.Restore(tmp, include...); err != nil {
+		return nil, err
+	}
+
+	os.Remove(path.Join(tmp, backupMetaName))
+
+	//the container is paused so it can't swap the target directories for symlinks during the copy
+	resume, err := cont.Freeze()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pause container: %s", err)
+	}
+
+	defer resume()
+
+	if err := restoreTree(tmp, cont.root(), args.Target); err != nil {
+		return nil, fmt.Errorf("failed to copy restored files: %s", err)
+	}
+
+	return nil, nil
+}
+
+/*
+restoreTree copies the files of src to dst (a path inside root), ownership, modes and times are kept. The
+parent of each file is resolved inside root, and existing files are replaced, so symlinks of the container are
+never followed.
+*/
+func restoreTree(src, root, dst string) error {
+	dst = path.Clean("/" + dst)
+	dirs := make(map[string]time.Time)
+
+	err := filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		} else if rel == "." {
+			//the temporary directory itself, dst keeps its attributes
+			return nil
+		}
+
+		name := path.Join(dst, rel)
+		parent, err := utils.InRoot(root, path.Dir(name))
+		if err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(parent, 0755); err != nil {
+			return err
+		}
+
+		target := path.Join(parent, path.Base(name))
+		if existing, err := os.Lstat(target); err == nil && !(existing.IsDir() && info.IsDir()) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+
+		mode := info.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.Mkdir(target, 0755); err != nil && !os.IsExist(err) {
+				return err
+			}
+			dirs[target] = info.ModTime()
+		case mode.IsRegular():
+			if err := copyFile(p, target); err != nil {
+				return err
+			}
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		default:
+			log.Warningf("skipping restore of '%s': unsupported file type", name)
+			return nil
+		}
+
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			if err := os.Lchown(target, int(stat.Uid), int(stat.Gid)); err != nil {
+				return err
+			}
+		}
+
+		if mode&os.ModeSymlink != 0 {
+			return nil
+		}
+
+		//chown clears the setuid and setgid bits, so the mode is set after
+		if err := os.Chmod(target, mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+			return err
+		}
+
+		if !mode.IsDir() {
+			return os.Chtimes(target, info.ModTime(), info.ModTime())
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	//the times of the directories are changed by their entries, so they are set last
+	for dir, mtime := range dirs {
+		if err := os.Chtimes(dir, mtime, mtime); err != nil {
+			log.Errorf("failed to set times of '%s': %s", dir, err)
+		}
+	}
+
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+type ContainerBackupListArguments struct {
+	URL  string   `json:"url"`
+	Tags []string `json:"tags"` //only snapshots that have all the tags
+}
+
+//backupList lists the snapshots of a repository
+func (m *containerManager) backupList(cmd *pm.Command) (interface{}, error) {
+	var args ContainerBackupListArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	repo, err := helper.ParseResticURL(args.URL)
+	if err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	restic := []string{"snapshots", "--json"}
+	if len(args.Tags) != 0 {
+		restic = append(restic, "--tag", strings.Join(args.Tags, ","))
+	}
+
+	result, err := repo.Run(restic...)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []interface{}
+	if err := json.Unmarshal([]byte(result.Streams.Stdout()), &snapshots); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshots: %s", err)
+	}
+
+	return snapshots, nil
+}
+
+type ContainerBackupForgetArguments struct {
+	URL       string          `json:"url"`
+	Tags      []string        `json:"tags"`      //only consider snapshots that have all the tags
+	Snapshots []string        `json:"snapshots"` //forget these snapshots, instead of applying the retention
+	Retention BackupRetention `json:"retention"`
+}
+
+//backupForget forgets snapshots of a repository, either explicitly or according to a retention policy
+func (m *containerManager) backupForget(cmd *pm.Command) (interface{}, error) {
+	var args ContainerBackupForgetArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	if len(args.Snapshots) == 0 {
+		if err := args.Retention.Validate(); err != nil {
+			return nil, pm.BadRequestError(err)
+		}
+	} else if !args.Retention.empty() {
+		return nil, pm.BadRequestError(fmt.Errorf("snapshots and keep policies are exclusive"))
+	}
+
+	repo, err := helper.ParseResticURL(args.URL)
+	if err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	restic := append([]string{"forget"}, args.Retention.args()...)
+	if len(args.Tags) != 0 {
+		restic = append(restic, "--tag", strings.Join(args.Tags, ","))
+	}
+
+	restic = append(restic, args.Snapshots...)
+
+	_, err = repo.Run(restic...)
+	return nil, err
+}
+
+type ContainerBackupScheduleArguments struct {
+	Container uint16          `json:"container"`
+	Schedule  *BackupSchedule `json:"schedule"` //null removes the schedule
+}
+
+//backupSchedule sets (or removes) the recurring backup of a running container
+func (m *containerManager) backupSchedule(cmd *pm.Command) (interface{}, error) {
+	var args ContainerBackupScheduleArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	if args.Schedule != nil {
+		if err := args.Schedule.Validate(); err != nil {
+			return nil, pm.BadRequestError(err)
+		}
+	}
+
+	m.conM.RLock()
+	cont, ok := m.containers[args.Container]
+	m.conM.RUnlock()
+
+	if !ok {
+		return nil, pm.NotFoundError(fmt.Errorf("container does not exist"))
+	}
+
+	m.conM.Lock()
+	cont.Args.Backup = args.Schedule
+	m.conM.Unlock()
+
+	cont.scheduleBackup(args.Schedule)
+	m.persist(cont)
+
+	return nil, nil
+}
+
+/*
+scheduleBackup starts the recurring backup of the container, replacing the previous schedule (if any).
+A nil schedule stops the recurring backup. Each backup runs as a corex.backup job, so it's logged like
+any other job.
+*/
+func (c *container) scheduleBackup(schedule *BackupSchedule) {
+	c.backupM.Lock()
+	defer c.backupM.Unlock()
+
+	if c.backupStop != nil {
+		close(c.backupStop)
+		c.backupStop = nil
+	}
+
+	if schedule == nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	c.backupStop = stop
+
+	go func() {
+		period := time.Duration(schedule.Period) * time.Second
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(period):
+			}
+
+			job, err := pm.Run(&pm.Command{
+				Command: cmdContainerBackup,
+				Arguments: pm.MustArguments(ContainerBackupArguments{
+					Container: c.id,
+					URL:       schedule.URL,
+					Tags:      schedule.Tags,
+					Retention: schedule.Retention,
+				}),
+			})
+
+			if err != nil {
+				log.Errorf("failed to start scheduled backup of container-%d: %s", c.id, err)
+				continue
+			}
+
+			if result := job.Wait(); result.State != pm.StateSuccess {
+				log.Errorf("scheduled backup of container-%d failed: %s", c.id, result.Data)
+			}
+		}
+	}()
+}
+
+type ContainerBackupCredentialsArguments struct {
+	URL      string `json:"url"` //restic url without password
+	Password string `json:"password"`
+}
+
+//backupCredentialsSet stores the password of a restic repository on the node
+func (m *containerManager) backupCredentialsSet(cmd *pm.Command) (interface{}, error) {
+	var args ContainerBackupCredentialsArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	if err := helper.SetResticPassword(args.URL, args.Password); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	return nil, nil
+}
+
+//backupCredentialsRemove removes the stored password of a restic repository
+func (m *containerManager) backupCredentialsRemove(cmd *pm.Command) (interface{}, error) {
+	var args ContainerBackupCredentialsArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	return nil, helper.RemoveResticPassword(args.URL)
+}
+
+//backupCredentialsList lists the repositories that have a stored password (passwords are never returned)
+func (m *containerManager) backupCredentialsList(cmd *pm.Command) (interface{}, error) {
+	return helper.ResticRepos()
+}
@@ -0,0 +1,80 @@
+package containers
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupRetentionArgs(t *testing.T) {
+	retention := BackupRetention{KeepLast: 3, KeepDaily: 7, KeepYearly: 1, Prune: true}
+
+	if assert.NoError(t, retention.Validate()) {
+		assert.Equal(t, []string{
+			"--keep-last", "3",
+			"--keep-daily", "7",
+			"--keep-yearly", "1",
+			"--prune",
+		}, retention.args())
+	}
+
+	assert.Error(t, (&BackupRetention{}).Validate())
+	assert.Error(t, (&BackupRetention{Prune: true}).Validate())
+	assert.Error(t, (&BackupRetention{KeepLast: 1, KeepDaily: -1}).Validate())
+}
+
+func TestBackupScheduleValidate(t *testing.T) {
+	assert.NoError(t, (&BackupSchedule{URL: "s3://host/bucket", Period: 3600}).Validate())
+	assert.Error(t, (&BackupSchedule{Period: 3600}).Validate())
+	assert.Error(t, (&BackupSchedule{URL: "s3://host/bucket", Period: 10}).Validate())
+	assert.Error(t, (&BackupSchedule{URL: "s3://host/bucket", Period: 3600, Retention: &BackupRetention{}}).Validate())
+}
+
+func TestRestoreTree(t *testing.T) {
+	src, err := ioutil.TempDir("", "restore")
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+	defer os.RemoveAll(src)
+
+	root, err := ioutil.TempDir("", "restore")
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+	defer os.RemoveAll(root)
+
+	outside, err := ioutil.TempDir("", "restore")
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+	defer os.RemoveAll(outside)
+
+	assert.NoError(t, os.MkdirAll(path.Join(src, "etc"), 0755))
+	assert.NoError(t, ioutil.WriteFile(path.Join(src, "etc", "config"), []byte("key=value"), 0600))
+	assert.NoError(t, ioutil.WriteFile(path.Join(src, "data"), []byte("data"), 0644))
+
+	//the target is resolved inside the root, and the links of the container are replaced
+	assert.NoError(t, os.Symlink("/", path.Join(root, "restore")))
+	assert.NoError(t, os.Symlink(path.Join(outside, "etc"), path.Join(root, "etc")))
+	assert.NoError(t, os.Symlink(path.Join(outside, "data"), path.Join(root, "data")))
+
+	assert.NoError(t, restoreTree(src, root, "/restore"))
+
+	data, err := ioutil.ReadFile(path.Join(root, "etc", "config"))
+	assert.NoError(t, err)
+	assert.Equal(t, "key=value", string(data))
+
+	info, err := os.Lstat(path.Join(root, "etc"))
+	assert.NoError(t, err)
+	assert.True(t, info.IsDir())
+
+	info, err = os.Lstat(path.Join(root, "data"))
+	assert.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+
+	_, err = os.Stat(path.Join(outside, "data"))
+	assert.True(t, os.IsNotExist(err))
+}
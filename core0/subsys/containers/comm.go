@@ -19,9 +19,9 @@ type Message struct {
 func (c *container) forward() {
 	log.Debugf("start commands forwarder for '%s'", c.name())
 	enc := json.NewEncoder(c.channel)
-	for cmd := range c.forwardChan {
-		if err := enc.Encode(cmd); err != nil {
-			log.Errorf("failed to forward command (%s) to container (%d)", cmd.ID, c.id)
+	for msg := range c.forwardChan {
+		if err := enc.Encode(msg); err != nil {
+			log.Errorf("failed to forward message (%v) to container (%d)", msg, c.id)
 		}
 	}
 }
@@ -47,6 +47,7 @@ func (c *container) rewind() {
 			}
 			result.Container = uint64(c.id)
 			c.mgr.sink.Forward(&result)
+			c.detach(message.Command, &result)
 		case "log":
 			var msg stream.Message
 			if err := json.Unmarshal(message.Payload, &msg); err != nil {
@@ -58,6 +59,9 @@ func (c *container) rewind() {
 				Command: message.Command,
 				Message: &msg,
 			})
+
+			c.record(message.Command, &msg)
+			c.attached(message.Command, &msg)
 		case "stats":
 			var stat stats.Stats
 			if err := json.Unmarshal(message.Payload, &stat); err != nil {
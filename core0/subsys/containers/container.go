@@ -2,6 +2,7 @@ package containers
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path"
 	"strings"
@@ -11,7 +12,9 @@ import (
 
 	"github.com/zero-os/0-core/base/pm"
 	"github.com/zero-os/0-core/base/pm/stream"
+	"github.com/zero-os/0-core/core0/helper"
 	"github.com/zero-os/0-core/core0/logger"
+	"github.com/zero-os/0-core/core0/subsys/cgroups"
 )
 
 const (
@@ -35,10 +38,28 @@ type container struct {
 	zterr error
 	zto   sync.Once
 
+	cgroups   map[string]cgroups.Group
+	log       *containerLog
+	volumes   []string            //names of the acquired volumes
+	image     *helper.ImageConfig //config of the root image, nil if the root is an flist
+	defaultIP *net.IPNet          //ip allocated on the default bridge
+
 	channel     pm.Channel
-	forwardChan chan *pm.Command
+	forwardChan chan interface{}
+
+	attachments map[string]*Attachment
+	attM        sync.Mutex
+
+	backupStop chan struct{}
+	backupM    sync.Mutex
 
 	terminating bool
+	terminated  bool //terminated with corex.terminate
+	killed      bool //killed after the terminate timeout
+	started     time.Time
+	restarts    int
+	exitCode    uint32
+	exit        *ExitInfo
 }
 
 func newContainer(mgr *containerManager, id uint16, args ContainerCreateArguments) *container {
@@ -46,7 +67,8 @@ func newContainer(mgr *containerManager, id uint16, args ContainerCreateArgument
 		mgr:         mgr,
 		id:          id,
 		Args:        args,
-		forwardChan: make(chan *pm.Command),
+		forwardChan: make(chan interface{}),
+		attachments: make(map[string]*Attachment),
 	}
 	c.Root = c.root()
 	return c
@@ -57,8 +79,20 @@ func (c *container) ID() uint16 {
 }
 
 func (c *container) dispatch(cmd *pm.Command) error {
+	return c.send(cmd)
+}
+
+//send a message to coreX, it fails if the container is gone
+func (c *container) send(msg interface{}) (err error) {
+	defer func() {
+		//forward channel is closed when the container exits
+		if recover() != nil {
+			err = fmt.Errorf("container is not running")
+		}
+	}()
+
 	select {
-	case c.forwardChan <- cmd:
+	case c.forwardChan <- msg:
 	case <-time.After(5 * time.Second):
 		return fmt.Errorf("failed to dispatch command to container, check system logs for errors")
 	}
@@ -69,8 +103,30 @@ func (c *container) Arguments() ContainerCreateArguments {
 	return c.Args
 }
 
+//RootPath gets the path of the container root filesystem on the host
+func (c *container) RootPath() string {
+	return c.Root
+}
+
+//environment of coreX, a default env merged with the image env and the given env
+func (c *container) environment(env map[string]string) map[string]string {
+	result := map[string]string{
+		"PATH": "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"HOME": "/",
+	}
+	for key, value := range c.imageEnv() {
+		result[key] = value
+	}
+	for key, value := range env {
+		result[key] = value
+	}
+
+	return result
+}
+
 func (c *container) Start() (runner pm.Job, err error) {
 	coreID := fmt.Sprintf("core-%d", c.id)
+	c.started = time.Now()
 
 	defer func() {
 		if err != nil {
@@ -78,6 +134,11 @@ func (c *container) Start() (runner pm.Job, err error) {
 		}
 	}()
 
+	if err = c.setUpCGroups(); err != nil {
+		log.Errorf("error in container cgroups: %s", err)
+		return
+	}
+
 	if err = c.sandbox(); err != nil {
 		log.Errorf("error in container mount: %s", err)
 		return
@@ -88,23 +149,39 @@ func (c *container) Start() (runner pm.Job, err error) {
 		return
 	}
 
+	if err = c.runHook(HookPreStart, c.Args.Hooks.PreStart, 0); err != nil {
+		log.Errorf("error in container prestart hook: %s", err)
+		return
+	}
+
 	args := []string{
-		"-hostname", c.Args.Hostname,
+		"-hostname", c.hostname(),
 	}
 
 	if !c.Args.Privileged {
 		args = append(args, "-unprivileged")
 	}
 
-	//Set a Default Env and merge it with environment map from args
-	env := map[string]string{
-		"PATH": "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
-		"HOME": "/",
+	if c.Args.UserNS {
+		args = append(args, "-userns")
 	}
-	for key, value := range c.Args.Env {
-		env[key] = value
+
+	security, err := c.securityArgs()
+	if err != nil {
+		log.Errorf("error in container security profile: %s", err)
+		return
 	}
 
+	args = append(args, security...)
+
+	mappings, err := c.idMappings()
+	if err != nil {
+		log.Errorf("error in container user namespace: %s", err)
+		return
+	}
+
+	env := c.environment(c.Args.Env)
+
 	extCmd := &pm.Command{
 		ID: coreID,
 		Arguments: pm.MustArguments(
@@ -115,7 +192,8 @@ func (c *container) Start() (runner pm.Job, err error) {
 				HostNetwork: c.Args.HostNetwork,
 				Args:        args,
 				Env:         env,
-				Log:         path.Join(BackendBaseDir, c.name(), "container.log"),
+				UIDMappings: mappings,
+				GIDMappings: mappings,
 			},
 		),
 	}
@@ -128,7 +206,7 @@ func (c *container) Start() (runner pm.Job, err error) {
 		Action: c.onExit,
 	}
 
-	runner, err = pm.RunFactory(extCmd, pm.NewContainerProcess, onpid, onexit)
+	runner, err = pm.RunFactory(extCmd, pm.NewContainerProcess, onpid, &exitCodeHook{c: c}, onexit)
 	if err != nil {
 		log.Errorf("error in container runner: %s", err)
 		return
@@ -138,25 +216,60 @@ func (c *container) Start() (runner pm.Job, err error) {
 	return
 }
 
-func (c *container) Terminate() error {
+/*
+Terminate stops the container with SIGTERM and waits for it to exit. If the container doesn't exit within
+timeout seconds its PID namespace is killed (coreX is the init of the namespace, so all the container
+processes are killed with it). The prestop hook runs first and counts in the timeout.
+*/
+func (c *container) Terminate(timeout int) (*ExitInfo, error) {
 	if c.runner == nil {
-		return fmt.Errorf("container was not started")
+		return nil, fmt.Errorf("container was not started")
+	}
+
+	if timeout == 0 {
+		timeout = DefaultTerminateTimeout
+	}
+
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	if err := c.runHook(HookPreStop, c.Args.Hooks.PreStop, timeout); err != nil {
+		return nil, pm.PreconditionFailedError(err)
 	}
+
+	c.terminated = true
 	c.runner.Signal(syscall.SIGTERM)
-	c.runner.Wait()
-	return nil
-}
+	if c.Frozen() {
+		//signals are not delivered to frozen processes
+		c.Resume()
+	}
 
-func (c *container) preStart() error {
-	if c.Args.HostNetwork {
-		return c.preStartHostNetworking()
+	done := make(chan struct{})
+	go func() {
+		c.runner.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(deadline.Sub(time.Now())):
+		log.Warningf("container %d didn't exit after %d seconds, killing it", c.id, timeout)
+		c.log.Log(stream.LevelOperator, "container didn't exit after %d seconds, killing it", timeout)
+		c.killed = true
+		c.runner.Signal(syscall.SIGKILL)
+		<-done
 	}
 
-	if err := c.preStartIsolatedNetworking(); err != nil {
-		return err
+	return c.exit, nil
+}
+
+func (c *container) preStart() error {
+	//networking goes first so the hosts file gets the default ip
+	if !c.Args.HostNetwork {
+		if err := c.preStartIsolatedNetworking(); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return c.setUpResolver()
 }
 
 func (c *container) onStart(pid int) {
@@ -168,6 +281,9 @@ func (c *container) onStart(pid int) {
 		return
 	} else {
 		c.channel = ps.Channel()
+		if output := ps.Output(); output != nil {
+			go c.output(output)
+		}
 	}
 
 	c.PID = pid
@@ -175,6 +291,13 @@ func (c *container) onStart(pid int) {
 		c.mgr.cgroup.Task(pid)
 	}
 
+	c.joinCGroups(pid)
+
+	//coreX unshares its cgroup namespace once released, so it has to be in the container cgroups by now
+	if err := ps.(pm.ContainerProcess).Release(); err != nil {
+		log.Errorf("failed to release container-%d process: %s", c.id, err)
+	}
+
 	if err := c.postStart(); err != nil {
 		log.Errorf("container post start error: %s", err)
 		//TODO. Should we shut the container down?
@@ -182,12 +305,19 @@ func (c *container) onStart(pid int) {
 
 	go c.rewind()
 	go c.forward()
+	go c.lifecycle()
 }
 
 func (c *container) onExit(state bool) {
 	c.terminating = true
-	log.Debugf("Container %v exited with state %v", c.id, state)
+	c.exit = newExitInfo(c.exitCode, c.started, time.Now())
+	c.exit.Killed = c.killed
+	c.exit.Terminated = c.terminated
+	log.Debugf("Container %v exited with state %v: %s", c.id, state, c.exit)
+	c.log.Log(stream.LevelOperator, "container %s", c.exit)
+	c.mgr.recordExit(c)
 	tags := strings.Join(c.Args.Tags, ".")
+	defer c.mgr.onExit(c, c.spec(), state)
 	defer c.cleanup()
 	if len(tags) == 0 {
 		return
@@ -205,6 +335,8 @@ func (c *container) cleanup() {
 	defer c.mgr.unsetContainer(c.id)
 
 	close(c.forwardChan)
+	c.detachAll()
+	c.scheduleBackup(nil)
 	if c.channel != nil {
 		c.channel.Close()
 	}
@@ -214,10 +346,23 @@ func (c *container) cleanup() {
 	if err := c.unMountAll(); err != nil {
 		log.Errorf("unmounting container-%d was not clean", err)
 	}
+
+	if helper.IsImage(c.Args.Root) {
+		//image roots are not mounted with g8ufs, so there is no exit hook to clean the sandbox
+		c.cleanSandbox()
+	}
+
+	c.releaseVolumes()
+	c.removeCGroups()
+	c.log.Close()
 }
 
 func (c *container) cleanSandbox() {
 	if c.getFSType(BackendBaseDir) == "btrfs" {
+		//the image snapshot must be deleted before its parent subvolume
+		if _, err := os.Stat(c.rootfs()); err == nil {
+			pm.System("btrfs", "subvolume", "delete", c.rootfs())
+		}
 		pm.System("btrfs", "subvolume", "delete", path.Join(BackendBaseDir, c.name()))
 	} else {
 		os.RemoveAll(path.Join(BackendBaseDir, c.name()))
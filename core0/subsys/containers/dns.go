@@ -0,0 +1,259 @@
+package containers
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/settings"
+)
+
+const (
+	//same default as coreX
+	defaultHostname = "corex"
+
+	//DefaultBridgeHosts is the hosts directory served by the dnsmasq of the default bridge (see bridge.create),
+	//each container on the default bridge has a file named after its id
+	DefaultBridgeHosts = "/var/run/dnsmasq/" + DefaultBridgeName + ".hosts"
+)
+
+var (
+	hostnamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9_\-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9_\-]{0,61}[a-zA-Z0-9])?)*$`)
+)
+
+//DNSConfig resolver configuration of the container, written to /etc/resolv.conf
+type DNSConfig struct {
+	Nameservers []string `json:"nameservers"` //used before the nameservers of the nics
+	Search      []string `json:"search"`      //search domains
+	Options     []string `json:"options"`     //resolver options, ex: ndots:2
+}
+
+func (d *DNSConfig) empty() bool {
+	return len(d.Nameservers) == 0 && len(d.Search) == 0 && len(d.Options) == 0
+}
+
+func (d *DNSConfig) Validate() error {
+	for _, ns := range d.Nameservers {
+		if net.ParseIP(ns) == nil {
+			return fmt.Errorf("invalid nameserver '%s'", ns)
+		}
+	}
+
+	for _, domain := range d.Search {
+		if !validHostname(domain) {
+			return fmt.Errorf("invalid search domain '%s'", domain)
+		}
+	}
+
+	for _, option := range d.Options {
+		if len(option) == 0 || strings.ContainsAny(option, " \t\n") {
+			return fmt.Errorf("invalid resolver option '%s'", option)
+		}
+	}
+
+	return nil
+}
+
+func validHostname(name string) bool {
+	return len(name) <= 253 && hostnamePattern.MatchString(name)
+}
+
+func validateHosts(hosts map[string]string) error {
+	for name, ip := range hosts {
+		if !validHostname(name) {
+			return fmt.Errorf("invalid host name '%s'", name)
+		}
+
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid ip '%s' for host '%s'", ip, name)
+		}
+	}
+
+	return nil
+}
+
+//resolvConf renders a resolv.conf, duplicate nameservers are dropped
+func resolvConf(nameservers, search, options []string) []byte {
+	var buf bytes.Buffer
+	seen := make(map[string]bool)
+	for _, ns := range nameservers {
+		if seen[ns] {
+			continue
+		}
+		seen[ns] = true
+		fmt.Fprintf(&buf, "nameserver %s\n", ns)
+	}
+
+	if len(search) != 0 {
+		fmt.Fprintf(&buf, "search %s\n", strings.Join(search, " "))
+	}
+
+	if len(options) != 0 {
+		fmt.Fprintf(&buf, "options %s\n", strings.Join(options, " "))
+	}
+
+	return buf.Bytes()
+}
+
+//hostsFile renders an /etc/hosts with the hostname resolving to the loopback (and ip if set), followed
+//by the extra hosts sorted by name
+func hostsFile(hostname string, ip string, hosts map[string]string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprint(&buf, "127.0.0.1    localhost.localdomain localhost\n")
+	fmt.Fprint(&buf, "::1          localhost ip6-localhost ip6-loopback\n")
+	fmt.Fprintf(&buf, "127.0.0.1    %s.local %s\n", hostname, hostname)
+	if len(ip) != 0 {
+		fmt.Fprintf(&buf, "%s    %s\n", ip, hostname)
+	}
+
+	names := make([]string, 0, len(hosts))
+	for name := range hosts {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s    %s\n", hosts[name], name)
+	}
+
+	return buf.Bytes()
+}
+
+func (c *container) hostname() string {
+	if len(c.Args.Hostname) != 0 {
+		return c.Args.Hostname
+	}
+
+	return defaultHostname
+}
+
+//nameservers of the container, the configured ones first then the nameservers of the nics in order
+func (c *container) nameservers() []string {
+	nameservers := append([]string{}, c.Args.DNS.Nameservers...)
+	for _, nic := range c.Args.Nics {
+		if nic.State == NicStateDestroyed {
+			continue
+		}
+
+		if nic.Type == "default" {
+			nameservers = append(nameservers, DefaultBridgeIP)
+		} else if !nic.Config.Dhcp {
+			nameservers = append(nameservers, nic.Config.DNS...)
+		}
+	}
+
+	return nameservers
+}
+
+func (c *container) writeResolvConf() error {
+	_, err := c.writeFile("/etc/resolv.conf", resolvConf(c.nameservers(), c.Args.DNS.Search, c.Args.DNS.Options), 0644)
+	return err
+}
+
+//writeHosts writes the container /etc/hostname and /etc/hosts
+func (c *container) writeHosts() error {
+	hostname := c.hostname()
+	if _, err := c.writeFile("/etc/hostname", []byte(hostname+"\n"), 0644); err != nil {
+		return err
+	}
+
+	var ip string
+	if c.hasDefaultNetwork() {
+		ip = c.getDefaultIP().String()
+	}
+
+	_, err := c.writeFile("/etc/hosts", hostsFile(hostname, ip, c.Args.Hosts), 0644)
+	return err
+}
+
+//setUpResolver writes the container hosts and resolver files, with host networking the node resolv.conf
+//is used unless a dns config is given
+func (c *container) setUpResolver() error {
+	if err := c.writeHosts(); err != nil {
+		return err
+	}
+
+	if c.Args.HostNetwork && c.Args.DNS.empty() {
+		//the mount target is a new regular file, so the mount can't land outside of the root
+		p, err := c.writeFile("/etc/resolv.conf", nil, 0644)
+		if err != nil {
+			return err
+		}
+
+		return syscall.Mount("/etc/resolv.conf", p, "", syscall.MS_BIND, "")
+	}
+
+	return c.writeResolvConf()
+}
+
+//dnsNames the names the container is resolved with by the default bridge dns, the container name,
+//hostname and tags (only the ones that are valid host names)
+func (c *container) dnsNames() []string {
+	var names []string
+	candidates := append([]string{c.Args.Name, c.Args.Hostname}, c.Args.Tags...)
+	seen := make(map[string]bool)
+	for _, name := range candidates {
+		if seen[name] || !validHostname(name) {
+			continue
+		}
+
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	return names
+}
+
+func reloadDNS() {
+	job, ok := pm.JobOf(fmt.Sprintf("dnsmasq-%s", DefaultBridgeName))
+	if !ok {
+		return
+	}
+
+	if err := job.Signal(syscall.SIGHUP); err != nil {
+		log.Errorf("failed to reload default bridge dns: %s", err)
+	}
+}
+
+//registerDNS adds the container to the default bridge dns, only if it's enabled in the settings
+func (c *container) registerDNS() error {
+	if !settings.Settings.Containers.DNS || !c.hasDefaultNetwork() {
+		return nil
+	}
+
+	names := c.dnsNames()
+	if len(names) == 0 {
+		return nil
+	}
+
+	record := fmt.Sprintf("%s %s\n", c.getDefaultIP(), strings.Join(names, " "))
+	if ip6 := c.getDefaultIP6(); ip6 != nil {
+		record += fmt.Sprintf("%s %s\n", ip6, strings.Join(names, " "))
+	}
+	if err := ioutil.WriteFile(path.Join(DefaultBridgeHosts, fmt.Sprint(c.id)), []byte(record), 0644); err != nil {
+		return err
+	}
+
+	reloadDNS()
+	return nil
+}
+
+func (c *container) unregisterDNS() {
+	p := path.Join(DefaultBridgeHosts, fmt.Sprint(c.id))
+	if err := os.Remove(p); os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Errorf("failed to remove container %d dns record: %s", c.id, err)
+		return
+	}
+
+	reloadDNS()
+}
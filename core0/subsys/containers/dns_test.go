@@ -0,0 +1,61 @@
+package containers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolvConf(t *testing.T) {
+	conf := resolvConf(
+		[]string{"8.8.8.8", "172.18.0.1", "8.8.8.8"},
+		[]string{"example.com", "local"},
+		[]string{"ndots:2"},
+	)
+
+	assert.Equal(t, "nameserver 8.8.8.8\nnameserver 172.18.0.1\nsearch example.com local\noptions ndots:2\n", string(conf))
+	assert.Empty(t, resolvConf(nil, nil, nil))
+}
+
+func TestHostsFile(t *testing.T) {
+	hosts := hostsFile("app", "172.18.0.3", map[string]string{
+		"db":    "10.0.0.2",
+		"cache": "10.0.0.3",
+	})
+
+	assert.Equal(t, `127.0.0.1    localhost.localdomain localhost
+::1          localhost ip6-localhost ip6-loopback
+127.0.0.1    app.local app
+172.18.0.3    app
+10.0.0.3    cache
+10.0.0.2    db
+`, string(hosts))
+}
+
+func TestDNSConfigValidate(t *testing.T) {
+	assert.NoError(t, (&DNSConfig{
+		Nameservers: []string{"8.8.8.8", "2001:4860:4860::8888"},
+		Search:      []string{"example.com"},
+		Options:     []string{"ndots:2", "rotate"},
+	}).Validate())
+
+	assert.Error(t, (&DNSConfig{Nameservers: []string{"dns.example.com"}}).Validate())
+	assert.Error(t, (&DNSConfig{Search: []string{"-bad.com"}}).Validate())
+	assert.Error(t, (&DNSConfig{Options: []string{"ndots:2 rotate"}}).Validate())
+
+	assert.NoError(t, validateHosts(map[string]string{"db.local": "10.0.0.2"}))
+	assert.Error(t, validateHosts(map[string]string{"db": "10.0.0"}))
+	assert.Error(t, validateHosts(map[string]string{"d b": "10.0.0.2"}))
+}
+
+func TestContainerDNSNames(t *testing.T) {
+	c := &container{
+		Args: ContainerCreateArguments{
+			Name:     "web",
+			Hostname: "web",
+			Tags:     []string{"frontend", "not a name"},
+		},
+	}
+
+	assert.Equal(t, []string{"web", "frontend"}, c.dnsNames())
+}
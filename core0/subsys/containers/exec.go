@@ -0,0 +1,293 @@
+package containers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/pm/stream"
+	"github.com/zero-os/0-core/core0/transport"
+)
+
+const (
+	cmdContainerExec       = "corex.exec"
+	cmdContainerExecResize = "corex.exec_resize"
+
+	attachmentBufferSize = 1000
+)
+
+//inputMessage is sent to coreX to feed the stdin of a running job
+type inputMessage struct {
+	Input *pm.Input `json:"input"`
+}
+
+func (c *container) input(in *pm.Input) error {
+	return c.send(&inputMessage{Input: in})
+}
+
+/*
+Attachment is an interactive session to a job that runs inside a container. The job output (if requested)
+and its result are delivered to the Output and Result channels, both are closed when the job exits or when
+the container goes away.
+*/
+type Attachment struct {
+	ID     string
+	Output <-chan *stream.Message
+	Result <-chan *pm.JobResult
+
+	output    chan *stream.Message
+	result    chan *pm.JobResult
+	container *container
+	o         sync.Once
+}
+
+//Write sends data to the job stdin
+func (a *Attachment) Write(p []byte) error {
+	return a.container.input(&pm.Input{ID: a.ID, Data: p})
+}
+
+//CloseInput closes the job stdin
+func (a *Attachment) CloseInput() error {
+	return a.container.input(&pm.Input{ID: a.ID, EOF: true})
+}
+
+//Resize resizes the job terminal
+func (a *Attachment) Resize(rows, cols uint16) error {
+	return a.container.input(&pm.Input{ID: a.ID, Rows: rows, Cols: cols})
+}
+
+//close must be called with the container attachments lock held
+func (a *Attachment) close() {
+	a.o.Do(func() {
+		if a.output != nil {
+			close(a.output)
+		}
+		close(a.result)
+	})
+}
+
+//Detach stops receiving the job output, it doesn't stop the job.
+func (a *Attachment) Detach() {
+	a.container.attM.Lock()
+	defer a.container.attM.Unlock()
+
+	delete(a.container.attachments, a.ID)
+	a.close()
+}
+
+func (c *container) attach(id string, output bool) *Attachment {
+	attachment := &Attachment{
+		ID:        id,
+		result:    make(chan *pm.JobResult, 1),
+		container: c,
+	}
+
+	if output {
+		attachment.output = make(chan *stream.Message, attachmentBufferSize)
+		attachment.Output = attachment.output
+	}
+
+	attachment.Result = attachment.result
+
+	c.attM.Lock()
+	defer c.attM.Unlock()
+	c.attachments[id] = attachment
+
+	return attachment
+}
+
+//attached delivers a job message to its attachment
+func (c *container) attached(id string, msg *stream.Message) {
+	c.attM.Lock()
+	defer c.attM.Unlock()
+
+	attachment, ok := c.attachments[id]
+	if !ok || attachment.output == nil {
+		return
+	}
+
+	select {
+	case attachment.output <- msg:
+	default:
+		log.Warningf("attachment of job '%s' is not consuming output, dropping message", id)
+	}
+}
+
+//detach delivers the job result to its attachment and closes it
+func (c *container) detach(id string, result *pm.JobResult) {
+	c.attM.Lock()
+	defer c.attM.Unlock()
+
+	attachment, ok := c.attachments[id]
+	if !ok {
+		return
+	}
+
+	delete(c.attachments, id)
+	attachment.result <- result
+	attachment.close()
+}
+
+func (c *container) detachAll() {
+	c.attM.Lock()
+	defer c.attM.Unlock()
+
+	for id, attachment := range c.attachments {
+		delete(c.attachments, id)
+		attachment.close()
+	}
+}
+
+//Attach dispatches cmd to container id and attaches to it
+func (m *containerManager) Attach(id uint16, cmd *pm.Command) (*Attachment, error) {
+	m.conM.RLock()
+	cont, ok := m.containers[id]
+	m.conM.RUnlock()
+
+	if !ok {
+		return nil, pm.NotFoundError(fmt.Errorf("container does not exist"))
+	}
+
+	if cmd.ID == "" {
+		cmd.ID = uuid.New()
+	}
+
+	attachment := cont.attach(cmd.ID, true)
+	if err := m.pushToContainer(cont, cmd); err != nil {
+		attachment.Detach()
+		return nil, err
+	}
+
+	return attachment, nil
+}
+
+type ContainerExecArguments struct {
+	Container uint16 `json:"container"`
+	ID        string `json:"id"`
+	pm.ExecCommandArguments
+}
+
+/*
+exec starts a process inside the container with an optional user, working directory, environment and
+terminal. The job ID is returned and the job result is reported the same way as corex.dispatch. If stdin
+is set, the client feeds the process by pushing chunks to `stdin:<job-id>` (namespaced like the job result).
+*/
+func (m *containerManager) exec(cmd *pm.Command) (interface{}, error) {
+	var args ContainerExecArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	if args.Name == "" {
+		return nil, pm.BadRequestError(fmt.Errorf("name is required"))
+	}
+
+	m.conM.RLock()
+	cont, ok := m.containers[args.Container]
+	m.conM.RUnlock()
+
+	if !ok {
+		return nil, pm.NotFoundError(fmt.Errorf("container does not exist"))
+	}
+
+	if args.ID == "" {
+		args.ID = uuid.New()
+	}
+
+	job := &pm.Command{
+		ID:        args.ID,
+		Command:   pm.CommandExec,
+		Arguments: pm.MustArguments(args.ExecCommandArguments),
+		Tags:      cmd.Tags,
+		MaxTime:   cmd.MaxTime,
+		Stream:    cmd.Stream || args.TTY || args.Stdin,
+	}
+
+	m.sink.Inherit(cmd.ID, job.ID)
+
+	var attachment *Attachment
+	if args.Stdin {
+		attachment = cont.attach(job.ID, false)
+	}
+
+	if err := m.pushToContainer(cont, job); err != nil {
+		if attachment != nil {
+			attachment.Detach()
+		}
+		return nil, err
+	}
+
+	if attachment != nil {
+		go m.stdin(attachment)
+	}
+
+	return job.ID, nil
+}
+
+//stdin forwards the chunks pushed to the job stdin queue till the job exits, the queue is namespaced like
+//the job result so only the owner of the job can feed it
+func (m *containerManager) stdin(attachment *Attachment) {
+	queue := []byte(m.sink.JobQueue(transport.StdinQueue, attachment.ID))
+	defer m.sink.Del(queue)
+
+	for {
+		select {
+		case <-attachment.Result:
+			return
+		default:
+		}
+
+		chunk, err := m.sink.BLPop(queue, time.Second)
+		if err != nil {
+			log.Errorf("failed to read stdin of job '%s': %s", attachment.ID, err)
+			attachment.Detach()
+			return
+		}
+
+		if chunk == nil {
+			continue
+		}
+
+		if len(chunk) == 0 {
+			err = attachment.CloseInput()
+		} else {
+			err = attachment.Write(chunk)
+		}
+
+		if err != nil {
+			log.Errorf("failed to forward stdin of job '%s': %s", attachment.ID, err)
+		}
+	}
+}
+
+type ContainerExecResizeArguments struct {
+	Container uint16 `json:"container"`
+	ID        string `json:"id"`
+	Rows      uint16 `json:"rows"`
+	Cols      uint16 `json:"cols"`
+}
+
+//resize changes the terminal size of a corex.exec job
+func (m *containerManager) resize(cmd *pm.Command) (interface{}, error) {
+	var args ContainerExecResizeArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	if args.Rows == 0 || args.Cols == 0 {
+		return nil, pm.BadRequestError(fmt.Errorf("rows and cols are required"))
+	}
+
+	m.conM.RLock()
+	cont, ok := m.containers[args.Container]
+	m.conM.RUnlock()
+
+	if !ok {
+		return nil, pm.NotFoundError(fmt.Errorf("container does not exist"))
+	}
+
+	return nil, cont.input(&pm.Input{ID: args.ID, Rows: args.Rows, Cols: args.Cols})
+}
@@ -0,0 +1,100 @@
+package containers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/pm/stream"
+)
+
+const (
+	//DefaultTerminateTimeout seconds to wait for a container to exit after SIGTERM before it's killed
+	DefaultTerminateTimeout = 30
+
+	//exitedHistory number of exited containers kept for corex.list
+	exitedHistory = 20
+)
+
+//ExitInfo describes how a container ended
+type ExitInfo struct {
+	Code       uint32  `json:"code"`       //exit code of coreX, 128 + signal if it was killed by a signal
+	Signal     int     `json:"signal"`     //signal that killed coreX, 0 if it exited
+	Duration   float64 `json:"duration"`   //seconds the container was running
+	Killed     bool    `json:"killed"`     //killed because it didn't exit before the terminate timeout
+	Terminated bool    `json:"terminated"` //stopped with corex.terminate (or by an aborting hook)
+	Exited     int64   `json:"exited"`     //unix time
+}
+
+func newExitInfo(code uint32, started, exited time.Time) *ExitInfo {
+	info := &ExitInfo{
+		Code:     code,
+		Duration: exited.Sub(started).Seconds(),
+		Exited:   exited.Unix(),
+	}
+
+	//codes above 128 are reported for processes killed by a signal (64 signals on linux)
+	if code > 128 && code <= 128+64 {
+		info.Signal = int(code - 128)
+	}
+
+	return info
+}
+
+func (e *ExitInfo) String() string {
+	if e.Signal != 0 {
+		return fmt.Sprintf("killed by signal %d after %.1fs (force killed: %v)", e.Signal, e.Duration, e.Killed)
+	}
+
+	return fmt.Sprintf("exited with code %d after %.1fs", e.Code, e.Duration)
+}
+
+//exitCodeHook records the exit code of coreX, it's only part of the exit message
+type exitCodeHook struct {
+	pm.NOOPHook
+	c *container
+}
+
+func (h *exitCodeHook) Message(msg *stream.Message) {
+	if msg.Meta.Is(stream.ExitSuccessFlag | stream.ExitErrorFlag) {
+		h.c.exitCode = msg.Meta.Code()
+	}
+}
+
+//recordExit keeps the exited container for corex.list, only the last exitedHistory containers are kept
+func (m *containerManager) recordExit(c *container) {
+	m.conM.Lock()
+	defer m.conM.Unlock()
+
+	m.exited = append(m.exited, c)
+	if len(m.exited) > exitedHistory {
+		m.exited = m.exited[len(m.exited)-exitedHistory:]
+	}
+}
+
+//forgetExit drops the exits of the containers with the given id, it's called when the id is given to a
+//new container (restarted containers keep the exit of their previous run)
+func (m *containerManager) forgetExit(id uint16) {
+	m.conM.Lock()
+	defer m.conM.Unlock()
+
+	exited := m.exited[:0]
+	for _, c := range m.exited {
+		if c.id != id {
+			exited = append(exited, c)
+		}
+	}
+
+	m.exited = exited
+}
+
+//lastExit gets the last exited container with the given id, the caller must hold the containers lock
+func (m *containerManager) lastExit(id uint16) *container {
+	for i := len(m.exited) - 1; i >= 0; i-- {
+		if m.exited[i].id == id {
+			return m.exited[i]
+		}
+	}
+
+	return nil
+}
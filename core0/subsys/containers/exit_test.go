@@ -0,0 +1,46 @@
+package containers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewExitInfo(t *testing.T) {
+	started := time.Unix(1500000000, 0)
+	exited := started.Add(90 * time.Second)
+
+	info := newExitInfo(0, started, exited)
+	assert.Equal(t, uint32(0), info.Code)
+	assert.Equal(t, 0, info.Signal)
+	assert.Equal(t, 90.0, info.Duration)
+	assert.Equal(t, exited.Unix(), info.Exited)
+
+	info = newExitInfo(137, started, exited)
+	assert.Equal(t, 9, info.Signal)
+
+	info = newExitInfo(1, started, exited)
+	assert.Equal(t, 0, info.Signal)
+	assert.Equal(t, "exited with code 1 after 90.0s", info.String())
+}
+
+func TestExitHistory(t *testing.T) {
+	m := &containerManager{}
+	for i := 1; i <= exitedHistory+5; i++ {
+		m.recordExit(&container{id: uint16(i)})
+	}
+
+	assert.Len(t, m.exited, exitedHistory)
+	assert.Nil(t, m.lastExit(5))
+	assert.NotNil(t, m.lastExit(6))
+
+	again := &container{id: 10}
+	m.recordExit(again)
+	assert.True(t, m.lastExit(10) == again)
+
+	//both exits of the id are dropped
+	m.forgetExit(10)
+	assert.Nil(t, m.lastExit(10))
+	assert.Len(t, m.exited, exitedHistory-2)
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/shirou/gopsutil/disk"
 	"github.com/zero-os/0-core/base/pm"
 	"github.com/zero-os/0-core/base/settings"
+	"github.com/zero-os/0-core/base/utils"
 	"github.com/zero-os/0-core/core0/helper"
 )
 
@@ -36,7 +37,64 @@ func (c *container) mountFList(src string, target string, hooks ...pm.RunnerHook
 		c.Args.Storage = storage
 	}
 
-	return helper.MountFList(namespace, storage, src, target, hooks...)
+	mount := helper.MountFList
+	if len(c.Args.Snapshot) != 0 && src == c.Args.Root {
+		//the writable layer of the root is restored from the snapshot
+		mount = helper.RemountFList
+	}
+
+	return mount(namespace, storage, src, target, hooks...)
+}
+
+/*
+writeInRoot writes the file name of root owned by uid and gid (-1 keeps the owner). The parent directory is
+resolved inside root, and an existing file is replaced and never followed, so a symlink in the root (from an
+image, a snapshot or the container itself) can't make core0 write outside of it. It returns the host path of
+the file.
+*/
+func writeInRoot(root, name string, data []byte, perm os.FileMode, uid, gid int) (string, error) {
+	name = path.Clean("/" + name)
+	parent, err := utils.InRoot(root, path.Dir(name))
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+
+	dir, err := syscall.Open(parent, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return "", &os.PathError{Op: "open", Path: parent, Err: err}
+	}
+
+	defer syscall.Close(dir)
+
+	base := path.Base(name)
+	p := path.Join(parent, base)
+	if err := syscall.Unlinkat(dir, base); err != nil && err != syscall.ENOENT {
+		return "", &os.PathError{Op: "remove", Path: p, Err: err}
+	}
+
+	fd, err := syscall.Openat(dir, base, syscall.O_WRONLY|syscall.O_CREAT|syscall.O_EXCL|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, uint32(perm))
+	if err != nil {
+		return "", &os.PathError{Op: "open", Path: p, Err: err}
+	}
+
+	file := os.NewFile(uintptr(fd), p)
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return "", err
+	}
+
+	if uid != -1 || gid != -1 {
+		if err := file.Chown(uid, gid); err != nil {
+			return "", err
+		}
+	}
+
+	return p, nil
 }
 
 func (c *container) root() string {
@@ -101,7 +159,15 @@ func (c *container) sandbox() error {
 	if fstype == "btrfs" {
 		//make sure we delete it if sub volume exists
 		pm.System("btrfs", "subvolume", "delete", path.Join(BackendBaseDir, c.name()))
-		pm.System("btrfs", "subvolume", "create", path.Join(BackendBaseDir, c.name()))
+		if len(c.Args.Snapshot) != 0 {
+			if err := c.restoreSnapshot(); err != nil {
+				return fmt.Errorf("restore-snapshot(%s)", err)
+			}
+		} else {
+			pm.System("btrfs", "subvolume", "create", path.Join(BackendBaseDir, c.name()))
+		}
+	} else if len(c.Args.Snapshot) != 0 {
+		return fmt.Errorf("snapshots are only supported if '%s' is on btrfs", BackendBaseDir)
 	}
 
 	root := c.root()
@@ -114,10 +180,20 @@ func (c *container) sandbox() error {
 		},
 	}
 
-	if err := c.mountFList(c.Args.Root, root, onSBExit); err != nil {
+	if helper.IsImage(c.Args.Root) {
+		if err := c.mountImage(root); err != nil {
+			return fmt.Errorf("mount-root-image(%s)", err)
+		}
+	} else if err := c.mountFList(c.Args.Root, root, onSBExit); err != nil {
 		return fmt.Errorf("mount-root-flist(%s)", err)
 	}
 
+	if c.Args.UserNS {
+		if err := c.setUpUserNS(); err != nil {
+			return err
+		}
+	}
+
 	for src, dst := range c.Args.Mount {
 		target := path.Join(root, dst)
 		if err := os.MkdirAll(target, 0755); err != nil {
@@ -141,6 +217,12 @@ func (c *container) sandbox() error {
 		}
 	}
 
+	for i := range c.Args.Mounts {
+		if err := c.mount(&c.Args.Mounts[i]); err != nil {
+			return err
+		}
+	}
+
 	coreXTarget := path.Join(root, coreXBinaryName)
 	if f, err := os.Create(coreXTarget); err == nil {
 		f.Close()
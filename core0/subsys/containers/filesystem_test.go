@@ -0,0 +1,49 @@
+package containers
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteInRoot(t *testing.T) {
+	root, err := ioutil.TempDir("", "root")
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+	defer os.RemoveAll(root)
+
+	outside, err := ioutil.TempDir("", "outside")
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+	defer os.RemoveAll(outside)
+
+	target := path.Join(outside, "shadow")
+	assert.NoError(t, ioutil.WriteFile(target, []byte("secret"), 0600))
+
+	//a directory link is resolved inside the root, a file link is replaced
+	assert.NoError(t, os.Symlink(outside, path.Join(root, "etc")))
+	p, err := writeInRoot(root, "/etc/hosts", []byte("hosts"), 0644, -1, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, path.Join(root, outside, "hosts"), p)
+
+	assert.NoError(t, os.Remove(path.Join(root, "etc")))
+	assert.NoError(t, os.Mkdir(path.Join(root, "etc"), 0755))
+	assert.NoError(t, os.Symlink(target, path.Join(root, "etc", "hosts")))
+
+	p, err = writeInRoot(root, "/etc/hosts", []byte("hosts"), 0644, -1, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, path.Join(root, "etc", "hosts"), p)
+
+	info, err := os.Lstat(p)
+	assert.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+
+	data, err := ioutil.ReadFile(target)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", string(data))
+}
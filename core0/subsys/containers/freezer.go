@@ -0,0 +1,129 @@
+package containers
+
+import (
+	"encoding/json"
+	"fmt"
+	"syscall"
+
+	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/core0/subsys/cgroups"
+)
+
+var (
+	errNoFreezer = fmt.Errorf("%s cgroup is not supported on this node", cgroups.FreezerSubsystem)
+)
+
+func (c *container) freezer() (cgroups.FreezerGroup, error) {
+	group, err := c.group(cgroups.FreezerSubsystem, false)
+	if err != nil {
+		return nil, err
+	} else if group == nil {
+		return nil, errNoFreezer
+	}
+
+	freezer, ok := group.(cgroups.FreezerGroup)
+	if !ok {
+		return nil, fmt.Errorf("invalid freezer cgroup")
+	}
+
+	return freezer, nil
+}
+
+//Pause freezes all the processes of the container, unlike SIGSTOP this is not visible to the processes
+func (c *container) Pause() error {
+	freezer, err := c.freezer()
+	if err != nil {
+		return err
+	}
+
+	return freezer.Freeze()
+}
+
+//Resume thaws the processes of a paused container
+func (c *container) Resume() error {
+	freezer, err := c.freezer()
+	if err != nil {
+		return err
+	}
+
+	return freezer.Thaw()
+}
+
+/*
+Freeze pauses the container while its files are accessed from the host (backup, copy), a container that
+is already paused is left as is. It returns the function that resumes the container. On nodes without
+the freezer cgroup the container processes are stopped with SIGSTOP instead.
+*/
+func (c *container) Freeze() (func(), error) {
+	if c.Frozen() {
+		return func() {}, nil
+	}
+
+	err := c.Pause()
+	if err == errNoFreezer {
+		//the container is still starting, signaling -0 would stop the core0 process group
+		if c.PID <= 0 {
+			return nil, fmt.Errorf("container is not fully started yet")
+		}
+
+		if err := syscall.Kill(-c.PID, syscall.SIGSTOP); err != nil {
+			return nil, err
+		}
+
+		return func() {
+			syscall.Kill(-c.PID, syscall.SIGCONT)
+		}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if err := c.Resume(); err != nil {
+			log.Errorf("failed to resume container %d: %s", c.id, err)
+		}
+	}, nil
+}
+
+//Frozen checks if the container is paused
+func (c *container) Frozen() bool {
+	freezer, err := c.freezer()
+	if err != nil {
+		return false
+	}
+
+	state, err := freezer.State()
+	if err != nil {
+		return false
+	}
+
+	return state == cgroups.FreezerStateFrozen
+}
+
+func (m *containerManager) freeze(cmd *pm.Command, pause bool) (interface{}, error) {
+	var args ContainerArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	m.conM.RLock()
+	container, ok := m.containers[args.Container]
+	m.conM.RUnlock()
+
+	if !ok {
+		return nil, pm.NotFoundError(fmt.Errorf("container does not exist"))
+	}
+
+	if pause {
+		return nil, container.Pause()
+	}
+
+	return nil, container.Resume()
+}
+
+func (m *containerManager) pause(cmd *pm.Command) (interface{}, error) {
+	return m.freeze(cmd, true)
+}
+
+func (m *containerManager) resume(cmd *pm.Command) (interface{}, error) {
+	return m.freeze(cmd, false)
+}
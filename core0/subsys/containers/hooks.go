@@ -0,0 +1,232 @@
+package containers
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/pborman/uuid"
+	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/pm/stream"
+)
+
+const (
+	HookPreStart  = "prestart"
+	HookPostStart = "poststart"
+	HookPreStop   = "prestop"
+	HookInit      = "init"
+
+	HookFailureIgnore = HookFailurePolicy("ignore")
+	HookFailureAbort  = HookFailurePolicy("abort")
+
+	//DefaultHookTimeout is the hook timeout in seconds if not set
+	DefaultHookTimeout = 60
+)
+
+//HookFailurePolicy decides what happens if a hook fails or times out
+type HookFailurePolicy string
+
+//Hook is a command that runs at a stage of the container life cycle
+type Hook struct {
+	Name      string            `json:"name"`       //binary to run
+	Args      []string          `json:"args"`       //binary arguments
+	Env       map[string]string `json:"env"`        //extra environment variables
+	Timeout   int               `json:"timeout"`    //seconds before the hook is killed (default 60)
+	OnFailure HookFailurePolicy `json:"on_failure"` //ignore (default) or abort
+}
+
+func (h *Hook) Validate() error {
+	if h == nil {
+		return nil
+	}
+
+	if len(h.Name) == 0 {
+		return fmt.Errorf("hook name is required")
+	}
+
+	if h.Timeout < 0 {
+		return fmt.Errorf("invalid hook timeout '%d'", h.Timeout)
+	}
+
+	switch h.OnFailure {
+	case "", HookFailureIgnore, HookFailureAbort:
+		return nil
+	default:
+		return fmt.Errorf("invalid hook failure policy '%s'", h.OnFailure)
+	}
+}
+
+func (h *Hook) timeout() int {
+	if h.Timeout == 0 {
+		return DefaultHookTimeout
+	}
+
+	return h.Timeout
+}
+
+/*
+Hooks of the container life cycle. The prestart, poststart and prestop hooks run on the host, the init hook
+runs inside the container.
+  - prestart: before coreX starts, the container root, mounts and networking are ready. If it aborts the container is not started.
+  - poststart: once the container is started and configured. If it aborts the container is stopped.
+  - init: inside the container after poststart, before the image entrypoint. If it aborts the container is stopped.
+  - prestop: before the container is terminated with corex.terminate. If it aborts the container is not terminated.
+*/
+type Hooks struct {
+	PreStart  *Hook `json:"prestart"`
+	PostStart *Hook `json:"poststart"`
+	PreStop   *Hook `json:"prestop"`
+	Init      *Hook `json:"init"`
+}
+
+func (h *Hooks) Validate() error {
+	for name, hook := range map[string]*Hook{
+		HookPreStart:  h.PreStart,
+		HookPostStart: h.PostStart,
+		HookPreStop:   h.PreStop,
+		HookInit:      h.Init,
+	} {
+		if err := hook.Validate(); err != nil {
+			return fmt.Errorf("%s hook: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+//hookEnv the environment of the host hooks, that describes the container
+func (c *container) hookEnv(stage string, hook *Hook) map[string]string {
+	env := map[string]string{
+		"CONTAINER_ID":   fmt.Sprint(c.id),
+		"CONTAINER_NAME": c.Args.Name,
+		"CONTAINER_ROOT": c.root(),
+		"CONTAINER_HOOK": stage,
+	}
+
+	if c.PID > 0 {
+		env["CONTAINER_PID"] = fmt.Sprint(c.PID)
+	}
+
+	if ip := c.getDefaultIP(); ip != nil {
+		env["CONTAINER_IP"] = ip.String()
+	}
+
+	for key, value := range hook.Env {
+		env[key] = value
+	}
+
+	return env
+}
+
+func resultError(result *pm.JobResult) error {
+	if result.State == pm.StateSuccess {
+		return nil
+	}
+
+	return fmt.Errorf("exited with '%s': %s", result.State, strings.TrimSpace(result.Streams.Stderr()))
+}
+
+//hookFailed logs the hook failure to the container log, the error is returned only if the hook policy is abort
+func (c *container) hookFailed(stage string, hook *Hook, err error) error {
+	err = fmt.Errorf("%s hook '%s' failed: %s", stage, hook.Name, err)
+	c.log.Log(stream.LevelOperator, "%s", err)
+
+	if hook.OnFailure == HookFailureAbort {
+		return err
+	}
+
+	log.Warningf("container %d: %s (ignored)", c.id, err)
+	return nil
+}
+
+/*
+runHook runs a host hook and waits for it, an error is returned only if the hook failed and its policy is abort.
+The hook is killed after limit seconds if it's less than the hook timeout (0 means no limit).
+*/
+func (c *container) runHook(stage string, hook *Hook, limit int) error {
+	if hook == nil {
+		return nil
+	}
+
+	timeout := hook.timeout()
+	if limit > 0 && limit < timeout {
+		timeout = limit
+	}
+
+	job, err := pm.Run(&pm.Command{
+		ID:      uuid.New(),
+		Command: pm.CommandSystem,
+		MaxTime: timeout,
+		Arguments: pm.MustArguments(
+			pm.SystemCommandArguments{
+				Name: hook.Name,
+				Args: hook.Args,
+				Env:  c.hookEnv(stage, hook),
+			},
+		),
+	})
+
+	if err == nil {
+		err = resultError(job.Wait())
+	}
+
+	if err != nil {
+		return c.hookFailed(stage, hook, err)
+	}
+
+	return nil
+}
+
+//runInitHook runs the init hook inside the container and waits for it
+func (c *container) runInitHook() error {
+	hook := c.Args.Hooks.Init
+	if hook == nil {
+		return nil
+	}
+
+	cmd := &pm.Command{
+		ID:      uuid.New(),
+		Command: pm.CommandSystem,
+		MaxTime: hook.timeout(),
+		Arguments: pm.MustArguments(
+			pm.SystemCommandArguments{
+				Name: hook.Name,
+				Args: hook.Args,
+				Env:  hook.Env,
+			},
+		),
+	}
+
+	err := c.mgr.pushToContainer(c, cmd)
+	if err == nil {
+		//coreX kills the hook after its timeout, give it some time to report back
+		var result *pm.JobResult
+		if result, err = c.mgr.sink.GetResult(cmd.ID, hook.timeout()+10); err == nil {
+			err = resultError(result)
+		}
+	}
+
+	if err != nil {
+		return c.hookFailed(HookInit, hook, err)
+	}
+
+	return nil
+}
+
+//lifecycle runs the poststart and init hooks of a started container then its entrypoint, the container is
+//stopped if an aborting hook fails
+func (c *container) lifecycle() {
+	err := c.runHook(HookPostStart, c.Args.Hooks.PostStart, 0)
+	if err == nil {
+		err = c.runInitHook()
+	}
+
+	if err != nil {
+		log.Errorf("container %d: %s, stopping container", c.id, err)
+		c.terminated = true
+		c.runner.Signal(syscall.SIGTERM)
+		return
+	}
+
+	c.runEntrypoint()
+}
@@ -0,0 +1,59 @@
+package containers
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zero-os/0-core/base/pm"
+)
+
+func TestHooksValidate(t *testing.T) {
+	hooks := Hooks{
+		PreStart: &Hook{Name: "/bin/register", OnFailure: HookFailureAbort},
+		PreStop:  &Hook{Name: "/bin/flush", Timeout: 300},
+	}
+
+	assert.NoError(t, hooks.Validate())
+	assert.NoError(t, (&Hooks{}).Validate())
+
+	for _, hook := range []*Hook{
+		{},
+		{Name: "/bin/true", Timeout: -1},
+		{Name: "/bin/true", OnFailure: "retry"},
+	} {
+		assert.Error(t, (&Hooks{Init: hook}).Validate(), "%+v", hook)
+	}
+
+	assert.Equal(t, DefaultHookTimeout, hooks.PreStart.timeout())
+	assert.Equal(t, 300, hooks.PreStop.timeout())
+}
+
+func TestHookEnv(t *testing.T) {
+	c := &container{
+		id:        3,
+		PID:       1234,
+		Args:      ContainerCreateArguments{Name: "web"},
+		defaultIP: &net.IPNet{IP: net.ParseIP("172.18.0.5"), Mask: net.CIDRMask(16, 32)},
+	}
+
+	env := c.hookEnv(HookPostStart, &Hook{Name: "/bin/register", Env: map[string]string{"SERVICE": "http"}})
+	assert.Equal(t, map[string]string{
+		"CONTAINER_ID":   "3",
+		"CONTAINER_NAME": "web",
+		"CONTAINER_ROOT": c.root(),
+		"CONTAINER_HOOK": HookPostStart,
+		"CONTAINER_PID":  "1234",
+		"CONTAINER_IP":   "172.18.0.5",
+		"SERVICE":        "http",
+	}, env)
+}
+
+func TestHookResultError(t *testing.T) {
+	assert.NoError(t, resultError(&pm.JobResult{State: pm.StateSuccess}))
+
+	err := resultError(&pm.JobResult{State: pm.StateTimeout, Streams: pm.Streams{"", "killed\n"}})
+	if assert.Error(t, err) {
+		assert.Equal(t, "exited with 'TIMEOUT': killed", err.Error())
+	}
+}
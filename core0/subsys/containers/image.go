@@ -0,0 +1,110 @@
+package containers
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/core0/helper"
+)
+
+func (c *container) rootfs() string {
+	return path.Join(BackendBaseDir, c.name(), "rootfs")
+}
+
+//mountImage unpacks the root image and mounts a writable copy of it on the container root, a btrfs
+//snapshot of the image if possible otherwise an overlay with the image as the lower dir
+func (c *container) mountImage(root string) error {
+	image, err := helper.PrepareImage(c.Args.Root)
+	if err != nil {
+		return err
+	}
+
+	c.image = &image.Config
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+
+	backend := path.Join(BackendBaseDir, c.name())
+	if err := os.MkdirAll(backend, 0755); err != nil {
+		return err
+	}
+
+	if len(c.Args.Snapshot) != 0 {
+		if _, err := os.Stat(c.rootfs()); err == nil {
+			//the root was restored from the snapshot
+			return syscall.Mount(c.rootfs(), root, "", syscall.MS_BIND, "")
+		}
+	} else if image.Subvolume {
+		if _, err := pm.System("btrfs", "subvolume", "snapshot", image.Path, c.rootfs()); err == nil {
+			return syscall.Mount(c.rootfs(), root, "", syscall.MS_BIND, "")
+		} else {
+			log.Errorf("failed to snapshot image, falling back to overlay: %s", err)
+		}
+	}
+
+	upper := path.Join(backend, "upper")
+	work := path.Join(backend, "work")
+	for _, dir := range []string{upper, work} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	return syscall.Mount("overlay", root, "overlay", 0,
+		fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", image.Path, upper, work))
+}
+
+//imageEnv gets the environment of the root image
+func (c *container) imageEnv() map[string]string {
+	env := make(map[string]string)
+	if c.image == nil {
+		return env
+	}
+
+	for _, kv := range c.image.Env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		env[parts[0]] = parts[1]
+	}
+
+	return env
+}
+
+//runEntrypoint starts the image entrypoint and cmd inside the container
+func (c *container) runEntrypoint() {
+	if c.image == nil {
+		return
+	}
+
+	command := append(append([]string{}, c.image.Entrypoint...), c.image.Cmd...)
+	if len(command) == 0 {
+		return
+	}
+
+	dir := c.image.WorkingDir
+	if len(dir) == 0 {
+		dir = "/"
+	}
+
+	cmd := &pm.Command{
+		ID:      fmt.Sprintf("core-%d-entrypoint", c.id),
+		Command: pm.CommandExec,
+		Arguments: pm.MustArguments(pm.ExecCommandArguments{
+			Name: command[0],
+			Args: command[1:],
+			Dir:  dir,
+			User: c.image.User,
+		}),
+	}
+
+	if err := c.mgr.pushToContainer(c, cmd); err != nil {
+		log.Errorf("failed to start container %d entrypoint: %s", c.id, err)
+	}
+}
@@ -0,0 +1,105 @@
+package containers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	selectorEquals    = "="
+	selectorNotEquals = "!="
+	selectorExists    = "exists"
+	selectorNotExists = "!exists"
+)
+
+var (
+	labelKeyPattern   = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9._/-]*[a-zA-Z0-9])?$`)
+	labelValuePattern = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9._-]*[a-zA-Z0-9])?)?$`)
+)
+
+func validateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if len(key) > 253 || !labelKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid label key '%s'", key)
+		}
+
+		if len(value) > 253 || !labelValuePattern.MatchString(value) {
+			return fmt.Errorf("invalid value '%s' of label '%s'", value, key)
+		}
+	}
+
+	return nil
+}
+
+type requirement struct {
+	key   string
+	op    string
+	value string
+}
+
+func (r *requirement) matches(labels map[string]string) bool {
+	value, ok := labels[r.key]
+	switch r.op {
+	case selectorEquals:
+		return ok && value == r.value
+	case selectorNotEquals:
+		return !ok || value != r.value
+	case selectorExists:
+		return ok
+	default:
+		return !ok
+	}
+}
+
+//Selector is a list of label requirements, a container matches the selector if it matches all of them
+type Selector []requirement
+
+/*
+ParseSelector parses a label selector, a comma separated list of requirements:
+  - key=value (or key==value): the label is set to value
+  - key!=value: the label is not set to value (or not set at all)
+  - key: the label is set
+  - !key: the label is not set
+*/
+func ParseSelector(selector string) (Selector, error) {
+	var result Selector
+	for _, part := range strings.Split(selector, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+
+		var req requirement
+		if index := strings.Index(part, "!="); index >= 0 {
+			req = requirement{key: part[:index], op: selectorNotEquals, value: part[index+2:]}
+		} else if index := strings.Index(part, "="); index >= 0 {
+			req = requirement{key: part[:index], op: selectorEquals, value: strings.TrimPrefix(part[index+1:], "=")}
+		} else if strings.HasPrefix(part, "!") {
+			req = requirement{key: part[1:], op: selectorNotExists}
+		} else {
+			req = requirement{key: part, op: selectorExists}
+		}
+
+		req.key = strings.TrimSpace(req.key)
+		req.value = strings.TrimSpace(req.value)
+		if !labelKeyPattern.MatchString(req.key) || !labelValuePattern.MatchString(req.value) {
+			return nil, fmt.Errorf("invalid selector requirement '%s'", part)
+		}
+
+		result = append(result, req)
+	}
+
+	return result, nil
+}
+
+//Matches checks if the labels match all the requirements of the selector, an empty selector matches everything
+func (s Selector) Matches(labels map[string]string) bool {
+	for i := range s {
+		if !s[i].matches(labels) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,85 @@
+package containers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateLabels(t *testing.T) {
+	assert.NoError(t, validateLabels(map[string]string{
+		"env":                  "prod",
+		"app.example.com/tier": "front-end",
+		"empty":                "",
+	}))
+
+	for _, labels := range []map[string]string{
+		{"": "value"},
+		{"env": "prod,dev"},
+		{"env=prod": "dev"},
+		{"-env": "prod"},
+		{"env": "prod "},
+	} {
+		assert.Error(t, validateLabels(labels), "%v", labels)
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	selector, err := ParseSelector("env=prod, role!=db,tier==front,backup,!debug")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, Selector{
+		{key: "env", op: selectorEquals, value: "prod"},
+		{key: "role", op: selectorNotEquals, value: "db"},
+		{key: "tier", op: selectorEquals, value: "front"},
+		{key: "backup", op: selectorExists},
+		{key: "debug", op: selectorNotExists},
+	}, selector)
+
+	selector, err = ParseSelector("")
+	assert.NoError(t, err)
+	assert.Empty(t, selector)
+
+	for _, invalid := range []string{"=prod", "env=a=b", "!", "env!=a,b c"} {
+		_, err := ParseSelector(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	selector, _ := ParseSelector("env=prod,role!=db")
+
+	assert.True(t, selector.Matches(map[string]string{"env": "prod", "role": "web"}))
+	assert.True(t, selector.Matches(map[string]string{"env": "prod"}))
+	assert.False(t, selector.Matches(map[string]string{"env": "prod", "role": "db"}))
+	assert.False(t, selector.Matches(map[string]string{"env": "dev"}))
+	assert.False(t, selector.Matches(nil))
+
+	selector, _ = ParseSelector("backup,!debug")
+	assert.True(t, selector.Matches(map[string]string{"backup": ""}))
+	assert.False(t, selector.Matches(map[string]string{"backup": "", "debug": "true"}))
+
+	assert.True(t, Selector(nil).Matches(nil))
+}
+
+func TestEnvChanges(t *testing.T) {
+	c := &container{
+		Args: ContainerCreateArguments{
+			Env: map[string]string{"A": "1", "B": "2", "PATH": "/bin"},
+		},
+	}
+
+	changes := c.envChanges(map[string]string{"A": "1", "B": "3", "C": "4"})
+	assert.Equal(t, map[string]string{
+		"B":    "3",
+		"C":    "4",
+		"PATH": "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+	}, changes.Set)
+	assert.Empty(t, changes.Unset)
+
+	changes = c.envChanges(map[string]string{"PATH": "/bin"})
+	assert.Empty(t, changes.Set)
+	assert.Equal(t, []string{"A", "B"}, changes.Unset)
+}
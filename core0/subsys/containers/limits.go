@@ -0,0 +1,278 @@
+package containers
+
+import (
+	"fmt"
+	"regexp"
+	"syscall"
+
+	"github.com/zero-os/0-core/core0/subsys/cgroups"
+)
+
+var (
+	cpusetPattern = regexp.MustCompile(`^[0-9,\-]*$`)
+	devicePattern = regexp.MustCompile(`^\d+:\d+$`)
+
+	//cgroups subsystems that are setup per container
+	containerSubsystems = []string{
+		cgroups.CPUSubsystem,
+		cgroups.CPUAcctSubsystem,
+		cgroups.CPUSetSubsystem,
+		cgroups.MemorySubsystem,
+		cgroups.PidsSubsystem,
+		cgroups.BlkioSubsystem,
+		cgroups.FreezerSubsystem,
+	}
+)
+
+//BlkioThrottle max io rate of a block device, a rate of 0 means no limit
+type BlkioThrottle struct {
+	Device    string `json:"device"`     //device path (/dev/sda) or major:minor
+	ReadBPS   uint64 `json:"read_bps"`   //bytes per second
+	WriteBPS  uint64 `json:"write_bps"`  //bytes per second
+	ReadIOPS  uint64 `json:"read_iops"`  //operations per second
+	WriteIOPS uint64 `json:"write_iops"` //operations per second
+}
+
+//ContainerLimits resource limits of a container, zero values means no limit (or the default weight)
+type ContainerLimits struct {
+	CPUShares     uint64          `json:"cpu_shares"`     //relative cpu weight (default 1024)
+	CPUQuota      int64           `json:"cpu_quota"`      //cpu time in microseconds per cpu period
+	CPUPeriod     uint64          `json:"cpu_period"`     //cpu period in microseconds (default 100000)
+	CPUSet        string          `json:"cpuset"`         //cpus the container can use (ex: 0-2,5)
+	Memory        int64           `json:"memory"`         //memory limit in bytes
+	Swap          int64           `json:"swap"`           //swap on top of the memory limit in bytes, -1 means unlimited
+	PidsMax       int64           `json:"pids_max"`       //max number of processes and threads
+	BlkioWeight   uint16          `json:"blkio_weight"`   //relative io weight, 10 to 1000 (default 500)
+	BlkioThrottle []BlkioThrottle `json:"blkio_throttle"` //per device io limits
+}
+
+func (l *ContainerLimits) Validate() error {
+	if l.CPUShares != 0 && (l.CPUShares < 2 || l.CPUShares > 262144) {
+		return fmt.Errorf("cpu shares must be between 2 and 262144")
+	}
+
+	if l.CPUQuota < 0 {
+		return fmt.Errorf("invalid cpu quota '%d'", l.CPUQuota)
+	}
+
+	if l.CPUQuota != 0 && l.CPUQuota < 1000 {
+		return fmt.Errorf("cpu quota must be at least 1000us")
+	}
+
+	if l.CPUPeriod != 0 && (l.CPUPeriod < 1000 || l.CPUPeriod > 1000000) {
+		return fmt.Errorf("cpu period must be between 1000us and 1000000us")
+	}
+
+	if !cpusetPattern.MatchString(l.CPUSet) {
+		return fmt.Errorf("invalid cpuset '%s'", l.CPUSet)
+	}
+
+	if l.Memory < 0 {
+		return fmt.Errorf("invalid memory limit '%d'", l.Memory)
+	}
+
+	if l.Swap < -1 {
+		return fmt.Errorf("invalid swap limit '%d'", l.Swap)
+	}
+
+	if l.PidsMax < 0 {
+		return fmt.Errorf("invalid pids max '%d'", l.PidsMax)
+	}
+
+	if l.BlkioWeight != 0 && (l.BlkioWeight < 10 || l.BlkioWeight > 1000) {
+		return fmt.Errorf("blkio weight must be between 10 and 1000")
+	}
+
+	for _, throttle := range l.BlkioThrottle {
+		if _, err := deviceNumber(throttle.Device); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//deviceNumber gets the major:minor of a block device
+func deviceNumber(device string) (string, error) {
+	if devicePattern.MatchString(device) {
+		return device, nil
+	}
+
+	var stat syscall.Stat_t
+	if err := syscall.Stat(device, &stat); err != nil {
+		return "", fmt.Errorf("invalid device '%s': %s", device, err)
+	}
+
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+		return "", fmt.Errorf("'%s' is not a block device", device)
+	}
+
+	major := (stat.Rdev >> 8) & 0xfff
+	minor := (stat.Rdev & 0xff) | ((stat.Rdev >> 12) & 0xfff00)
+
+	return fmt.Sprintf("%d:%d", major, minor), nil
+}
+
+func (c *container) cgroupName() string {
+	return fmt.Sprintf("corex-%d", c.id)
+}
+
+//setUpCGroups creates the resource cgroups of the container and applies the container limits
+func (c *container) setUpCGroups() error {
+	c.cgroups = make(map[string]cgroups.Group)
+	for _, subsystem := range containerSubsystems {
+		if !cgroups.Available(subsystem) {
+			continue
+		}
+
+		group, err := cgroups.GetGroup(c.cgroupName(), subsystem)
+		if err != nil {
+			return err
+		}
+
+		c.cgroups[subsystem] = group
+	}
+
+	return c.applyLimits(nil, &c.Args.Limits)
+}
+
+func (c *container) removeCGroups() {
+	for _, group := range c.cgroups {
+		if err := group.Remove(); err != nil {
+			log.Errorf("failed to remove cgroup '%s/%s': %s", group.Subsystem(), group.Name(), err)
+		}
+	}
+}
+
+//group gets the container group of subsystem, it fails if the subsystem is not available and
+//required is true
+func (c *container) group(subsystem string, required bool) (cgroups.Group, error) {
+	group, ok := c.cgroups[subsystem]
+	if !ok && required {
+		return nil, fmt.Errorf("%s cgroup is not supported on this node", subsystem)
+	}
+
+	return group, nil
+}
+
+//applyLimits applies the limits to the container cgroups, old are the previously applied limits
+//(if any) so limits that are not set anymore are removed
+func (c *container) applyLimits(old, limits *ContainerLimits) error {
+	if group, err := c.group(cgroups.CPUSubsystem, limits.CPUShares != 0 || limits.CPUQuota != 0); err != nil {
+		return err
+	} else if group, ok := group.(cgroups.CPUGroup); ok {
+		if err := group.Shares(limits.CPUShares); err != nil {
+			return err
+		}
+
+		if err := group.Quota(limits.CPUQuota, limits.CPUPeriod); err != nil {
+			return err
+		}
+	}
+
+	if group, err := c.group(cgroups.CPUSetSubsystem, limits.CPUSet != ""); err != nil {
+		return err
+	} else if group, ok := group.(cgroups.CPUSetGroup); ok {
+		if err := group.CPUs(limits.CPUSet); err != nil {
+			return err
+		}
+	}
+
+	if group, err := c.group(cgroups.MemorySubsystem, limits.Memory != 0); err != nil {
+		return err
+	} else if group, ok := group.(cgroups.MemoryGroup); ok {
+		if err := group.Limits(limits.Memory, limits.Swap); err != nil {
+			return err
+		}
+	}
+
+	if group, err := c.group(cgroups.PidsSubsystem, limits.PidsMax != 0); err != nil {
+		return err
+	} else if group, ok := group.(cgroups.PidsGroup); ok {
+		if err := group.Max(limits.PidsMax); err != nil {
+			return err
+		}
+	}
+
+	group, err := c.group(cgroups.BlkioSubsystem, limits.BlkioWeight != 0 || len(limits.BlkioThrottle) != 0)
+	if err != nil {
+		return err
+	}
+
+	blkio, ok := group.(cgroups.BlkioGroup)
+	if !ok {
+		return nil
+	}
+
+	if err := blkio.Weight(limits.BlkioWeight); err != nil {
+		return err
+	}
+
+	throttles := make(map[string]BlkioThrottle)
+	if old != nil {
+		//reset the throttles of the old limits
+		for _, throttle := range old.BlkioThrottle {
+			if device, err := deviceNumber(throttle.Device); err == nil {
+				throttles[device] = BlkioThrottle{}
+			}
+		}
+	}
+
+	for _, throttle := range limits.BlkioThrottle {
+		device, err := deviceNumber(throttle.Device)
+		if err != nil {
+			return err
+		}
+
+		throttles[device] = throttle
+	}
+
+	for device, throttle := range throttles {
+		for kind, rate := range map[string]uint64{
+			cgroups.ThrottleReadBPS:   throttle.ReadBPS,
+			cgroups.ThrottleWriteBPS:  throttle.WriteBPS,
+			cgroups.ThrottleReadIOPS:  throttle.ReadIOPS,
+			cgroups.ThrottleWriteIOPS: throttle.WriteIOPS,
+		} {
+			if err := blkio.Throttle(kind, device, rate); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//UpdateLimits applies the new limits to a running container
+func (c *container) UpdateLimits(limits ContainerLimits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+
+	//Args is read concurrently (spec, persist, list), and concurrent updates must not apply over each other
+	c.mgr.conM.Lock()
+	defer c.mgr.conM.Unlock()
+
+	old := c.Args.Limits
+	if err := c.applyLimits(&old, &limits); err != nil {
+		//the subsystems before the failure have the new limits, they are set back so the cgroups
+		//match the limits of the container
+		if err := c.applyLimits(&limits, &old); err != nil {
+			log.Errorf("failed to restore the limits of container-%d: %s", c.id, err)
+		}
+
+		return err
+	}
+
+	c.Args.Limits = limits
+	return nil
+}
+
+//joinCGroups moves the container process to the container cgroups
+func (c *container) joinCGroups(pid int) {
+	for _, group := range c.cgroups {
+		if err := group.Task(pid); err != nil {
+			log.Errorf("failed to add container-%d to cgroup '%s': %s", c.id, group.Subsystem(), err)
+		}
+	}
+}
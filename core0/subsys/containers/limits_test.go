@@ -0,0 +1,50 @@
+package containers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitsValidate(t *testing.T) {
+	valid := []ContainerLimits{
+		{},
+		{CPUShares: 512, CPUQuota: 50000, CPUPeriod: 100000},
+		{CPUSet: "0-2,5"},
+		{Memory: 256 * 1024 * 1024, Swap: -1},
+		{PidsMax: 100},
+		{BlkioWeight: 100, BlkioThrottle: []BlkioThrottle{{Device: "8:0", ReadBPS: 1024}}},
+	}
+
+	for _, limits := range valid {
+		assert.NoError(t, limits.Validate(), "%+v", limits)
+	}
+
+	invalid := []ContainerLimits{
+		{CPUShares: 1},
+		{CPUQuota: -1},
+		{CPUQuota: 10},
+		{CPUPeriod: 10},
+		{CPUSet: "all"},
+		{Memory: -1},
+		{Swap: -2},
+		{PidsMax: -1},
+		{BlkioWeight: 5000},
+		{BlkioThrottle: []BlkioThrottle{{Device: "/dev/does-not-exist"}}},
+	}
+
+	for _, limits := range invalid {
+		assert.Error(t, limits.Validate(), "%+v", limits)
+	}
+}
+
+func TestDeviceNumber(t *testing.T) {
+	device, err := deviceNumber("8:16")
+	if ok := assert.NoError(t, err); !ok {
+		t.Fatal()
+	}
+	assert.Equal(t, "8:16", device)
+
+	_, err = deviceNumber("/dev/null")
+	assert.Error(t, err, "/dev/null is not a block device")
+}
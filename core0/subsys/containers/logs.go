@@ -0,0 +1,479 @@
+package containers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/pm/stream"
+	"github.com/zero-os/0-core/base/settings"
+	"github.com/zero-os/0-core/base/utils"
+)
+
+const (
+	cmdContainerLogs       = "corex.logs"
+	cmdContainerLogsRemove = "corex.logs_remove"
+
+	//ContainerLogDir where the container logs are kept, logs of each container are under a directory named
+	//after the container id.
+	ContainerLogDir  = "/var/log/corex"
+	containerLogFile = "container.log"
+
+	defaultLogSize   = 10 //MB
+	defaultLogFiles  = 3
+	logFollowBuffer  = 1000
+	logMaxLineLength = 4 * 1024 * 1024
+)
+
+//LogEntry is a line of the container log
+type LogEntry struct {
+	Epoch   int64  `json:"epoch"`   //unix time in nano seconds
+	Command string `json:"command"` //id of the job that produced the entry, empty for the coreX output
+	Level   uint16 `json:"level"`
+	Message string `json:"message"`
+}
+
+func logDir(id uint16) string {
+	return path.Join(ContainerLogDir, fmt.Sprint(id))
+}
+
+//previousLogDir is where the logs of the previous container with the same id are moved when the id is reused
+func previousLogDir(id uint16) string {
+	return logDir(id) + ".previous"
+}
+
+//rotateLogDir moves the logs of an old container aside, so a new container with the same id starts a new log
+func rotateLogDir(id uint16) error {
+	if !utils.Exists(logDir(id)) {
+		return nil
+	}
+
+	if err := os.RemoveAll(previousLogDir(id)); err != nil {
+		return err
+	}
+
+	return os.Rename(logDir(id), previousLogDir(id))
+}
+
+/*
+containerLog is a size limited log of a container. Once the log file reaches the max size it's rotated
+to container.log.1 (container.log.1 to container.log.2 and so on) and the oldest file is dropped.
+*/
+type containerLog struct {
+	dir   string
+	max   int64
+	files int
+
+	file      *os.File
+	size      int64
+	followers map[chan *LogEntry]struct{}
+	m         sync.Mutex
+}
+
+func openContainerLog(id uint16) (*containerLog, error) {
+	dir := logDir(id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	size := settings.Settings.Containers.LogSize
+	if size <= 0 {
+		size = defaultLogSize
+	}
+
+	files := settings.Settings.Containers.LogFiles
+	if files <= 0 {
+		files = defaultLogFiles
+	}
+
+	l := &containerLog{
+		dir:       dir,
+		max:       size * 1024 * 1024,
+		files:     files,
+		followers: make(map[chan *LogEntry]struct{}),
+	}
+
+	return l, l.open()
+}
+
+func (l *containerLog) name(index int) string {
+	name := path.Join(l.dir, containerLogFile)
+	if index == 0 {
+		return name
+	}
+
+	return fmt.Sprintf("%s.%d", name, index)
+}
+
+func (l *containerLog) open() error {
+	file, err := os.OpenFile(l.name(0), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+//rotate must be called with the log lock held
+func (l *containerLog) rotate() error {
+	l.file.Close()
+	l.file = nil
+
+	os.Remove(l.name(l.files))
+	for i := l.files - 1; i >= 0; i-- {
+		os.Rename(l.name(i), l.name(i+1))
+	}
+
+	return l.open()
+}
+
+//Write appends an entry to the log, a nil log drops the entry
+func (l *containerLog) Write(entry *LogEntry) {
+	if l == nil {
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Errorf("failed to marshal container log entry: %s", err)
+		return
+	}
+	data = append(data, '\n')
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.file == nil {
+		return
+	}
+
+	if l.size > 0 && l.size+int64(len(data)) > l.max {
+		if err := l.rotate(); err != nil {
+			log.Errorf("failed to rotate container log '%s': %s", l.dir, err)
+			return
+		}
+	}
+
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	if err != nil {
+		log.Errorf("failed to write container log '%s': %s", l.dir, err)
+	}
+
+	for ch := range l.followers {
+		select {
+		case ch <- entry:
+		default:
+			//slow follower, it's better to miss entries than blocking the container
+		}
+	}
+}
+
+//Log writes a message that is not produced by a job (operator messages and coreX output)
+func (l *containerLog) Log(level uint16, format string, a ...interface{}) {
+	l.Write(&LogEntry{
+		Epoch:   time.Now().UnixNano(),
+		Level:   level,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+//follow gets a channel that receives the new log entries, the channel is closed when the log is closed
+func (l *containerLog) follow() chan *LogEntry {
+	if l == nil {
+		return nil
+	}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	ch := make(chan *LogEntry, logFollowBuffer)
+	l.followers[ch] = struct{}{}
+	return ch
+}
+
+func (l *containerLog) unfollow(ch chan *LogEntry) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if _, ok := l.followers[ch]; ok {
+		delete(l.followers, ch)
+		close(ch)
+	}
+}
+
+func (l *containerLog) Close() {
+	if l == nil {
+		return
+	}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+
+	for ch := range l.followers {
+		delete(l.followers, ch)
+		close(ch)
+	}
+}
+
+//output writes the coreX output to the container log line by line, the output is closed once coreX exits
+func (c *container) output(reader io.ReadCloser) {
+	defer reader.Close()
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, logMaxLineLength)
+	for scanner.Scan() {
+		c.log.Log(stream.LevelUnknown, "%s", scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Errorf("failed to read container-%d output: %s", c.id, err)
+		//coreX would get a broken pipe if the output is closed before it exits
+		io.Copy(ioutil.Discard, reader)
+	}
+}
+
+//record writes a message of a job that runs inside the container to the container log
+func (c *container) record(command string, msg *stream.Message) {
+	if len(msg.Message) == 0 || msg.Meta.Assert(stream.LevelStatsd) {
+		return
+	}
+
+	c.log.Write(&LogEntry{
+		Epoch:   msg.Epoch,
+		Command: command,
+		Level:   msg.Meta.Level(),
+		Message: msg.Message,
+	})
+}
+
+type logFilter struct {
+	since  int64
+	levels []uint16
+	job    string
+}
+
+func (f *logFilter) match(entry *LogEntry) bool {
+	if entry.Epoch < f.since {
+		return false
+	}
+
+	if f.job != "" && entry.Command != f.job {
+		return false
+	}
+
+	if len(f.levels) == 0 {
+		return true
+	}
+
+	for _, level := range f.levels {
+		if entry.Level == level {
+			return true
+		}
+	}
+
+	return false
+}
+
+//readLogs reads the entries of the logs under dir that match the filter from the oldest to the newest,
+//if tail is set only the last tail entries are returned
+func readLogs(dir string, filter *logFilter, tail int) ([]*LogEntry, error) {
+	l := &containerLog{dir: dir}
+
+	var names []string
+	for i := 0; ; i++ {
+		name := l.name(i)
+		if !utils.Exists(name) {
+			break
+		}
+		names = append([]string{name}, names...)
+	}
+
+	entries := make([]*LogEntry, 0)
+	for _, name := range names {
+		file, err := os.Open(name)
+		if os.IsNotExist(err) {
+			//rotated while reading
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, logMaxLineLength)
+		for scanner.Scan() {
+			var entry LogEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				continue
+			}
+
+			if !filter.match(&entry) {
+				continue
+			}
+
+			entries = append(entries, &entry)
+			if tail > 0 && len(entries) >= 2*tail {
+				entries = append(entries[:0], entries[len(entries)-tail:]...)
+			}
+		}
+
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if tail > 0 && len(entries) > tail {
+		entries = entries[len(entries)-tail:]
+	}
+
+	return entries, nil
+}
+
+type ContainerLogsArguments struct {
+	Container uint16   `json:"container"`
+	Since     int64    `json:"since"`    //only entries logged after this unix time (in seconds)
+	Tail      int      `json:"tail"`     //only the last tail entries (0 means all)
+	Levels    []uint16 `json:"levels"`   //only entries of these levels (empty means all)
+	Job       string   `json:"job"`      //only entries of this job
+	Follow    bool     `json:"follow"`   //keep streaming new entries till the container exits
+	Timeout   int      `json:"timeout"`  //stop following after timeout seconds (0 means no timeout)
+	Previous  bool     `json:"previous"` //logs of the previous container that had this id
+}
+
+/*
+logs gets the container log entries. In follow mode the entries are not returned but streamed
+as job messages (one json entry per message) till the container exits or the timeout is reached.
+*/
+func (m *containerManager) logs(ctx *pm.Context) (interface{}, error) {
+	var args ContainerLogsArguments
+	if err := json.Unmarshal(*ctx.Command.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	if args.Container == 0 {
+		return nil, pm.BadRequestError(fmt.Errorf("container is required"))
+	}
+
+	dir := logDir(args.Container)
+	if args.Previous {
+		if args.Follow {
+			return nil, pm.BadRequestError(fmt.Errorf("can't follow the logs of a previous container"))
+		}
+
+		dir = previousLogDir(args.Container)
+	}
+
+	if !utils.Exists(dir) {
+		return nil, pm.NotFoundError(fmt.Errorf("no logs for container %d", args.Container))
+	}
+
+	filter := &logFilter{
+		since:  args.Since * int64(time.Second),
+		levels: args.Levels,
+		job:    args.Job,
+	}
+
+	if !args.Follow {
+		return readLogs(dir, filter, args.Tail)
+	}
+
+	var cl *containerLog
+	m.conM.RLock()
+	if cont, ok := m.containers[args.Container]; ok {
+		cl = cont.log
+	}
+	m.conM.RUnlock()
+
+	//follow before reading the log so no entries are missed
+	ch := cl.follow()
+
+	emit := func(entry *LogEntry) {
+		data, _ := json.Marshal(entry)
+		ctx.Log(string(data), stream.LevelResultJSON)
+	}
+
+	entries, err := readLogs(logDir(args.Container), filter, args.Tail)
+	if err != nil {
+		return nil, err
+	}
+
+	var last int64
+	for _, entry := range entries {
+		emit(entry)
+		last = entry.Epoch
+	}
+
+	if ch == nil {
+		return nil, nil
+	}
+
+	var timeout <-chan time.Time
+	if args.Timeout > 0 {
+		timeout = time.After(time.Duration(args.Timeout) * time.Second)
+	}
+
+	for {
+		select {
+		case entry, ok := <-ch:
+			if !ok {
+				return nil, nil
+			}
+
+			if entry.Epoch <= last || !filter.match(entry) {
+				continue
+			}
+			emit(entry)
+		case <-timeout:
+			cl.unfollow(ch)
+			return nil, nil
+		}
+	}
+}
+
+//logsRemove removes the logs of a container that is not running
+func (m *containerManager) logsRemove(cmd *pm.Command) (interface{}, error) {
+	var args ContainerArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	m.conM.RLock()
+	_, running := m.containers[args.Container]
+	m.conM.RUnlock()
+
+	if running {
+		return nil, pm.PreconditionFailedError(fmt.Errorf("container %d is running", args.Container))
+	}
+
+	if err := os.RemoveAll(previousLogDir(args.Container)); err != nil {
+		return nil, err
+	}
+
+	return nil, os.RemoveAll(logDir(args.Container))
+}
@@ -0,0 +1,121 @@
+package containers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zero-os/0-core/base/pm/stream"
+	"github.com/zero-os/0-core/base/utils"
+)
+
+func testLog(t *testing.T, max int64, files int) *containerLog {
+	dir, err := ioutil.TempDir("", "corex-log")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	l := &containerLog{
+		dir:       dir,
+		max:       max,
+		files:     files,
+		followers: make(map[chan *LogEntry]struct{}),
+	}
+
+	if !assert.NoError(t, l.open()) {
+		t.FailNow()
+	}
+
+	return l
+}
+
+func TestContainerLogRotate(t *testing.T) {
+	l := testLog(t, 512, 2)
+	defer os.RemoveAll(l.dir)
+	defer l.Close()
+
+	for i := 0; i < 100; i++ {
+		l.Log(stream.LevelStdout, "line %d", i)
+	}
+
+	assert.True(t, utils.Exists(l.name(1)))
+	assert.True(t, utils.Exists(l.name(2)))
+	assert.False(t, utils.Exists(l.name(3)))
+
+	entries, err := readLogs(l.dir, &logFilter{}, 0)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	//oldest entries are dropped, the rest are in order
+	if assert.NotEmpty(t, entries) {
+		assert.True(t, len(entries) < 100)
+		assert.Equal(t, "line 99", entries[len(entries)-1].Message)
+		for i := 1; i < len(entries); i++ {
+			assert.True(t, entries[i-1].Epoch <= entries[i].Epoch)
+		}
+	}
+}
+
+func TestContainerLogFilter(t *testing.T) {
+	l := testLog(t, 1024*1024, 2)
+	defer os.RemoveAll(l.dir)
+	defer l.Close()
+
+	for i := 0; i < 10; i++ {
+		level := stream.LevelStdout
+		if i%2 == 1 {
+			level = stream.LevelStderr
+		}
+
+		l.Write(&LogEntry{
+			Epoch:   int64(i),
+			Command: fmt.Sprintf("job-%d", i%3),
+			Level:   level,
+			Message: fmt.Sprint(i),
+		})
+	}
+
+	entries, err := readLogs(l.dir, &logFilter{}, 3)
+	if assert.NoError(t, err) && assert.Len(t, entries, 3) {
+		assert.Equal(t, "7", entries[0].Message)
+		assert.Equal(t, "9", entries[2].Message)
+	}
+
+	entries, err = readLogs(l.dir, &logFilter{since: 5, levels: []uint16{stream.LevelStderr}}, 0)
+	if assert.NoError(t, err) && assert.Len(t, entries, 3) {
+		assert.Equal(t, "5", entries[0].Message)
+		assert.Equal(t, "9", entries[2].Message)
+	}
+
+	entries, err = readLogs(l.dir, &logFilter{job: "job-0"}, 0)
+	if assert.NoError(t, err) && assert.Len(t, entries, 4) {
+		assert.Equal(t, "9", entries[3].Message)
+	}
+}
+
+func TestContainerLogFollow(t *testing.T) {
+	l := testLog(t, 1024*1024, 2)
+	defer os.RemoveAll(l.dir)
+
+	ch := l.follow()
+	l.Log(stream.LevelOperator, "container started")
+	l.Close()
+
+	entry, ok := <-ch
+	if assert.True(t, ok) {
+		assert.Equal(t, "container started", entry.Message)
+	}
+
+	_, ok = <-ch
+	assert.False(t, ok)
+
+	//a closed log drops entries
+	l.Log(stream.LevelOperator, "dropped")
+	entries, err := readLogs(l.dir, &logFilter{}, 0)
+	if assert.NoError(t, err) {
+		assert.Len(t, entries, 1)
+	}
+}
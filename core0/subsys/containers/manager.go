@@ -6,12 +6,16 @@ import (
 	"github.com/op/go-logging"
 	"github.com/pborman/uuid"
 	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/pm/stream"
 	"github.com/zero-os/0-core/base/settings"
 	"github.com/zero-os/0-core/base/utils"
+	"github.com/zero-os/0-core/core0/helper"
 	"github.com/zero-os/0-core/core0/screen"
 	"github.com/zero-os/0-core/core0/subsys/cgroups"
+	"github.com/zero-os/0-core/core0/subsys/ipam"
 	"github.com/zero-os/0-core/core0/transport"
 	"math"
+	"net"
 	"net/url"
 	"os"
 	"path"
@@ -32,6 +36,21 @@ const (
 	cmdContainerNicRemove    = "corex.nic-remove"
 	cmdContainerBackup       = "corex.backup"
 	cmdContainerRestore      = "corex.restore"
+	cmdContainerUpdate       = "corex.update"
+	cmdContainerPause        = "corex.pause"
+	cmdContainerResume       = "corex.resume"
+	cmdContainerPortAdd      = "corex.portforward_add"
+	cmdContainerPortRemove   = "corex.portforward_remove"
+	cmdContainerStats        = "corex.stats"
+	cmdContainerMonitor      = "corex.monitor"
+
+	cmdContainerRestoreFiles      = "corex.restore_files"
+	cmdContainerBackupList        = "corex.backup_list"
+	cmdContainerBackupForget      = "corex.backup_forget"
+	cmdContainerBackupSchedule    = "corex.backup_schedule"
+	cmdContainerBackupCredsSet    = "corex.backup_credentials_set"
+	cmdContainerBackupCredsRemove = "corex.backup_credentials_remove"
+	cmdContainerBackupCredsList   = "corex.backup_credentials_list"
 
 	coreXResponseQueue = "corex:results"
 	coreXBinaryName    = "coreX"
@@ -52,6 +71,13 @@ var (
 	BridgeIP          = []byte{172, 18, 0, 1}
 	DefaultBridgeIP   = fmt.Sprintf("%d.%d.%d.%d", BridgeIP[0], BridgeIP[1], BridgeIP[2], BridgeIP[3])
 	DefaultBridgeCIDR = fmt.Sprintf("%s/16", DefaultBridgeIP)
+
+	//the default bridge ipv6 prefix, a container gets the ip of the prefix that ends with its ipv4
+	DefaultBridgeIP6   = "fd00:172:18::1"
+	DefaultBridgeCIDR6 = fmt.Sprintf("%s/64", DefaultBridgeIP6)
+
+	//defaultBridgeIPv6 is set if the default bridge has the ipv6 prefix (the node supports ipv6)
+	defaultBridgeIPv6 bool
 )
 
 var (
@@ -59,10 +85,47 @@ var (
 )
 
 type NetworkConfig struct {
-	Dhcp    bool     `json:"dhcp"`
-	CIDR    string   `json:"cidr"`
-	Gateway string   `json:"gateway"`
-	DNS     []string `json:"dns"`
+	Dhcp     bool     `json:"dhcp"`
+	Slaac    bool     `json:"slaac"` //ipv6 auto configuration from the router advertisements
+	CIDR     string   `json:"cidr"`
+	CIDRs    []string `json:"cidrs"` //extra ipv4 or ipv6 addresses
+	Gateway  string   `json:"gateway"`
+	Gateway6 string   `json:"gateway6"`
+	DNS      []string `json:"dns"`
+}
+
+func (n *NetworkConfig) Validate() error {
+	for _, cidr := range append([]string{n.CIDR}, n.CIDRs...) {
+		if len(cidr) == 0 {
+			continue
+		}
+
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return err
+		}
+	}
+
+	if len(n.Gateway) != 0 && net.ParseIP(n.Gateway).To4() == nil {
+		return fmt.Errorf("invalid ipv4 gateway '%s'", n.Gateway)
+	}
+
+	if len(n.Gateway6) != 0 {
+		if ip := net.ParseIP(n.Gateway6); ip == nil || ip.To4() != nil {
+			return fmt.Errorf("invalid ipv6 gateway '%s'", n.Gateway6)
+		}
+	}
+
+	return nil
+}
+
+//addresses the static addresses of the nic
+func (n *NetworkConfig) addresses() []string {
+	var addresses []string
+	if len(n.CIDR) != 0 {
+		addresses = append(addresses, n.CIDR)
+	}
+
+	return append(addresses, n.CIDRs...)
 }
 
 type NicState string
@@ -81,16 +144,31 @@ type Nic struct {
 type ContainerCreateArguments struct {
 	Root        string            `json:"root"`         //Root plist
 	Mount       map[string]string `json:"mount"`        //data disk mounts.
+	Mounts      []Mount           `json:"mounts"`       //mounts with options (bind, flist, volume, tmpfs)
 	HostNetwork bool              `json:"host_network"` //share host networking stack
 	Identity    string            `json:"identity"`     //zerotier identity
 	Nics        []*Nic            `json:"nics"`         //network setup (only respected if HostNetwork is false)
-	Port        map[int]int       `json:"port"`         //port forwards (only if default networking is enabled)
+	Port        map[string]int    `json:"port"`         //port forwards (only if default networking is enabled)
 	Privileged  bool              `json:"privileged"`   //Apply cgroups and capabilities limitations on the container
+	UserNS      bool              `json:"userns"`       //run the container in a user namespace with ids mapped to a dedicated host range
+	CapAdd      []string          `json:"cap_add"`      //capabilities added to the defaults of an unprivileged container
+	CapDrop     []string          `json:"cap_drop"`     //capabilities removed from the defaults of an unprivileged container
+	Seccomp     json.RawMessage   `json:"seccomp"`      //"default", "unconfined" or a seccomp profile in the docker format
 	Hostname    string            `json:"hostname"`     //hostname
+	Hosts       map[string]string `json:"hosts"`        //extra /etc/hosts entries (name: ip)
+	DNS         DNSConfig         `json:"dns"`          //resolv.conf nameservers, search domains and options
 	Storage     string            `json:"storage"`      //ardb storage needed for g8ufs mounts.
 	Name        string            `json:"name"`         //for searching containers
 	Tags        pm.Tags           `json:"tags"`         //for searching containers
+	Labels      map[string]string `json:"labels"`       //key/value labels for searching containers with selectors
 	Env         map[string]string `json:"env"`          //environment variables.
+	Limits      ContainerLimits   `json:"limits"`       //resource limits
+	Persistent  bool              `json:"persistent"`   //recreate the container after a node reboot
+	Restart     RestartPolicy     `json:"restart"`      //restart policy if coreX exits (no, on-failure, always)
+	Backup      *BackupSchedule   `json:"backup"`       //recurring backup of the container
+	Hooks       Hooks             `json:"hooks"`        //commands run on the container life cycle (prestart, poststart, prestop and init)
+	Snapshot    string            `json:"snapshot"`     //snapshot the writable layer of the container is created from (btrfs only)
+	Instance    string            `json:"instance"`     //unique id of the container set by the node, container ids are reused
 }
 
 type ContainerDispatchArguments struct {
@@ -103,6 +181,12 @@ func (c *ContainerCreateArguments) Validate() error {
 		return fmt.Errorf("root plist is required")
 	}
 
+	if helper.IsImage(c.Root) {
+		if err := helper.ValidateImage(c.Root); err != nil {
+			return err
+		}
+	}
+
 	for host, guest := range c.Mount {
 		u, err := url.Parse(host)
 		if err != nil {
@@ -124,13 +208,82 @@ func (c *ContainerCreateArguments) Validate() error {
 		}
 	}
 
+	if c.UserNS && (c.Privileged || c.HostNetwork) {
+		return fmt.Errorf("user namespace is not supported for privileged or host network containers")
+	}
+
+	if len(c.Snapshot) != 0 {
+		if err := validateSnapshotName(c.Snapshot); err != nil {
+			return err
+		}
+
+		if _, err := os.Stat(snapshotPath(c.Snapshot)); os.IsNotExist(err) {
+			return fmt.Errorf("snapshot '%s' does not exist", c.Snapshot)
+		}
+
+		if c.UserNS {
+			return fmt.Errorf("user namespace is not supported for containers created from a snapshot")
+		}
+	}
+
+	if err := c.validateSecurity(); err != nil {
+		return err
+	}
+
+	if len(c.Hostname) != 0 && !validHostname(c.Hostname) {
+		return fmt.Errorf("invalid hostname '%s'", c.Hostname)
+	}
+
+	if err := validateHosts(c.Hosts); err != nil {
+		return err
+	}
+
+	if err := c.DNS.Validate(); err != nil {
+		return err
+	}
+
+	for i := range c.Mounts {
+		if err := c.Mounts[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Limits.Validate(); err != nil {
+		return err
+	}
+
+	if err := validateLabels(c.Labels); err != nil {
+		return err
+	}
+
+	if err := c.Hooks.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Restart.Validate(); err != nil {
+		return err
+	}
+
+	if c.Backup != nil {
+		if err := c.Backup.Validate(); err != nil {
+			return err
+		}
+	}
+
+	var forwards []*portForward
 	for host, guest := range c.Port {
-		if host < 0 || host > 65535 {
-			return fmt.Errorf("invalid host port '%d'", host)
+		forward, err := parsePortForward(host, guest)
+		if err != nil {
+			return err
 		}
-		if guest < 0 || guest > 65535 {
-			return fmt.Errorf("invalid guest port '%d'", guest)
+
+		for _, other := range forwards {
+			if forward.overlaps(other) {
+				return fmt.Errorf("port forwards '%s' and '%s' overlap", forward.host, other.host)
+			}
 		}
+
+		forwards = append(forwards, forward)
 	}
 
 	//validating networking
@@ -139,6 +292,9 @@ func (c *ContainerCreateArguments) Validate() error {
 		if nic.State == NicStateDestroyed {
 			continue
 		}
+		if err := nic.Config.Validate(); err != nil {
+			return err
+		}
 		switch nic.Type {
 		case "default":
 			brcounter[DefaultBridgeName]++
@@ -191,9 +347,13 @@ func (c *ContainerCreateArguments) Validate() error {
 
 type containerManager struct {
 	sequence uint16
+	reserved map[uint16]bool          //ids of persistent containers that are not started yet
+	restarts map[uint16]chan struct{} //pending restarts, closed to cancel the restart
+	specs    map[uint16]ContainerCreateArguments
 	seqM     sync.Mutex
 
 	containers map[uint16]*container
+	exited     []*container //recently exited containers, oldest first
 	conM       sync.RWMutex
 
 	cell   *screen.RowCell
@@ -214,13 +374,21 @@ TODO:
 type Container interface {
 	ID() uint16
 	Arguments() ContainerCreateArguments
+	RootPath() string
+	Frozen() bool
+	Freeze() (func(), error)
+	IDMappings() ([]pm.IDMap, error)
 }
 
 type ContainerManager interface {
 	Dispatch(id uint16, cmd *pm.Command) (*pm.JobResult, error)
+	Attach(id uint16, cmd *pm.Command) (*Attachment, error)
 	GetWithTags(tags ...string) []Container
+	GetWithSelector(selector Selector, tags ...string) []Container
 	GetOneWithTags(tags ...string) Container
 	Of(id uint16) Container
+	OfPID(pid int) Container
+	Replay()
 }
 
 func ContainerSubsystem(sink *transport.Sink, cell *screen.RowCell) (ContainerManager, error) {
@@ -230,6 +398,9 @@ func ContainerSubsystem(sink *transport.Sink, cell *screen.RowCell) (ContainerMa
 
 	containerMgr := &containerManager{
 		containers: make(map[uint16]*container),
+		reserved:   make(map[uint16]bool),
+		specs:      make(map[uint16]ContainerCreateArguments),
+		restarts:   make(map[uint16]chan struct{}),
 		sink:       sink,
 		cell:       cell,
 	}
@@ -243,6 +414,8 @@ func ContainerSubsystem(sink *transport.Sink, cell *screen.RowCell) (ContainerMa
 		return nil, err
 	}
 
+	containerMgr.loadSpecs()
+
 	pm.RegisterBuiltIn(cmdContainerCreate, containerMgr.create)
 	pm.RegisterBuiltIn(cmdContainerCreateSync, containerMgr.createSync)
 	pm.RegisterBuiltIn(cmdContainerList, containerMgr.list)
@@ -253,11 +426,40 @@ func ContainerSubsystem(sink *transport.Sink, cell *screen.RowCell) (ContainerMa
 	pm.RegisterBuiltIn(cmdContainerNicRemove, containerMgr.nicRemove)
 	pm.RegisterBuiltIn(cmdContainerBackup, containerMgr.backup)
 	pm.RegisterBuiltIn(cmdContainerRestore, containerMgr.restore)
+	pm.RegisterBuiltIn(cmdContainerRestoreFiles, containerMgr.restoreFiles)
+	pm.RegisterBuiltIn(cmdContainerBackupList, containerMgr.backupList)
+	pm.RegisterBuiltIn(cmdContainerBackupForget, containerMgr.backupForget)
+	pm.RegisterBuiltIn(cmdContainerBackupSchedule, containerMgr.backupSchedule)
+	pm.RegisterBuiltIn(cmdContainerBackupCredsSet, containerMgr.backupCredentialsSet)
+	pm.RegisterBuiltIn(cmdContainerBackupCredsRemove, containerMgr.backupCredentialsRemove)
+	pm.RegisterBuiltIn(cmdContainerBackupCredsList, containerMgr.backupCredentialsList)
+	pm.RegisterBuiltIn(cmdContainerUpdate, containerMgr.update)
+	pm.RegisterBuiltIn(cmdContainerPause, containerMgr.pause)
+	pm.RegisterBuiltIn(cmdContainerResume, containerMgr.resume)
+	pm.RegisterBuiltIn(cmdContainerSnapshot, containerMgr.snapshot)
+	pm.RegisterBuiltIn(cmdContainerSnapshotList, containerMgr.snapshotList)
+	pm.RegisterBuiltIn(cmdContainerSnapshotRemove, containerMgr.snapshotRemove)
+	pm.RegisterBuiltIn(cmdContainerClone, containerMgr.clone)
+	pm.RegisterBuiltIn(cmdContainerPortAdd, containerMgr.portforwardAdd)
+	pm.RegisterBuiltIn(cmdContainerPortRemove, containerMgr.portforwardRemove)
+	pm.RegisterBuiltIn(cmdContainerExec, containerMgr.exec)
+	pm.RegisterBuiltIn(cmdContainerExecResize, containerMgr.resize)
+	pm.RegisterBuiltInWithCtx(cmdContainerLogs, containerMgr.logs)
+	pm.RegisterBuiltIn(cmdContainerLogsRemove, containerMgr.logsRemove)
+	pm.RegisterBuiltIn(cmdContainerStats, containerMgr.stats)
 
 	//container specific info
 	pm.RegisterBuiltIn(cmdContainerZerotierInfo, containerMgr.ztInfo)
 	pm.RegisterBuiltIn(cmdContainerZerotierList, containerMgr.ztList)
 
+	//internal command that feeds the containers stats to the aggregator
+	pm.RegisterBuiltIn(cmdContainerMonitor, containerMgr.monitor)
+	pm.Run(&pm.Command{
+		ID:              cmdContainerMonitor,
+		Command:         cmdContainerMonitor,
+		RecurringPeriod: 30,
+	})
+
 	return containerMgr, nil
 }
 
@@ -293,6 +495,16 @@ func (m *containerManager) setUpCGroups() error {
 }
 
 func (m *containerManager) setUpDefaultBridge() error {
+	settings := pm.M{
+		"cidr": DefaultBridgeCIDR,
+	}
+
+	//the default bridge is dual stack if the node supports ipv6
+	if _, err := os.Stat("/proc/sys/net/ipv6"); err == nil {
+		settings["cidr6"] = DefaultBridgeCIDR6
+		defaultBridgeIPv6 = true
+	}
+
 	cmd := &pm.Command{
 		ID:      uuid.New(),
 		Command: "bridge.create",
@@ -300,11 +512,9 @@ func (m *containerManager) setUpDefaultBridge() error {
 			pm.M{
 				"name": DefaultBridgeName,
 				"network": pm.M{
-					"nat":  true,
-					"mode": "static",
-					"settings": pm.M{
-						"cidr": DefaultBridgeCIDR,
-					},
+					"nat":      true,
+					"mode":     "static",
+					"settings": settings,
 				},
 			},
 		),
@@ -319,7 +529,7 @@ func (m *containerManager) setUpDefaultBridge() error {
 		return fmt.Errorf("failed to create default container bridge: %s", result.Data)
 	}
 
-	return nil
+	return setUpLocalForwards()
 }
 
 func (m *containerManager) getNextSequence() uint16 {
@@ -332,7 +542,7 @@ func (m *containerManager) getNextSequence() uint16 {
 	for {
 		m.sequence += 1
 		if m.sequence != 0 && m.sequence < math.MaxUint16 {
-			if _, ok := m.containers[m.sequence]; !ok {
+			if _, ok := m.containers[m.sequence]; !ok && !m.reserved[m.sequence] {
 				break
 			}
 		}
@@ -396,6 +606,7 @@ func (m *containerManager) nicAdd(cmd *pm.Command) (interface{}, error) {
 		return nil, err
 	}
 
+	m.persist(container)
 	return nil, nil
 }
 
@@ -432,6 +643,7 @@ func (m *containerManager) nicRemove(cmd *pm.Command) (interface{}, error) {
 		}
 
 		nic.State = NicStateDestroyed
+		m.persist(container)
 		return nil, nil
 	}
 
@@ -440,7 +652,21 @@ func (m *containerManager) nicRemove(cmd *pm.Command) (interface{}, error) {
 		ovs = m.GetOneWithTags("ovs")
 	}
 
-	return nil, container.unBridge(args.Index, nic, ovs)
+	if err := container.unBridge(args.Index, nic, ovs); err != nil {
+		return nil, err
+	}
+
+	switch nic.Type {
+	case "default":
+		container.unregisterDNS()
+		ipam.Release(DefaultBridgeName, container.name())
+		container.defaultIP = nil
+	case "bridge":
+		ipam.Release(nic.ID, container.name())
+	}
+
+	m.persist(container)
+	return nil, nil
 }
 
 func (m *containerManager) createContainer(args ContainerCreateArguments) (*container, error) {
@@ -450,6 +676,13 @@ func (m *containerManager) createContainer(args ContainerCreateArguments) (*cont
 
 	m.conM.RLock()
 	count := len(m.containers)
+	for host, guest := range args.Port {
+		forward, _ := parsePortForward(host, guest)
+		if err := m.checkPortForward(forward); err != nil {
+			m.conM.RUnlock()
+			return nil, pm.PreconditionFailedError(err)
+		}
+	}
 	m.conM.RUnlock()
 	limit := settings.Settings.Containers.MaxCount
 	if limit == 0 {
@@ -461,16 +694,49 @@ func (m *containerManager) createContainer(args ContainerCreateArguments) (*cont
 	}
 
 	id := m.getNextSequence()
-	c := newContainer(m, id, args)
-	m.setContainer(id, c)
+	m.forgetExit(id)
+	//the id might be reused, logs of the old container are kept aside
+	if err := rotateLogDir(id); err != nil {
+		log.Errorf("failed to move old logs of container %d: %s", id, err)
+	}
 
-	if _, err := c.Start(); err != nil {
+	//the instance id is never inherited from a restored or snapshot spec
+	args.Instance = uuid.New()
+	c := newContainer(m, id, args)
+	if err := m.startContainer(c); err != nil {
 		return nil, err
 	}
 
 	return c, nil
 }
 
+func (m *containerManager) startContainer(c *container) error {
+	var err error
+	if c.log, err = openContainerLog(c.id); err != nil {
+		log.Errorf("failed to open container %d log: %s", c.id, err)
+	}
+	c.log.Log(stream.LevelOperator, "container started")
+
+	if c.Args.Instance == "" {
+		//spec persisted before the instance id was introduced
+		c.Args.Instance = uuid.New()
+	}
+
+	m.setContainer(c.id, c)
+	m.unreserve(c.id)
+
+	if _, err := c.Start(); err != nil {
+		return err
+	}
+
+	if c.Args.Backup != nil {
+		c.scheduleBackup(c.Args.Backup)
+	}
+
+	m.persist(c)
+	return nil
+}
+
 func (m *containerManager) createSync(cmd *pm.Command) (interface{}, error) {
 	var args ContainerCreateArguments
 	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
@@ -505,6 +771,8 @@ func (m *containerManager) create(cmd *pm.Command) (interface{}, error) {
 type ContainerInfo struct {
 	pm.ProcessStats
 	Container Container `json:"container"`
+	Frozen    bool      `json:"frozen"`         //container is paused
+	Exit      *ExitInfo `json:"exit,omitempty"` //how the container (or its previous run if it was restarted) ended
 }
 
 func (m *containerManager) list(cmd *pm.Command) (interface{}, error) {
@@ -525,9 +793,29 @@ func (m *containerManager) list(cmd *pm.Command) (interface{}, error) {
 				state = *(stater.Stats())
 			}
 		}
-		containers[id] = ContainerInfo{
+		info := ContainerInfo{
 			ProcessStats: state,
 			Container:    c,
+			Frozen:       c.Frozen(),
+		}
+
+		if exited := m.lastExit(id); exited != nil {
+			info.Exit = exited.exit
+		}
+
+		containers[id] = info
+	}
+
+	//recently exited containers, the last exit of an id wins
+	for i := len(m.exited) - 1; i >= 0; i-- {
+		c := m.exited[i]
+		if _, ok := containers[c.id]; ok {
+			continue
+		}
+
+		containers[c.id] = ContainerInfo{
+			Container: c,
+			Exit:      c.exit,
 		}
 	}
 
@@ -565,6 +853,8 @@ func (m *containerManager) dispatch(cmd *pm.Command) (interface{}, error) {
 		args.Command.ID = uuid.New()
 	}
 
+	//results of the dispatched command go to the same result namespace as the caller
+	m.sink.Inherit(cmd.ID, args.Command.ID)
 	if err := m.pushToContainer(cont, &args.Command); err != nil {
 		return nil, err
 	}
@@ -595,8 +885,13 @@ type ContainerArguments struct {
 	Container uint16 `json:"container"`
 }
 
+type ContainerTerminateArguments struct {
+	Container uint16 `json:"container"`
+	Timeout   int    `json:"timeout"` //seconds to wait for the container to exit before it's killed (default 30)
+}
+
 func (m *containerManager) terminate(cmd *pm.Command) (interface{}, error) {
-	var args ContainerArguments
+	var args ContainerTerminateArguments
 	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
 		return nil, err
 	}
@@ -605,14 +900,70 @@ func (m *containerManager) terminate(cmd *pm.Command) (interface{}, error) {
 	m.conM.RUnlock()
 
 	if !ok {
+		//a container that waits to be restarted is not running, terminate cancels the restart
+		if m.cancelRestart(args.Container) {
+			return nil, nil
+		}
+
 		return nil, fmt.Errorf("no container with id '%d'", args.Container)
 	}
 
-	return nil, container.Terminate()
+	if args.Timeout < 0 {
+		return nil, pm.BadRequestError(fmt.Errorf("invalid timeout '%d'", args.Timeout))
+	}
+
+	return container.Terminate(args.Timeout)
+}
+
+type ContainerUpdateArguments struct {
+	ContainerMetadata
+	Container uint16           `json:"container"`
+	Limits    *ContainerLimits `json:"limits"` //replaces the resource limits if set
+}
+
+//update replaces the resource limits and the metadata of a running container
+func (m *containerManager) update(cmd *pm.Command) (interface{}, error) {
+	var args ContainerUpdateArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	m.conM.RLock()
+	container, ok := m.containers[args.Container]
+	m.conM.RUnlock()
+	if !ok {
+		return nil, pm.NotFoundError(fmt.Errorf("container does not exist"))
+	}
+
+	if err := args.ContainerMetadata.Validate(); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	if args.Limits != nil {
+		if err := args.Limits.Validate(); err != nil {
+			return nil, pm.BadRequestError(err)
+		}
+
+		if err := container.UpdateLimits(*args.Limits); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := container.UpdateMetadata(&args.ContainerMetadata); err != nil {
+		if args.Limits != nil {
+			//the new limits are applied already, they must survive a restart
+			m.persist(container)
+		}
+		return nil, err
+	}
+
+	m.persist(container)
+	return nil, nil
 }
 
 type ContainerFindArguments struct {
-	Tags []string `json:"tags"`
+	Tags     []string `json:"tags"`
+	Selector string   `json:"selector"` //label selector (ex: env=prod,role!=db)
 }
 
 func (m *containerManager) find(cmd *pm.Command) (interface{}, error) {
@@ -621,7 +972,12 @@ func (m *containerManager) find(cmd *pm.Command) (interface{}, error) {
 		return nil, err
 	}
 
-	containers := m.GetWithTags(args.Tags...)
+	selector, err := ParseSelector(args.Selector)
+	if err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	containers := m.GetWithSelector(selector, args.Tags...)
 	result := make(map[uint16]ContainerInfo)
 	for _, c := range containers {
 		name := fmt.Sprintf("core-%d", c.ID())
@@ -640,6 +996,7 @@ func (m *containerManager) find(cmd *pm.Command) (interface{}, error) {
 		result[c.ID()] = ContainerInfo{
 			ProcessStats: state,
 			Container:    c,
+			Frozen:       c.Frozen(),
 		}
 	}
 
@@ -647,12 +1004,21 @@ func (m *containerManager) find(cmd *pm.Command) (interface{}, error) {
 }
 
 func (m *containerManager) GetWithTags(tags ...string) []Container {
+	return m.GetWithSelector(nil, tags...)
+}
+
+//GetWithSelector gets the containers with all the tags and the labels that match the selector
+func (m *containerManager) GetWithSelector(selector Selector, tags ...string) []Container {
 	m.conM.RLock()
 	defer m.conM.RUnlock()
 
 	var result []Container
 loop:
 	for _, c := range m.containers {
+		if !selector.Matches(c.Args.Labels) {
+			continue
+		}
+
 		for _, tag := range tags {
 			if !utils.InString(c.Args.Tags, tag) {
 				continue loop
@@ -676,6 +1042,33 @@ func (m *containerManager) GetOneWithTags(tags ...string) Container {
 func (m *containerManager) Of(id uint16) Container {
 	m.conM.RLock()
 	defer m.conM.RUnlock()
-	cont, _ := m.containers[id]
+	cont, ok := m.containers[id]
+	if !ok {
+		return nil
+	}
 	return cont
 }
+
+//OfPID gets the container that runs the process with the given (host) pid, or nil if the
+//process doesn't run inside a container.
+func (m *containerManager) OfPID(pid int) Container {
+	ns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/pid", pid))
+	if err != nil {
+		return nil
+	}
+
+	m.conM.RLock()
+	defer m.conM.RUnlock()
+
+	for _, cont := range m.containers {
+		if cont.PID <= 0 {
+			continue
+		}
+
+		if cns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/pid", cont.PID)); err == nil && cns == ns {
+			return cont
+		}
+	}
+
+	return nil
+}
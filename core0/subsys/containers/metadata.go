@@ -0,0 +1,128 @@
+package containers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/pm/stream"
+	"github.com/zero-os/0-core/core0/subsys/ipam"
+)
+
+const (
+	//cmdCoreXSetEnv changes the environment of coreX (see coreX builtin)
+	cmdCoreXSetEnv = "corex.setenv"
+)
+
+//ContainerMetadata the container properties that can change while the container is running, nil fields are not changed
+type ContainerMetadata struct {
+	Name   *string           `json:"name"`   //new name of the container
+	Tags   *pm.Tags          `json:"tags"`   //replaces the tags of the container
+	Env    map[string]string `json:"env"`    //replaces the env of the container for the next commands
+	Labels map[string]string `json:"labels"` //replaces the labels of the container
+}
+
+type setEnvArguments struct {
+	Set   map[string]string `json:"set"`
+	Unset []string          `json:"unset"`
+}
+
+func (m *ContainerMetadata) Validate() error {
+	for key := range m.Env {
+		if len(key) == 0 || strings.ContainsAny(key, "=\x00") {
+			return fmt.Errorf("invalid environment variable '%s'", key)
+		}
+	}
+
+	return validateLabels(m.Labels)
+}
+
+//envChanges gets the variables to set and unset to move coreX from the current env of the container to env
+func (c *container) envChanges(env map[string]string) setEnvArguments {
+	current := c.environment(c.Args.Env)
+	target := c.environment(env)
+
+	changes := setEnvArguments{
+		Set: make(map[string]string),
+	}
+
+	for key, value := range target {
+		if old, ok := current[key]; !ok || old != value {
+			changes.Set[key] = value
+		}
+	}
+
+	for key := range current {
+		if _, ok := target[key]; !ok {
+			changes.Unset = append(changes.Unset, key)
+		}
+	}
+
+	sort.Strings(changes.Unset)
+	return changes
+}
+
+//setEnv changes the env of coreX, the commands that are already running keep their env
+func (c *container) setEnv(env map[string]string) error {
+	changes := c.envChanges(env)
+	if len(changes.Set) == 0 && len(changes.Unset) == 0 {
+		return nil
+	}
+
+	result, err := c.mgr.Dispatch(c.id, &pm.Command{
+		Command:   cmdCoreXSetEnv,
+		Arguments: pm.MustArguments(changes),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if result.State != pm.StateSuccess {
+		return fmt.Errorf("failed to update container environment: %s", result.Data)
+	}
+
+	return nil
+}
+
+//UpdateMetadata changes the name, tags, env and labels of a running container
+func (c *container) UpdateMetadata(meta *ContainerMetadata) error {
+	if err := meta.Validate(); err != nil {
+		return pm.BadRequestError(err)
+	}
+
+	if meta.Env != nil {
+		if err := c.setEnv(meta.Env); err != nil {
+			return err
+		}
+	}
+
+	c.mgr.conM.Lock()
+	if meta.Name != nil && *meta.Name != c.Args.Name {
+		c.Args.Name = *meta.Name
+		//sticky ips follow the container name, they are renamed under the lock so concurrent renames
+		//are applied in the same order
+		ipam.Rename(c.name(), *meta.Name)
+	}
+	if meta.Tags != nil {
+		c.Args.Tags = *meta.Tags
+	}
+	if meta.Env != nil {
+		c.Args.Env = meta.Env
+	}
+	if meta.Labels != nil {
+		c.Args.Labels = meta.Labels
+	}
+	c.mgr.conM.Unlock()
+
+	if meta.Name != nil || meta.Tags != nil {
+		//the container is resolved with its name and tags
+		if err := c.registerDNS(); err != nil {
+			log.Errorf("failed to update container %d dns record: %s", c.id, err)
+		}
+	}
+
+	c.log.Log(stream.LevelOperator, "container metadata updated")
+	return nil
+}
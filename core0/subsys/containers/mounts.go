@@ -0,0 +1,161 @@
+package containers
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/zero-os/0-core/base/utils"
+	"github.com/zero-os/0-core/core0/subsys/volumes"
+)
+
+const (
+	MountTypeBind   = "bind"
+	MountTypeFList  = "flist"
+	MountTypeVolume = "volume"
+	MountTypeTmpfs  = "tmpfs"
+)
+
+var (
+	propagations = map[string]uintptr{
+		"private":  syscall.MS_PRIVATE,
+		"rprivate": syscall.MS_PRIVATE | syscall.MS_REC,
+		"shared":   syscall.MS_SHARED,
+		"rshared":  syscall.MS_SHARED | syscall.MS_REC,
+		"slave":    syscall.MS_SLAVE,
+		"rslave":   syscall.MS_SLAVE | syscall.MS_REC,
+	}
+)
+
+//Mount a mount point inside the container
+type Mount struct {
+	Type        string `json:"type"`        //bind (default), flist, volume or tmpfs
+	Source      string `json:"source"`      //host path, flist url or volume name (not used for tmpfs)
+	Target      string `json:"target"`      //absolute path inside the container
+	ReadOnly    bool   `json:"read_only"`   //mount read-only
+	Propagation string `json:"propagation"` //private, rprivate, shared, rshared, slave or rslave
+	Size        int64  `json:"size"`        //size of a tmpfs mount in bytes, 0 means the tmpfs default
+}
+
+func (m *Mount) Validate() error {
+	if !path.IsAbs(m.Target) {
+		return fmt.Errorf("mount target '%s' must be absolute", m.Target)
+	}
+
+	for _, part := range strings.Split(m.Target, "/") {
+		if part == ".." {
+			return fmt.Errorf("mount target '%s' must not contain '..'", m.Target)
+		}
+	}
+
+	if len(m.Propagation) != 0 {
+		if _, ok := propagations[m.Propagation]; !ok {
+			return fmt.Errorf("invalid mount propagation '%s'", m.Propagation)
+		}
+	}
+
+	if m.Size < 0 || m.Size != 0 && m.Type != MountTypeTmpfs {
+		return fmt.Errorf("invalid mount size '%d', size is only supported by tmpfs", m.Size)
+	}
+
+	switch m.Type {
+	case "", MountTypeBind:
+		if !path.IsAbs(m.Source) {
+			return fmt.Errorf("host path '%s' must be absolute", m.Source)
+		}
+		if _, err := os.Stat(m.Source); os.IsNotExist(err) {
+			return fmt.Errorf("host path '%s' does not exist", m.Source)
+		}
+	case MountTypeFList:
+		if _, err := url.Parse(m.Source); err != nil {
+			return fmt.Errorf("invalid flist '%s': %s", m.Source, err)
+		}
+	case MountTypeVolume:
+		if len(m.Source) == 0 {
+			return fmt.Errorf("volume name is required")
+		}
+	case MountTypeTmpfs:
+		if len(m.Source) != 0 {
+			return fmt.Errorf("tmpfs mount does not take a source")
+		}
+	default:
+		return fmt.Errorf("unsupported mount type '%s'", m.Type)
+	}
+
+	return nil
+}
+
+//mount mounts m under the container root, volumes are acquired till the container is cleaned up
+func (c *container) mount(m *Mount) error {
+	//the target is resolved inside the root, the image can have symlinks to host paths
+	target, err := utils.InRoot(c.root(), m.Target)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("mkdirAll(%s)", err)
+	}
+
+	switch m.Type {
+	case "", MountTypeBind:
+		if err := syscall.Mount(m.Source, target, "", syscall.MS_BIND, ""); err != nil {
+			return fmt.Errorf("mount-bind(%s)", err)
+		}
+	case MountTypeFList:
+		if err := c.mountFList(m.Source, target); err != nil {
+			return fmt.Errorf("mount-bind-flist(%s)", err)
+		}
+	case MountTypeVolume:
+		src, err := volumes.Acquire(m.Source)
+		if err != nil {
+			return err
+		}
+
+		c.volumes = append(c.volumes, m.Source)
+		if err := syscall.Mount(src, target, "", syscall.MS_BIND, ""); err != nil {
+			return fmt.Errorf("mount-volume(%s)", err)
+		}
+	case MountTypeTmpfs:
+		var data string
+		if m.Size != 0 {
+			data = fmt.Sprintf("size=%d", m.Size)
+		}
+
+		if err := syscall.Mount("tmpfs", target, "tmpfs", 0, data); err != nil {
+			return fmt.Errorf("mount-tmpfs(%s)", err)
+		}
+	}
+
+	if m.ReadOnly {
+		//read-only bind mounts only take effect with a remount
+		flags := uintptr(syscall.MS_REMOUNT | syscall.MS_RDONLY)
+		if m.Type != MountTypeTmpfs {
+			flags |= syscall.MS_BIND
+		}
+
+		if err := syscall.Mount("", target, "", flags, ""); err != nil {
+			return fmt.Errorf("mount-readonly(%s)", err)
+		}
+	}
+
+	if flags, ok := propagations[m.Propagation]; ok {
+		if err := syscall.Mount("", target, "", flags, ""); err != nil {
+			return fmt.Errorf("mount-propagation(%s)", err)
+		}
+	}
+
+	return nil
+}
+
+//releaseVolumes releases the volumes mounted by the container, the volumes data is kept
+func (c *container) releaseVolumes() {
+	for _, name := range c.volumes {
+		volumes.Release(name)
+	}
+
+	c.volumes = nil
+}
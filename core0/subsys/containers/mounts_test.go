@@ -0,0 +1,37 @@
+package containers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMountValidate(t *testing.T) {
+	valid := []Mount{
+		{Source: "/tmp", Target: "/data"},
+		{Type: MountTypeBind, Source: "/tmp", Target: "/data", ReadOnly: true, Propagation: "rslave"},
+		{Type: MountTypeVolume, Source: "db", Target: "/var/lib/db"},
+		{Type: MountTypeTmpfs, Target: "/run", Size: 64 * 1024 * 1024},
+	}
+
+	for _, m := range valid {
+		assert.NoError(t, m.Validate(), "mount: %+v", m)
+	}
+
+	invalid := []Mount{
+		{Source: "/tmp", Target: "data"},
+		{Source: "tmp", Target: "/data"},
+		{Source: "/tmp", Target: "/../../etc"},
+		{Source: "/tmp", Target: "/data/../../etc"},
+		{Source: "/tmp", Target: "/data", Propagation: "unbindable"},
+		{Source: "/tmp", Target: "/data", Size: 1024},
+		{Type: MountTypeVolume, Target: "/data"},
+		{Type: MountTypeTmpfs, Source: "/tmp", Target: "/run"},
+		{Type: MountTypeTmpfs, Target: "/run", Size: -1},
+		{Type: "nfs", Source: "/tmp", Target: "/data"},
+	}
+
+	for _, m := range invalid {
+		assert.Error(t, m.Validate(), "mount: %+v", m)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/pborman/uuid"
 	"github.com/vishvananda/netlink"
 	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/core0/subsys/ipam"
 	"io/ioutil"
 	"net"
 	"os"
@@ -23,14 +24,6 @@ const (
 	containerPeerNameFmt          = "%sp"
 )
 
-func (c *container) preStartHostNetworking() error {
-	os.MkdirAll(path.Join(c.root(), "etc"), 0755)
-	p := path.Join(c.root(), "etc", "resolv.conf")
-	os.Remove(p)
-	ioutil.WriteFile(p, []byte{}, 0644) //touch the file.
-	return syscall.Mount("/etc/resolv.conf", p, "", syscall.MS_BIND, "")
-}
-
 func (c *container) zerotierHome() string {
 	return path.Join(BackendBaseDir, c.name(), "zerotier")
 }
@@ -220,11 +213,19 @@ func (c *container) postBridge(dev string, index int, n *Nic) error {
 			),
 		}
 		pm.Run(dhcpc)
-	} else if n.Config.CIDR != "" {
-		if _, _, err := net.ParseCIDR(n.Config.CIDR); err != nil {
-			return err
-		}
+	}
+
+	//with dhcp only the extra addresses are static
+	addresses := n.Config.CIDRs
+	if !n.Config.Dhcp {
+		addresses = n.Config.addresses()
+	}
+
+	if err := c.setSlaac(dev, n.Config.Slaac); err != nil {
+		log.Errorf("failed to set ipv6 auto configuration of %s: %s", dev, err)
+	}
 
+	if len(addresses) != 0 || n.Config.Slaac {
 		//putting the interface up
 		_, err := pm.System("ip", "netns",
 			"exec",
@@ -234,22 +235,32 @@ func (c *container) postBridge(dev string, index int, n *Nic) error {
 		if err != nil {
 			return fmt.Errorf("error bringing interface up: %v", err)
 		}
+	}
+
+	for _, address := range addresses {
+		if _, _, err := net.ParseCIDR(address); err != nil {
+			return err
+		}
 
 		//setting the ip address
-		_, err = pm.System("ip", "netns", "exec", fmt.Sprintf("%v", c.id), "ip", "address", "add", n.Config.CIDR, "dev", dev)
+		_, err = pm.System("ip", "netns", "exec", fmt.Sprintf("%v", c.id), "ip", "address", "add", address, "dev", dev)
 		if err != nil {
 			return fmt.Errorf("error settings interface ip: %v", err)
 		}
 	}
 
-	if n.Config.Gateway != "" {
-		if err := c.setGateway(dev, n.Config.Gateway); err != nil {
+	for _, gw := range []string{n.Config.Gateway, n.Config.Gateway6} {
+		if gw == "" {
+			continue
+		}
+
+		if err := c.setGateway(dev, gw); err != nil {
 			return err
 		}
 	}
 
-	for _, dns := range n.Config.DNS {
-		if err := c.setDNS(dns); err != nil {
+	if len(n.Config.DNS) != 0 {
+		if err := c.writeResolvConf(); err != nil {
 			return err
 		}
 	}
@@ -336,71 +347,68 @@ func (c *container) preBridge(index int, bridge string, n *Nic, ovs Container) e
 }
 
 func (c *container) getDefaultIP() net.IP {
-	base := c.id + 1
-	//we increment the ID to avoid getting the ip of the bridge itself.
-	return net.IPv4(BridgeIP[0], BridgeIP[1], byte(base&0xff00>>8), byte(base&0x00ff))
-}
-
-func (c *container) setDNS(dns string) error {
-	file, err := os.OpenFile(path.Join(c.root(), "etc", "resolv.conf"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+	if c.defaultIP == nil {
+		return nil
 	}
 
-	defer file.Close()
-	_, err = file.WriteString(fmt.Sprintf("\nnameserver %s\n", dns))
-
-	return err
+	return c.defaultIP.IP
 }
 
-func (c *container) forwardId(host int, container int) string {
-	return fmt.Sprintf("socat-%d-%d-%d", c.id, host, container)
+//defaultIP6 gets the ipv6 of the container on the default bridge, the ipv4 is the last 32 bits of the bridge prefix
+func defaultIP6(ip net.IP) net.IP {
+	ip6 := make(net.IP, net.IPv6len)
+	copy(ip6, net.ParseIP(DefaultBridgeIP6).Mask(net.CIDRMask(64, 128)))
+	copy(ip6[12:], ip.To4())
+	return ip6
 }
 
-func (c *container) unPortForward() {
-	for host, container := range c.Args.Port {
-		pm.Kill(c.forwardId(host, container))
+func (c *container) getDefaultIP6() net.IP {
+	if c.defaultIP == nil || !defaultBridgeIPv6 {
+		return nil
 	}
-}
 
-func (c *container) setPortForwards() error {
-	ip := c.getDefaultIP()
+	return defaultIP6(c.defaultIP.IP)
+}
 
-	for host, container := range c.Args.Port {
-		//nft add rule nat prerouting iif eth0 tcp dport { 80, 443 } dnat 192.168.1.120
-		cmd := &pm.Command{
-			ID:      c.forwardId(host, container),
-			Command: pm.CommandSystem,
-			Flags: pm.JobFlags{
-				NoOutput: true,
-			},
-			Arguments: pm.MustArguments(
-				pm.SystemCommandArguments{
-					Name: "socat",
-					Args: []string{
-						fmt.Sprintf("tcp-listen:%d,reuseaddr,fork", host),
-						fmt.Sprintf("tcp-connect:%s:%d", ip, container),
-					},
-				},
-			),
+/*
+allocateIP allocates the nic ip from the bridge ipam, the ip of the nic cidr is requested if set. The lease
+is sticky to the container name, so a recreated container gets the same ip.
+*/
+func (c *container) allocateIP(bridge string, n *Nic) (*net.IPNet, error) {
+	var requested net.IP
+	if len(n.Config.CIDR) != 0 {
+		ip, _, err := net.ParseCIDR(n.Config.CIDR)
+		if err != nil {
+			return nil, pm.BadRequestError(err)
 		}
+		requested = ip
+	}
 
-		onExit := &pm.ExitHook{
-			Action: func(s bool) {
-				log.Infof("Port forward %d:%d container: %d exited", host, container, c.id)
-			},
-		}
+	return ipam.Allocate(bridge, c.name(), c.Args.Name, requested)
+}
 
-		pm.Run(cmd, onExit)
+//setSlaac enables or disables the ipv6 auto configuration of a nic from the router advertisements
+func (c *container) setSlaac(dev string, slaac bool) error {
+	accept := 0
+	if slaac {
+		accept = 1
 	}
 
-	return nil
+	_, err := pm.System("ip", "netns", "exec", fmt.Sprintf("%v", c.id),
+		"sysctl", "-w", fmt.Sprintf("net.ipv6.conf.%s.accept_ra=%d", dev, accept))
+
+	return err
 }
 
 func (c *container) setGateway(dev string, gw string) error {
+	family := "-4"
+	if ip := net.ParseIP(gw); ip != nil && ip.To4() == nil {
+		family = "-6"
+	}
+
 	////setting the ip address
 	_, err := pm.System("ip", "netns", "exec", fmt.Sprintf("%v", c.id),
-		"ip", "route", "add", "metric", "1000", "default", "via", gw, "dev", dev)
+		"ip", family, "route", "add", "metric", "1000", "default", "via", gw, "dev", dev)
 
 	if err != nil {
 		return fmt.Errorf("error settings default gateway: %v", err)
@@ -413,12 +421,17 @@ func (c *container) postDefaultNetwork(name string, idx int, net *Nic) error {
 	//Add to the default bridge
 	defnet := &Nic{
 		Config: NetworkConfig{
-			CIDR:    fmt.Sprintf("%s/16", c.getDefaultIP().String()),
+			CIDR:    c.defaultIP.String(),
 			Gateway: DefaultBridgeIP,
 			DNS:     []string{DefaultBridgeIP},
 		},
 	}
 
+	if ip6 := c.getDefaultIP6(); ip6 != nil {
+		defnet.Config.CIDRs = []string{fmt.Sprintf("%s/64", ip6)}
+		defnet.Config.Gateway6 = DefaultBridgeIP6
+	}
+
 	if err := c.postBridge(name, idx, defnet); err != nil {
 		return err
 	}
@@ -427,24 +440,59 @@ func (c *container) postDefaultNetwork(name string, idx int, net *Nic) error {
 		return err
 	}
 
+	if err := c.registerDNS(); err != nil {
+		log.Errorf("failed to register container %d dns record: %s", c.id, err)
+	}
+
 	return nil
 }
 
 func (c *container) preDefaultNetwork(i int, net *Nic) error {
 	//Add to the default bridge
+	ip, err := c.allocateIP(DefaultBridgeName, net)
+	if err != nil {
+		return err
+	}
 
 	defnet := &Nic{
 		Config: NetworkConfig{
-			CIDR:    fmt.Sprintf("%s/16", c.getDefaultIP().String()),
+			CIDR:    ip.String(),
 			Gateway: DefaultBridgeIP,
 			DNS:     []string{DefaultBridgeIP},
 		},
 	}
 
 	if err := c.preBridge(i, DefaultBridgeName, defnet, nil); err != nil {
+		ipam.Release(DefaultBridgeName, c.name())
+		return err
+	}
+
+	c.defaultIP = ip
+	return nil
+}
+
+func isIPv6(cidr string) bool {
+	ip, _, err := net.ParseCIDR(cidr)
+	return err == nil && ip.To4() == nil
+}
+
+//preBridgeNetwork attaches the nic to a bridge, a static nic on a bridge managed by the ipam gets its ip allocated
+func (c *container) preBridgeNetwork(idx int, n *Nic) error {
+	if n.Config.Dhcp || !ipam.Managed(n.ID) || isIPv6(n.Config.CIDR) {
+		return c.preBridge(idx, n.ID, n, nil)
+	}
+
+	ip, err := c.allocateIP(n.ID, n)
+	if err != nil {
 		return err
 	}
 
+	if err := c.preBridge(idx, n.ID, n, nil); err != nil {
+		ipam.Release(n.ID, c.name())
+		return err
+	}
+
+	n.Config.CIDR = ip.String()
 	return nil
 }
 
@@ -589,7 +637,7 @@ func (c *container) preStartNetwork(idx int, network *Nic) (err error) {
 	case "default":
 		err = c.preDefaultNetwork(idx, network)
 	case "bridge":
-		err = c.preBridge(idx, network.ID, network, nil)
+		err = c.preBridgeNetwork(idx, network)
 	case "zerotier":
 	default:
 		err = pm.BadRequestError(fmt.Errorf("unkown network type '%s'", network.Type))
@@ -648,6 +696,10 @@ func (c *container) destroyNetwork() {
 		return
 	}
 
+	//port forwards are dropped even if the default nic was already removed
+	c.unPortForwards()
+	c.unregisterDNS()
+
 	for idx, network := range c.Args.Nics {
 		switch network.Type {
 		case "vxlan":
@@ -657,11 +709,12 @@ func (c *container) destroyNetwork() {
 			c.unBridge(idx, network, ovs)
 		case "default":
 			c.unBridge(idx, network, nil)
-			c.unPortForward()
 		}
 	}
 
 	pm.Kill(c.zerotierID())
+	ipam.ReleaseAll(c.name())
+	c.defaultIP = nil
 
 	//clean up namespace
 	if c.PID > 0 {
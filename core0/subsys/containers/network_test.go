@@ -0,0 +1,36 @@
+package containers
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetworkConfigValidate(t *testing.T) {
+	config := NetworkConfig{
+		CIDR:     "192.168.1.10/24",
+		CIDRs:    []string{"192.168.2.10/24", "fd00:1::10/64"},
+		Gateway:  "192.168.1.1",
+		Gateway6: "fd00:1::1",
+	}
+
+	if assert.NoError(t, config.Validate()) {
+		assert.Equal(t, []string{"192.168.1.10/24", "192.168.2.10/24", "fd00:1::10/64"}, config.addresses())
+	}
+
+	for _, config := range []NetworkConfig{
+		{CIDR: "192.168.1.10"},
+		{CIDRs: []string{"fd00:1::10"}},
+		{Gateway: "fd00:1::1"},
+		{Gateway6: "192.168.1.1"},
+		{Gateway6: "gateway"},
+	} {
+		assert.Error(t, config.Validate(), "%+v", config)
+	}
+}
+
+func TestDefaultIP6(t *testing.T) {
+	assert.Equal(t, "fd00:172:18::ac12:2", defaultIP6(net.ParseIP("172.18.0.2")).String())
+	assert.Equal(t, "fd00:172:18::ac12:ff01", defaultIP6(net.ParseIP("172.18.255.1")).String())
+}
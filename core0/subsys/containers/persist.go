@@ -0,0 +1,306 @@
+package containers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	//PersistentSpecDir is where the specs of the persistent containers are kept
+	PersistentSpecDir = "/var/cache/corex/containers"
+
+	RestartNo        = RestartPolicy("no")
+	RestartOnFailure = RestartPolicy("on-failure")
+	RestartAlways    = RestartPolicy("always")
+
+	restartMinDelay   = 1 * time.Second
+	restartMaxDelay   = 1 * time.Minute
+	restartResetAfter = 5 * time.Minute
+	//restartMaxFailures is the number of consecutive failed starts after which a container is not restarted anymore
+	restartMaxFailures = 10
+)
+
+//RestartPolicy decides if a container is restarted when coreX exits, a container that is terminated
+//with corex.terminate is never restarted
+type RestartPolicy string
+
+func (p RestartPolicy) Validate() error {
+	switch p {
+	case "", RestartNo, RestartOnFailure, RestartAlways:
+		return nil
+	default:
+		return fmt.Errorf("invalid restart policy '%s'", p)
+	}
+}
+
+//Restart checks if the container should be restarted given the coreX exit state
+func (p RestartPolicy) Restart(success bool) bool {
+	switch p {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return !success
+	default:
+		return false
+	}
+}
+
+//spec gets a copy of the container arguments that can be used to recreate the container, nics that
+//were removed from the container are dropped
+func (c *container) spec() ContainerCreateArguments {
+	args := c.Args
+	args.Nics = nil
+	for _, nic := range c.Args.Nics {
+		if nic.State == NicStateDestroyed {
+			continue
+		}
+
+		n := *nic
+		n.State = ""
+		args.Nics = append(args.Nics, &n)
+	}
+
+	return args
+}
+
+func specPath(id uint16) string {
+	return path.Join(PersistentSpecDir, fmt.Sprintf("%d.json", id))
+}
+
+//persist writes the spec of the container to disk if the container is persistent, it's called
+//every time the container configuration changes.
+func (m *containerManager) persist(c *container) {
+	if !c.Args.Persistent {
+		return
+	}
+
+	data, err := json.MarshalIndent(c.spec(), "", "  ")
+	if err != nil {
+		log.Errorf("failed to serialize container-%d spec: %s", c.id, err)
+		return
+	}
+
+	if err := os.MkdirAll(PersistentSpecDir, 0700); err != nil {
+		log.Errorf("failed to create container spec directory: %s", err)
+		return
+	}
+
+	tmp := specPath(c.id) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		log.Errorf("failed to write container-%d spec: %s", c.id, err)
+		return
+	}
+
+	if err := os.Rename(tmp, specPath(c.id)); err != nil {
+		log.Errorf("failed to write container-%d spec: %s", c.id, err)
+	}
+}
+
+//forget removes the spec of the container, so it's not recreated on next boot
+func (m *containerManager) forget(id uint16) {
+	if err := os.Remove(specPath(id)); err != nil && !os.IsNotExist(err) {
+		log.Errorf("failed to remove container-%d spec: %s", id, err)
+	}
+}
+
+//loadSpecs reads the specs of the persistent containers and reserves their IDs so new containers
+//don't take them before the specs are replayed.
+func (m *containerManager) loadSpecs() {
+	files, err := ioutil.ReadDir(PersistentSpecDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("failed to read container specs: %s", err)
+		}
+		return
+	}
+
+	m.seqM.Lock()
+	defer m.seqM.Unlock()
+
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, ".json"), 10, 16)
+		if err != nil || id == 0 {
+			log.Warningf("ignoring invalid container spec '%s'", name)
+			continue
+		}
+
+		data, err := ioutil.ReadFile(path.Join(PersistentSpecDir, name))
+		if err != nil {
+			log.Errorf("failed to read container spec '%s': %s", name, err)
+			continue
+		}
+
+		var args ContainerCreateArguments
+		if err := json.Unmarshal(data, &args); err != nil {
+			log.Errorf("invalid container spec '%s': %s", name, err)
+			continue
+		}
+
+		m.specs[uint16(id)] = args
+		m.reserved[uint16(id)] = true
+	}
+}
+
+func (m *containerManager) reserve(id uint16) {
+	m.seqM.Lock()
+	defer m.seqM.Unlock()
+	m.reserved[id] = true
+}
+
+func (m *containerManager) unreserve(id uint16) {
+	m.seqM.Lock()
+	defer m.seqM.Unlock()
+	delete(m.reserved, id)
+}
+
+/*
+Replay recreates the persistent containers from their specs, it's called once at boot after the
+node is fully bootstrapped. Containers keep their IDs, the container root filesystem is recreated
+from the root flist, so only data on the container mounts survives a reboot.
+*/
+func (m *containerManager) Replay() {
+	m.seqM.Lock()
+	var ids []int
+	for id := range m.specs {
+		ids = append(ids, int(id))
+	}
+	specs := m.specs
+	m.specs = make(map[uint16]ContainerCreateArguments)
+	m.seqM.Unlock()
+
+	sort.Ints(ids)
+	for _, id := range ids {
+		args := specs[uint16(id)]
+		log.Infof("recreating persistent container-%d", id)
+
+		if err := m.startContainer(newContainer(m, uint16(id), args)); err != nil {
+			log.Errorf("failed to recreate persistent container-%d: %s", id, err)
+		}
+	}
+}
+
+//onExit is called after a container exits and is cleaned up, it restarts the container from spec
+//according to its restart policy
+func (m *containerManager) onExit(c *container, spec ContainerCreateArguments, success bool) {
+	if c.terminated {
+		m.forget(c.id)
+		return
+	}
+
+	if !c.Args.Restart.Restart(success) {
+		return
+	}
+
+	restarts := c.restarts
+	if time.Since(c.started) > restartResetAfter {
+		restarts = 0
+	}
+
+	//keep the container ID for the restarted container
+	m.reserve(c.id)
+	cancel := m.registerRestart(c.id)
+	go func() {
+		for failures := 0; ; failures++ {
+			if failures == restartMaxFailures {
+				log.Errorf("giving up restarting container-%d after %d failed starts", c.id, failures)
+				m.cancelRestart(c.id)
+				return
+			}
+
+			delay := restartDelay(restarts)
+			log.Infof("restarting container-%d in %s", c.id, delay)
+			select {
+			case <-time.After(delay):
+			case <-cancel:
+				log.Infof("restart of container-%d canceled", c.id)
+				return
+			}
+
+			if !m.unregisterRestart(c.id, cancel) {
+				//canceled while the delay elapsed
+				return
+			}
+
+			restarts++
+			restarted := newContainer(m, c.id, spec)
+			restarted.restarts = restarts
+			err := m.startContainer(restarted)
+			if err == nil {
+				return
+			}
+
+			log.Errorf("failed to restart container-%d: %s", c.id, err)
+			m.reserve(c.id)
+			cancel = m.registerRestart(c.id)
+		}
+	}()
+}
+
+//registerRestart registers a pending restart of container id, the returned channel is closed if the
+//restart is canceled
+func (m *containerManager) registerRestart(id uint16) chan struct{} {
+	m.seqM.Lock()
+	defer m.seqM.Unlock()
+
+	cancel := make(chan struct{})
+	m.restarts[id] = cancel
+	return cancel
+}
+
+//unregisterRestart removes the pending restart of container id before it's started, it returns false if
+//the restart was canceled
+func (m *containerManager) unregisterRestart(id uint16, cancel chan struct{}) bool {
+	m.seqM.Lock()
+	defer m.seqM.Unlock()
+
+	if m.restarts[id] != cancel {
+		return false
+	}
+
+	delete(m.restarts, id)
+	return true
+}
+
+//cancelRestart cancels the pending restart of container id, its id is released and its spec is forgotten.
+//It returns false if the container doesn't wait to be restarted.
+func (m *containerManager) cancelRestart(id uint16) bool {
+	m.seqM.Lock()
+	cancel, ok := m.restarts[id]
+	if ok {
+		delete(m.restarts, id)
+		delete(m.reserved, id)
+		close(cancel)
+	}
+	m.seqM.Unlock()
+
+	if ok {
+		m.forget(id)
+	}
+
+	return ok
+}
+
+func restartDelay(restarts int) time.Duration {
+	if restarts > 6 {
+		return restartMaxDelay
+	}
+
+	delay := restartMinDelay << uint(restarts)
+	if delay > restartMaxDelay {
+		delay = restartMaxDelay
+	}
+
+	return delay
+}
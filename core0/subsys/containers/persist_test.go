@@ -0,0 +1,33 @@
+package containers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCancelRestart(t *testing.T) {
+	m := &containerManager{
+		reserved: make(map[uint16]bool),
+		restarts: make(map[uint16]chan struct{}),
+	}
+
+	assert.False(t, m.cancelRestart(1))
+
+	m.reserve(1)
+	cancel := m.registerRestart(1)
+	assert.True(t, m.cancelRestart(1))
+	assert.False(t, m.reserved[1])
+
+	select {
+	case <-cancel:
+	default:
+		assert.Fail(t, "restart was not canceled")
+	}
+
+	assert.False(t, m.unregisterRestart(1, cancel))
+
+	cancel = m.registerRestart(2)
+	assert.True(t, m.unregisterRestart(2, cancel))
+	assert.False(t, m.cancelRestart(2))
+}
@@ -0,0 +1,354 @@
+package containers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/zero-os/0-core/base/nft"
+	"github.com/zero-os/0-core/base/pm"
+)
+
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+
+	//kvmForwardPrefix is the job id prefix of the kvm port forwards (kvm-socat-<uuid>-<host port>), they
+	//are tcp only
+	kvmForwardPrefix = "kvm-socat-"
+)
+
+var (
+	//forwardChains are the nat chains of the forward rules, prerouting for the traffic received by the node
+	//and output for the traffic of the node itself
+	forwardChains = []string{"pre", "output"}
+)
+
+/*
+portForward is a parsed port forward of a container. Forwards are given as `{host: container}` where
+host is `<port>[-<end>][/tcp|/udp]`, for example `8080`, `53/udp` or `8000-8100`. A range is always
+forwarded to the same ports inside the container.
+*/
+type portForward struct {
+	host      string
+	protocol  string
+	start     int
+	end       int
+	container int
+}
+
+func parsePortForward(host string, container int) (*portForward, error) {
+	forward := &portForward{
+		host:      host,
+		protocol:  ProtocolTCP,
+		container: container,
+	}
+
+	spec := host
+	if idx := strings.Index(spec, "/"); idx >= 0 {
+		forward.protocol = spec[idx+1:]
+		spec = spec[:idx]
+	}
+
+	if forward.protocol != ProtocolTCP && forward.protocol != ProtocolUDP {
+		return nil, fmt.Errorf("invalid protocol '%s' in port forward '%s'", forward.protocol, host)
+	}
+
+	ports := strings.SplitN(spec, "-", 2)
+	var err error
+	if forward.start, err = strconv.Atoi(ports[0]); err != nil {
+		return nil, fmt.Errorf("invalid host port '%s'", host)
+	}
+
+	forward.end = forward.start
+	if len(ports) == 2 {
+		if forward.end, err = strconv.Atoi(ports[1]); err != nil {
+			return nil, fmt.Errorf("invalid host port '%s'", host)
+		}
+	}
+
+	if forward.start <= 0 || forward.end > 65535 || forward.start > forward.end {
+		return nil, fmt.Errorf("invalid host port '%s'", host)
+	}
+
+	if container < 0 || container > 65535 {
+		return nil, fmt.Errorf("invalid guest port '%d'", container)
+	}
+
+	if forward.start != forward.end {
+		if container != 0 && container != forward.start {
+			return nil, fmt.Errorf("port range '%s' can only be forwarded to the same ports", host)
+		}
+	} else if container == 0 {
+		forward.container = forward.start
+	}
+
+	return forward, nil
+}
+
+//overlaps checks if both forwards use a common host port
+func (f *portForward) overlaps(o *portForward) bool {
+	return f.protocol == o.protocol && f.start <= o.end && o.start <= f.end
+}
+
+func (f *portForward) ports() string {
+	if f.start == f.end {
+		return fmt.Sprint(f.start)
+	}
+
+	return fmt.Sprintf("%d-%d", f.start, f.end)
+}
+
+/*
+setUpLocalForwards lets the port forwards work for connections from the node to localhost. The output rules
+dnat them to the container with a loopback source, which the kernel only routes to the default bridge with
+route_localnet, and which is masqueraded so the container can reply.
+*/
+func setUpLocalForwards() error {
+	if err := ioutil.WriteFile(fmt.Sprintf("/proc/sys/net/ipv4/conf/%s/route_localnet", DefaultBridgeName), []byte("1"), 0644); err != nil {
+		return err
+	}
+
+	return nft.Apply(nft.Nft{
+		"nat": nft.Table{
+			Family: nft.FamilyIP,
+			Chains: nft.Chains{
+				"post": nft.Chain{
+					Rules: []nft.Rule{
+						{Body: fmt.Sprintf(`ip saddr 127.0.0.0/8 oifname "%s" masquerade`, DefaultBridgeName)},
+					},
+				},
+			},
+		},
+	})
+}
+
+func (c *container) forwardComment(host string) string {
+	return fmt.Sprintf("port-%d-%s", c.id, host)
+}
+
+func (c *container) forwardRule(f *portForward) nft.Nft {
+	target := c.getDefaultIP().String()
+	if f.start == f.end {
+		target = fmt.Sprintf("%s:%d", target, f.container)
+	}
+
+	rule := nft.Rule{
+		Body: fmt.Sprintf(`fib daddr type local %s dport %s dnat to %s comment "%s"`,
+			f.protocol, f.ports(), target, c.forwardComment(f.host)),
+	}
+
+	chains := nft.Chains{}
+	for _, chain := range forwardChains {
+		chains[chain] = nft.Chain{
+			Rules: []nft.Rule{rule},
+		}
+	}
+
+	return nft.Nft{
+		"nat": nft.Table{
+			Family: nft.FamilyIP,
+			Chains: chains,
+		},
+	}
+}
+
+//setPortForward adds a DNAT rule that forwards traffic received on the host port(s) to the container
+func (c *container) setPortForward(host string, container int) error {
+	forward, err := parsePortForward(host, container)
+	if err != nil {
+		return err
+	}
+
+	return nft.Apply(c.forwardRule(forward))
+}
+
+func (c *container) setPortForwards() error {
+	//make sure no rules are left from an older container with the same id
+	c.unPortForwards()
+
+	for host, container := range c.Args.Port {
+		if err := c.setPortForward(host, container); err != nil {
+			return fmt.Errorf("port forward %s:%d: %s", host, container, err)
+		}
+	}
+
+	return nil
+}
+
+//dropPortForwards removes the DNAT rules of the container that matches the given comment prefix
+func (c *container) dropPortForwards(prefix string) error {
+	ruleset, err := nft.Get()
+	if err != nil {
+		return err
+	}
+
+	table, ok := ruleset["nat"]
+	if !ok {
+		return nil
+	}
+
+	var errored bool
+	for _, chain := range forwardChains {
+		for _, rule := range table.Chains[chain].Rules {
+			if !strings.Contains(rule.Body, fmt.Sprintf(`comment "%s`, prefix)) {
+				continue
+			}
+
+			if err := nft.Drop(table.Family, "nat", chain, rule.Handle); err != nil {
+				log.Errorf("nft delete rule: %s", err)
+				errored = true
+			}
+		}
+	}
+
+	if errored {
+		return fmt.Errorf("failed to clean up port forwards of container-%d", c.id)
+	}
+
+	return nil
+}
+
+func (c *container) unPortForward(host string) error {
+	return c.dropPortForwards(c.forwardComment(host) + `"`)
+}
+
+func (c *container) unPortForwards() {
+	if err := c.dropPortForwards(c.forwardComment("")); err != nil {
+		log.Errorf("%s", err)
+	}
+}
+
+func (c *container) hasDefaultNetwork() bool {
+	if c.Args.HostNetwork {
+		return false
+	}
+
+	for _, nic := range c.Args.Nics {
+		if nic.Type == "default" && nic.State != NicStateDestroyed {
+			return true
+		}
+	}
+
+	return false
+}
+
+//kvmForwardPort gets the host port of a kvm port forward job
+func kvmForwardPort(id string) (int, bool) {
+	if !strings.HasPrefix(id, kvmForwardPrefix) {
+		return 0, false
+	}
+
+	port, err := strconv.Atoi(id[strings.LastIndex(id, "-")+1:])
+	if err != nil {
+		return 0, false
+	}
+
+	return port, true
+}
+
+//checkPortForward makes sure the host port(s) are not already forwarded to another container or to a vm
+func (m *containerManager) checkPortForward(forward *portForward) error {
+	for id := range pm.Jobs() {
+		port, ok := kvmForwardPort(id)
+		if !ok {
+			continue
+		}
+
+		if forward.overlaps(&portForward{protocol: ProtocolTCP, start: port, end: port}) {
+			return fmt.Errorf("host port '%d' is already forwarded to a vm", port)
+		}
+	}
+
+	for _, c := range m.containers {
+		for host, container := range c.Args.Port {
+			other, err := parsePortForward(host, container)
+			if err != nil {
+				continue
+			}
+
+			if forward.overlaps(other) {
+				return fmt.Errorf("host port '%s' is already forwarded to container %d", host, c.id)
+			}
+		}
+	}
+
+	return nil
+}
+
+type ContainerPortForwardArguments struct {
+	Container     uint16 `json:"container"`
+	HostPort      string `json:"host_port"`
+	ContainerPort int    `json:"container_port"`
+}
+
+func (m *containerManager) portforwardAdd(cmd *pm.Command) (interface{}, error) {
+	var args ContainerPortForwardArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	forward, err := parsePortForward(args.HostPort, args.ContainerPort)
+	if err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	m.conM.Lock()
+	defer m.conM.Unlock()
+
+	container, ok := m.containers[args.Container]
+	if !ok {
+		return nil, pm.NotFoundError(fmt.Errorf("container does not exist"))
+	}
+
+	if !container.hasDefaultNetwork() {
+		return nil, pm.BadRequestError(fmt.Errorf("port forwards are only supported with default networking"))
+	}
+
+	if err := m.checkPortForward(forward); err != nil {
+		return nil, pm.PreconditionFailedError(err)
+	}
+
+	if err := nft.Apply(container.forwardRule(forward)); err != nil {
+		return nil, err
+	}
+
+	if container.Args.Port == nil {
+		container.Args.Port = make(map[string]int)
+	}
+
+	container.Args.Port[args.HostPort] = args.ContainerPort
+	m.persist(container)
+
+	return nil, nil
+}
+
+func (m *containerManager) portforwardRemove(cmd *pm.Command) (interface{}, error) {
+	var args ContainerPortForwardArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	m.conM.Lock()
+	defer m.conM.Unlock()
+
+	container, ok := m.containers[args.Container]
+	if !ok {
+		return nil, pm.NotFoundError(fmt.Errorf("container does not exist"))
+	}
+
+	if _, ok := container.Args.Port[args.HostPort]; !ok {
+		return nil, pm.NotFoundError(fmt.Errorf("no port forward for host port '%s'", args.HostPort))
+	}
+
+	if err := container.unPortForward(args.HostPort); err != nil {
+		return nil, err
+	}
+
+	delete(container.Args.Port, args.HostPort)
+	m.persist(container)
+
+	return nil, nil
+}
@@ -0,0 +1,68 @@
+package containers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePortForward(t *testing.T) {
+	forward, err := parsePortForward("8080", 80)
+	if ok := assert.NoError(t, err); !ok {
+		t.Fatal()
+	}
+
+	assert.Equal(t, ProtocolTCP, forward.protocol)
+	assert.Equal(t, 8080, forward.start)
+	assert.Equal(t, 8080, forward.end)
+	assert.Equal(t, 80, forward.container)
+
+	forward, err = parsePortForward("53/udp", 0)
+	if ok := assert.NoError(t, err); !ok {
+		t.Fatal()
+	}
+
+	assert.Equal(t, ProtocolUDP, forward.protocol)
+	assert.Equal(t, 53, forward.container)
+
+	forward, err = parsePortForward("8000-8100", 0)
+	if ok := assert.NoError(t, err); !ok {
+		t.Fatal()
+	}
+
+	assert.Equal(t, 8000, forward.start)
+	assert.Equal(t, 8100, forward.end)
+	assert.Equal(t, "8000-8100", forward.ports())
+
+	for _, host := range []string{"", "abc", "0", "70000", "90-80", "80/sctp", "80-"} {
+		_, err := parsePortForward(host, 80)
+		assert.Error(t, err, host)
+	}
+
+	_, err = parsePortForward("8000-8100", 9000)
+	assert.Error(t, err, "range to different ports")
+}
+
+func TestPortForwardOverlaps(t *testing.T) {
+	parse := func(host string) *portForward {
+		forward, err := parsePortForward(host, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return forward
+	}
+
+	assert.True(t, parse("8080").overlaps(parse("8000-9000")))
+	assert.True(t, parse("8000-8100").overlaps(parse("8100-8200")))
+	assert.False(t, parse("8000-8100").overlaps(parse("8101-8200")))
+	assert.False(t, parse("53").overlaps(parse("53/udp")))
+}
+
+func TestKVMForwardPort(t *testing.T) {
+	port, ok := kvmForwardPort("kvm-socat-0b3f6a1e-7d2c-4f8a-9e1b-2c3d4e5f6a7b-8080")
+	assert.True(t, ok)
+	assert.Equal(t, 8080, port)
+
+	_, ok = kvmForwardPort("core-1")
+	assert.False(t, ok)
+}
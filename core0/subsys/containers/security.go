@@ -0,0 +1,89 @@
+package containers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/seccomp"
+)
+
+const (
+	//seccompProfilePath is where the profile is written in the container root, coreX removes it once installed
+	seccompProfilePath = "/.seccomp.json"
+)
+
+/*
+seccompProfile gets the profile of the container from the seccomp argument, which is either the name of a
+builtin profile (default or unconfined) or a profile in the docker format. If not set, unprivileged containers
+use the default profile and privileged containers are unconfined. A nil profile means unconfined.
+*/
+func (c *ContainerCreateArguments) seccompProfile() (*seccomp.Profile, error) {
+	raw := strings.TrimSpace(string(c.Seccomp))
+	if len(raw) == 0 || raw == "null" {
+		if c.Privileged {
+			return nil, nil
+		}
+		return seccomp.DefaultProfile(), nil
+	}
+
+	if strings.HasPrefix(raw, "{") {
+		return seccomp.Parse(c.Seccomp)
+	}
+
+	var name string
+	if err := json.Unmarshal(c.Seccomp, &name); err != nil {
+		return nil, fmt.Errorf("invalid seccomp profile: %s", err)
+	}
+
+	switch name {
+	case seccomp.ProfileDefault:
+		return seccomp.DefaultProfile(), nil
+	case seccomp.ProfileUnconfined:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown seccomp profile '%s'", name)
+	}
+}
+
+func (c *ContainerCreateArguments) validateSecurity() error {
+	if c.Privileged && (len(c.CapAdd) != 0 || len(c.CapDrop) != 0) {
+		return fmt.Errorf("capabilities can't be changed for privileged containers")
+	}
+
+	if _, err := pm.ResolveCapabilities(c.CapAdd, c.CapDrop); err != nil {
+		return err
+	}
+
+	_, err := c.seccompProfile()
+	return err
+}
+
+//securityArgs writes the seccomp profile in the container root and gets the coreX capabilities and seccomp flags
+func (c *container) securityArgs() ([]string, error) {
+	var args []string
+	if len(c.Args.CapAdd) != 0 {
+		args = append(args, "-cap-add", strings.Join(c.Args.CapAdd, ","))
+	}
+
+	if len(c.Args.CapDrop) != 0 {
+		args = append(args, "-cap-drop", strings.Join(c.Args.CapDrop, ","))
+	}
+
+	profile, err := c.Args.seccompProfile()
+	if err != nil || profile == nil {
+		return args, err
+	}
+
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := c.writeFile(seccompProfilePath, data, 0600); err != nil {
+		return nil, err
+	}
+
+	return append(args, "-seccomp", seccompProfilePath), nil
+}
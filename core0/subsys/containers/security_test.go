@@ -0,0 +1,57 @@
+package containers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zero-os/0-core/base/seccomp"
+)
+
+func TestSeccompProfile(t *testing.T) {
+	args := ContainerCreateArguments{}
+	profile, err := args.seccompProfile()
+	if assert.NoError(t, err) {
+		assert.Equal(t, seccomp.DefaultProfile(), profile)
+	}
+
+	args.Privileged = true
+	profile, err = args.seccompProfile()
+	if assert.NoError(t, err) {
+		assert.Nil(t, profile)
+	}
+
+	args.Seccomp = json.RawMessage(`"default"`)
+	profile, err = args.seccompProfile()
+	if assert.NoError(t, err) {
+		assert.Equal(t, seccomp.DefaultProfile(), profile)
+	}
+
+	args = ContainerCreateArguments{Seccomp: json.RawMessage(`"unconfined"`)}
+	profile, err = args.seccompProfile()
+	if assert.NoError(t, err) {
+		assert.Nil(t, profile)
+	}
+
+	args.Seccomp = json.RawMessage(`{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["mount"], "action": "SCMP_ACT_ERRNO"}]}`)
+	profile, err = args.seccompProfile()
+	if assert.NoError(t, err) {
+		assert.Equal(t, seccomp.ActAllow, profile.DefaultAction)
+		assert.Len(t, profile.Syscalls, 1)
+	}
+
+	args.Seccomp = json.RawMessage(`"strict"`)
+	_, err = args.seccompProfile()
+	assert.Error(t, err)
+}
+
+func TestValidateSecurity(t *testing.T) {
+	args := ContainerCreateArguments{CapAdd: []string{"net_admin"}, CapDrop: []string{"CAP_MKNOD"}}
+	assert.NoError(t, args.validateSecurity())
+
+	args.CapAdd = []string{"CAP_NOPE"}
+	assert.Error(t, args.validateSecurity())
+
+	args = ContainerCreateArguments{Privileged: true, CapDrop: []string{"ALL"}}
+	assert.Error(t, args.validateSecurity())
+}
@@ -0,0 +1,364 @@
+package containers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/pm/stream"
+)
+
+const (
+	cmdContainerSnapshot       = "corex.snapshot"
+	cmdContainerSnapshotList   = "corex.snapshot_list"
+	cmdContainerSnapshotRemove = "corex.snapshot_remove"
+	cmdContainerClone          = "corex.clone"
+
+	snapshotLayer  = "layer"  //read-only snapshot of the container sandbox subvolume
+	snapshotRootFS = "rootfs" //read-only snapshot of the root image subvolume (nested in the sandbox subvolume)
+	snapshotInfo   = "snapshot.json"
+)
+
+var (
+	//SnapshotsDir where the container snapshots are kept, it must be on the same btrfs filesystem as the sandboxes
+	SnapshotsDir = path.Join(BackendBaseDir, "snapshots")
+
+	snapshotNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+)
+
+//Snapshot is a point in time copy of the writable layer of a container
+type Snapshot struct {
+	Name      string                   `json:"name"`
+	Container uint16                   `json:"container"` //id of the snapshotted container
+	Created   int64                    `json:"created"`
+	Arguments ContainerCreateArguments `json:"arguments"` //spec of the container at the time of the snapshot
+}
+
+type ContainerSnapshotArguments struct {
+	Container uint16 `json:"container"`
+	Name      string `json:"name"` //generated from the container id and time if not set
+}
+
+type ContainerSnapshotListArguments struct {
+	Container uint16 `json:"container"` //only the snapshots of this container if set
+}
+
+type ContainerSnapshotRemoveArguments struct {
+	Name string `json:"name"`
+}
+
+type ContainerCloneArguments struct {
+	Snapshot   string         `json:"snapshot"`
+	Name       string         `json:"name"`
+	Hostname   string         `json:"hostname"`
+	Nics       []*Nic         `json:"nics"`
+	Port       map[string]int `json:"port"`
+	Persistent bool           `json:"persistent"`
+}
+
+func validateSnapshotName(name string) error {
+	if len(name) > 255 || !snapshotNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid snapshot name '%s'", name)
+	}
+
+	return nil
+}
+
+func snapshotPath(name string) string {
+	return path.Join(SnapshotsDir, name)
+}
+
+func deleteSubvolume(p string) {
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		return
+	}
+
+	if _, err := pm.System("btrfs", "subvolume", "delete", p); err != nil {
+		log.Errorf("failed to delete subvolume '%s': %s", p, err)
+	}
+}
+
+//removeSnapshot deletes the subvolumes of the snapshot then its directory
+func removeSnapshot(name string) error {
+	dir := snapshotPath(name)
+	deleteSubvolume(path.Join(dir, snapshotRootFS))
+	deleteSubvolume(path.Join(dir, snapshotLayer))
+
+	return os.RemoveAll(dir)
+}
+
+func loadSnapshot(name string) (*Snapshot, error) {
+	if err := validateSnapshotName(name); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	data, err := ioutil.ReadFile(path.Join(snapshotPath(name), snapshotInfo))
+	if os.IsNotExist(err) {
+		return nil, pm.NotFoundError(fmt.Errorf("snapshot '%s' does not exist", name))
+	} else if err != nil {
+		return nil, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+//listSnapshots gets the snapshots sorted by creation time
+func listSnapshots() ([]Snapshot, error) {
+	entries, err := ioutil.ReadDir(SnapshotsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		snapshot, err := loadSnapshot(entry.Name())
+		if err != nil {
+			//incomplete snapshot
+			continue
+		}
+
+		snapshots = append(snapshots, *snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].Created != snapshots[j].Created {
+			return snapshots[i].Created < snapshots[j].Created
+		}
+
+		return snapshots[i].Name < snapshots[j].Name
+	})
+
+	return snapshots, nil
+}
+
+//snapshot makes read-only snapshots of the sandbox subvolumes in dir, the container is frozen meanwhile so
+//the snapshot is consistent
+func (c *container) snapshot(dir string) error {
+	if !c.Frozen() {
+		if err := c.Pause(); err != nil {
+			return err
+		}
+
+		defer func() {
+			if err := c.Resume(); err != nil {
+				log.Errorf("failed to resume container %d after snapshot: %s", c.id, err)
+			}
+		}()
+	}
+
+	if _, err := pm.System("btrfs", "subvolume", "snapshot", "-r",
+		path.Join(BackendBaseDir, c.name()), path.Join(dir, snapshotLayer)); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(c.rootfs()); os.IsNotExist(err) {
+		return nil
+	}
+
+	_, err := pm.System("btrfs", "subvolume", "snapshot", "-r", c.rootfs(), path.Join(dir, snapshotRootFS))
+	return err
+}
+
+//Snapshot takes a snapshot of the writable layer of the container, the mounts are not part of the snapshot
+func (c *container) Snapshot(name string) (*Snapshot, error) {
+	if c.getFSType(BackendBaseDir) != "btrfs" {
+		return nil, pm.PreconditionFailedError(fmt.Errorf("snapshots are only supported if '%s' is on btrfs", BackendBaseDir))
+	}
+
+	if c.Args.UserNS {
+		return nil, pm.PreconditionFailedError(fmt.Errorf("snapshots of user namespace containers are not supported"))
+	}
+
+	if err := os.MkdirAll(SnapshotsDir, 0755); err != nil {
+		return nil, err
+	}
+
+	//the directory is created atomically, so a concurrent snapshot with the same name fails here and never
+	//cleans up a snapshot it didn't create
+	dir := snapshotPath(name)
+	if err := os.Mkdir(dir, 0755); os.IsExist(err) {
+		return nil, pm.PreconditionFailedError(fmt.Errorf("snapshot '%s' already exists", name))
+	} else if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{
+		Name:      name,
+		Container: c.id,
+		Created:   time.Now().Unix(),
+		Arguments: c.spec(),
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err == nil {
+		if err = c.snapshot(dir); err == nil {
+			err = ioutil.WriteFile(path.Join(dir, snapshotInfo), data, 0600)
+		}
+	}
+
+	if err != nil {
+		removeSnapshot(name)
+		return nil, err
+	}
+
+	c.log.Log(stream.LevelOperator, "snapshot '%s' taken", name)
+	return snapshot, nil
+}
+
+//restoreSnapshot creates the sandbox subvolume of the container from its snapshot
+func (c *container) restoreSnapshot() error {
+	dir := snapshotPath(c.Args.Snapshot)
+	if _, err := pm.System("btrfs", "subvolume", "snapshot",
+		path.Join(dir, snapshotLayer), path.Join(BackendBaseDir, c.name())); err != nil {
+		return err
+	}
+
+	rootfs := path.Join(dir, snapshotRootFS)
+	if _, err := os.Stat(rootfs); os.IsNotExist(err) {
+		return nil
+	}
+
+	//nested subvolumes are left as empty directories by the snapshot, the rootfs has its own snapshot
+	os.Remove(c.rootfs())
+	_, err := pm.System("btrfs", "subvolume", "snapshot", rootfs, c.rootfs())
+	return err
+}
+
+func (m *containerManager) snapshot(cmd *pm.Command) (interface{}, error) {
+	var args ContainerSnapshotArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	m.conM.RLock()
+	container, ok := m.containers[args.Container]
+	m.conM.RUnlock()
+
+	if !ok {
+		return nil, pm.NotFoundError(fmt.Errorf("container does not exist"))
+	}
+
+	if len(args.Name) == 0 {
+		args.Name = fmt.Sprintf("%s-%d", container.name(), time.Now().Unix())
+	}
+
+	if err := validateSnapshotName(args.Name); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	return container.Snapshot(args.Name)
+}
+
+func (m *containerManager) snapshotList(cmd *pm.Command) (interface{}, error) {
+	var args ContainerSnapshotListArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	snapshots, err := listSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Snapshot, 0)
+	for _, snapshot := range snapshots {
+		if args.Container != 0 && snapshot.Container != args.Container {
+			continue
+		}
+
+		result = append(result, snapshot)
+	}
+
+	return result, nil
+}
+
+//snapshotRemove removes a snapshot, a snapshot can't be removed while a container is created from it
+func (m *containerManager) snapshotRemove(cmd *pm.Command) (interface{}, error) {
+	var args ContainerSnapshotRemoveArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	if _, err := loadSnapshot(args.Name); err != nil {
+		return nil, err
+	}
+
+	if id, ok := m.snapshotUser(args.Name); ok {
+		return nil, pm.PreconditionFailedError(fmt.Errorf("snapshot '%s' is used by container %d", args.Name, id))
+	}
+
+	return nil, removeSnapshot(args.Name)
+}
+
+//snapshotUser gets a container created from the snapshot, including the persistent containers that are not
+//recreated yet
+func (m *containerManager) snapshotUser(name string) (uint16, bool) {
+	m.conM.RLock()
+	defer m.conM.RUnlock()
+
+	for id, c := range m.containers {
+		if c.Args.Snapshot == name {
+			return id, true
+		}
+	}
+
+	m.seqM.Lock()
+	defer m.seqM.Unlock()
+
+	for id, spec := range m.specs {
+		if spec.Snapshot == name {
+			return id, true
+		}
+	}
+
+	return 0, false
+}
+
+//clone creates a new container from a snapshot, with the spec of the snapshotted container and new networking
+func (m *containerManager) clone(cmd *pm.Command) (interface{}, error) {
+	var args ContainerCloneArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	snapshot, err := loadSnapshot(args.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := snapshot.Arguments
+	spec.Snapshot = snapshot.Name
+	spec.Name = args.Name
+	spec.Hostname = args.Hostname
+	spec.Nics = args.Nics
+	spec.Port = args.Port
+	spec.Persistent = args.Persistent
+	spec.Tags = cmd.Tags
+	//the zerotier identity and the backups belong to the snapshotted container
+	spec.Identity = ""
+	spec.Backup = nil
+
+	container, err := m.createContainer(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return container.id, nil
+}
@@ -0,0 +1,76 @@
+package containers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSnapshotName(t *testing.T) {
+	for _, name := range []string{"web", "container-1-1500000000", "v1.2_test"} {
+		assert.NoError(t, validateSnapshotName(name), name)
+	}
+
+	for _, name := range []string{"", "../etc", "a/b", ".hidden", "-x", "with space"} {
+		assert.Error(t, validateSnapshotName(name), name)
+	}
+}
+
+func TestListSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := SnapshotsDir
+	SnapshotsDir = dir
+	defer func() {
+		SnapshotsDir = old
+	}()
+
+	snapshots, err := listSnapshots()
+	assert.NoError(t, err)
+	assert.Empty(t, snapshots)
+
+	for _, snapshot := range []Snapshot{
+		{Name: "b", Container: 1, Created: 20},
+		{Name: "a", Container: 2, Created: 20},
+		{Name: "c", Container: 1, Created: 10},
+	} {
+		data, _ := json.Marshal(snapshot)
+		os.MkdirAll(snapshotPath(snapshot.Name), 0755)
+		if err := ioutil.WriteFile(path.Join(snapshotPath(snapshot.Name), snapshotInfo), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//incomplete snapshot
+	os.MkdirAll(snapshotPath("d"), 0755)
+
+	snapshots, err = listSnapshots()
+	if assert.NoError(t, err) && assert.Len(t, snapshots, 3) {
+		assert.Equal(t, "c", snapshots[0].Name)
+		assert.Equal(t, "a", snapshots[1].Name)
+		assert.Equal(t, "b", snapshots[2].Name)
+	}
+
+	snapshot, err := loadSnapshot("a")
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint16(2), snapshot.Container)
+	}
+
+	_, err = loadSnapshot("d")
+	assert.Error(t, err)
+
+	_, err = loadSnapshot("../a")
+	assert.Error(t, err)
+
+	assert.NoError(t, (&ContainerCreateArguments{Root: "/", Snapshot: "a"}).Validate())
+	assert.Error(t, (&ContainerCreateArguments{Root: "/", Snapshot: "e"}).Validate())
+	assert.Error(t, (&ContainerCreateArguments{Root: "/", Snapshot: "a", UserNS: true}).Validate())
+}
@@ -0,0 +1,250 @@
+package containers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/core0/subsys/cgroups"
+)
+
+//MemoryStats memory usage of a container in bytes
+type MemoryStats struct {
+	Usage uint64 `json:"usage"` //total usage including the page cache
+	Cache uint64 `json:"cache"`
+	RSS   uint64 `json:"rss"`
+	Swap  uint64 `json:"swap"`
+}
+
+//NicStats traffic of a container nic as seen from inside the container
+type NicStats struct {
+	RxBytes   uint64 `json:"rxbytes"`
+	RxPackets uint64 `json:"rxpackets"`
+	TxBytes   uint64 `json:"txbytes"`
+	TxPackets uint64 `json:"txpackets"`
+}
+
+//ContainerStats resource usage of the whole container (all its processes), as accounted by the container cgroups
+type ContainerStats struct {
+	CPU     uint64                `json:"cpu"` //cpu time in nanoseconds
+	Memory  MemoryStats           `json:"memory"`
+	Pids    uint64                `json:"pids"`
+	Blkio   cgroups.BlkioServiced `json:"blkio"`
+	Network map[string]NicStats   `json:"network"` //nics inside the container (not set for host networking)
+}
+
+//parseNetDev parses /proc/net/dev, the loopback is skipped
+func parseNetDev(reader io.Reader) (map[string]NicStats, error) {
+	nics := make(map[string]NicStats)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.Index(line, ":")
+		if i < 0 {
+			//header lines
+			continue
+		}
+
+		name := strings.TrimSpace(line[:i])
+		fields := strings.Fields(line[i+1:])
+		if name == "lo" || len(fields) < 10 {
+			continue
+		}
+
+		values := make([]uint64, len(fields))
+		for i, field := range fields {
+			values[i], _ = strconv.ParseUint(field, 10, 64)
+		}
+
+		nics[name] = NicStats{
+			RxBytes:   values[0],
+			RxPackets: values[1],
+			TxBytes:   values[8],
+			TxPackets: values[9],
+		}
+	}
+
+	return nics, scanner.Err()
+}
+
+//network gets the traffic of the container nics from the network namespace of the container process
+func (c *container) network() (map[string]NicStats, error) {
+	if c.Args.HostNetwork || c.PID == 0 {
+		return nil, nil
+	}
+
+	file, err := os.Open(fmt.Sprintf("/proc/%d/net/dev", c.PID))
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+	return parseNetDev(file)
+}
+
+//Stats gets the resource usage of the container, the usage of a cgroup subsystem that is not
+//available on the node is not reported
+func (c *container) Stats() (*ContainerStats, error) {
+	var stats ContainerStats
+	var err error
+
+	if group, ok := c.cgroups[cgroups.CPUAcctSubsystem].(cgroups.CPUAcctGroup); ok {
+		if stats.CPU, err = group.Usage(); err != nil {
+			return nil, err
+		}
+	}
+
+	if group, ok := c.cgroups[cgroups.MemorySubsystem].(cgroups.MemoryGroup); ok {
+		if stats.Memory.Usage, err = group.Usage(); err != nil {
+			return nil, err
+		}
+
+		stat, err := group.Stat()
+		if err != nil {
+			return nil, err
+		}
+
+		stats.Memory.Cache = stat["total_cache"]
+		stats.Memory.RSS = stat["total_rss"]
+		stats.Memory.Swap = stat["total_swap"]
+	}
+
+	if group, ok := c.cgroups[cgroups.PidsSubsystem].(cgroups.PidsGroup); ok {
+		if stats.Pids, err = group.Current(); err != nil {
+			return nil, err
+		}
+	}
+
+	if group, ok := c.cgroups[cgroups.BlkioSubsystem].(cgroups.BlkioGroup); ok {
+		serviced, err := group.Serviced()
+		if err != nil {
+			return nil, err
+		}
+
+		stats.Blkio = *serviced
+	}
+
+	if stats.Network, err = c.network(); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+//stats gets the resource usage of a container, or of all the running containers if no container is given
+func (m *containerManager) stats(cmd *pm.Command) (interface{}, error) {
+	var args ContainerArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	m.conM.RLock()
+	defer m.conM.RUnlock()
+
+	if args.Container != 0 {
+		cont, ok := m.containers[args.Container]
+		if !ok {
+			return nil, pm.NotFoundError(fmt.Errorf("container does not exist"))
+		}
+
+		return cont.Stats()
+	}
+
+	all := make(map[uint16]*ContainerStats)
+	for id, cont := range m.containers {
+		stats, err := cont.Stats()
+		if err != nil {
+			log.Errorf("failed to get container %d stats: %s", id, err)
+			continue
+		}
+
+		all[id] = stats
+	}
+
+	return all, nil
+}
+
+//monitor feeds the stats of all the running containers to the aggregator
+func (m *containerManager) monitor(cmd *pm.Command) (interface{}, error) {
+	m.conM.RLock()
+	containers := make([]*container, 0, len(m.containers))
+	for _, cont := range m.containers {
+		containers = append(containers, cont)
+	}
+	m.conM.RUnlock()
+
+	for _, cont := range containers {
+		stats, err := cont.Stats()
+		if err != nil {
+			log.Errorf("failed to get container %d stats: %s", cont.id, err)
+			continue
+		}
+
+		id := fmt.Sprint(cont.id)
+		tags := []pm.Tag{{Key: "type", Value: "container"}}
+		if len(cont.Args.Tags) != 0 {
+			tags = append(tags, pm.Tag{Key: "tags", Value: strings.Join(cont.Args.Tags, ",")})
+		}
+
+		//convert time from nano to seconds
+		pm.Aggregate(pm.AggreagteDifference,
+			"container.cpu.time", float64(stats.CPU)/1000000000., id, tags...,
+		)
+
+		pm.Aggregate(pm.AggreagteAverage,
+			"container.memory.usage", float64(stats.Memory.Usage)/(1024.*1024.), id, tags...,
+		)
+
+		pm.Aggregate(pm.AggreagteAverage,
+			"container.memory.cache", float64(stats.Memory.Cache)/(1024.*1024.), id, tags...,
+		)
+
+		pm.Aggregate(pm.AggreagteAverage,
+			"container.pids", float64(stats.Pids), id, tags...,
+		)
+
+		pm.Aggregate(pm.AggreagteDifference,
+			"container.disk.throughput.read", float64(stats.Blkio.ReadBytes/1024), id, tags...,
+		)
+
+		pm.Aggregate(pm.AggreagteDifference,
+			"container.disk.throughput.write", float64(stats.Blkio.WriteBytes/1024), id, tags...,
+		)
+
+		pm.Aggregate(pm.AggreagteDifference,
+			"container.disk.iops.read", float64(stats.Blkio.ReadOps), id, tags...,
+		)
+
+		pm.Aggregate(pm.AggreagteDifference,
+			"container.disk.iops.write", float64(stats.Blkio.WriteOps), id, tags...,
+		)
+
+		for name, nic := range stats.Network {
+			nicID := fmt.Sprintf("%s.%s", id, name)
+			nicTags := append([]pm.Tag{{Key: "name", Value: name}}, tags...)
+
+			pm.Aggregate(pm.AggreagteDifference,
+				"container.network.throughput.incoming", float64(nic.RxBytes)/(1024.*1024.), nicID, nicTags...,
+			)
+
+			pm.Aggregate(pm.AggreagteDifference,
+				"container.network.throughput.outgoing", float64(nic.TxBytes)/(1024.*1024.), nicID, nicTags...,
+			)
+
+			pm.Aggregate(pm.AggreagteDifference,
+				"container.network.packets.rx", float64(nic.RxPackets), nicID, nicTags...,
+			)
+
+			pm.Aggregate(pm.AggreagteDifference,
+				"container.network.packets.tx", float64(nic.TxPackets), nicID, nicTags...,
+			)
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,26 @@
+package containers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNetDev(t *testing.T) {
+	data := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    1024      10    0    0    0     0          0         0     1024      10    0    0    0     0       0          0
+  eth0: 2048576    1500    0    0    0     0          0         0   102400     800    0    0    0     0       0          0
+   zt0:     300       3    0    0    0     0          0         0      400       4    0    0    0     0       0          0
+`
+
+	nics, err := parseNetDev(strings.NewReader(data))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.Len(t, nics, 2)
+	assert.Equal(t, NicStats{RxBytes: 2048576, RxPackets: 1500, TxBytes: 102400, TxPackets: 800}, nics["eth0"])
+	assert.Equal(t, NicStats{RxBytes: 300, RxPackets: 3, TxBytes: 400, TxPackets: 4}, nics["zt0"])
+}
@@ -0,0 +1,255 @@
+package containers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/settings"
+	"github.com/zero-os/0-core/base/utils"
+)
+
+const (
+	//defaults of the node subordinate id range, the container with id N gets the host ids
+	//[start + N * size, start + (N + 1) * size)
+	defaultUserNSStart = 100000
+	defaultUserNSSize  = 65536
+
+	//userNSMarker keeps the first host id the root files were shifted to, it's in the writable layer of the
+	//root so it follows the files (on snapshot restore for example)
+	userNSMarker = ".corex.userns"
+)
+
+type device struct {
+	name  string
+	mode  uint32
+	major int
+	minor int
+}
+
+var (
+	//minimum devices of a container that runs in a user namespace (same as coreX unprivileged /dev)
+	userNSDevices = []device{
+		{"console", syscall.S_IFCHR | 0600, 136, 2},
+		{"full", syscall.S_IFCHR | 0666, 1, 7},
+		{"null", syscall.S_IFCHR | 0666, 1, 3},
+		{"random", syscall.S_IFCHR | 0666, 1, 8},
+		{"tty", syscall.S_IFCHR | 0666, 5, 0},
+		{"urandom", syscall.S_IFCHR | 0666, 1, 9},
+		{"zero", syscall.S_IFCHR | 0666, 1, 5},
+	}
+)
+
+//userNSRange gets the first host id and the number of ids mapped for the container id
+func userNSRange(id uint16, start, size uint64) (int, int, error) {
+	if size == 0 {
+		return 0, 0, fmt.Errorf("invalid user namespace size")
+	}
+
+	first := start + uint64(id)*size
+	if first+size-1 > math.MaxUint32 {
+		return 0, 0, fmt.Errorf("container %d is out of the subordinate id range", id)
+	}
+
+	return int(first), int(size), nil
+}
+
+//idRange gets the host ids range of the container from the node settings
+func (c *container) idRange() (int, int, error) {
+	start := uint64(settings.Settings.Containers.UserNSStart)
+	if start == 0 {
+		start = defaultUserNSStart
+	}
+
+	size := uint64(settings.Settings.Containers.UserNSSize)
+	if size == 0 {
+		size = defaultUserNSSize
+	}
+
+	return userNSRange(c.id, start, size)
+}
+
+//idMappings gets the uid and gid mappings of the container, nil if the container doesn't use a user namespace
+func (c *container) idMappings() ([]pm.IDMap, error) {
+	if !c.Args.UserNS {
+		return nil, nil
+	}
+
+	host, size, err := c.idRange()
+	if err != nil {
+		return nil, err
+	}
+
+	return []pm.IDMap{{ContainerID: 0, HostID: host, Size: size}}, nil
+}
+
+//IDMappings gets the uid and gid mappings of the container user namespace
+func (c *container) IDMappings() ([]pm.IDMap, error) {
+	return c.idMappings()
+}
+
+//shiftedTo gets the first host id the files of root were shifted to by a previous start, -1 if they were
+//not shifted
+func shiftedTo(root string) int {
+	file, err := os.OpenFile(path.Join(root, userNSMarker), os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return -1
+	}
+
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return -1
+	}
+
+	host, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || host < 0 {
+		return -1
+	}
+
+	return host
+}
+
+/*
+shiftOwnership changes the owner of all the files under root from [0, size) to [host, host + size), files
+that were shifted to another range (the container id changed) are moved to the new range too. Mounts under
+root are not crossed. Chown clears the setuid and setgid bits so they are set again.
+
+Chown copies the files up to the writable layer of the root, so the files are only shifted if they were not
+shifted to the same range already.
+*/
+func shiftOwnership(root string, host, size int) error {
+	old := shiftedTo(root)
+	if old == host {
+		return nil
+	}
+
+	shift := func(id int) int {
+		if id < size {
+			return id + host
+		} else if old >= 0 && id >= old && id < old+size {
+			return id - old + host
+		}
+
+		return id
+	}
+
+	if err := shiftFiles(root, shift); err != nil {
+		return err
+	}
+
+	_, err := writeInRoot(root, userNSMarker, []byte(strconv.Itoa(host)), 0400, -1, -1)
+	return err
+}
+
+//shiftFiles changes the owner of all the files under root with shift
+func shiftFiles(root string, shift func(int) int) error {
+	var rootStat syscall.Stat_t
+	if err := syscall.Lstat(root, &rootStat); err != nil {
+		return err
+	}
+
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+
+		if stat.Dev != rootStat.Dev {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		uid, gid := shift(int(stat.Uid)), shift(int(stat.Gid))
+		if uid == int(stat.Uid) && gid == int(stat.Gid) {
+			return nil
+		}
+
+		if err := os.Lchown(p, uid, gid); err != nil {
+			return err
+		}
+
+		mode := info.Mode()
+		if mode&os.ModeSymlink == 0 && mode&(os.ModeSetuid|os.ModeSetgid) != 0 {
+			return os.Chmod(p, mode)
+		}
+
+		return nil
+	})
+}
+
+//writeFile writes a file in the container root, owned by the container root user
+func (c *container) writeFile(name string, data []byte, perm os.FileMode) (string, error) {
+	owner := -1
+	if c.Args.UserNS {
+		host, _, err := c.idRange()
+		if err != nil {
+			return "", err
+		}
+
+		owner = host
+	}
+
+	return writeInRoot(c.root(), name, data, perm, owner, owner)
+}
+
+//setUpUserNS prepares the container root to run in a user namespace, the root files are owned by
+//the mapped ids and /dev is populated since device nodes can't be created inside the container
+func (c *container) setUpUserNS() error {
+	host, size, err := c.idRange()
+	if err != nil {
+		return err
+	}
+
+	root := c.root()
+	if err := shiftOwnership(root, host, size); err != nil {
+		return fmt.Errorf("shift-ownership(%s)", err)
+	}
+
+	//dev is resolved inside the root, the image or the writable layer can have it as a symlink
+	dev, err := utils.InRoot(root, "/dev")
+	if err != nil {
+		return err
+	}
+
+	if info, err := os.Lstat(dev); os.IsNotExist(err) {
+		if err := os.Mkdir(dev, 0755); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dev)
+	}
+
+	if err := syscall.Mount("none", dev, "tmpfs", syscall.MS_NOSUID,
+		fmt.Sprintf("mode=755,uid=%d,gid=%d", host, host)); err != nil {
+		return fmt.Errorf("mount-dev(%s)", err)
+	}
+
+	for _, d := range userNSDevices {
+		p := path.Join(dev, d.name)
+		if err := syscall.Mknod(p, d.mode, d.major<<8|d.minor); err != nil {
+			return fmt.Errorf("failed to create device %s: %s", d.name, err)
+		}
+
+		if err := os.Lchown(p, host, host); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
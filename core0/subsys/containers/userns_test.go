@@ -0,0 +1,102 @@
+package containers
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserNSRange(t *testing.T) {
+	host, size, err := userNSRange(1, 100000, 65536)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 165536, host)
+		assert.Equal(t, 65536, size)
+	}
+
+	_, _, err = userNSRange(math.MaxUint16, 100000, 65536)
+	assert.Error(t, err)
+
+	_, _, err = userNSRange(1, 100000, 0)
+	assert.Error(t, err)
+}
+
+func TestShiftOwnership(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	root, err := ioutil.TempDir("", "userns")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(root)
+
+	bin := path.Join(root, "bin")
+	assert.NoError(t, os.Mkdir(bin, 0755))
+	assert.NoError(t, ioutil.WriteFile(path.Join(bin, "su"), nil, 0755|os.ModeSetuid))
+	assert.NoError(t, os.Chmod(path.Join(bin, "su"), 0755|os.ModeSetuid))
+	assert.NoError(t, os.Symlink("su", path.Join(bin, "link")))
+	assert.NoError(t, os.Lchown(path.Join(bin, "link"), 1000, 1000))
+
+	if !assert.NoError(t, shiftOwnership(root, 100000, 65536)) {
+		t.FailNow()
+	}
+
+	for name, id := range map[string]uint32{"": 100000, "bin": 100000, "bin/su": 100000, "bin/link": 101000} {
+		var stat syscall.Stat_t
+		if assert.NoError(t, syscall.Lstat(path.Join(root, name), &stat)) {
+			assert.Equal(t, id, stat.Uid, name)
+			assert.Equal(t, id, stat.Gid, name)
+		}
+	}
+
+	info, err := os.Stat(path.Join(bin, "su"))
+	if assert.NoError(t, err) {
+		assert.True(t, info.Mode()&os.ModeSetuid != 0)
+	}
+}
+
+func TestShiftOwnershipMarker(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	root, err := ioutil.TempDir("", "userns")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(root)
+
+	file := path.Join(root, "file")
+	assert.NoError(t, ioutil.WriteFile(file, nil, 0644))
+	assert.NoError(t, os.Lchown(file, 1000, 1000))
+
+	if !assert.NoError(t, shiftOwnership(root, 100000, 65536)) {
+		t.FailNow()
+	}
+	assert.Equal(t, 100000, shiftedTo(root))
+
+	//the files are not shifted again to the same range
+	assert.NoError(t, os.Lchown(file, 5, 5))
+	assert.NoError(t, shiftOwnership(root, 100000, 65536))
+
+	var stat syscall.Stat_t
+	if assert.NoError(t, syscall.Lstat(file, &stat)) {
+		assert.Equal(t, uint32(5), stat.Uid)
+	}
+
+	//the files are moved to the new range when the container id changes
+	assert.NoError(t, os.Lchown(file, 101000, 101000))
+	assert.NoError(t, shiftOwnership(root, 200000, 65536))
+	assert.Equal(t, 200000, shiftedTo(root))
+
+	if assert.NoError(t, syscall.Lstat(file, &stat)) {
+		assert.Equal(t, uint32(201000), stat.Uid)
+		assert.Equal(t, uint32(201000), stat.Gid)
+	}
+}
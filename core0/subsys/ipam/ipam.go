@@ -0,0 +1,425 @@
+package ipam
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/zero-os/0-core/base/pm"
+)
+
+const (
+	cmdIPAMList    = "ipam.list"
+	cmdIPAMRelease = "ipam.release"
+)
+
+var (
+	log = logging.MustGetLogger("ipam")
+
+	//LeasesDir is where the sticky leases are kept, one file per network named after the bridge
+	LeasesDir = "/var/cache/ipam"
+
+	networks = make(map[string]*network)
+	m        sync.Mutex
+)
+
+/*
+Lease is an ip allocated on a bridge. A lease with a name is sticky, it's kept when the owner releases
+it so the next owner with the same name gets the same ip.
+*/
+type Lease struct {
+	Network string `json:"network"`
+	IP      string `json:"ip"`
+	Owner   string `json:"owner"` //container-<id> or vm-<uuid>, empty if the ip is only reserved for the name
+	Name    string `json:"name"`
+	Created int64  `json:"created"`
+}
+
+type network struct {
+	name    string
+	subnet  *net.IPNet
+	gateway uint32
+	first   uint32
+	last    uint32
+	leases  map[uint32]*Lease
+}
+
+type ListArguments struct {
+	Network string `json:"network"` //all the networks if not set
+}
+
+type ReleaseArguments struct {
+	Network string `json:"network"`
+	IP      string `json:"ip"`
+}
+
+func init() {
+	pm.RegisterBuiltIn(cmdIPAMList, list)
+	pm.RegisterBuiltIn(cmdIPAMRelease, release)
+}
+
+func toUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func toIP(n uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
+
+func leasesPath(name string) string {
+	return path.Join(LeasesDir, name+".json")
+}
+
+func newNetwork(name string, addr *net.IPNet) (*network, error) {
+	ip := addr.IP.To4()
+	if ip == nil {
+		return nil, fmt.Errorf("only ipv4 networks are supported")
+	}
+
+	ones, bits := addr.Mask.Size()
+	if bits-ones < 2 {
+		return nil, fmt.Errorf("network '%s' is too small", addr)
+	}
+
+	base := toUint32(ip.Mask(addr.Mask))
+	return &network{
+		name:    name,
+		subnet:  &net.IPNet{IP: toIP(base), Mask: addr.Mask},
+		gateway: toUint32(ip),
+		first:   base + 1,
+		last:    base + (1 << uint(bits-ones)) - 2,
+		leases:  make(map[uint32]*Lease),
+	}, nil
+}
+
+//load loads the sticky leases of the network, leases outside the network are dropped
+func (n *network) load() {
+	data, err := ioutil.ReadFile(leasesPath(n.name))
+	if err != nil {
+		return
+	}
+
+	var leases []*Lease
+	if err := json.Unmarshal(data, &leases); err != nil {
+		log.Errorf("failed to load leases of network '%s': %s", n.name, err)
+		return
+	}
+
+	for _, lease := range leases {
+		ip := net.ParseIP(lease.IP)
+		if ip == nil || ip.To4() == nil || !n.contains(toUint32(ip)) || len(lease.Name) == 0 {
+			continue
+		}
+
+		lease.Owner = ""
+		n.leases[toUint32(ip)] = lease
+	}
+}
+
+//save writes the sticky leases of the network
+func (n *network) save() {
+	var leases []*Lease
+	for _, lease := range n.leases {
+		if len(lease.Name) != 0 {
+			leases = append(leases, lease)
+		}
+	}
+
+	sort.Slice(leases, func(i, j int) bool {
+		return toUint32(net.ParseIP(leases[i].IP)) < toUint32(net.ParseIP(leases[j].IP))
+	})
+
+	data, err := json.Marshal(leases)
+	if err == nil {
+		os.MkdirAll(LeasesDir, 0755)
+		err = ioutil.WriteFile(leasesPath(n.name), data, 0644)
+	}
+
+	if err != nil {
+		log.Errorf("failed to save leases of network '%s': %s", n.name, err)
+	}
+}
+
+func (n *network) contains(ip uint32) bool {
+	return ip >= n.first && ip <= n.last && ip != n.gateway
+}
+
+func (n *network) lease(ip uint32, owner, name string) *net.IPNet {
+	n.leases[ip] = &Lease{
+		Network: n.name,
+		IP:      toIP(ip).String(),
+		Owner:   owner,
+		Name:    name,
+		Created: time.Now().Unix(),
+	}
+
+	if len(name) != 0 {
+		n.save()
+	}
+
+	return &net.IPNet{IP: toIP(ip), Mask: n.subnet.Mask}
+}
+
+//named gets the lease reserved for name
+func (n *network) named(name string) (uint32, *Lease) {
+	for ip, lease := range n.leases {
+		if len(name) != 0 && lease.Name == name {
+			return ip, lease
+		}
+	}
+
+	return 0, nil
+}
+
+func (n *network) allocate(owner, name string, requested net.IP) (*net.IPNet, error) {
+	reservedIP, reserved := n.named(name)
+	if reserved != nil && len(reserved.Owner) != 0 {
+		//the name is used by another owner, the ip is not sticky then
+		reserved, name = nil, ""
+	}
+
+	if requested != nil {
+		if requested.To4() == nil || !n.contains(toUint32(requested)) {
+			return nil, pm.BadRequestError(fmt.Errorf("ip '%s' is not available in network '%s' (%s)", requested, n.name, n.subnet))
+		}
+
+		ip := toUint32(requested)
+		if lease, ok := n.leases[ip]; ok && (len(lease.Owner) != 0 || lease.Name != name) {
+			return nil, pm.PreconditionFailedError(fmt.Errorf("ip '%s' is already allocated", requested))
+		}
+
+		if reserved != nil && reservedIP != ip {
+			//the name moves to the requested ip
+			delete(n.leases, reservedIP)
+		}
+
+		return n.lease(ip, owner, name), nil
+	}
+
+	if reserved != nil {
+		return n.lease(reservedIP, owner, name), nil
+	}
+
+	for ip := n.first; ip <= n.last; ip++ {
+		if _, ok := n.leases[ip]; ok || ip == n.gateway {
+			continue
+		}
+
+		return n.lease(ip, owner, name), nil
+	}
+
+	return nil, pm.PreconditionFailedError(fmt.Errorf("no ip available in network '%s'", n.name))
+}
+
+//release releases the leases of owner, sticky leases are kept for their name
+func (n *network) release(owner string) {
+	changed := false
+	for ip, lease := range n.leases {
+		if lease.Owner != owner {
+			continue
+		}
+
+		if len(lease.Name) == 0 {
+			delete(n.leases, ip)
+			continue
+		}
+
+		lease.Owner = ""
+		changed = true
+	}
+
+	if changed {
+		n.save()
+	}
+}
+
+/*
+Register manages the ips of a bridge, addr is the bridge address (the gateway of the network). If the bridge
+is registered again with the same network the current leases are kept.
+*/
+func Register(name string, addr *net.IPNet) error {
+	n, err := newNetwork(name, addr)
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if current, ok := networks[name]; ok && current.subnet.String() == n.subnet.String() && current.gateway == n.gateway {
+		return nil
+	}
+
+	n.load()
+	networks[name] = n
+	return nil
+}
+
+//Unregister stops managing the ips of a bridge, the sticky leases are kept in case the bridge is created again
+func Unregister(name string) {
+	m.Lock()
+	defer m.Unlock()
+
+	delete(networks, name)
+}
+
+//Managed checks if the ips of a bridge are managed
+func Managed(name string) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	_, ok := networks[name]
+	return ok
+}
+
+/*
+Allocate allocates an ip on a managed network for owner. If ip is set, this ip is allocated if it's free. If
+name is set the lease is sticky, the ip reserved for the name is reused (or the name is moved to the requested ip).
+The ip is returned with the network mask.
+*/
+func Allocate(name, owner, lease string, ip net.IP) (*net.IPNet, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	n, ok := networks[name]
+	if !ok {
+		return nil, pm.NotFoundError(fmt.Errorf("network '%s' is not managed", name))
+	}
+
+	return n.allocate(owner, lease, ip)
+}
+
+//rename changes the name of the leases of owner, a reservation of another ip for the new name is dropped
+func (n *network) rename(owner, name string) {
+	if _, reserved := n.named(name); reserved != nil && len(reserved.Owner) != 0 && reserved.Owner != owner {
+		//the name is used by another owner, the ips are not sticky then
+		name = ""
+	}
+
+	changed := false
+	for ip, lease := range n.leases {
+		if lease.Owner == owner && lease.Name != name {
+			lease.Name = name
+			changed = true
+		} else if len(lease.Owner) == 0 && len(name) != 0 && lease.Name == name {
+			delete(n.leases, ip)
+			changed = true
+		}
+	}
+
+	if changed {
+		n.save()
+	}
+}
+
+//Release releases the leases of owner on a network
+func Release(name, owner string) {
+	m.Lock()
+	defer m.Unlock()
+
+	if n, ok := networks[name]; ok {
+		n.release(owner)
+	}
+}
+
+//ReleaseAll releases the leases of owner on all networks
+func ReleaseAll(owner string) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, n := range networks {
+		n.release(owner)
+	}
+}
+
+//Rename changes the name of the leases of owner on all networks, so the sticky ips follow the new name
+func Rename(owner, name string) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, n := range networks {
+		n.rename(owner, name)
+	}
+}
+
+func list(cmd *pm.Command) (interface{}, error) {
+	var args ListArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if len(args.Network) != 0 {
+		if _, ok := networks[args.Network]; !ok {
+			return nil, pm.NotFoundError(fmt.Errorf("network '%s' is not managed", args.Network))
+		}
+	}
+
+	leases := make([]Lease, 0)
+	for _, n := range networks {
+		if len(args.Network) != 0 && n.name != args.Network {
+			continue
+		}
+
+		for _, lease := range n.leases {
+			leases = append(leases, *lease)
+		}
+	}
+
+	sort.Slice(leases, func(i, j int) bool {
+		if leases[i].Network != leases[j].Network {
+			return leases[i].Network < leases[j].Network
+		}
+
+		return toUint32(net.ParseIP(leases[i].IP)) < toUint32(net.ParseIP(leases[j].IP))
+	})
+
+	return leases, nil
+}
+
+//release removes a lease or a sticky reservation, an ip in use can't be released
+func release(cmd *pm.Command) (interface{}, error) {
+	var args ReleaseArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	ip := net.ParseIP(args.IP)
+	if ip == nil || ip.To4() == nil {
+		return nil, pm.BadRequestError(fmt.Errorf("invalid ip '%s'", args.IP))
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	n, ok := networks[args.Network]
+	if !ok {
+		return nil, pm.NotFoundError(fmt.Errorf("network '%s' is not managed", args.Network))
+	}
+
+	lease, ok := n.leases[toUint32(ip)]
+	if !ok {
+		return nil, pm.NotFoundError(fmt.Errorf("ip '%s' is not allocated", args.IP))
+	}
+
+	if len(lease.Owner) != 0 {
+		return nil, pm.PreconditionFailedError(fmt.Errorf("ip '%s' is in use by '%s'", args.IP, lease.Owner))
+	}
+
+	delete(n.leases, toUint32(ip))
+	n.save()
+
+	return nil, nil
+}
@@ -0,0 +1,159 @@
+package ipam
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setUp(t *testing.T, cidr string) string {
+	dir, err := ioutil.TempDir("", "ipam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	LeasesDir = dir
+
+	ip, n, _ := net.ParseCIDR(cidr)
+	n.IP = ip
+	if err := Register("br0", n); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func tearDown(dir string) {
+	Unregister("br0")
+	os.RemoveAll(dir)
+}
+
+func TestAllocate(t *testing.T) {
+	dir := setUp(t, "10.20.0.1/29")
+	defer tearDown(dir)
+
+	assert.True(t, Managed("br0"))
+	assert.False(t, Managed("br1"))
+
+	ip, err := Allocate("br0", "container-1", "", nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "10.20.0.2/29", ip.String())
+	}
+
+	ip, err = Allocate("br0", "container-2", "", net.ParseIP("10.20.0.6"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "10.20.0.6/29", ip.String())
+	}
+
+	_, err = Allocate("br0", "container-3", "", net.ParseIP("10.20.0.6"))
+	assert.Error(t, err)
+
+	//network, gateway and broadcast can't be allocated
+	for _, requested := range []string{"10.20.0.0", "10.20.0.1", "10.20.0.7", "10.30.0.2"} {
+		_, err = Allocate("br0", "container-3", "", net.ParseIP(requested))
+		assert.Error(t, err, requested)
+	}
+
+	for _, expected := range []string{"10.20.0.3/29", "10.20.0.4/29", "10.20.0.5/29"} {
+		ip, err = Allocate("br0", "vm-uuid", "", nil)
+		if assert.NoError(t, err) {
+			assert.Equal(t, expected, ip.String())
+		}
+	}
+
+	_, err = Allocate("br0", "container-4", "", nil)
+	assert.Error(t, err)
+
+	ReleaseAll("vm-uuid")
+	ip, err = Allocate("br0", "container-4", "", nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "10.20.0.3/29", ip.String())
+	}
+
+	_, err = Allocate("br1", "container-4", "", nil)
+	assert.Error(t, err)
+}
+
+func TestStickyLease(t *testing.T) {
+	dir := setUp(t, "10.20.0.1/24")
+	defer tearDown(dir)
+
+	Allocate("br0", "container-1", "", nil)
+	ip, err := Allocate("br0", "container-2", "web", nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "10.20.0.3/24", ip.String())
+	}
+
+	//the ip is kept for the name
+	Release("br0", "container-1")
+	Release("br0", "container-2")
+	ip, err = Allocate("br0", "container-3", "", nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "10.20.0.2/24", ip.String())
+	}
+
+	ip, err = Allocate("br0", "container-4", "", nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "10.20.0.4/24", ip.String())
+	}
+
+	//and survives a reload of the network
+	Unregister("br0")
+	_, n, _ := net.ParseCIDR("10.20.0.1/24")
+	n.IP = net.ParseIP("10.20.0.1")
+	assert.NoError(t, Register("br0", n))
+
+	ip, err = Allocate("br0", "container-5", "web", nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "10.20.0.3/24", ip.String())
+	}
+
+	//a second owner with the same name doesn't get a sticky lease
+	ip, err = Allocate("br0", "container-6", "web", nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "10.20.0.2/24", ip.String())
+	}
+
+	//requesting another ip moves the name
+	Release("br0", "container-5")
+	ip, err = Allocate("br0", "container-7", "web", net.ParseIP("10.20.0.100"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "10.20.0.100/24", ip.String())
+	}
+
+	_, reserved := networks["br0"].named("web")
+	assert.Equal(t, "10.20.0.100", reserved.IP)
+	_, ok := networks["br0"].leases[toUint32(net.ParseIP("10.20.0.3"))]
+	assert.False(t, ok)
+}
+
+func TestRename(t *testing.T) {
+	dir := setUp(t, "10.20.0.1/24")
+	defer tearDown(dir)
+
+	Allocate("br0", "container-1", "web", nil)
+	Allocate("br0", "container-2", "db", nil)
+	Release("br0", "container-2")
+
+	//the ip follows the new name and the old reservation of the name is dropped
+	Rename("container-1", "db")
+	_, reserved := networks["br0"].named("db")
+	if assert.NotNil(t, reserved) {
+		assert.Equal(t, "10.20.0.2", reserved.IP)
+		assert.Equal(t, "container-1", reserved.Owner)
+	}
+
+	_, reserved = networks["br0"].named("web")
+	assert.Nil(t, reserved)
+	assert.Len(t, networks["br0"].leases, 1)
+
+	//a name in use by another owner is not sticky
+	Allocate("br0", "container-3", "", nil)
+	Rename("container-3", "db")
+	lease := networks["br0"].leases[toUint32(net.ParseIP("10.20.0.3"))]
+	if assert.NotNil(t, lease) {
+		assert.Equal(t, "", lease.Name)
+	}
+}
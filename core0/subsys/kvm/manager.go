@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"net"
 	"net/url"
 	"regexp"
 	"strings"
@@ -21,6 +22,7 @@ import (
 	"github.com/zero-os/0-core/core0/helper"
 	"github.com/zero-os/0-core/core0/screen"
 	"github.com/zero-os/0-core/core0/subsys/containers"
+	"github.com/zero-os/0-core/core0/subsys/ipam"
 	"gopkg.in/yaml.v2"
 )
 
@@ -165,6 +167,7 @@ type Nic struct {
 	Type      string `json:"type"`
 	ID        string `json:"id"`
 	HWAddress string `json:"hwaddr"`
+	IP        string `json:"ip"` //requested ip of the default nic, allocated by the ipam if not set
 }
 type NicParams struct {
 	Nics []Nic       `json:"nics"`
@@ -229,6 +232,9 @@ func (c *NicParams) Valid() error {
 			if brcounter[DefaultBridgeName] > 1 {
 				return fmt.Errorf("only one default network is allowed")
 			}
+			if len(nic.IP) != 0 && net.ParseIP(nic.IP).To4() == nil {
+				return fmt.Errorf("invalid ip '%s'", nic.IP)
+			}
 		case "bridge":
 			if nic.ID == DefaultBridgeName {
 				return fmt.Errorf("cannot use bridge %s with nic type 'bridge', please use type default instead", DefaultBridgeName)
@@ -662,8 +668,9 @@ func (m *kvmManager) macAddr(s uint16) string {
 	)
 }
 
-func (m *kvmManager) ipAddr(s uint16) string {
-	return fmt.Sprintf("%d.%d.%d.%d", BridgeIP[0], BridgeIP[1], (s&0xff00)>>8, s&0x00ff)
+//owner the owner of the vm ips in the ipam
+func (m *kvmManager) owner(uuid string) string {
+	return fmt.Sprintf("vm-%s", uuid)
 }
 
 func (m *kvmManager) mkDomain(seq uint16, params *CreateParams) (*Domain, error) {
@@ -748,9 +755,7 @@ func (m *kvmManager) mkDomain(seq uint16, params *CreateParams) (*Domain, error)
 	return &domain, nil
 }
 
-func (m *kvmManager) setPortForwards(uuid string, seq uint16, port map[int]int) error {
-	ip := m.ipAddr(seq)
-
+func (m *kvmManager) setPortForwards(uuid string, ip string, port map[int]int) error {
 	for host, container := range port {
 		//nft add rule nat prerouting iif eth0 tcp dport { 80, 443 } dnat 192.168.1.120
 		cmd := &pm.Command{
@@ -891,7 +896,7 @@ func (m *kvmManager) create(cmd *pm.Command) (interface{}, error) {
 		domain.Devices.Filesystems = append(domain.Devices.Filesystems, fs)
 	}
 
-	if err := m.setNetworking(&params.NicParams, seq, domain); err != nil {
+	if err := m.setNetworking(&params.NicParams, params.Name, seq, domain); err != nil {
 		return nil, err
 	}
 
@@ -907,6 +912,8 @@ func (m *kvmManager) create(cmd *pm.Command) (interface{}, error) {
 	//create domain
 	_, err = conn.DomainCreateXML(string(data), libvirt.DOMAIN_NONE)
 	if err != nil {
+		m.unPortForward(domain.UUID)
+		ipam.ReleaseAll(m.owner(domain.UUID))
 		return nil, fmt.Errorf("failed to create machine: %s", err)
 	}
 
@@ -952,7 +959,7 @@ func (m *kvmManager) prepareMigrationTarget(cmd *pm.Command) (interface{}, error
 	}
 
 	seq := m.getNextSequence()
-	if err := m.setNetworking(&params.NicParams, seq, &domain); err != nil {
+	if err := m.setNetworking(&params.NicParams, "", seq, &domain); err != nil {
 		return nil, err
 	}
 	return nil, nil
@@ -981,6 +988,7 @@ func (m *kvmManager) destroyDomain(uuid string, domain *libvirt.Domain) error {
 	}
 
 	m.unPortForward(uuid)
+	ipam.ReleaseAll(m.owner(uuid))
 	return nil
 }
 
@@ -1004,6 +1012,7 @@ func (m *kvmManager) shutdown(cmd *pm.Command) (interface{}, error) {
 	}
 
 	m.unPortForward(uuid)
+	ipam.ReleaseAll(m.owner(uuid))
 
 	return nil, nil
 }
@@ -1210,6 +1219,7 @@ func (m *kvmManager) addNic(cmd *pm.Command) (interface{}, error) {
 		Type:      params.Type,
 		ID:        params.ID,
 		HWAddress: params.HWAddress,
+		IP:        params.IP,
 	}
 
 	domainstruct, err := m.getDomainStruct(params.UUID)
@@ -1226,7 +1236,7 @@ func (m *kvmManager) addNic(cmd *pm.Command) (interface{}, error) {
 		}
 		seq := m.getNextSequence()
 		// TODO: use the ports that the domain was created with initially
-		inf, err = m.prepareDefaultNetwork(params.UUID, seq, map[int]int{})
+		inf, err = m.prepareDefaultNetwork(params.UUID, domainstruct.Name, seq, &nic, map[int]int{})
 	case "bridge":
 		if nic.ID == DefaultBridgeName {
 			err = fmt.Errorf("the default bridge for the vm should not be added manually")
@@ -1317,7 +1327,15 @@ func (m *kvmManager) removeNic(cmd *pm.Command) (interface{}, error) {
 	if err != nil {
 		return nil, fmt.Errorf("cannot marshal nic to xml")
 	}
-	return nil, m.detachDevice(params.UUID, string(ifxml[:]))
+	if err := m.detachDevice(params.UUID, string(ifxml[:])); err != nil {
+		return nil, err
+	}
+
+	if nic.Type == "default" {
+		ipam.Release(DefaultBridgeName, m.owner(params.UUID))
+	}
+
+	return nil, nil
 }
 
 func (m *kvmManager) limitDiskIO(cmd *pm.Command) (interface{}, error) {
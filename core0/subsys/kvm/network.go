@@ -14,6 +14,7 @@ import (
 	"github.com/pborman/uuid"
 	"github.com/vishvananda/netlink"
 	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/core0/subsys/ipam"
 )
 
 const (
@@ -46,7 +47,7 @@ func (m *kvmManager) setVirtNetwork(network Network) error {
 	return nil
 }
 
-func (m *kvmManager) setNetworking(args *NicParams, seq uint16, domain *Domain) error {
+func (m *kvmManager) setNetworking(args *NicParams, name string, seq uint16, domain *Domain) error {
 	var (
 		inf *InterfaceDevice
 		err error
@@ -55,7 +56,7 @@ func (m *kvmManager) setNetworking(args *NicParams, seq uint16, domain *Domain)
 	for _, nic := range args.Nics {
 		switch nic.Type {
 		case "default":
-			inf, err = m.prepareDefaultNetwork(domain.UUID, seq, args.Port)
+			inf, err = m.prepareDefaultNetwork(domain.UUID, name, seq, &nic, args.Port)
 		case "bridge":
 			inf, err = m.prepareBridgeNetwork(&nic)
 		case "vlan":
@@ -215,12 +216,22 @@ func (m *kvmManager) prepareVXLanNetwork(nic *Nic) (*InterfaceDevice, error) {
 	return &inf, nil
 }
 
-func (m *kvmManager) prepareDefaultNetwork(uuid string, seq uint16, port map[int]int) (*InterfaceDevice, error) {
+/*
+prepareDefaultNetwork attaches the vm to the default bridge, the vm ip is allocated by the ipam (sticky to the
+vm name) and served by the bridge dhcp.
+*/
+func (m *kvmManager) prepareDefaultNetwork(uuid string, name string, seq uint16, nic *Nic, port map[int]int) (*InterfaceDevice, error) {
 	_, err := netlink.LinkByName(DefaultBridgeName)
 	if err != nil {
 		return nil, fmt.Errorf("bridge '%s' not found", DefaultBridgeName)
 	}
 
+	addr, err := ipam.Allocate(DefaultBridgeName, m.owner(uuid), name, net.ParseIP(nic.IP))
+	if err != nil {
+		return nil, err
+	}
+	ip := addr.IP.String()
+
 	//attach to default bridge.
 	inf := InterfaceDevice{
 		Type: InterfaceDeviceTypeBridge,
@@ -235,12 +246,13 @@ func (m *kvmManager) prepareDefaultNetwork(uuid string, seq uint16, port map[int
 		},
 	}
 
-	if err := m.setDHCPHost(seq); err != nil {
+	if err := m.setDHCPHost(seq, ip); err != nil {
+		ipam.Release(DefaultBridgeName, m.owner(uuid))
 		return nil, err
 	}
 
 	//start port forwarders
-	if err := m.setPortForwards(uuid, seq, port); err != nil {
+	if err := m.setPortForwards(uuid, ip, port); err != nil {
 		return nil, err
 	}
 	return &inf, nil
@@ -274,9 +286,8 @@ func (m *kvmManager) prepareBridgeNetwork(nic *Nic) (*InterfaceDevice, error) {
 	return &inf, nil
 }
 
-func (m *kvmManager) setDHCPHost(seq uint16) error {
+func (m *kvmManager) setDHCPHost(seq uint16, ip string) error {
 	mac := m.macAddr(seq)
-	ip := m.ipAddr(seq)
 
 	job, err := pm.Run(&pm.Command{
 		ID:      uuid.New(),
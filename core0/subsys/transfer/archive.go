@@ -0,0 +1,606 @@
+package transfer
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/utils"
+	"github.com/zero-os/0-core/core0/subsys/containers"
+)
+
+const (
+	cmdContainerCopyTo   = "corex.copy_to"
+	cmdContainerCopyFrom = "corex.copy_from"
+
+	//overflowID owner of the files with an id that is not mapped in the container user namespace
+	overflowID = 65534
+
+	//modes that are restored from the archive
+	archiveModeMask = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+	//spoolMaxSize max size of the archive copy_from builds while the container is paused
+	spoolMaxSize = 1024 * 1024 * 1024
+)
+
+var (
+	errSpoolFull = fmt.Errorf("archive is larger than %d bytes, copy a smaller path", spoolMaxSize)
+)
+
+type CopyArguments struct {
+	Container   uint16 `json:"container"`
+	Path        string `json:"path"`        //path inside the container, the destination directory on copy_to
+	Compression string `json:"compression"` //compression of each chunk ('' or gzip)
+}
+
+type CopyFromArguments struct {
+	CopyArguments
+	ChunkSize int `json:"chunk_size"` //max size of each chunk before compression
+}
+
+type CopyResult struct {
+	Files int64 `json:"files"` //number of archive entries
+	Count int64 `json:"count"` //size of the tar archive
+}
+
+func (a *CopyArguments) validate() error {
+	if a.Container == 0 {
+		return fmt.Errorf("container is required")
+	}
+
+	if a.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+
+	switch a.Compression {
+	case CompressionNone, CompressionGzip:
+	default:
+		return fmt.Errorf("unsupported compression '%s'", a.Compression)
+	}
+
+	return nil
+}
+
+/*
+idShift maps the ids of a container user namespace to the host ids, the zero value maps the ids as is. Ids
+that are not mapped are changed to the overflow id like the kernel does, so an archive can't give files to
+host ids outside of the container range (the ids of another container for example).
+*/
+type idShift struct {
+	host int
+	size int
+}
+
+func (s idShift) toHost(id int) int {
+	if s.size == 0 {
+		return id
+	} else if id >= 0 && id < s.size {
+		return id + s.host
+	}
+
+	return overflowID
+}
+
+func (s idShift) toContainer(id int) int {
+	if s.size == 0 {
+		return id
+	} else if id >= s.host && id < s.host+s.size {
+		return id - s.host
+	}
+
+	return overflowID
+}
+
+//chunkWriter pushes the written data to the transfer queue in chunks
+type chunkWriter struct {
+	m           *transferManager
+	queue       []byte
+	compression string
+	size        int
+	buffer      []byte
+	count       int64
+}
+
+func (w *chunkWriter) Write(data []byte) (int, error) {
+	written := len(data)
+	for len(data) > 0 {
+		n := w.size - len(w.buffer)
+		if n > len(data) {
+			n = len(data)
+		}
+
+		w.buffer = append(w.buffer, data[:n]...)
+		data = data[n:]
+		if len(w.buffer) == w.size {
+			if err := w.Flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+func (w *chunkWriter) Flush() error {
+	if len(w.buffer) == 0 {
+		return nil
+	}
+
+	chunk := w.buffer
+	if w.compression == CompressionGzip {
+		var err error
+		if chunk, err = compress(chunk); err != nil {
+			return err
+		}
+	}
+
+	if err := w.m.push(w.queue, chunk); err != nil {
+		return err
+	}
+
+	w.count += int64(len(w.buffer))
+	w.buffer = w.buffer[:0]
+	return nil
+}
+
+//chunkReader reads the chunks pushed by the client to the transfer queue, an empty chunk is the end of data
+type chunkReader struct {
+	m           *transferManager
+	queue       []byte
+	compression string
+	chunk       []byte
+	done        bool
+}
+
+func (r *chunkReader) Read(data []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		chunk, err := r.m.next(r.queue)
+		if err != nil {
+			return 0, err
+		}
+
+		if len(chunk) == 0 {
+			r.done = true
+			continue
+		}
+
+		if r.compression == CompressionGzip {
+			if chunk, err = decompress(chunk); err != nil {
+				return 0, pm.BadRequestError(fmt.Errorf("invalid chunk: %s", err))
+			}
+		}
+
+		r.chunk = chunk
+	}
+
+	n := copy(data, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+/*
+writeArchive writes the tree at src to the archive, entries are named after base (the name of src). Symlinks
+are archived as is and not followed, files with more than one link are archived as hard links.
+*/
+func writeArchive(archive *tar.Writer, src, base string, ids idShift) (int64, error) {
+	var files int64
+	links := make(map[uint64]string)
+
+	err := filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+
+		name := path.Join(base, rel)
+		if name == "." {
+			return nil
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			//sockets can't be archived
+			log.Warningf("skipping '%s': %s", p, err)
+			return nil
+		}
+
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			header.Uid = ids.toContainer(int(stat.Uid))
+			header.Gid = ids.toContainer(int(stat.Gid))
+			header.Uname, header.Gname = "", ""
+
+			if info.Mode().IsRegular() && stat.Nlink > 1 {
+				if first, ok := links[stat.Ino]; ok {
+					header.Typeflag = tar.TypeLink
+					header.Linkname = first
+					header.Size = 0
+				} else {
+					links[stat.Ino] = name
+				}
+			}
+		}
+
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+
+		files++
+		if header.Typeflag != tar.TypeReg {
+			return nil
+		}
+
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+		_, err = io.CopyN(archive, file, header.Size)
+		return err
+	})
+
+	return files, err
+}
+
+//target resolves the path of an archive entry inside the root, the last element is not resolved so an existing
+//symlink is replaced and not followed
+func target(root, dst, name string) (string, error) {
+	name = path.Join(dst, path.Clean("/"+name))
+	parent, err := utils.InRoot(root, path.Dir(name))
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(parent, path.Base(name)), nil
+}
+
+/*
+extractArchive extracts the archive in dst (a path inside root), ownership and modes are restored. Entries can't
+be extracted outside root, device nodes are skipped.
+*/
+func extractArchive(archive *tar.Reader, root, dst string, ids idShift) (int64, error) {
+	var files int64
+	dirs := make(map[string]time.Time)
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return files, pm.BadRequestError(fmt.Errorf("invalid archive: %s", err))
+		}
+
+		name := path.Clean("/" + header.Name)
+		if name == "/" {
+			continue
+		}
+
+		p, err := target(root, dst, name)
+		if err != nil {
+			return files, err
+		}
+
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			return files, err
+		}
+
+		info := header.FileInfo()
+		if existing, err := os.Lstat(p); err == nil && !(existing.IsDir() && info.IsDir()) {
+			if err := os.RemoveAll(p); err != nil {
+				return files, err
+			}
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.Mkdir(p, 0755); err != nil && !os.IsExist(err) {
+				return files, err
+			}
+			dirs[p] = header.ModTime
+		case tar.TypeReg, tar.TypeRegA:
+			file, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+			if err != nil {
+				return files, err
+			}
+
+			_, err = io.Copy(file, archive)
+			file.Close()
+			if err != nil {
+				return files, err
+			}
+		case tar.TypeSymlink:
+			if err := os.Symlink(header.Linkname, p); err != nil {
+				return files, err
+			}
+		case tar.TypeLink:
+			old, err := target(root, dst, header.Linkname)
+			if err != nil {
+				return files, err
+			}
+
+			if err := os.Link(old, p); err != nil {
+				return files, err
+			}
+		case tar.TypeFifo:
+			if err := syscall.Mkfifo(p, uint32(info.Mode().Perm())); err != nil {
+				return files, err
+			}
+		default:
+			log.Warningf("skipping '%s': unsupported entry type '%c'", header.Name, header.Typeflag)
+			continue
+		}
+
+		files++
+		if err := os.Lchown(p, ids.toHost(header.Uid), ids.toHost(header.Gid)); err != nil {
+			return files, err
+		}
+
+		if header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeLink {
+			continue
+		}
+
+		//chown clears the setuid and setgid bits, so the mode is set after
+		if err := os.Chmod(p, info.Mode()&archiveModeMask); err != nil {
+			return files, err
+		}
+
+		if header.Typeflag != tar.TypeDir {
+			if err := os.Chtimes(p, header.ModTime, header.ModTime); err != nil {
+				return files, err
+			}
+		}
+	}
+
+	//the times of the directories are changed by their entries, so they are set last
+	for dir, mtime := range dirs {
+		if err := os.Chtimes(dir, mtime, mtime); err != nil {
+			log.Errorf("failed to set times of '%s': %s", dir, err)
+		}
+	}
+
+	return files, nil
+}
+
+//container gets the container and the id mapping of its user namespace
+func (m *transferManager) container(id uint16) (containers.Container, idShift, error) {
+	container := m.containers.Of(id)
+	if container == nil {
+		return nil, idShift{}, pm.NotFoundError(fmt.Errorf("container does not exist"))
+	}
+
+	var ids idShift
+	mappings, err := container.IDMappings()
+	if err != nil {
+		return nil, ids, err
+	}
+
+	if len(mappings) != 0 {
+		ids = idShift{host: mappings[0].HostID, size: mappings[0].Size}
+	}
+
+	return container, ids, nil
+}
+
+/*
+copyTo extracts a tar archive in a directory of the container, the archive chunks are pushed by the
+client to the transfer queue like an upload. It works on the container root from the host, so the container
+doesn't need to run anything. The container is paused during the copy, otherwise a process of the container
+could replace a directory with a symlink after it's resolved in the container root and have the node
+write outside of it.
+*/
+func (m *transferManager) copyTo(cmd *pm.Command) (interface{}, error) {
+	var args CopyArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	if err := args.validate(); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	container, ids, err := m.container(args.Container)
+	if err != nil {
+		return nil, err
+	}
+
+	queue := m.queue(cmd)
+	defer m.sink.Del(queue)
+
+	resume, err := container.Freeze()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pause container: %s", err)
+	}
+
+	defer resume()
+
+	root := container.RootPath()
+	dst, err := utils.InRoot(root, args.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return nil, err
+	}
+
+	reader := &chunkReader{
+		m:           m,
+		queue:       queue,
+		compression: args.Compression,
+	}
+
+	counter := &counter{reader: reader}
+	files, err := extractArchive(tar.NewReader(counter), root, args.Path, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	//consume the rest of the data (tar padding)
+	if _, err := io.Copy(ioutil.Discard, counter); err != nil {
+		return nil, err
+	}
+
+	return CopyResult{Files: files, Count: counter.count}, nil
+}
+
+//spoolWriter writes to a spool file and fails once the spool reaches its max size
+type spoolWriter struct {
+	file *os.File
+	left int64
+}
+
+func (w *spoolWriter) Write(data []byte) (int, error) {
+	if int64(len(data)) > w.left {
+		return 0, errSpoolFull
+	}
+
+	n, err := w.file.Write(data)
+	w.left -= int64(n)
+	return n, err
+}
+
+/*
+spool writes a tar archive of src to a temporary file, the container is paused while the archive is built
+for the same reason as copyTo (reading files outside of its root). The archive is streamed from the spool
+after the container is resumed, so a slow client doesn't keep the container paused.
+*/
+func spool(container containers.Container, p string, ids idShift) (*os.File, int64, error) {
+	resume, err := container.Freeze()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to pause container: %s", err)
+	}
+
+	defer resume()
+
+	src, err := utils.InRoot(container.RootPath(), p)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if _, err := os.Lstat(src); os.IsNotExist(err) {
+		return nil, 0, pm.NotFoundError(fmt.Errorf("path '%s' does not exist", p))
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	file, err := ioutil.TempFile("", "copy")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	//the spool is only reachable through the open file
+	os.Remove(file.Name())
+
+	archive := tar.NewWriter(&spoolWriter{file: file, left: spoolMaxSize})
+	files, err := writeArchive(archive, src, path.Base(path.Clean("/"+p)), ids)
+	if err == nil {
+		err = archive.Close()
+	}
+
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	return file, files, nil
+}
+
+//copyFrom streams a tar archive of a path of the container to the transfer queue like a download
+func (m *transferManager) copyFrom(cmd *pm.Command) (interface{}, error) {
+	var args CopyFromArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	if err := args.validate(); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	if args.ChunkSize <= 0 {
+		args.ChunkSize = DefaultChunkSize
+	} else if args.ChunkSize > MaxChunkSize {
+		args.ChunkSize = MaxChunkSize
+	}
+
+	container, ids, err := m.container(args.Container)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, files, err := spool(container, args.Path, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	defer archive.Close()
+
+	queue := m.queue(cmd)
+	writer := &chunkWriter{
+		m:           m,
+		queue:       queue,
+		compression: args.Compression,
+		size:        args.ChunkSize,
+	}
+
+	_, err = io.Copy(writer, archive)
+	if err == nil {
+		err = writer.Flush()
+	}
+
+	//end of transfer
+	if err == nil {
+		err = m.push(queue, []byte{})
+	}
+
+	if err != nil {
+		m.sink.Del(queue)
+		return nil, err
+	}
+
+	log.Debugf("copy of '%s' from container %d done (%d files)", args.Path, args.Container, files)
+	return CopyResult{Files: files, Count: writer.count}, nil
+}
+
+//counter counts the bytes read from reader
+type counter struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *counter) Read(data []byte) (int, error) {
+	n, err := c.reader.Read(data)
+	c.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,126 @@
+package transfer
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIDShift(t *testing.T) {
+	ids := idShift{host: 100000, size: 65536}
+
+	assert.Equal(t, 100000, ids.toHost(0))
+	assert.Equal(t, 101000, ids.toHost(1000))
+	assert.Equal(t, overflowID, ids.toHost(65536))
+	assert.Equal(t, overflowID, ids.toHost(-1))
+
+	assert.Equal(t, 0, ids.toContainer(100000))
+	assert.Equal(t, 1000, ids.toContainer(101000))
+	assert.Equal(t, overflowID, ids.toContainer(1000))
+	assert.Equal(t, overflowID, ids.toContainer(165536))
+
+	var none idShift
+	assert.Equal(t, 1000, none.toHost(1000))
+	assert.Equal(t, 1000, none.toContainer(1000))
+}
+
+func TestTarget(t *testing.T) {
+	root, err := ioutil.TempDir("", "archive")
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+	defer os.RemoveAll(root)
+
+	assert.NoError(t, os.Symlink("/", path.Join(root, "escape")))
+
+	p, err := target(root, "/data", "../../etc/passwd")
+	assert.NoError(t, err)
+	assert.Equal(t, path.Join(root, "data/etc/passwd"), p)
+
+	p, err = target(root, "/", "escape/etc/passwd")
+	assert.NoError(t, err)
+	assert.Equal(t, path.Join(root, "etc/passwd"), p)
+
+	//the last element is not followed
+	p, err = target(root, "/", "escape")
+	assert.NoError(t, err)
+	assert.Equal(t, path.Join(root, "escape"), p)
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	src, err := ioutil.TempDir("", "archive")
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+	defer os.RemoveAll(src)
+
+	root, err := ioutil.TempDir("", "archive")
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+	defer os.RemoveAll(root)
+
+	dir := path.Join(src, "app")
+	assert.NoError(t, os.MkdirAll(path.Join(dir, "bin"), 0755))
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, "bin", "run"), []byte("#!/bin/sh\n"), 0755))
+	assert.NoError(t, os.Chmod(path.Join(dir, "bin", "run"), 0755|os.ModeSetuid))
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, "config"), []byte("key=value"), 0600))
+	assert.NoError(t, os.Link(path.Join(dir, "config"), path.Join(dir, "config.link")))
+	assert.NoError(t, os.Symlink("/etc/hosts", path.Join(dir, "hosts")))
+
+	var buffer bytes.Buffer
+	archive := tar.NewWriter(&buffer)
+	files, err := writeArchive(archive, dir, "app", idShift{})
+	assert.NoError(t, err)
+	assert.NoError(t, archive.Close())
+	assert.Equal(t, int64(6), files)
+
+	files, err = extractArchive(tar.NewReader(&buffer), root, "/opt", idShift{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), files)
+
+	data, err := ioutil.ReadFile(path.Join(root, "opt/app/config"))
+	assert.NoError(t, err)
+	assert.Equal(t, "key=value", string(data))
+
+	info, err := os.Stat(path.Join(root, "opt/app/config"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode())
+	assert.Equal(t, uint64(2), uint64(info.Sys().(*syscall.Stat_t).Nlink))
+
+	info, err = os.Stat(path.Join(root, "opt/app/bin/run"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0755|os.ModeSetuid, info.Mode())
+
+	link, err := os.Readlink(path.Join(root, "opt/app/hosts"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/etc/hosts", link)
+}
+
+func TestSpoolWriter(t *testing.T) {
+	file, err := ioutil.TempFile("", "spool")
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	writer := &spoolWriter{file: file, left: 8}
+	n, err := writer.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+
+	_, err = writer.Write([]byte("world"))
+	assert.Equal(t, errSpoolFull, err)
+
+	n, err = writer.Write([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, int64(0), writer.left)
+}
@@ -0,0 +1,397 @@
+package transfer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/utils"
+	"github.com/zero-os/0-core/core0/subsys/containers"
+	"github.com/zero-os/0-core/core0/transport"
+)
+
+const (
+	cmdFilesystemUpload   = "filesystem.upload"
+	cmdFilesystemDownload = "filesystem.download"
+
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+
+	DefaultChunkSize = 512 * 1024 //512K
+	MaxChunkSize     = 8 * 1024 * 1024
+
+	transferWindow      = 32 //max number of chunks waiting in the transfer queue on download
+	transferIdleTimeout = 60 * time.Second
+	transferExpire      = 300
+)
+
+var (
+	log = logging.MustGetLogger("transfer")
+)
+
+//queues is the part of the sink the transfers use
+type queues interface {
+	BLPop(key []byte, timeout time.Duration) ([]byte, error)
+	RPush(key []byte, args ...[]byte) (int64, error)
+	LLen(key []byte) (int64, error)
+	LExpire(key []byte, duration int64) (int64, error)
+	Del(keys ...[]byte) (int64, error)
+	JobQueue(kind, id string) string
+}
+
+type transferManager struct {
+	sink       queues
+	containers containers.ContainerManager
+}
+
+type TransferArguments struct {
+	Container   uint16 `json:"container"`   //if set, file is relative to the container root
+	File        string `json:"file"`        //file path
+	Offset      int64  `json:"offset"`      //start offset of the transfer
+	Compression string `json:"compression"` //compression of each chunk ('' or gzip)
+}
+
+type UploadArguments struct {
+	TransferArguments
+	Perm     uint32 `json:"perm"`     //permission of the file if created
+	Checksum string `json:"checksum"` //expected md5 of the full file after upload (optional)
+}
+
+type DownloadArguments struct {
+	TransferArguments
+	Length    int64 `json:"length"`     //number of bytes to download, 0 means till end of file
+	ChunkSize int   `json:"chunk_size"` //max size of each chunk before compression
+}
+
+type TransferResult struct {
+	Offset int64  `json:"offset"` //start offset of the transfer
+	Count  int64  `json:"count"`  //number of bytes transferred (uncompressed)
+	Size   int64  `json:"size"`   //size of the file after the transfer
+	MD5    string `json:"md5"`    //md5 of the full file on upload, and of the transferred bytes on download
+}
+
+func (a *TransferArguments) validate() error {
+	if a.File == "" {
+		return fmt.Errorf("file is required")
+	}
+
+	switch a.Compression {
+	case CompressionNone, CompressionGzip:
+	default:
+		return fmt.Errorf("unsupported compression '%s'", a.Compression)
+	}
+
+	return nil
+}
+
+/*
+TransferSubsystem registers the bulk transfer commands. Unlike filesystem.read/write the data
+doesn't go through the command results but through a dedicated `transfer:<job-id>` queue (namespaced
+like the job result), so large files can be moved in a single job. Transfers can be resumed by starting
+a new job from an offset.
+*/
+func TransferSubsystem(sink *transport.Sink, containers containers.ContainerManager) error {
+	mgr := &transferManager{
+		sink:       sink,
+		containers: containers,
+	}
+
+	pm.RegisterBuiltIn(cmdFilesystemUpload, mgr.upload)
+	pm.RegisterBuiltIn(cmdFilesystemDownload, mgr.download)
+	pm.RegisterBuiltIn(cmdContainerCopyTo, mgr.copyTo)
+	pm.RegisterBuiltIn(cmdContainerCopyFrom, mgr.copyFrom)
+
+	return nil
+}
+
+/*
+open opens the file of the transfer, files of containers are resolved inside the container root. The
+container keeps running during the transfer, so the file is opened without following symlinks once it's
+resolved, a process of the container can't swap a directory for a symlink to have the node open a host file.
+*/
+func (m *transferManager) open(args *TransferArguments, flag int, perm os.FileMode) (*os.File, error) {
+	if args.Container == 0 {
+		return os.OpenFile(path.Clean(args.File), flag, perm)
+	}
+
+	container := m.containers.Of(args.Container)
+	if container == nil {
+		return nil, pm.NotFoundError(fmt.Errorf("container does not exist"))
+	}
+
+	return utils.OpenInRoot(container.RootPath(), args.File, flag, perm)
+}
+
+//queue gets the transfer queue of the job, it's namespaced like the job result
+func (m *transferManager) queue(cmd *pm.Command) []byte {
+	return []byte(m.sink.JobQueue(transport.TransferQueue, cmd.ID))
+}
+
+func decompress(chunk []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewBuffer(chunk))
+	if err != nil {
+		return nil, err
+	}
+
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+func compress(chunk []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write(chunk); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func sum(file *os.File) (string, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+//next gets the next chunk of an upload, it fails if the client didn't push anything for transferIdleTimeout
+func (m *transferManager) next(queue []byte) ([]byte, error) {
+	deadline := time.Now().Add(transferIdleTimeout)
+	for time.Now().Before(deadline) {
+		chunk, err := m.sink.BLPop(queue, time.Second)
+		if err != nil {
+			return nil, err
+		}
+
+		if chunk != nil {
+			return chunk, nil
+		}
+	}
+
+	return nil, fmt.Errorf("timeout waiting for data")
+}
+
+func (m *transferManager) upload(cmd *pm.Command) (interface{}, error) {
+	var args UploadArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	if err := args.validate(); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	queue := m.queue(cmd)
+	defer m.sink.Del(queue)
+
+	perm := os.FileMode(args.Perm) & os.ModePerm
+	if perm == 0 {
+		perm = 0644
+	}
+
+	file, err := m.open(&args.TransferArguments, os.O_RDWR|os.O_CREATE, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	//negative offset resumes the upload from the end of the file
+	offset := args.Offset
+	if offset < 0 || offset > info.Size() {
+		offset = info.Size()
+	}
+
+	if err := file.Truncate(offset); err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	result := TransferResult{
+		Offset: offset,
+	}
+
+	for {
+		chunk, err := m.next(queue)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(chunk) == 0 {
+			break
+		}
+
+		if args.Compression == CompressionGzip {
+			if chunk, err = decompress(chunk); err != nil {
+				return nil, pm.BadRequestError(fmt.Errorf("invalid chunk: %s", err))
+			}
+		}
+
+		n, err := file.Write(chunk)
+		result.Count += int64(n)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if result.MD5, err = sum(file); err != nil {
+		return nil, err
+	}
+
+	result.Size = offset + result.Count
+
+	if args.Checksum != "" && args.Checksum != result.MD5 {
+		return nil, pm.PreconditionFailedError(
+			fmt.Errorf("checksum mismatch, expected '%s' got '%s'", args.Checksum, result.MD5),
+		)
+	}
+
+	return result, nil
+}
+
+//push pushes a chunk to the download queue, it waits for the client to consume older chunks
+//if the queue is full
+func (m *transferManager) push(queue []byte, chunk []byte) error {
+	deadline := time.Now().Add(transferIdleTimeout)
+	for {
+		size, err := m.sink.LLen(queue)
+		if err != nil {
+			return err
+		}
+
+		if size < transferWindow {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout waiting for client to consume data")
+		}
+
+		<-time.After(100 * time.Millisecond)
+	}
+
+	if _, err := m.sink.RPush(queue, chunk); err != nil {
+		return err
+	}
+
+	_, err := m.sink.LExpire(queue, transferExpire)
+	return err
+}
+
+func (m *transferManager) download(cmd *pm.Command) (interface{}, error) {
+	var args DownloadArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	if err := args.validate(); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	if args.ChunkSize <= 0 {
+		args.ChunkSize = DefaultChunkSize
+	} else if args.ChunkSize > MaxChunkSize {
+		args.ChunkSize = MaxChunkSize
+	}
+
+	file, err := m.open(&args.TransferArguments, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if args.Offset < 0 || args.Offset > info.Size() {
+		return nil, pm.BadRequestError(fmt.Errorf("invalid offset %d (file size is %d)", args.Offset, info.Size()))
+	}
+
+	if _, err := file.Seek(args.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader = file
+	if args.Length > 0 {
+		reader = io.LimitReader(file, args.Length)
+	}
+
+	digest := md5.New()
+	reader = io.TeeReader(reader, digest)
+
+	queue := m.queue(cmd)
+	result := TransferResult{
+		Offset: args.Offset,
+		Size:   info.Size(),
+	}
+
+	buffer := make([]byte, args.ChunkSize)
+	for {
+		n, err := io.ReadFull(reader, buffer)
+		if n > 0 {
+			chunk := buffer[:n]
+			if args.Compression == CompressionGzip {
+				var cerr error
+				if chunk, cerr = compress(chunk); cerr != nil {
+					m.sink.Del(queue)
+					return nil, cerr
+				}
+			}
+
+			if err := m.push(queue, chunk); err != nil {
+				m.sink.Del(queue)
+				return nil, err
+			}
+
+			result.Count += int64(n)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			m.sink.Del(queue)
+			return nil, err
+		}
+	}
+
+	//end of transfer
+	if err := m.push(queue, []byte{}); err != nil {
+		m.sink.Del(queue)
+		return nil, err
+	}
+
+	result.MD5 = fmt.Sprintf("%x", digest.Sum(nil))
+
+	log.Debugf("download of '%s' done (%d bytes)", file.Name(), result.Count)
+	return result, nil
+}
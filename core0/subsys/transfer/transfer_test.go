@@ -0,0 +1,247 @@
+package transfer
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/core0/transport"
+)
+
+//memoryQueues is an in memory sink for the transfer tests
+type memoryQueues struct {
+	lists map[string][][]byte
+	m     sync.Mutex
+}
+
+func newMemoryQueues() *memoryQueues {
+	return &memoryQueues{lists: make(map[string][][]byte)}
+}
+
+func (q *memoryQueues) BLPop(key []byte, timeout time.Duration) ([]byte, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	list := q.lists[string(key)]
+	if len(list) == 0 {
+		return nil, nil
+	}
+
+	q.lists[string(key)] = list[1:]
+	return list[0], nil
+}
+
+func (q *memoryQueues) RPush(key []byte, args ...[]byte) (int64, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	//the values are copied like the sink db does, the callers reuse their buffers
+	for _, arg := range args {
+		q.lists[string(key)] = append(q.lists[string(key)], append([]byte{}, arg...))
+	}
+	return int64(len(q.lists[string(key)])), nil
+}
+
+func (q *memoryQueues) LLen(key []byte) (int64, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	return int64(len(q.lists[string(key)])), nil
+}
+
+func (q *memoryQueues) LExpire(key []byte, duration int64) (int64, error) {
+	return 1, nil
+}
+
+func (q *memoryQueues) Del(keys ...[]byte) (int64, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	for _, key := range keys {
+		delete(q.lists, string(key))
+	}
+
+	return int64(len(keys)), nil
+}
+
+func (q *memoryQueues) JobQueue(kind, id string) string {
+	return fmt.Sprintf("%s:%s", kind, id)
+}
+
+//all pops all the chunks of the queue
+func (q *memoryQueues) all(key string) [][]byte {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	list := q.lists[key]
+	delete(q.lists, key)
+	return list
+}
+
+func md5sum(data string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(data)))
+}
+
+func gzipped(t *testing.T, data string) []byte {
+	chunk, err := compress([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return chunk
+}
+
+func TestUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transfer")
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name        string
+		existing    string
+		offset      int64
+		compression string
+		checksum    string
+		chunks      [][]byte
+		content     string
+		err         bool
+	}{
+		{name: "new", chunks: [][]byte{[]byte("hello "), []byte("world")}, content: "hello world"},
+		{name: "truncate", existing: "hello there", offset: 6, chunks: [][]byte{[]byte("world")}, content: "hello world"},
+		{name: "resume", existing: "hello ", offset: -1, chunks: [][]byte{[]byte("world")}, content: "hello world"},
+		{name: "after-end", existing: "hello ", offset: 100, chunks: [][]byte{[]byte("world")}, content: "hello world"},
+		{name: "gzip", compression: CompressionGzip, chunks: [][]byte{gzipped(t, "hello "), gzipped(t, "world")}, content: "hello world"},
+		{name: "checksum", checksum: md5sum("hello world"), chunks: [][]byte{[]byte("hello world")}, content: "hello world"},
+		{name: "checksum-mismatch", checksum: md5sum("hello"), chunks: [][]byte{[]byte("hello world")}, err: true},
+		{name: "invalid-gzip", compression: CompressionGzip, chunks: [][]byte{[]byte("hello")}, err: true},
+	}
+
+	for _, c := range cases {
+		queues := newMemoryQueues()
+		m := &transferManager{sink: queues}
+
+		file := path.Join(dir, c.name)
+		if c.existing != "" {
+			assert.NoError(t, ioutil.WriteFile(file, []byte(c.existing), 0644))
+		}
+
+		cmd := &pm.Command{
+			ID: c.name,
+			Arguments: pm.MustArguments(UploadArguments{
+				TransferArguments: TransferArguments{File: file, Offset: c.offset, Compression: c.compression},
+				Checksum:          c.checksum,
+			}),
+		}
+
+		queues.RPush([]byte(queues.JobQueue(transport.TransferQueue, c.name)), append(c.chunks, []byte{})...)
+
+		result, err := m.upload(cmd)
+		if c.err {
+			assert.Error(t, err, c.name)
+			continue
+		}
+
+		if !assert.NoError(t, err, c.name) {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(file)
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, c.content, string(data), c.name)
+
+		transfer := result.(TransferResult)
+		assert.Equal(t, int64(len(c.content)), transfer.Size, c.name)
+		assert.Equal(t, int64(len(c.content))-transfer.Offset, transfer.Count, c.name)
+		assert.Equal(t, md5sum(c.content), transfer.MD5, c.name)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transfer")
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "file")
+	content := "hello world"
+	assert.NoError(t, ioutil.WriteFile(file, []byte(content), 0644))
+
+	cases := []struct {
+		name        string
+		offset      int64
+		length      int64
+		chunkSize   int
+		compression string
+		expected    string
+		chunks      int
+		err         bool
+	}{
+		{name: "all", expected: "hello world", chunks: 1},
+		{name: "chunks", chunkSize: 4, expected: "hello world", chunks: 3},
+		{name: "offset", offset: 6, expected: "world", chunks: 1},
+		{name: "length", offset: 2, length: 3, expected: "llo", chunks: 1},
+		{name: "gzip", chunkSize: 6, compression: CompressionGzip, expected: "hello world", chunks: 2},
+		{name: "end", offset: 11, expected: "", chunks: 0},
+		{name: "invalid-offset", offset: 12, err: true},
+		{name: "negative-offset", offset: -1, err: true},
+	}
+
+	for _, c := range cases {
+		queues := newMemoryQueues()
+		m := &transferManager{sink: queues}
+
+		cmd := &pm.Command{
+			ID: c.name,
+			Arguments: pm.MustArguments(DownloadArguments{
+				TransferArguments: TransferArguments{File: file, Offset: c.offset, Compression: c.compression},
+				Length:            c.length,
+				ChunkSize:         c.chunkSize,
+			}),
+		}
+
+		result, err := m.download(cmd)
+		if c.err {
+			assert.Error(t, err, c.name)
+			continue
+		}
+
+		if !assert.NoError(t, err, c.name) {
+			continue
+		}
+
+		chunks := queues.all(queues.JobQueue(transport.TransferQueue, c.name))
+		if !assert.Len(t, chunks, c.chunks+1, c.name) {
+			continue
+		}
+
+		assert.Empty(t, chunks[c.chunks], c.name)
+
+		var data []byte
+		for _, chunk := range chunks[:c.chunks] {
+			if c.compression == CompressionGzip {
+				chunk, err = decompress(chunk)
+				assert.NoError(t, err, c.name)
+			}
+
+			data = append(data, chunk...)
+		}
+
+		assert.Equal(t, c.expected, string(data), c.name)
+
+		transfer := result.(TransferResult)
+		assert.Equal(t, c.offset, transfer.Offset, c.name)
+		assert.Equal(t, int64(len(c.expected)), transfer.Count, c.name)
+		assert.Equal(t, int64(len(content)), transfer.Size, c.name)
+		assert.Equal(t, md5sum(c.expected), transfer.MD5, c.name)
+	}
+}
@@ -0,0 +1,336 @@
+package volumes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/zero-os/0-core/base/pm"
+)
+
+const (
+	cmdVolumeCreate = "volume.create"
+	cmdVolumeList   = "volume.list"
+	cmdVolumeGet    = "volume.get"
+	cmdVolumeDelete = "volume.delete"
+
+	//VolumesDir is where the volumes are created, each volume is a btrfs subvolume (or a plain
+	//directory if the filesystem is not btrfs) named after the volume.
+	VolumesDir = "/var/cache/volumes"
+
+	metaDir = ".meta"
+
+	btrfsMagic = 0x9123683E
+)
+
+var (
+	log = logging.MustGetLogger("volumes")
+
+	namePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.\-]{0,63}$`)
+
+	//number of running containers using each volume
+	refs  = make(map[string]int)
+	refsM sync.Mutex
+)
+
+//Volume is a named storage that outlives the containers that mount it
+type Volume struct {
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`      //quota in bytes, 0 means no quota
+	Used      int64  `json:"used"`      //referenced bytes (only reported for btrfs volumes)
+	Created   int64  `json:"created"`   //unix time
+	Subvolume bool   `json:"subvolume"` //backed by a btrfs subvolume
+	InUse     int    `json:"in_use"`    //number of running containers that mount the volume
+}
+
+type VolumeCreateArguments struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"` //quota in bytes, requires a btrfs filesystem
+}
+
+type VolumeArguments struct {
+	Name string `json:"name"`
+}
+
+func validName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid volume name '%s'", name)
+	}
+
+	return nil
+}
+
+func volumePath(name string) string {
+	return path.Join(VolumesDir, name)
+}
+
+func metaPath(name string) string {
+	return path.Join(VolumesDir, metaDir, name+".json")
+}
+
+func btrfs(args ...string) (*pm.JobResult, error) {
+	return pm.System("btrfs", args...)
+}
+
+func isBtrfs(dir string) bool {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return false
+	}
+
+	return uint32(stat.Type) == btrfsMagic
+}
+
+//VolumeSubsystem registers the volume.* commands
+func VolumeSubsystem() error {
+	if err := os.MkdirAll(path.Join(VolumesDir, metaDir), 0755); err != nil {
+		return err
+	}
+
+	pm.RegisterBuiltIn(cmdVolumeCreate, create)
+	pm.RegisterBuiltIn(cmdVolumeList, list)
+	pm.RegisterBuiltIn(cmdVolumeGet, get)
+	pm.RegisterBuiltIn(cmdVolumeDelete, remove)
+
+	return nil
+}
+
+func load(name string) (*Volume, error) {
+	data, err := ioutil.ReadFile(metaPath(name))
+	if os.IsNotExist(err) {
+		return nil, pm.NotFoundError(fmt.Errorf("volume '%s' does not exist", name))
+	} else if err != nil {
+		return nil, err
+	}
+
+	var volume Volume
+	if err := json.Unmarshal(data, &volume); err != nil {
+		return nil, err
+	}
+
+	if volume.Subvolume && volume.Size > 0 {
+		if used, err := usage(volume.Path); err == nil {
+			volume.Used = used
+		} else {
+			log.Errorf("failed to get volume '%s' usage: %s", name, err)
+		}
+	}
+
+	refsM.Lock()
+	volume.InUse = refs[name]
+	refsM.Unlock()
+
+	return &volume, nil
+}
+
+//parseQGroupUsage parses the output of `btrfs qgroup show -f --raw` and gets the referenced bytes
+func parseQGroupUsage(output string) (int64, error) {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || !strings.HasPrefix(fields[0], "0/") {
+			continue
+		}
+
+		return strconv.ParseInt(fields[1], 10, 64)
+	}
+
+	return 0, fmt.Errorf("no qgroup found")
+}
+
+func usage(p string) (int64, error) {
+	result, err := btrfs("qgroup", "show", "-f", "--raw", p)
+	if err != nil {
+		return 0, err
+	}
+
+	return parseQGroupUsage(result.Streams.Stdout())
+}
+
+func create(cmd *pm.Command) (interface{}, error) {
+	var args VolumeCreateArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	if err := validName(args.Name); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	if args.Size < 0 {
+		return nil, pm.BadRequestError(fmt.Errorf("invalid volume size '%d'", args.Size))
+	}
+
+	refsM.Lock()
+	defer refsM.Unlock()
+
+	p := volumePath(args.Name)
+	if _, err := os.Stat(p); err == nil {
+		return nil, pm.PreconditionFailedError(fmt.Errorf("volume '%s' already exists", args.Name))
+	}
+
+	volume := Volume{
+		Name:      args.Name,
+		Path:      p,
+		Size:      args.Size,
+		Created:   time.Now().Unix(),
+		Subvolume: isBtrfs(VolumesDir),
+	}
+
+	if volume.Size > 0 && !volume.Subvolume {
+		return nil, pm.PreconditionFailedError(fmt.Errorf("volume size requires a btrfs filesystem"))
+	}
+
+	if volume.Subvolume {
+		if _, err := btrfs("subvolume", "create", p); err != nil {
+			return nil, err
+		}
+	} else if err := os.Mkdir(p, 0755); err != nil {
+		return nil, err
+	}
+
+	if volume.Size > 0 {
+		_, err := btrfs("quota", "enable", p)
+		if err == nil {
+			_, err = btrfs("qgroup", "limit", fmt.Sprint(volume.Size), p)
+		}
+
+		if err != nil {
+			destroy(&volume)
+			return nil, err
+		}
+	}
+
+	data, err := json.Marshal(&volume)
+	if err != nil {
+		destroy(&volume)
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(metaPath(volume.Name), data, 0644); err != nil {
+		destroy(&volume)
+		return nil, err
+	}
+
+	return &volume, nil
+}
+
+func list(cmd *pm.Command) (interface{}, error) {
+	files, err := ioutil.ReadDir(path.Join(VolumesDir, metaDir))
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := make([]*Volume, 0, len(files))
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		volume, err := load(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			log.Errorf("failed to load volume '%s': %s", file.Name(), err)
+			continue
+		}
+
+		volumes = append(volumes, volume)
+	}
+
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].Name < volumes[j].Name
+	})
+
+	return volumes, nil
+}
+
+func get(cmd *pm.Command) (interface{}, error) {
+	var args VolumeArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	if err := validName(args.Name); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	return load(args.Name)
+}
+
+func destroy(volume *Volume) error {
+	if volume.Subvolume {
+		if _, err := btrfs("subvolume", "delete", volume.Path); err != nil {
+			return err
+		}
+	} else if err := os.RemoveAll(volume.Path); err != nil {
+		return err
+	}
+
+	return os.Remove(metaPath(volume.Name))
+}
+
+//remove deletes a volume and all its data, a volume that is mounted by a running container can't be deleted
+func remove(cmd *pm.Command) (interface{}, error) {
+	var args VolumeArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	if err := validName(args.Name); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	volume, err := load(args.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	refsM.Lock()
+	defer refsM.Unlock()
+
+	if refs[args.Name] > 0 {
+		return nil, pm.PreconditionFailedError(fmt.Errorf("volume '%s' is in use", args.Name))
+	}
+
+	return nil, destroy(volume)
+}
+
+//Acquire marks the volume as used by a container and gets its path, the volume can't be deleted
+//till it's released
+func Acquire(name string) (string, error) {
+	if err := validName(name); err != nil {
+		return "", err
+	}
+
+	refsM.Lock()
+	defer refsM.Unlock()
+
+	if _, err := os.Stat(metaPath(name)); err != nil {
+		return "", fmt.Errorf("volume '%s' does not exist", name)
+	}
+
+	refs[name]++
+	return volumePath(name), nil
+}
+
+//Release releases a volume acquired with Acquire
+func Release(name string) {
+	refsM.Lock()
+	defer refsM.Unlock()
+
+	if refs[name] <= 1 {
+		delete(refs, name)
+		return
+	}
+
+	refs[name]--
+}
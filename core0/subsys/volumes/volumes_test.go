@@ -0,0 +1,41 @@
+package volumes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQGroupUsage(t *testing.T) {
+	output := `qgroupid         rfer         excl     max_rfer     max_excl 
+--------         ----         ----     --------     -------- 
+0/258        16384000     16384000   1073741824         none 
+`
+
+	used, err := parseQGroupUsage(output)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(16384000), used)
+	}
+
+	_, err = parseQGroupUsage("")
+	assert.Error(t, err)
+}
+
+func TestValidName(t *testing.T) {
+	assert.NoError(t, validName("data"))
+	assert.NoError(t, validName("db-1.data_2"))
+
+	assert.Error(t, validName(""))
+	assert.Error(t, validName(".."))
+	assert.Error(t, validName("a/b"))
+	assert.Error(t, validName("-data"))
+}
+
+func TestRefs(t *testing.T) {
+	refs["test"] = 2
+	Release("test")
+	assert.Equal(t, 1, refs["test"])
+	Release("test")
+	_, ok := refs["test"]
+	assert.False(t, ok)
+}
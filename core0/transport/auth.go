@@ -1,71 +1,211 @@
 package transport
 
 import (
+	"crypto/ecdsa"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
-	"github.com/siddontang/ledisdb/config"
+	"github.com/patrickmn/go-cache"
 )
 
-func AuthMethod(organization string, key string) (config.AuthMethod, error) {
+func parseToken(pub *ecdsa.PublicKey, token string) (jwt.MapClaims, error) {
+	log.Debugf("checking token: %s", token)
+	t, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		m, ok := t.Method.(*jwt.SigningMethodECDSA)
+		if !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", t.Header["alg"])
+		}
+		if t.Header["alg"] != m.Alg() {
+			return nil, fmt.Errorf("Unexpected signing algorithm: %v", t.Header["alg"])
+		}
+		return pub, nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("JWT parse error: %s", err)
+	}
+
+	if !t.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	claims := t.Claims.(jwt.MapClaims)
+
+	if err := claims.Valid(); err != nil {
+		return nil, fmt.Errorf("itsyouonline calim validation error: %s", err)
+	}
+
+	return claims, nil
+}
+
+func memberOf(claims jwt.MapClaims, organization string) bool {
+	if organization == "" {
+		return false
+	}
+
+	if claims["azp"] == organization {
+		return true
+	}
+
 	scope := fmt.Sprintf("user:memberof:%s", organization)
+	var scopes []interface{}
+	if value, ok := claims["scope"]; ok {
+		if scopes, ok = value.([]interface{}); !ok {
+			return false
+		}
+	} else {
+		return false
+	}
+
+	for _, s := range scopes {
+		switch s.(type) {
+		case string:
+			if s == scope {
+				return true
+			}
+		}
+	}
+
+	return false
+}
 
+/*
+queueAuth authenticates tokens for the node organization and for the organizations
+of the extra sink queues. A token of the node organization is allowed to do everything,
+while a queue token is only authorized to push to its queue and read its own results.
+*/
+type queueAuth struct {
+	pub          *ecdsa.PublicKey
+	organization string
+	queues       []*sinkQueue
+
+	grants *cache.Cache
+}
+
+func newQueueAuth(organization string, key string, queues []*sinkQueue) (*queueAuth, error) {
 	pub, err := jwt.ParseECPublicKeyFromPEM([]byte(key))
 	if err != nil {
 		return nil, err
 	}
 
-	return func(_ *config.Config, token string) bool {
-		log.Debugf("checking token: %s", token)
-		t, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-			m, ok := t.Method.(*jwt.SigningMethodECDSA)
-			if !ok {
-				return nil, fmt.Errorf("Unexpected signing method: %v", t.Header["alg"])
-			}
-			if t.Header["alg"] != m.Alg() {
-				return nil, fmt.Errorf("Unexpected signing algorithm: %v", t.Header["alg"])
+	return &queueAuth{
+		pub:          pub,
+		organization: organization,
+		queues:       queues,
+		grants:       cache.New(5*time.Minute, 1*time.Minute),
+	}, nil
+}
+
+//grant of a token, nil grant means full access.
+type grant struct {
+	queues []*sinkQueue
+}
+
+func (a *queueAuth) grant(token string) (*grant, bool) {
+	if g, ok := a.grants.Get(token); ok {
+		return g.(*grant), true
+	}
+
+	claims, err := parseToken(a.pub, token)
+	if err != nil {
+		log.Errorf("%s", err)
+		return nil, false
+	}
+
+	g := &grant{}
+	if memberOf(claims, a.organization) {
+		g = nil
+	} else {
+		for _, q := range a.queues {
+			if memberOf(claims, q.organization) {
+				g.queues = append(g.queues, q)
 			}
-			return pub, nil
-		})
+		}
 
-		if err != nil {
-			log.Errorf("JWT parse error: %s", err)
-			return false
+		if len(g.queues) == 0 {
+			return nil, false
 		}
+	}
 
-		if !t.Valid {
-			return false
+	a.grants.Set(token, g, cache.DefaultExpiration)
+	return g, true
+}
+
+func (a *queueAuth) Authenticate(token string) bool {
+	_, ok := a.grant(token)
+	return ok
+}
+
+//commandKeys gets the key arguments of cmd, false if cmd is not allowed for queue tokens
+func commandKeys(cmd string, args [][]byte) ([][]byte, bool) {
+	switch cmd {
+	case "ping", "echo", "select":
+		return nil, true
+	case "rpush", "lpush", "lpop", "rpop", "llen", "lrange", "lindex", "lkeyexists", "lttl":
+		if len(args) < 1 {
+			return nil, false
+		}
+		return args[:1], true
+	case "blpop", "brpop":
+		if len(args) < 2 {
+			return nil, false
 		}
+		//last argument is the timeout
+		return args[:len(args)-1], true
+	case "rpoplpush", "brpoplpush":
+		if len(args) < 2 {
+			return nil, false
+		}
+		return args[:2], true
+	}
+
+	return nil, false
+}
 
-		claims := t.Claims.(jwt.MapClaims)
+//authorize checks if token is allowed to run cmd with args, queue tokens can only run the list
+//commands needed by the clients, on the keys of their own namespace
+func (a *queueAuth) authorize(token string, cmd string, args [][]byte) bool {
+	g, ok := a.grant(token)
+	if !ok {
+		return false
+	}
 
-		if err := claims.Valid(); err != nil {
-			log.Errorf("itsyouonline calim validation error: %s", err)
+	if g == nil {
+		//full access
+		return true
+	}
+
+	keys, ok := commandKeys(cmd, args)
+	if !ok {
+		return false
+	}
+
+	for _, key := range keys {
+		if !g.allowed(cmd, string(key)) {
 			return false
 		}
+	}
 
-		if claims["azp"] == organization {
-			return true
-		}
+	return true
+}
 
-		var scopes []interface{}
-		if value, ok := claims["scope"]; ok {
-			if scopes, ok = value.([]interface{}); !ok {
-				return false
-			}
-		} else {
-			return false
+//jobQueues kinds of the per job queues a queue token can access for the jobs of its namespace
+var jobQueues = []string{ResultQueue, StreamQueue, TransferQueue, StdinQueue}
+
+func (g *grant) allowed(cmd string, key string) bool {
+	for _, q := range g.queues {
+		if key == q.key && (cmd == "rpush" || cmd == "lpush") {
+			return true
 		}
 
-		for _, s := range scopes {
-			switch s.(type) {
-			case string:
-				if s == scope {
-					return true
-				}
+		for _, kind := range jobQueues {
+			if strings.HasPrefix(key, jobQueue(kind, q.namespace, "")) {
+				return true
 			}
 		}
+	}
 
-		return false
-	}, nil
+	return false
 }
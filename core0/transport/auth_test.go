@@ -0,0 +1,57 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func testAuth() *queueAuth {
+	tenant := &sinkQueue{name: "tenant", key: "core:tenant", namespace: "tenant"}
+	auth := &queueAuth{
+		grants: cache.New(5*time.Minute, 1*time.Minute),
+	}
+
+	auth.grants.Set("tenant", &grant{queues: []*sinkQueue{tenant}}, cache.DefaultExpiration)
+	return auth
+}
+
+func args(values ...string) [][]byte {
+	var result [][]byte
+	for _, value := range values {
+		result = append(result, []byte(value))
+	}
+
+	return result
+}
+
+func TestQueueAuth_Authorize(t *testing.T) {
+	auth := testAuth()
+
+	assert.True(t, auth.authorize("tenant", "ping", nil))
+	assert.True(t, auth.authorize("tenant", "rpush", args("core:tenant", "{}")))
+	assert.False(t, auth.authorize("tenant", "rpush", args("core:default", "{}")))
+	assert.False(t, auth.authorize("tenant", "lrange", args("core:tenant", "0", "-1")))
+
+	assert.True(t, auth.authorize("tenant", "brpoplpush", args("result:tenant:job", "result:tenant:job", "10")))
+	assert.False(t, auth.authorize("tenant", "brpoplpush", args("result:tenant:job", "result:job", "10")))
+	assert.True(t, auth.authorize("tenant", "blpop", args("stream:tenant:job", "10")))
+	assert.False(t, auth.authorize("tenant", "blpop", args("stream:tenant:job", "stream:job", "10")))
+	assert.False(t, auth.authorize("tenant", "blpop", args("stream:job", "10")))
+	assert.True(t, auth.authorize("tenant", "lkeyexists", args("result:tenant:job:flag")))
+	assert.True(t, auth.authorize("tenant", "rpush", args("transfer:tenant:job", "data")))
+	assert.False(t, auth.authorize("tenant", "rpush", args("transfer:job", "data")))
+	assert.False(t, auth.authorize("tenant", "blpop", args("transfer:other:job", "10")))
+	assert.True(t, auth.authorize("tenant", "rpush", args("stdin:tenant:job", "ls\n")))
+	assert.False(t, auth.authorize("tenant", "rpush", args("stdin:other:job", "ls\n")))
+	assert.False(t, auth.authorize("tenant", "rpush", args("stdin:job", "ls\n")))
+
+	//commands with several keys or without a known key layout are rejected
+	assert.False(t, auth.authorize("tenant", "del", args("result:tenant:job", "result:job")))
+	assert.False(t, auth.authorize("tenant", "mget", args("result:tenant:job", "result:job")))
+	assert.False(t, auth.authorize("tenant", "exists", args("result:tenant:job")))
+
+	assert.False(t, auth.authorize("unknown", "ping", nil))
+}
@@ -11,6 +11,17 @@ import (
 
 const (
 	ReturnExpire = 300
+
+	//ResultQueue kind of the queue where the result of a job is pushed
+	ResultQueue = "result"
+	//StreamQueue kind of the queue where the output of a streamed job is pushed
+	StreamQueue = "stream"
+	//TransferQueue kind of the queue where the data chunks of a transfer job are pushed (by the client
+	//on upload and by the node on download), an empty chunk marks the end of the transfer.
+	TransferQueue = "transfer"
+	//StdinQueue kind of the queue where the client pushes the stdin of a corex.exec job, an empty chunk
+	//closes the stdin.
+	StdinQueue = "stdin"
 )
 
 /*
@@ -36,25 +47,46 @@ func (client *channel) String() string {
 	return "ledis"
 }
 
-func (cl *channel) GetNext(queue string, command *pm.Command) error {
-	payload, err := redis.ByteSlices(cl.db.BLPop([][]byte{[]byte(queue)}, 500*time.Millisecond))
+//GetNext pops the next command from the first non empty queue, and returns the queue name
+func (cl *channel) GetNext(queues []string, command *pm.Command) (string, error) {
+	keys := make([][]byte, 0, len(queues))
+	for _, queue := range queues {
+		keys = append(keys, []byte(queue))
+	}
+
+	payload, err := redis.ByteSlices(cl.db.BLPop(keys, 500*time.Millisecond))
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	if payload == nil || len(payload) < 2 {
-		return redis.ErrNil
+		return "", redis.ErrNil
+	}
+
+	return string(payload[0]), json.Unmarshal(payload[1], command)
+}
+
+//jobQueue gets a queue of kind (result, stream, ...) of job id, queues of jobs received on a named queue
+//are namespaced with the queue name
+func jobQueue(kind, namespace, id string) string {
+	if namespace == "" {
+		return fmt.Sprintf("%s:%s", kind, id)
 	}
 
-	return json.Unmarshal(payload[1], command)
+	return fmt.Sprintf("%s:%s:%s", kind, namespace, id)
+}
+
+//resultQueue gets the result queue of job id
+func resultQueue(namespace, id string) string {
+	return jobQueue(ResultQueue, namespace, id)
 }
 
-func (cl *channel) Respond(result *pm.JobResult) error {
+func (cl *channel) Respond(namespace string, result *pm.JobResult) error {
 	if result.ID == "" {
 		return fmt.Errorf("result with no ID, not pushing results back...")
 	}
 
-	queue := fmt.Sprintf("result:%s", result.ID)
+	queue := resultQueue(namespace, result.ID)
 
 	if err := cl.Push(queue, result); err != nil {
 		return err
@@ -99,8 +131,8 @@ func (cl *channel) cycle(queue string, timeout int) ([]byte, error) {
 	return data, nil
 }
 
-func (cl *channel) GetResponse(id string, timeout int) (*pm.JobResult, error) {
-	queue := fmt.Sprintf("result:%s", id)
+func (cl *channel) GetResponse(namespace, id string, timeout int) (*pm.JobResult, error) {
+	queue := resultQueue(namespace, id)
 	payload, err := cl.cycle(queue, timeout)
 	if err != nil {
 		return nil, err
@@ -114,20 +146,20 @@ func (cl *channel) GetResponse(id string, timeout int) (*pm.JobResult, error) {
 	return &result, nil
 }
 
-func (cl *channel) Flag(id string) error {
-	key := fmt.Sprintf("result:%s:flag", id)
+func (cl *channel) Flag(namespace, id string) error {
+	key := fmt.Sprintf("%s:flag", resultQueue(namespace, id))
 	_, err := cl.db.RPush([]byte(key), []byte(""))
 	return err
 }
 
-func (cl *channel) UnFlag(id string) error {
-	key := fmt.Sprintf("result:%s:flag", id)
+func (cl *channel) UnFlag(namespace, id string) error {
+	key := fmt.Sprintf("%s:flag", resultQueue(namespace, id))
 	_, err := cl.db.LExpire([]byte(key), ReturnExpire)
 	return err
 }
 
-func (cl *channel) Flagged(id string) bool {
-	key := fmt.Sprintf("result:%s:flag", id)
+func (cl *channel) Flagged(namespace, id string) bool {
+	key := fmt.Sprintf("%s:flag", resultQueue(namespace, id))
 	v, _ := cl.db.LKeyExists([]byte(key))
 	return v == 1
 }
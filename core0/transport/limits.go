@@ -0,0 +1,230 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/settings"
+)
+
+const (
+	throttleRate  = "rate"
+	throttleQueue = "queue"
+
+	monitorInterval = 30 * time.Second
+)
+
+var (
+	ErrRateLimited = errors.New("rate limit exceeded, slow down")
+	ErrQueueFull   = errors.New("queue is full, try again later")
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+//rateLimiter is a token bucket per client identity
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	buckets *cache.Cache
+	m       sync.Mutex
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: cache.New(10*time.Minute, 1*time.Minute),
+	}
+}
+
+//allow takes n tokens from the identity bucket, it returns false if the bucket doesn't have enough tokens
+func (r *rateLimiter) allow(identity string, n int) bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	now := time.Now()
+	b := &bucket{tokens: r.burst, last: now}
+	if v, ok := r.buckets.Get(identity); ok {
+		b = v.(*bucket)
+	}
+
+	b.tokens = math.Min(r.burst, b.tokens+now.Sub(b.last).Seconds()*r.rate)
+	b.last = now
+	r.buckets.Set(identity, b, cache.DefaultExpiration)
+
+	if b.tokens < float64(n) {
+		return false
+	}
+
+	b.tokens -= float64(n)
+	return true
+}
+
+//identity of the client, it's the auth token if set, otherwise the client ip
+func identity(r *request) string {
+	if r.Password != "" {
+		return r.Password
+	}
+
+	host, _, err := net.SplitHostPort(r.Remote)
+	if err != nil {
+		return r.Remote
+	}
+
+	return host
+}
+
+func (sink *Sink) queueOf(key string) *sinkQueue {
+	for _, queue := range sink.queues {
+		if queue.key == key {
+			return queue
+		}
+	}
+
+	return nil
+}
+
+//authorize is called by the sink proxy on each command, it applies the queues authorization then the
+//sink limits on the commands that are pushed to the sink queues.
+func (sink *Sink) authorize(r *request) error {
+	if sink.auth != nil && !sink.auth.authorize(r.Password, r.Cmd, r.Args) {
+		return errNotAuthorized
+	}
+
+	if (r.Cmd != "rpush" && r.Cmd != "lpush") || len(r.Args) < 2 {
+		return nil
+	}
+
+	queue := sink.queueOf(string(r.Args[0]))
+	if queue == nil {
+		return nil
+	}
+
+	count := len(r.Args) - 1
+	if sink.limiter != nil && !sink.limiter.allow(identity(r), count) {
+		sink.throttle(queue, throttleRate)
+		return ErrRateLimited
+	}
+
+	if max := settings.Settings.Sink.Limits.MaxQueueLength; max > 0 {
+		size, err := sink.LLen([]byte(queue.key))
+		if err != nil {
+			return err
+		}
+
+		sink.jm.Lock()
+		size += int64(queue.pending)
+		sink.jm.Unlock()
+
+		if size+int64(count) > max {
+			sink.throttle(queue, throttleQueue)
+			return ErrQueueFull
+		}
+	}
+
+	return nil
+}
+
+func (sink *Sink) throttle(queue *sinkQueue, reason string) {
+	sink.jm.Lock()
+	defer sink.jm.Unlock()
+
+	sink.throttled[fmt.Sprintf("%s:%s", queue.name, reason)]++
+}
+
+//acquire takes a slot for the job command, if the command reached its concurrency limit the
+//command is parked until a job of the same command exits. It must be called with jm locked.
+func (sink *Sink) acquire(job *sinkJob, cmd *pm.Command) bool {
+	max, ok := settings.Settings.Sink.Limits.Commands[cmd.Command]
+	if !ok || max <= 0 {
+		return true
+	}
+
+	job.command = cmd.Command
+	if sink.running[cmd.Command] < max {
+		sink.running[cmd.Command]++
+		return true
+	}
+
+	sink.pending[cmd.Command] = append(sink.pending[cmd.Command], cmd)
+	job.queue.pending++
+	return false
+}
+
+//unpark releases a slot of command, and gets the next parked command (if any) that takes
+//the released slot. It must be called with jm locked.
+func (sink *Sink) unpark(command string) *pm.Command {
+	pending := sink.pending[command]
+	if len(pending) == 0 {
+		sink.running[command]--
+		return nil
+	}
+
+	next := pending[0]
+	if len(pending) == 1 {
+		delete(sink.pending, command)
+	} else {
+		sink.pending[command] = pending[1:]
+	}
+
+	if job, ok := sink.jobs[next.ID]; ok {
+		job.queue.pending--
+	}
+
+	return next
+}
+
+//monitor reports the sink queues and limits metrics
+func (sink *Sink) monitor() {
+	for range time.Tick(monitorInterval) {
+		for _, queue := range sink.queues {
+			size, err := sink.LLen([]byte(queue.key))
+			if err != nil {
+				log.Errorf("failed to get queue '%s' length: %s", queue.name, err)
+				continue
+			}
+
+			sink.jm.Lock()
+			running, pending := queue.running, queue.pending
+			sink.jm.Unlock()
+
+			pm.Aggregate(pm.AggreagteAverage, "sink.queue.length", float64(size), queue.name)
+			pm.Aggregate(pm.AggreagteAverage, "sink.queue.running", float64(running), queue.name)
+			pm.Aggregate(pm.AggreagteAverage, "sink.queue.pending", float64(pending), queue.name)
+
+			for _, reason := range []string{throttleRate, throttleQueue} {
+				sink.jm.Lock()
+				count := sink.throttled[fmt.Sprintf("%s:%s", queue.name, reason)]
+				sink.jm.Unlock()
+
+				pm.Aggregate(pm.AggreagteDifference, "sink.throttled", float64(count), queue.name,
+					pm.Tag{Key: "reason", Value: reason},
+				)
+			}
+		}
+
+		sink.jm.Lock()
+		pending := make(map[string]int)
+		for command := range settings.Settings.Sink.Limits.Commands {
+			pending[command] = len(sink.pending[command])
+		}
+		sink.jm.Unlock()
+
+		for command, count := range pending {
+			pm.Aggregate(pm.AggreagteAverage, "sink.command.pending", float64(count), command)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/settings"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	limiter := newRateLimiter(10, 2)
+
+	assert.True(t, limiter.allow("client", 1))
+	assert.True(t, limiter.allow("client", 1))
+	assert.False(t, limiter.allow("client", 1))
+
+	//other identities have their own bucket
+	assert.True(t, limiter.allow("other", 2))
+
+	<-time.After(200 * time.Millisecond)
+	assert.True(t, limiter.allow("client", 2))
+	assert.False(t, limiter.allow("client", 1))
+}
+
+func TestSink_CommandLimit(t *testing.T) {
+	settings.Settings.Sink.Limits.Commands = map[string]int{"core.system": 1}
+	defer func() {
+		settings.Settings.Sink.Limits.Commands = nil
+	}()
+
+	sink := &Sink{
+		queues: []*sinkQueue{
+			{name: "default", key: SinkQueue},
+		},
+		jobs:    make(map[string]*sinkJob),
+		running: make(map[string]int),
+		pending: make(map[string][]*pm.Command),
+	}
+
+	_, ok, _ := sink.track(SinkQueue, &pm.Command{ID: "job-1", Command: "core.system"})
+	assert.True(t, ok)
+
+	_, ok, _ = sink.track(SinkQueue, &pm.Command{ID: "job-2", Command: "core.system"})
+	assert.False(t, ok)
+	assert.Equal(t, 1, sink.queues[0].pending)
+
+	//other commands are not limited
+	_, ok, _ = sink.track(SinkQueue, &pm.Command{ID: "job-3", Command: "core.ping"})
+	assert.True(t, ok)
+
+	sink.jm.Lock()
+	next := sink.unpark("core.system")
+	sink.jm.Unlock()
+
+	if ok := assert.NotNil(t, next); !ok {
+		t.Fatal()
+	}
+
+	assert.Equal(t, "job-2", next.ID)
+	assert.Equal(t, 0, sink.queues[0].pending)
+	assert.Equal(t, 1, sink.running["core.system"])
+}
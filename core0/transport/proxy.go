@@ -0,0 +1,135 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/siddontang/goredis"
+)
+
+var (
+	errEmptyCommand          = errors.New("empty command")
+	errNotAuthenticated      = errors.New("not authenticated")
+	errNotAuthorized         = errors.New("not authorized")
+	errAuthenticationFailure = errors.New("authentication failure")
+)
+
+//request is a command of a sink client, it's passed to the proxy authorize function
+type request struct {
+	Remote   string
+	Password string
+	Cmd      string
+	Args     [][]byte
+}
+
+/*
+proxy serves the sink clients. It authenticates the clients and authorizes each command before it's
+forwarded to the ledis server of the sink, which only listens on a local unix socket. The checks are done
+here so the vendored ledis is used as it is.
+*/
+type proxy struct {
+	listener     net.Listener
+	backend      string
+	authenticate func(password string) bool //nil if authentication is not enabled
+	authorize    func(r *request) error
+}
+
+func (p *proxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err, ok := err.(net.Error); ok && err.Temporary() {
+			log.Errorf("failed to accept sink connection: %s", err)
+			continue
+		} else if err != nil {
+			log.Errorf("sink proxy stopped: %s", err)
+			return
+		}
+
+		go p.handle(conn)
+	}
+}
+
+//handle serves the commands of a client, each client has its own connection to the ledis server so
+//blocking commands and the selected db work as if the client was connected to ledis directly
+func (p *proxy) handle(conn net.Conn) {
+	client, _ := goredis.NewConn(conn)
+	defer client.Close()
+
+	backend, err := goredis.Connect(p.backend)
+	if err != nil {
+		log.Errorf("failed to connect to sink server: %s", err)
+		client.SendValue(err)
+		return
+	}
+
+	defer backend.Close()
+
+	r := request{
+		Remote: conn.RemoteAddr().String(),
+	}
+
+	authenticated := p.authenticate == nil
+	for {
+		req, err := client.ReceiveRequest()
+		if err != nil {
+			return
+		}
+
+		if len(req) == 0 {
+			if err := client.SendValue(errEmptyCommand); err != nil {
+				return
+			}
+			continue
+		}
+
+		r.Cmd = strings.ToLower(string(req[0]))
+		r.Args = req[1:]
+
+		var reply interface{}
+		switch {
+		case r.Cmd == "quit":
+			client.SendValue("OK")
+			return
+		case r.Cmd == "auth" && p.authenticate != nil:
+			reply, authenticated = p.auth(&r)
+		case !authenticated:
+			reply = errNotAuthenticated
+		default:
+			if err := p.authorize(&r); err != nil {
+				reply = err
+				break
+			}
+
+			args := make([]interface{}, len(r.Args))
+			for i, arg := range r.Args {
+				args[i] = arg
+			}
+
+			reply, err = backend.Do(r.Cmd, args...)
+			if _, ok := err.(goredis.Error); err != nil && !ok {
+				log.Errorf("failed to forward command to sink server: %s", err)
+				return
+			}
+		}
+
+		if err := client.SendValue(reply); err != nil {
+			return
+		}
+	}
+}
+
+//auth handles the auth command, the password is kept for the authorization of the next commands
+func (p *proxy) auth(r *request) (interface{}, bool) {
+	r.Password = ""
+	if len(r.Args) != 1 {
+		return errAuthenticationFailure, false
+	}
+
+	if !p.authenticate(string(r.Args[0])) {
+		return errAuthenticationFailure, false
+	}
+
+	r.Password = string(r.Args[0])
+	return "OK", true
+}
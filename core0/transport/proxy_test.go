@@ -0,0 +1,118 @@
+package transport
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/siddontang/ledisdb/config"
+	"github.com/siddontang/ledisdb/server"
+	"github.com/stretchr/testify/assert"
+)
+
+//proxyTest starts a ledis server on a unix socket and a proxy in front of it, it returns a connection
+//to the proxy
+func proxyTest(t *testing.T, p *proxy) (redis.Conn, func()) {
+	dir, err := ioutil.TempDir("", "proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.NewConfigDefault()
+	cfg.DBName = "memory"
+	cfg.DataDir = dir
+	cfg.Addr = path.Join(dir, "sink.sock")
+
+	app, err := server.NewApp(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Run()
+
+	if p.listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+
+	p.backend = cfg.Addr
+	go p.serve()
+
+	conn, err := redis.Dial("tcp", p.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn, func() {
+		conn.Close()
+		p.listener.Close()
+		app.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestProxy_Authorize(t *testing.T) {
+	var requests []request
+	conn, cleanup := proxyTest(t, &proxy{
+		authorize: func(r *request) error {
+			requests = append(requests, *r)
+			if r.Cmd == "del" {
+				return errNotAuthorized
+			}
+			return nil
+		},
+	})
+	defer cleanup()
+
+	_, err := conn.Do("RPUSH", "queue", "a", "b")
+	assert.NoError(t, err)
+
+	values, err := redis.Strings(conn.Do("LRANGE", "queue", 0, -1))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, values)
+
+	_, err = conn.Do("DEL", "queue")
+	assert.EqualError(t, err, errNotAuthorized.Error())
+
+	value, err := conn.Do("BLPOP", "empty", 1)
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+
+	if assert.Len(t, requests, 4) {
+		assert.Equal(t, "rpush", requests[0].Cmd)
+		assert.Equal(t, [][]byte{[]byte("queue"), []byte("a"), []byte("b")}, requests[0].Args)
+	}
+}
+
+func TestProxy_Authenticate(t *testing.T) {
+	var password string
+	conn, cleanup := proxyTest(t, &proxy{
+		authenticate: func(p string) bool {
+			return p == "secret"
+		},
+		authorize: func(r *request) error {
+			password = r.Password
+			return nil
+		},
+	})
+	defer cleanup()
+
+	_, err := conn.Do("PING")
+	assert.EqualError(t, err, errNotAuthenticated.Error())
+
+	_, err = conn.Do("AUTH", "wrong")
+	assert.EqualError(t, err, errAuthenticationFailure.Error())
+
+	_, err = conn.Do("PING")
+	assert.EqualError(t, err, errNotAuthenticated.Error())
+
+	ok, err := redis.String(conn.Do("AUTH", "secret"))
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", ok)
+
+	pong, err := redis.String(conn.Do("PING"))
+	assert.NoError(t, err)
+	assert.Equal(t, "PONG", pong)
+	assert.Equal(t, "secret", password)
+}
@@ -1,14 +1,18 @@
 package transport
 
 import (
+	"crypto/tls"
 	"fmt"
 	"github.com/garyburd/redigo/redis"
 	"github.com/siddontang/ledisdb/config"
 	"github.com/siddontang/ledisdb/ledis"
 	"github.com/siddontang/ledisdb/server"
 	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/settings"
 	"github.com/zero-os/0-core/core0/assets"
 	"github.com/zero-os/0-core/core0/options"
+	"os"
+	"sort"
 	"sync"
 	"time"
 )
@@ -16,13 +20,63 @@ import (
 const (
 	SinkQueue = "core:default"
 	DBIndex   = 0
+
+	//sinkSocket is where the ledis server of the sink listens, clients connect through the sink proxy
+	sinkSocket = "/var/run/core0.sink.sock"
+)
+
+var (
+	errDuplicateID = fmt.Errorf("job id is used by a job of another queue")
 )
 
+/*
+sinkQueue is an ingress queue of the sink. The default queue (core:default) has no namespace
+and no budget, results of commands received on a named queue are namespaced with the queue name
+*/
+type sinkQueue struct {
+	name         string
+	key          string
+	namespace    string
+	organization string
+	max          int
+	running      int
+	pending      int
+}
+
+//available returns true if the queue didn't reach its concurrency budget
+func (q *sinkQueue) available() bool {
+	return q.max <= 0 || q.running < q.max
+}
+
+//sinkJob tracks a job that was received on one of the sink queues (or was started on behalf of one)
+type sinkJob struct {
+	queue   *sinkQueue
+	budget  bool
+	command string //set if the command has a concurrency limit
+}
+
+//Responder receives the results of the jobs of a result namespace instead of the sink result queues
+type Responder interface {
+	Respond(result *pm.JobResult) error
+}
+
 type Sink struct {
 	ch     *channel
 	server *server.App
+	proxy  *proxy
 	db     *ledis.DB
 
+	auth       *queueAuth
+	limiter    *rateLimiter
+	queues     []*sinkQueue
+	offset     int
+	jobs       map[string]*sinkJob
+	responders map[string]Responder
+	running    map[string]int
+	pending    map[string][]*pm.Command
+	throttled  map[string]int64
+	jm         sync.Mutex
+
 	l sync.RWMutex
 }
 
@@ -38,14 +92,23 @@ func NewSink(c SinkConfig) (*Sink, error) {
 	cfg := config.NewConfigDefault()
 	cfg.DBName = "memory"
 	cfg.DataDir = "/var/core0"
-	cfg.Addr = fmt.Sprintf(":%d", c.Port)
+	cfg.Addr = sinkSocket
+	cfg.AddrUnixSocketPerm = "0600"
+
+	queues := sinkQueues()
+	var org string
 	if orgs, ok := options.Options.Kernel.Get("organization"); ok {
-		org := orgs[len(orgs)-1]
-		auth, err := AuthMethod(org, string(assets.MustAsset("text/itsyouonline.pub")))
+		org = orgs[len(orgs)-1]
+	}
+
+	var auth *queueAuth
+	if org != "" || len(settings.Settings.Sink.Queues) > 0 {
+		//named queues must always be authenticated, otherwise anyone can read results of other tenants
+		var err error
+		auth, err = newQueueAuth(org, string(assets.MustAsset("text/itsyouonline.pub")), queues)
 		if err != nil {
 			return nil, err
 		}
-		cfg.AuthMethod = auth
 	}
 
 	crt, key, err := generateCRT()
@@ -53,10 +116,20 @@ func NewSink(c SinkConfig) (*Sink, error) {
 		return nil, err
 	}
 
-	cfg.TLS = config.TLS{
-		Enabled:     true,
-		Certificate: crt,
-		Key:         key,
+	certificate, err := tls.LoadX509KeyPair(crt, key)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", c.Port), &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Remove(sinkSocket); err != nil && !os.IsNotExist(err) {
+		return nil, err
 	}
 
 	server, err := server.NewApp(cfg)
@@ -70,9 +143,30 @@ func NewSink(c SinkConfig) (*Sink, error) {
 	}
 
 	sink := &Sink{
-		server: server,
-		db:     db,
-		ch:     newChannel(db),
+		server:     server,
+		db:         db,
+		ch:         newChannel(db),
+		auth:       auth,
+		queues:     queues,
+		jobs:       make(map[string]*sinkJob),
+		responders: make(map[string]Responder),
+		running:    make(map[string]int),
+		pending:    make(map[string][]*pm.Command),
+		throttled:  make(map[string]int64),
+	}
+
+	if limits := settings.Settings.Sink.Limits; limits.Rate > 0 {
+		sink.limiter = newRateLimiter(limits.Rate, limits.Burst)
+	}
+
+	sink.proxy = &proxy{
+		listener:  listener,
+		backend:   sinkSocket,
+		authorize: sink.authorize,
+	}
+
+	if auth != nil {
+		sink.proxy.authenticate = auth.Authenticate
 	}
 
 	pm.AddHandle(sink)
@@ -80,6 +174,41 @@ func NewSink(c SinkConfig) (*Sink, error) {
 	return sink, nil
 }
 
+//sinkQueues builds the list of the sink queues, the default queue followed by the named
+//queues from the settings.
+func sinkQueues() []*sinkQueue {
+	queues := []*sinkQueue{
+		{name: "default", key: SinkQueue},
+	}
+
+	var names []string
+	for name := range settings.Settings.Sink.Queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cfg := settings.Settings.Sink.Queues[name]
+		queues = append(queues, &sinkQueue{
+			name:         name,
+			key:          fmt.Sprintf("core:%s", name),
+			namespace:    name,
+			organization: cfg.Organization,
+			max:          cfg.MaxJobs,
+		})
+	}
+
+	if _, ok := GetUpstreamConfig(); ok {
+		queues = append(queues, &sinkQueue{
+			name:      upstreamNamespace,
+			key:       UpstreamQueue,
+			namespace: upstreamNamespace,
+		})
+	}
+
+	return queues
+}
+
 func (sink *Sink) RPush(key []byte, args ...[]byte) (int64, error) {
 	sink.l.RLock()
 	defer sink.l.RUnlock()
@@ -110,6 +239,26 @@ func (sink *Sink) Del(keys ...[]byte) (int64, error) {
 	return sink.db.Del(keys...)
 }
 
+func (sink *Sink) LLen(key []byte) (int64, error) {
+	sink.l.RLock()
+	defer sink.l.RUnlock()
+	return sink.db.LLen(key)
+}
+
+//BLPop pops the first element of the list at key, it returns a nil value if timeout is reached
+func (sink *Sink) BLPop(key []byte, timeout time.Duration) ([]byte, error) {
+	sink.l.RLock()
+	defer sink.l.RUnlock()
+	payload, err := redis.ByteSlices(sink.db.BLPop([][]byte{key}, timeout))
+	if err == redis.ErrNil || len(payload) < 2 {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return payload[1], nil
+}
+
 func (sink *Sink) LExpire(key []byte, duration int64) (int64, error) {
 	sink.l.RLock()
 	defer sink.l.RUnlock()
@@ -123,15 +272,135 @@ func (sink *Sink) Result(cmd *pm.Command, result *pm.JobResult) {
 	}
 }
 
+//next gets the keys of the queues to read from, in a round robin order so a busy queue
+//can't starve the others. Queues that reached their concurrency budget are skipped.
+func (sink *Sink) next() []string {
+	sink.jm.Lock()
+	defer sink.jm.Unlock()
+
+	var keys []string
+	for i := range sink.queues {
+		queue := sink.queues[(sink.offset+i)%len(sink.queues)]
+		if queue.available() {
+			keys = append(keys, queue.key)
+		}
+	}
+
+	sink.offset = (sink.offset + 1) % len(sink.queues)
+	return keys
+}
+
+/*
+track registers a job that was received on queue key, it returns the result namespace of the job
+and false if the job has to wait because its command reached the concurrency limit. It fails if
+the job id is used by a job of another queue, the duplicate can't be reported through Forward since
+its result would go to the namespace of the running job.
+*/
+func (sink *Sink) track(key string, cmd *pm.Command) (string, bool, error) {
+	sink.jm.Lock()
+	defer sink.jm.Unlock()
+
+	for _, queue := range sink.queues {
+		if queue.key != key {
+			continue
+		}
+
+		if job, ok := sink.jobs[cmd.ID]; ok {
+			if job.queue != queue {
+				return queue.namespace, false, errDuplicateID
+			}
+			//duplicate job id, the running job holds the slot.
+			return queue.namespace, true, nil
+		}
+
+		job := &sinkJob{queue: queue, budget: true}
+		sink.jobs[cmd.ID] = job
+		queue.running++
+
+		return queue.namespace, sink.acquire(job, cmd), nil
+	}
+
+	return "", true, nil
+}
+
+//release a tracked job and gets its result namespace
+func (sink *Sink) release(id string) string {
+	sink.jm.Lock()
+
+	job, ok := sink.jobs[id]
+	if !ok {
+		sink.jm.Unlock()
+		return ""
+	}
+
+	delete(sink.jobs, id)
+	if job.budget {
+		job.queue.running--
+	}
+
+	var next *pm.Command
+	if job.command != "" {
+		next = sink.unpark(job.command)
+	}
+
+	sink.jm.Unlock()
+
+	if next != nil {
+		go sink.run(next)
+	}
+
+	return job.queue.namespace
+}
+
+//namespace gets the result namespace of job id
+func (sink *Sink) namespace(id string) string {
+	sink.jm.Lock()
+	defer sink.jm.Unlock()
+
+	if job, ok := sink.jobs[id]; ok {
+		return job.queue.namespace
+	}
+
+	return ""
+}
+
+//JobQueue gets the queue of kind (see StreamQueue) of job id. It's namespaced like the job results, so
+//a queue token can only reach the queues of its own jobs. The job must be tracked (running).
+func (sink *Sink) JobQueue(kind, id string) string {
+	return jobQueue(kind, sink.namespace(id), id)
+}
+
+//Inherit makes the child job report its result in the same namespace as the parent job, it's used
+//when a job starts another job on behalf of the same client (for example corex.dispatch)
+func (sink *Sink) Inherit(parent, child string) {
+	sink.jm.Lock()
+	defer sink.jm.Unlock()
+
+	job, ok := sink.jobs[parent]
+	if !ok {
+		return
+	}
+
+	if _, ok := sink.jobs[child]; ok {
+		return
+	}
+
+	sink.jobs[child] = &sinkJob{queue: job.queue}
+}
+
 func (sink *Sink) process() {
 
 	for {
+		//commands are left on the queues while pm is saturated, so the queues length (and the
+		//max_queue_length limit) reflects the commands that are waiting to run
+		pm.WaitSlot()
+
 		var command pm.Command
-		err := sink.ch.GetNext(SinkQueue, &command)
+		queue, err := sink.ch.GetNext(sink.next(), &command)
 		if err == redis.ErrNil {
 			continue
 		} else if err != nil {
-			log.Errorf("Failed to get next command from (%s): %s", SinkQueue, err)
+			log.Errorf("Failed to get next command from (%s): %s", queue, err)
 			<-time.After(200 * time.Millisecond)
 			continue
 		}
@@ -141,44 +410,98 @@ func (sink *Sink) process() {
 			continue
 		}
 
-		sink.ch.Flag(command.ID)
-		log.Debugf("Starting command %s", &command)
-
-		_, err = pm.Run(&command)
-
-		if err == pm.UnknownCommandErr {
+		namespace, ok, err := sink.track(queue, &command)
+		sink.ch.Flag(namespace, command.ID)
+		if err != nil {
+			log.Warningf("dropping command %s: %s", &command, err)
 			result := pm.NewJobResult(&command)
-			result.State = pm.StateUnknownCmd
-			sink.Forward(result)
-		} else if err != nil {
-			log.Errorf("Unknown error while processing command (%s): %s", command, err)
+			result.State = pm.StateDuplicateID
+			sink.ch.UnFlag(namespace, command.ID)
+			sink.respond(namespace, result)
+			continue
+		} else if !ok {
+			log.Debugf("Command %s reached its concurrency limit, waiting", &command)
+			continue
 		}
+
+		sink.run(&command)
 	}
 }
 
+func (sink *Sink) run(command *pm.Command) {
+	log.Debugf("Starting command %s", command)
+
+	_, err := pm.Run(command)
+
+	if err == pm.UnknownCommandErr {
+		result := pm.NewJobResult(command)
+		result.State = pm.StateUnknownCmd
+		sink.Forward(result)
+	} else if err == pm.DuplicateIDErr {
+		result := pm.NewJobResult(command)
+		result.State = pm.StateDuplicateID
+		sink.Forward(result)
+	} else if err != nil {
+		sink.release(command.ID)
+		log.Errorf("Unknown error while processing command (%s): %s", command, err)
+	}
+}
+
+//SetResponder sets the responder of the results of namespace
+func (sink *Sink) SetResponder(namespace string, responder Responder) {
+	sink.jm.Lock()
+	defer sink.jm.Unlock()
+
+	sink.responders[namespace] = responder
+}
+
+func (sink *Sink) responder(namespace string) Responder {
+	sink.jm.Lock()
+	defer sink.jm.Unlock()
+
+	return sink.responders[namespace]
+}
+
 func (sink *Sink) Forward(result *pm.JobResult) error {
+	var namespace string
 	if result.State != pm.StateDuplicateID {
 		/*
 			Client tried to push a command with a duplicate id, it means another job
 			is running with that ID so we shouldn't flag
 		*/
-		sink.ch.UnFlag(result.ID)
+		namespace = sink.release(result.ID)
+		sink.ch.UnFlag(namespace, result.ID)
+	} else {
+		namespace = sink.namespace(result.ID)
 	}
-	return sink.ch.Respond(result)
+
+	return sink.respond(namespace, result)
+}
+
+//respond delivers the result to the responder of namespace if any, otherwise to the result queue
+func (sink *Sink) respond(namespace string, result *pm.JobResult) error {
+	if responder := sink.responder(namespace); responder != nil {
+		return responder.Respond(result)
+	}
+
+	return sink.ch.Respond(namespace, result)
 }
 
 func (sink *Sink) Flag(id string) error {
-	return sink.ch.Flag(id)
+	return sink.ch.Flag(sink.namespace(id), id)
 }
 
 func (sink *Sink) Start() {
 	go sink.server.Run()
+	go sink.proxy.serve()
 	go sink.process()
+	go sink.monitor()
 }
 
 func (sink *Sink) GetResult(job string, timeout int) (*pm.JobResult, error) {
-	if sink.ch.Flagged(job) {
-		return sink.ch.GetResponse(job, timeout)
+	namespace := sink.namespace(job)
+	if sink.ch.Flagged(namespace, job) {
+		return sink.ch.GetResponse(namespace, job, timeout)
 	} else {
 		return nil, fmt.Errorf("unknown job id '%s' (may be it has expired)", job)
 	}
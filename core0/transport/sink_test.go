@@ -0,0 +1,39 @@
+package transport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zero-os/0-core/base/pm"
+)
+
+func TestSink_TrackDuplicateID(t *testing.T) {
+	sink := &Sink{
+		queues: []*sinkQueue{
+			{name: "default", key: SinkQueue},
+			{name: "tenant", key: "core:tenant", namespace: "tenant"},
+		},
+		jobs:    make(map[string]*sinkJob),
+		running: make(map[string]int),
+		pending: make(map[string][]*pm.Command),
+	}
+
+	namespace, ok, err := sink.track("core:tenant", &pm.Command{ID: "job"})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "tenant", namespace)
+
+	//same queue, reported as a duplicate by the process manager
+	_, ok, err = sink.track("core:tenant", &pm.Command{ID: "job"})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	namespace, _, err = sink.track(SinkQueue, &pm.Command{ID: "job"})
+	assert.Equal(t, errDuplicateID, err)
+	assert.Equal(t, "", namespace)
+
+	//the running job still reports to its own namespace
+	assert.Equal(t, "tenant", sink.namespace("job"))
+	assert.Equal(t, 1, sink.queues[1].running)
+	assert.Equal(t, 0, sink.queues[0].running)
+}
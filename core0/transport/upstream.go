@@ -0,0 +1,292 @@
+package transport
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/settings"
+	"github.com/zero-os/0-core/base/utils"
+	"github.com/zero-os/0-core/core0/options"
+)
+
+const (
+	//UpstreamQueue local sink queue that receives the commands pulled from the upstream
+	UpstreamQueue = "core:upstream"
+
+	upstreamNamespace  = "upstream"
+	upstreamPullWait   = 10 //seconds
+	upstreamBufferSize = 10000
+	upstreamQueueSize  = 10000
+	upstreamMinBackoff = 1 * time.Second
+	upstreamMaxBackoff = 1 * time.Minute
+)
+
+type UpstreamConfig struct {
+	Address  string
+	Password string
+	Node     string
+	TLS      bool
+}
+
+/*
+GetUpstreamConfig gets the upstream (call-home) configuration. The kernel params `upstream`, `upstream-node`
+and `upstream-password` take precedence over the `[upstream]` section of the settings. It returns false if
+no upstream is configured.
+*/
+func GetUpstreamConfig() (UpstreamConfig, bool) {
+	cfg := UpstreamConfig{
+		Address:  settings.Settings.Upstream.Address,
+		Password: settings.Settings.Upstream.Password,
+		Node:     settings.Settings.Upstream.Node,
+		TLS:      settings.Settings.Upstream.TLS,
+	}
+
+	kernel := options.Options.Kernel
+	if values, ok := kernel.Get("upstream"); ok {
+		cfg.Address = values[len(values)-1]
+	}
+	if values, ok := kernel.Get("upstream-node"); ok {
+		cfg.Node = values[len(values)-1]
+	}
+	if values, ok := kernel.Get("upstream-password"); ok {
+		cfg.Password = values[len(values)-1]
+	}
+	if kernel.Is("upstream-tls") {
+		cfg.TLS = true
+	}
+
+	if cfg.Address == "" {
+		return cfg, false
+	}
+
+	if cfg.Node == "" {
+		cfg.Node = nodeID()
+	}
+
+	return cfg, true
+}
+
+//nodeID gets the mac address of the first physical nic, and falls back to the hostname
+func nodeID() string {
+	if nics, err := net.Interfaces(); err == nil {
+		for _, nic := range nics {
+			if nic.Flags&net.FlagLoopback != 0 || len(nic.HardwareAddr) == 0 {
+				continue
+			}
+
+			if _, err := os.Stat(fmt.Sprintf("/sys/class/net/%s/device", nic.Name)); err != nil {
+				//virtual device (bridge, veth, etc...)
+				continue
+			}
+
+			return strings.Replace(nic.HardwareAddr.String(), ":", "", -1)
+		}
+	}
+
+	name, _ := os.Hostname()
+	return name
+}
+
+//upstreamCmd is a redis command to run against the upstream
+type upstreamCmd struct {
+	name string
+	args []interface{}
+}
+
+/*
+Upstream is the outbound (call-home) transport. Instead of waiting for clients to connect to the node
+it connects to an upstream redis (or controller), pulls commands from `core:<node>` and pushes results
+to `result:<node>:<id>`, logs to `logs:<node>` and stats to `stats:<node>`. Commands and results use
+the same encoding as the sink, so a client can talk to the node through the upstream by using the node
+id as the sink queue name.
+*/
+type Upstream struct {
+	cfg  UpstreamConfig
+	pool *redis.Pool
+	sink *Sink
+
+	ch chan []upstreamCmd
+}
+
+func NewUpstream(sink *Sink, cfg UpstreamConfig) *Upstream {
+	var opts []redis.DialOption
+	if cfg.TLS {
+		opts = append(opts, redis.DialNetDial(func(network, address string) (net.Conn, error) {
+			return tls.Dial(network, address, &tls.Config{})
+		}))
+	}
+
+	up := &Upstream{
+		cfg:  cfg,
+		pool: utils.NewRedisPool("tcp", cfg.Address, cfg.Password, opts...),
+		sink: sink,
+		ch:   make(chan []upstreamCmd, upstreamBufferSize),
+	}
+
+	sink.SetResponder(upstreamNamespace, up)
+	return up
+}
+
+func (up *Upstream) key(kind string) string {
+	return fmt.Sprintf("%s:%s", kind, up.cfg.Node)
+}
+
+func (up *Upstream) resultQueue(id string) string {
+	return resultQueue(up.cfg.Node, id)
+}
+
+func (up *Upstream) next() error {
+	conn := up.pool.Get()
+	defer conn.Close()
+
+	payload, err := redis.ByteSlices(conn.Do("BLPOP", up.key("core"), upstreamPullWait))
+	if err != nil {
+		return err
+	}
+
+	if len(payload) < 2 {
+		return redis.ErrNil
+	}
+
+	var command pm.Command
+	if err := json.Unmarshal(payload[1], &command); err != nil {
+		log.Errorf("dropping invalid upstream command: %s", err)
+		return nil
+	}
+
+	if command.ID == "" {
+		log.Warningf("receiving a command with no ID from upstream, dropping")
+		return nil
+	}
+
+	if _, err := conn.Do("RPUSH", fmt.Sprintf("%s:flag", up.resultQueue(command.ID)), ""); err != nil {
+		log.Errorf("failed to flag upstream job %s: %s", command.ID, err)
+	}
+
+	_, err = up.sink.RPush([]byte(UpstreamQueue), payload[1])
+	return err
+}
+
+func (up *Upstream) pull() {
+	backoff := upstreamMinBackoff
+	for {
+		err := up.next()
+		if err == nil || err == redis.ErrNil {
+			backoff = upstreamMinBackoff
+			continue
+		}
+
+		log.Errorf("failed to pull commands from upstream (%s), retrying in %s: %s", up.cfg.Address, backoff, err)
+		<-time.After(backoff)
+		if backoff *= 2; backoff > upstreamMaxBackoff {
+			backoff = upstreamMaxBackoff
+		}
+	}
+}
+
+func (up *Upstream) do(cmds []upstreamCmd) error {
+	conn := up.pool.Get()
+	defer conn.Close()
+
+	for _, cmd := range cmds {
+		if err := conn.Send(cmd.name, cmd.args...); err != nil {
+			return err
+		}
+	}
+
+	_, err := conn.Do("")
+	return err
+}
+
+func (up *Upstream) push() {
+	for cmds := range up.ch {
+		backoff := upstreamMinBackoff
+		for {
+			err := up.do(cmds)
+			if err == nil {
+				break
+			}
+
+			log.Errorf("failed to push to upstream (%s), retrying in %s: %s", up.cfg.Address, backoff, err)
+			<-time.After(backoff)
+			if backoff *= 2; backoff > upstreamMaxBackoff {
+				backoff = upstreamMaxBackoff
+			}
+		}
+	}
+}
+
+//enqueue never blocks, if the upstream is unreachable for long enough to fill the buffer the data is dropped
+func (up *Upstream) enqueue(cmds ...upstreamCmd) {
+	select {
+	case up.ch <- cmds:
+	default:
+		log.Errorf("upstream buffer is full, dropping data")
+	}
+}
+
+//Respond Responder implementation, pushes the job result to the upstream
+func (up *Upstream) Respond(result *pm.JobResult) error {
+	if result.ID == "" {
+		return fmt.Errorf("result with no ID, not pushing results back...")
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	queue := up.resultQueue(result.ID)
+	up.enqueue(
+		upstreamCmd{"RPUSH", []interface{}{queue, data}},
+		upstreamCmd{"EXPIRE", []interface{}{queue, ReturnExpire}},
+		upstreamCmd{"EXPIRE", []interface{}{fmt.Sprintf("%s:flag", queue), ReturnExpire}},
+	)
+
+	return nil
+}
+
+//Push pushes data to the node queue `<kind>:<node>`, the queue is trimmed to the last size entries
+func (up *Upstream) Push(kind string, data []byte, size int64) {
+	if size <= 0 {
+		size = upstreamQueueSize
+	}
+
+	queue := up.key(kind)
+	up.enqueue(
+		upstreamCmd{"RPUSH", []interface{}{queue, data}},
+		upstreamCmd{"LTRIM", []interface{}{queue, -1 * size, -1}},
+	)
+}
+
+//Stats StatsHandler implementation, raw stats are pushed to the upstream for aggregation
+func (up *Upstream) Stats(op string, key string, value float64, id string, tags ...pm.Tag) {
+	data, err := json.Marshal(map[string]interface{}{
+		"operation": op,
+		"key":       key,
+		"value":     value,
+		"id":        id,
+		"tags":      tags,
+		"time":      time.Now().Unix(),
+	})
+
+	if err != nil {
+		log.Errorf("failed to marshal stats for upstream: %s", err)
+		return
+	}
+
+	up.Push("stats", data, 0)
+}
+
+func (up *Upstream) Start() {
+	log.Infof("Starting upstream transport to %s (node: %s)", up.cfg.Address, up.cfg.Node)
+	go up.pull()
+	go up.push()
+}
@@ -0,0 +1,126 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/siddontang/ledisdb/config"
+	"github.com/siddontang/ledisdb/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/zero-os/0-core/base/pm"
+)
+
+//upstreamTest starts a ledis server that plays the role of the upstream controller, the same
+//server is also used as the node local sink db.
+func upstreamTest(t *testing.T) (*Upstream, *server.App, func()) {
+	dir, err := ioutil.TempDir("", "upstream")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	cfg := config.NewConfigDefault()
+	cfg.DBName = "memory"
+	cfg.DataDir = dir
+	cfg.Addr = addr
+
+	app, err := server.NewApp(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Run()
+
+	db, err := app.Ledis().Select(DBIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &Sink{
+		db:         db,
+		ch:         newChannel(db),
+		jobs:       make(map[string]*sinkJob),
+		responders: make(map[string]Responder),
+		running:    make(map[string]int),
+		pending:    make(map[string][]*pm.Command),
+		throttled:  make(map[string]int64),
+		queues: []*sinkQueue{
+			{name: upstreamNamespace, key: UpstreamQueue, namespace: upstreamNamespace},
+		},
+	}
+
+	up := NewUpstream(sink, UpstreamConfig{Address: addr, Node: "node"})
+
+	return up, app, func() {
+		app.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestUpstream_Next(t *testing.T) {
+	up, app, cleanup := upstreamTest(t)
+	defer cleanup()
+
+	conn := up.pool.Get()
+	defer conn.Close()
+
+	data, _ := json.Marshal(&pm.Command{ID: "job-1", Command: "core.ping"})
+	if _, err := conn.Do("RPUSH", "core:node", data); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok := assert.NoError(t, up.next()); !ok {
+		t.Fatal()
+	}
+
+	db, _ := app.Ledis().Select(DBIndex)
+	local, err := db.LPop([]byte(UpstreamQueue))
+	if ok := assert.NoError(t, err); !ok {
+		t.Fatal()
+	}
+
+	assert.Equal(t, data, local)
+
+	flagged, err := redis.Int(conn.Do("LKEYEXISTS", "result:node:job-1:flag"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, flagged)
+}
+
+func TestUpstream_Respond(t *testing.T) {
+	up, _, cleanup := upstreamTest(t)
+	defer cleanup()
+
+	up.sink.track(UpstreamQueue, &pm.Command{ID: "job-2"})
+	result := &pm.JobResult{ID: "job-2", State: pm.StateSuccess}
+	if ok := assert.NoError(t, up.sink.Forward(result)); !ok {
+		t.Fatal()
+	}
+
+	//responses are pushed asynchronously.
+	if ok := assert.NoError(t, up.do(<-up.ch)); !ok {
+		t.Fatal()
+	}
+
+	conn := up.pool.Get()
+	defer conn.Close()
+
+	payload, err := redis.Bytes(conn.Do("LPOP", fmt.Sprintf("result:node:%s", result.ID)))
+	if ok := assert.NoError(t, err); !ok {
+		t.Fatal()
+	}
+
+	var loaded pm.JobResult
+	assert.NoError(t, json.Unmarshal(payload, &loaded))
+	assert.Equal(t, result.ID, loaded.ID)
+	assert.Equal(t, result.State, loaded.State)
+}
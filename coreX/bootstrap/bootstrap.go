@@ -90,6 +90,11 @@ func (o *Bootstrap) populateMinimumDev() error {
 		}
 	}
 
+	return o.populateDevMounts()
+}
+
+//populateDevMounts creates the /dev symlinks and ipc mounts, the device nodes must already exist
+func (o *Bootstrap) populateDevMounts() error {
 	for oldname, newname := range map[string]string{
 		"/proc/self/fd/0": "/dev/stdin",
 		"/proc/self/fd/1": "/dev/stdout",
@@ -137,7 +142,12 @@ func (o *Bootstrap) setupFS() error {
 	}
 
 	os.MkdirAll("/dev", 0755)
-	if options.Options.Unprivileged() {
+	if options.Options.UserNS() {
+		//device nodes can't be created in a user namespace, /dev is mounted and populated by core0
+		if err := o.populateDevMounts(); err != nil {
+			return err
+		}
+	} else if options.Options.Unprivileged() {
 		if err := syscall.Mount("none", "/dev", "tmpfs", syscall.MS_NOSUID, "mode=755"); err != nil {
 			return fmt.Errorf("failed to mount dev in unprivileged: %s", err)
 		}
@@ -194,9 +204,27 @@ func (b *Bootstrap) Bootstrap(hostname string) error {
 		return err
 	}
 
+	caps := make([]string, 0, len(pm.Capabilities))
+	for name := range pm.Capabilities {
+		caps = append(caps, name)
+	}
+
+	if options.Options.Unprivileged() {
+		var err error
+		if caps, err = pm.ResolveCapabilities(options.Options.CapAdd(), options.Options.CapDrop()); err != nil {
+			return err
+		}
+	}
+
+	//the filter is installed before the capabilities are dropped and before any command is dispatched
+	if err := b.installSeccomp(caps); err != nil {
+		return err
+	}
+
 	if options.Options.Unprivileged() {
 		pm.SetUnprivileged()
-		if err := b.revokePrivileges(); err != nil {
+		pm.SetCapabilities(caps)
+		if err := b.revokePrivileges(caps); err != nil {
 			return err
 		}
 	}
@@ -247,23 +275,7 @@ func (b *Bootstrap) UnBootstrap() {
 func updateHostname(hostname string) error {
 	log.Infof("Set hostname to %s", hostname)
 
-	// update /etc/hostname
-	fHostname, err := os.OpenFile("/etc/hostname", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return err
-	}
-	defer fHostname.Close()
-	fmt.Fprint(fHostname, hostname)
-
-	// update /etc/hosts
-	fHosts, err := os.OpenFile("/etc/hosts", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return err
-	}
-	defer fHosts.Close()
-	fmt.Fprintf(fHosts, "127.0.0.1    %s.local %s\n", hostname, hostname)
-	fmt.Fprint(fHosts, "127.0.0.1    localhost.localdomain localhost\n")
-
+	//the hostname and hosts files under /etc are generated by core0 before the container starts
 	return syscall.Sethostname([]byte(hostname))
 }
 
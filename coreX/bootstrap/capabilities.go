@@ -6,9 +6,13 @@ import "C"
 import (
 	"fmt"
 	"unsafe"
+
+	"github.com/zero-os/0-core/base/pm"
 )
 
-func (b *Bootstrap) revokePrivileges() error {
+//revokePrivileges keeps only caps in the coreX capabilities, CAP_SETPCAP is always kept since pm needs it to
+//drop the bounding set of the jobs
+func (b *Bootstrap) revokePrivileges(caps []string) error {
 	cap := C.cap_init()
 	defer C.cap_free(unsafe.Pointer(cap))
 
@@ -18,19 +22,12 @@ func (b *Bootstrap) revokePrivileges() error {
 
 	flags := []C.cap_value_t{
 		C.CAP_SETPCAP,
-		C.CAP_MKNOD,
-		C.CAP_AUDIT_WRITE,
-		C.CAP_CHOWN,
-		C.CAP_NET_RAW,
-		C.CAP_DAC_OVERRIDE,
-		C.CAP_FOWNER,
-		C.CAP_FSETID,
-		C.CAP_KILL,
-		C.CAP_SETGID,
-		C.CAP_SETUID,
-		C.CAP_NET_BIND_SERVICE,
-		C.CAP_SYS_CHROOT,
-		C.CAP_SETFCAP,
+	}
+
+	for _, name := range caps {
+		if value := C.cap_value_t(pm.Capabilities[name]); value != C.CAP_SETPCAP {
+			flags = append(flags, value)
+		}
 	}
 
 	if C.cap_set_flag(cap, C.CAP_PERMITTED, C.int(len(flags)), &flags[0], C.CAP_SET) != 0 {
@@ -0,0 +1,47 @@
+package bootstrap
+
+/*
+#define _GNU_SOURCE
+#include <errno.h>
+#include <fcntl.h>
+#include <sched.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <unistd.h>
+
+#ifndef CLONE_NEWCGROUP
+#define CLONE_NEWCGROUP 0x02000000
+#endif
+
+//the read end of the pipe core0 writes to once coreX is placed in the container cgroups
+#define RELEASE_FD 5
+
+//unshare_cgroupns runs before the go runtime starts, unshare only applies to the calling thread and the
+//runtime is multi threaded. The cgroup namespace is rooted at the cgroups of the process when it's unshared,
+//so coreX waits for core0 to place it in the container cgroups first.
+__attribute__((constructor)) static void unshare_cgroupns(void) {
+	char c;
+	ssize_t n;
+
+	if (fcntl(RELEASE_FD, F_GETFD) < 0) {
+		//not started by core0
+		return;
+	}
+
+	do {
+		n = read(RELEASE_FD, &c, 1);
+	} while (n < 0 && errno == EINTR);
+
+	close(RELEASE_FD);
+	if (n != 1) {
+		fprintf(stderr, "container was not released by core0\n");
+		exit(1);
+	}
+
+	if (unshare(CLONE_NEWCGROUP) < 0) {
+		perror("failed to unshare cgroup namespace");
+		exit(1);
+	}
+}
+*/
+import "C"
@@ -0,0 +1,32 @@
+package bootstrap
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/zero-os/0-core/base/seccomp"
+	"github.com/zero-os/0-core/coreX/options"
+)
+
+//installSeccomp installs the seccomp profile written by core0 in the container root, the profile file is
+//removed once loaded. caps are the container capabilities used by the profile rules.
+func (b *Bootstrap) installSeccomp(caps []string) error {
+	p := options.Options.Seccomp()
+	if len(p) == 0 {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+
+	os.Remove(p)
+	profile, err := seccomp.Parse(data)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("installing seccomp filter")
+	return profile.Install(caps)
+}
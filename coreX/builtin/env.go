@@ -0,0 +1,44 @@
+package builtin
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/zero-os/0-core/base/pm"
+)
+
+const (
+	cmdSetEnv = "corex.setenv"
+)
+
+func init() {
+	pm.RegisterBuiltIn(cmdSetEnv, setEnv)
+}
+
+type setEnvArguments struct {
+	Set   map[string]string `json:"set"`
+	Unset []string          `json:"unset"`
+}
+
+//setEnv changes the environment of coreX, so the processes started after it get the new environment
+func setEnv(cmd *pm.Command) (interface{}, error) {
+	var args setEnvArguments
+	if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, pm.BadRequestError(err)
+	}
+
+	for _, key := range args.Unset {
+		if err := os.Unsetenv(key); err != nil {
+			return nil, err
+		}
+	}
+
+	for key, value := range args.Set {
+		if err := os.Setenv(key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Debugf("environment updated (set: %d, unset: %d)", len(args.Set), len(args.Unset))
+	return nil, nil
+}
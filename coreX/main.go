@@ -28,6 +28,12 @@ func init() {
 	logging.SetLevel(logging.DEBUG, "")
 }
 
+//envelope is a message from core0, it's either a command to run or input to a running job
+type envelope struct {
+	pm.Command
+	Input *pm.Input `json:"input,omitempty"`
+}
+
 func handleSignal(bs *bootstrap.Bootstrap) {
 	ch := make(chan os.Signal)
 	signal.Notify(ch, syscall.SIGTERM)
@@ -56,6 +62,7 @@ func main() {
 
 	pm.MaxJobs = opt.MaxJobs()
 	pm.New()
+	pm.Register(pm.CommandExec, pm.NewExecProcess)
 
 	input := os.NewFile(3, "|input")
 	output := os.NewFile(4, "|output")
@@ -78,12 +85,20 @@ func main() {
 
 	dec := json.NewDecoder(input)
 	for {
-		var cmd pm.Command
-		if err := dec.Decode(&cmd); err != nil {
+		var msg envelope
+		if err := dec.Decode(&msg); err != nil {
 			log.Errorf("failed to decode command message: %s", err)
 
 		}
 
+		if msg.Input != nil {
+			if err := pm.Feed(msg.Input); err != nil {
+				log.Errorf("failed to feed input to job '%s': %s", msg.Input.ID, err)
+			}
+			continue
+		}
+
+		cmd := msg.Command
 		_, err := pm.Run(&cmd)
 
 		if err == pm.UnknownCommandErr {
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 )
 
 type AppOptions struct {
@@ -11,6 +12,10 @@ type AppOptions struct {
 	maxJobs      int
 	hostname     string
 	unprivileged bool
+	userns       bool
+	capAdd       string
+	capDrop      string
+	seccomp      string
 }
 
 func (o *AppOptions) Version() bool {
@@ -29,6 +34,30 @@ func (o *AppOptions) Unprivileged() bool {
 	return o.unprivileged
 }
 
+func (o *AppOptions) UserNS() bool {
+	return o.userns
+}
+
+func split(list string) []string {
+	if len(list) == 0 {
+		return nil
+	}
+
+	return strings.Split(list, ",")
+}
+
+func (o *AppOptions) CapAdd() []string {
+	return split(o.capAdd)
+}
+
+func (o *AppOptions) CapDrop() []string {
+	return split(o.capDrop)
+}
+
+func (o *AppOptions) Seccomp() string {
+	return o.seccomp
+}
+
 func (o *AppOptions) Validate() []error {
 	errors := make([]error, 0)
 
@@ -44,6 +73,10 @@ func init() {
 	flag.IntVar(&Options.maxJobs, "max-jobs", 100000, "Max number of jobs that can run concurrently")
 	flag.StringVar(&Options.hostname, "hostname", "", "Hostname of the container")
 	flag.BoolVar(&Options.unprivileged, "unprivileged", false, "Unprivileged container (strips down container capabilites)")
+	flag.BoolVar(&Options.userns, "userns", false, "Container runs in a user namespace (/dev is populated by core0)")
+	flag.StringVar(&Options.capAdd, "cap-add", "", "Comma separated capabilities added to the unprivileged container defaults")
+	flag.StringVar(&Options.capDrop, "cap-drop", "", "Comma separated capabilities dropped from the unprivileged container defaults")
+	flag.StringVar(&Options.seccomp, "seccomp", "", "Path of the seccomp profile (docker format) installed before the container starts")
 
 	flag.Parse()
 
@@ -6,11 +6,15 @@ import (
 	"github.com/codegangsta/cli"
 	"github.com/olekukonko/tablewriter"
 	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/pm/stream"
 	"gopkg.in/yaml.v2"
+	"io"
 	"os"
+	"os/signal"
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 )
 
 type containerData struct {
@@ -109,3 +113,122 @@ func containerInspect(t Transport, c *cli.Context) {
 	data, _ := yaml.Marshal(container)
 	fmt.Println(string(data))
 }
+
+func containerExec(t Transport, c *cli.Context) {
+	args := []string(c.Args())
+	if len(args) > 1 && args[1] == "--" {
+		args = append(args[:1], args[2:]...)
+	}
+
+	if len(args) < 2 {
+		log.Fatal("missing container id or command")
+	}
+
+	interactive := c.Bool("interactive") || c.Bool("it")
+	tty := c.Bool("tty") || c.Bool("it")
+
+	env := make(map[string]string)
+	for _, e := range c.StringSlice("env") {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 {
+			log.Fatalf("invalid environment variable '%s', expecting KEY=VALUE", e)
+		}
+		env[kv[0]] = kv[1]
+	}
+
+	exec := pm.ExecCommandArguments{
+		Name:  args[1],
+		Args:  args[2:],
+		Dir:   c.String("cwd"),
+		Env:   env,
+		User:  c.String("user"),
+		TTY:   tty,
+		Stdin: interactive,
+	}
+
+	terminal := tty && isTerminal(os.Stdin)
+	if terminal {
+		exec.Rows, exec.Cols = terminalSize(os.Stdin)
+	}
+
+	session, err := t.Attach(Command{
+		Container: args[0],
+		Content: pm.Command{
+			Command:   pm.CommandExec,
+			Arguments: pm.MustArguments(exec),
+		},
+	})
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	restore := func() {}
+	if terminal {
+		if restore, err = makeRaw(os.Stdin); err != nil {
+			log.Fatalf("failed to set terminal to raw mode: %s", err)
+		}
+
+		winch := make(chan os.Signal, 1)
+		signal.Notify(winch, syscall.SIGWINCH)
+		go func() {
+			for range winch {
+				rows, cols := terminalSize(os.Stdin)
+				session.Send(Frame{Rows: rows, Cols: cols})
+			}
+		}()
+	}
+
+	if interactive {
+		go func() {
+			buffer := make([]byte, 32*1024)
+			for {
+				n, err := os.Stdin.Read(buffer)
+				if n > 0 {
+					if err := session.Send(Frame{Stdin: buffer[:n]}); err != nil {
+						return
+					}
+				}
+
+				if err != nil {
+					session.Send(Frame{EOF: true})
+					return
+				}
+			}
+		}()
+	}
+
+	var result *Response
+	for result == nil {
+		frame, err := session.Next()
+		if err != nil {
+			restore()
+			log.Fatalf("connection lost: %s", err)
+		}
+
+		if msg := frame.Message; msg != nil && msg.Meta.Assert(stream.LevelStdout, stream.LevelStderr) {
+			output := msg.Message
+			if !tty {
+				//without a terminal the output is received line by line
+				output += "\n"
+			}
+			io.WriteString(outputs[msg.Meta.Level()-1], output)
+		}
+
+		result = frame.Result
+	}
+
+	restore()
+
+	if result.State == pm.StateSuccess {
+		os.Exit(0)
+	}
+
+	if result.Code >= 1000 {
+		//exit code of the process
+		os.Exit(int(result.Code - 1000))
+	}
+
+	result.PrintStreams()
+	result.ValidateResultOrExit()
+}
@@ -104,6 +104,38 @@ func main() {
 					Usage:     "print detailed container info",
 					ArgsUsage: "id",
 				},
+				{
+					Name:      "exec",
+					Action:    WithTransport(containerExec),
+					Usage:     "run a command inside a container",
+					ArgsUsage: "id [--] command [args...]",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "interactive, i",
+							Usage: "Keep stdin attached to the command",
+						},
+						cli.BoolFlag{
+							Name:  "tty, t",
+							Usage: "Run the command in a terminal",
+						},
+						cli.BoolFlag{
+							Name:  "it",
+							Usage: "Same as -i -t",
+						},
+						cli.StringFlag{
+							Name:  "user, u",
+							Usage: "Run the command as user (name or uid, optionally followed by :group)",
+						},
+						cli.StringFlag{
+							Name:  "cwd, w",
+							Usage: "Working directory of the command",
+						},
+						cli.StringSliceFlag{
+							Name:  "env, e",
+							Usage: "Set an environment variable (KEY=VALUE)",
+						},
+					},
+				},
 			},
 		},
 		{
@@ -5,16 +5,51 @@ import (
 	"github.com/codegangsta/cli"
 	"github.com/pborman/uuid"
 	"github.com/zero-os/0-core/base/pm"
+	"github.com/zero-os/0-core/base/pm/stream"
 	"net"
 	"sync"
 )
 
 type Command struct {
 	Sync      bool       `json:"sync"`
+	Attach    bool       `json:"attach"`
 	Container string     `json:"container"`
 	Content   pm.Command `json:"content"`
 }
 
+//Frame is a message of the attach protocol, see LocalFrame in core0
+type Frame struct {
+	Message *stream.Message `json:"message,omitempty"`
+	Result  *Response       `json:"result,omitempty"`
+	Stdin   []byte          `json:"stdin,omitempty"`
+	EOF     bool            `json:"eof,omitempty"`
+	Rows    uint16          `json:"rows,omitempty"`
+	Cols    uint16          `json:"cols,omitempty"`
+}
+
+//Session is an attached command, it receives the command output and sends its input
+type Session struct {
+	ID  string
+	enc *json.Encoder
+	dec *json.Decoder
+	m   sync.Mutex
+}
+
+func (s *Session) Send(frame Frame) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.enc.Encode(frame)
+}
+
+func (s *Session) Next() (*Frame, error) {
+	var frame Frame
+	if err := s.dec.Decode(&frame); err != nil {
+		return nil, err
+	}
+
+	return &frame, nil
+}
+
 type TransportOptions struct {
 	Timeout int
 	ID      string
@@ -22,6 +57,7 @@ type TransportOptions struct {
 
 type Transport interface {
 	Run(cmd Command) (*Response, error)
+	Attach(cmd Command) (*Session, error)
 }
 
 type unixSocketTransport struct {
@@ -63,26 +99,31 @@ func (t *unixSocketTransport) setDefaults(cmd *Command) error {
 	return nil
 }
 
-func (t *unixSocketTransport) Run(cmd Command) (*Response, error) {
-	t.m.Lock()
-	defer t.m.Unlock()
-
+func (t *unixSocketTransport) send(cmd *Command) error {
 	if t.opt.ID == "" {
 		cmd.Content.ID = uuid.New()
 	} else {
 		cmd.Content.ID = t.opt.ID
 	}
 
-	if err := t.setDefaults(&cmd); err != nil {
-		return nil, err
+	if err := t.setDefaults(cmd); err != nil {
+		return err
 	}
 
 	data, err := json.Marshal(cmd)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if _, err := t.con.Write(data); err != nil {
+	_, err = t.con.Write(data)
+	return err
+}
+
+func (t *unixSocketTransport) Run(cmd Command) (*Response, error) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if err := t.send(&cmd); err != nil {
 		return nil, err
 	}
 
@@ -96,6 +137,23 @@ func (t *unixSocketTransport) Run(cmd Command) (*Response, error) {
 	return &response, nil
 }
 
+//Attach runs the command and attaches to it, the connection is owned by the session till the command exits
+func (t *unixSocketTransport) Attach(cmd Command) (*Session, error) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	cmd.Attach = true
+	if err := t.send(&cmd); err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		ID:  cmd.Content.ID,
+		enc: json.NewEncoder(t.con),
+		dec: json.NewDecoder(t.con),
+	}, nil
+}
+
 func WithTransport(action func(t Transport, c *cli.Context)) cli.ActionFunc {
 	return func(c *cli.Context) error {
 		t, err := NewTransport(c)